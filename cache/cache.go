@@ -21,6 +21,7 @@ type DataStore interface {
 	Remove(u interface{}) (err error)
 	LockedModify(u interface{}, add func(a, b interface{}) interface{}, increment interface{}) (interface{}, error)
 	SetTimeOut(u interface{}, timeout time.Duration) (err error)
+	KeyList() []interface{}
 }
 
 // Cache is the structure that involves the map of entries. The cache
@@ -260,6 +261,20 @@ func (c *Cache) SizeOf() int {
 	return len(c.data)
 }
 
+// KeyList returns the keys of all the entries of the cache
+func (c *Cache) KeyList() []interface{} {
+
+	c.Lock()
+	defer c.Unlock()
+
+	list := []interface{}{}
+	for k := range c.data {
+		list = append(list, k)
+	}
+
+	return list
+}
+
 // LockedModify  locks the data store
 func (c *Cache) LockedModify(u interface{}, add func(a, b interface{}) interface{}, increment interface{}) (interface{}, error) {
 
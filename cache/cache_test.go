@@ -167,6 +167,29 @@ func TestLockedModify(t *testing.T) {
 	})
 }
 
+func TestKeyList(t *testing.T) {
+
+	t.Parallel()
+
+	Convey("Given a cache with two elements", t, func() {
+		c := NewCache()
+		So(c.Add("a", 1), ShouldBeNil)
+		So(c.Add("b", 2), ShouldBeNil)
+
+		Convey("The key list should contain both keys", func() {
+			keys := c.KeyList()
+			So(len(keys), ShouldEqual, 2)
+			So(keys, ShouldContain, "a")
+			So(keys, ShouldContain, "b")
+		})
+
+		Convey("When I remove an element, its key should not be listed", func() {
+			So(c.Remove("a"), ShouldBeNil)
+			So(c.KeyList(), ShouldResemble, []interface{}{"b"})
+		})
+	})
+}
+
 func TestTimerExpirationWithUpdate(t *testing.T) {
 
 	t.Parallel()
@@ -0,0 +1,152 @@
+// Package policysim implements a command that explains the decision of the
+// enforcer for a flow to a processing unit, without any packet traces.
+package policysim
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aporeto-inc/trireme/enforcer/simulator"
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+// PolicyFile is the policy of the destination processing unit as read by the
+// command. It is a JSON document.
+type PolicyFile struct {
+	ManagementID  string
+	TriremeAction policy.PUAction
+	ReceiverRules policy.TagSelectorList
+	NetworkACLs   policy.IPRuleList
+}
+
+// Simulate runs the simulation with the arguments of the command line
+func Simulate(arguments map[string]interface{}) error {
+
+	var policyFile string
+	if value, ok := arguments["<policy>"]; ok && value != nil {
+		policyFile = value.(string)
+	}
+
+	var tags []string
+	if value, ok := arguments["--tag"]; ok && value != nil {
+		tags = value.([]string)
+	}
+
+	var ip string
+	if value, ok := arguments["--ip"]; ok && value != nil {
+		ip = value.(string)
+	}
+
+	var port string
+	if value, ok := arguments["--port"]; ok && value != nil {
+		port = value.(string)
+	}
+
+	var protocol string
+	if value, ok := arguments["--protocol"]; ok && value != nil {
+		protocol = value.(string)
+	}
+
+	return SimulateWithParameters(os.Stdout, policyFile, tags, ip, port, protocol)
+}
+
+// SimulateWithParameters evaluates a flow against the policy in the file and
+// prints the explanation. A flow with tags comes from a processing unit with
+// this identity, otherwise it comes from the external address ip.
+func SimulateWithParameters(w io.Writer, policyFile string, tags []string, ip string, port string, protocol string) error {
+
+	data, err := ioutil.ReadFile(policyFile)
+	if err != nil {
+		return fmt.Errorf("Unable to read policy file: %s", err)
+	}
+
+	file := &PolicyFile{}
+	if err = json.Unmarshal(data, file); err != nil {
+		return fmt.Errorf("Invalid policy file: %s", err)
+	}
+
+	if file.TriremeAction == 0 {
+		file.TriremeAction = policy.Police
+	}
+
+	destination := policy.NewPUPolicy(file.ManagementID, file.TriremeAction, nil, file.NetworkACLs, nil, file.ReceiverRules, nil, nil, nil, []string{}, []string{})
+
+	dport, err := strconv.Atoi(port)
+	if err != nil || dport < 0 || dport > 65535 {
+		return fmt.Errorf("Invalid port %s", port)
+	}
+
+	var source *policy.TagStore
+	if len(tags) > 0 {
+		source = policy.NewTagStore()
+		for _, tag := range tags {
+			parts := strings.SplitN(tag, "=", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("Invalid tag %s", tag)
+			}
+			source.AppendKeyValue(parts[0], parts[1])
+		}
+	}
+
+	s, err := simulator.New(destination)
+	if err != nil {
+		return err
+	}
+
+	result, err := s.Simulate(source, ip, uint16(dport), protocol)
+	if err != nil {
+		return err
+	}
+
+	printResult(w, result)
+
+	return nil
+}
+
+// printResult prints the explanation of the decision
+func printResult(w io.Writer, r *simulator.Result) {
+
+	fmt.Fprintf(w, "Decision: %s\n", r.Action.ActionString())
+	fmt.Fprintf(w, "Enforced: %t\n", r.Enforced)
+	fmt.Fprintf(w, "Reason:   %s\n", r.Reason)
+
+	if r.PolicyID != "" {
+		fmt.Fprintf(w, "Policy:   %s\n", r.PolicyID)
+	}
+
+	if r.Selector != nil {
+		fmt.Fprintf(w, "Rule:     %s\n", selectorString(*r.Selector))
+	}
+
+	if r.Rule != nil {
+		fmt.Fprintf(w, "Rule:     %s %s port %s\n", r.Rule.Protocol, r.Rule.Address, r.Rule.Port)
+	}
+
+	if len(r.Mismatches) > 0 {
+		fmt.Fprintf(w, "Rules that did not match:\n")
+		for _, m := range r.Mismatches {
+			fmt.Fprintf(w, "  %s: %s\n", selectorString(m.Selector), m.Reason)
+		}
+	}
+}
+
+// selectorString returns a printable version of a selector
+func selectorString(selector policy.TagSelector) string {
+
+	clauses := make([]string, len(selector.Clause))
+	for i, c := range selector.Clause {
+		clauses[i] = c.Key + " " + string(c.Operator) + " " + strings.Join(c.Value, ",")
+	}
+
+	s := strings.Join(clauses, " and ")
+	if selector.Policy != nil && selector.Policy.PolicyID != "" {
+		s = s + " [" + selector.Policy.PolicyID + "]"
+	}
+
+	return s
+}
@@ -0,0 +1,226 @@
+package policysim
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const policedFile = `{
+	"ManagementID": "server",
+	"ReceiverRules": [
+		{
+			"Clause": [{"Key": "env", "Value": ["prod"], "Operator": "="}],
+			"Policy": {"Action": 2, "PolicyID": "deny-prod"}
+		},
+		{
+			"Clause": [{"Key": "app", "Value": ["web"], "Operator": "="}],
+			"Policy": {"Action": 1, "PolicyID": "allow-web"}
+		}
+	],
+	"NetworkACLs": [
+		{"Address": "10.0.0.0/8", "Port": "80", "Protocol": "tcp", "Policy": {"Action": 1, "PolicyID": "allow-lan"}}
+	]
+}`
+
+const auditedFile = `{
+	"ManagementID": "server",
+	"TriremeAction": 4
+}`
+
+func TestSimulateWithParameters(t *testing.T) {
+
+	Convey("Given policy files", t, func() {
+		dir, err := ioutil.TempDir("", "policysim")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir) // nolint
+
+		files := map[string]string{
+			"police.json": policedFile,
+			"audit.json":  auditedFile,
+			"broken.json": `{"ManagementID": `,
+		}
+		for name, content := range files {
+			So(ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600), ShouldBeNil)
+		}
+
+		tests := []struct {
+			name     string
+			file     string
+			tags     []string
+			ip       string
+			port     string
+			protocol string
+			output   string
+			err      string
+		}{
+			{
+				name: "a PU flow accepted by a receiver rule",
+				file: "police.json",
+				tags: []string{"app=web", "env=dev"},
+				port: "80",
+				output: "Decision: accept\n" +
+					"Enforced: true\n" +
+					"Reason:   Accepted by a receiver rule\n" +
+					"Policy:   allow-web\n" +
+					"Rule:     app = web [allow-web]\n" +
+					"Rules that did not match:\n" +
+					"  env = prod [deny-prod]: Tag env has value dev, expected prod\n",
+			},
+			{
+				name: "a PU flow rejected by a receiver rule",
+				file: "police.json",
+				tags: []string{"app=web", "env=prod"},
+				port: "80",
+				output: "Decision: reject\n" +
+					"Enforced: true\n" +
+					"Reason:   Rejected by a receiver rule\n" +
+					"Policy:   deny-prod\n" +
+					"Rule:     env = prod [deny-prod]\n",
+			},
+			{
+				name: "a PU flow that matches no receiver rule",
+				file: "police.json",
+				tags: []string{"app=db"},
+				port: "80",
+				output: "Decision: reject\n" +
+					"Enforced: true\n" +
+					"Reason:   No receiver rule matched the tags\n" +
+					"Rules that did not match:\n" +
+					"  env = prod [deny-prod]: No tag with key env\n" +
+					"  app = web [allow-web]: Tag app has value db, expected web\n",
+			},
+			{
+				name:     "an external flow accepted by a network ACL",
+				file:     "police.json",
+				ip:       "10.1.2.3",
+				port:     "80",
+				protocol: "tcp",
+				output: "Decision: accept\n" +
+					"Enforced: true\n" +
+					"Reason:   Accepted by a network ACL\n" +
+					"Policy:   allow-lan\n" +
+					"Rule:     tcp 10.0.0.0/8 port 80\n",
+			},
+			{
+				name:     "an external flow that matches no network ACL",
+				file:     "police.json",
+				ip:       "192.168.0.1",
+				port:     "80",
+				protocol: "tcp",
+				output: "Decision: reject\n" +
+					"Enforced: true\n" +
+					"Reason:   No network ACL matched 192.168.0.1 port 80\n" +
+					"Policy:   default\n",
+			},
+			{
+				name: "a flow to an audited PU",
+				file: "audit.json",
+				tags: []string{"app=web"},
+				port: "80",
+				output: "Decision: reject\n" +
+					"Enforced: false\n" +
+					"Reason:   No receiver rule matched the tags (not enforced: the destination is audited)\n",
+			},
+			{
+				name: "a missing policy file",
+				file: "missing.json",
+				port: "80",
+				err:  "Unable to read policy file",
+			},
+			{
+				name: "an invalid policy file",
+				file: "broken.json",
+				port: "80",
+				err:  "Invalid policy file",
+			},
+			{
+				name: "a port that is not a number",
+				file: "police.json",
+				tags: []string{"app=web"},
+				port: "http",
+				err:  "Invalid port http",
+			},
+			{
+				name: "a port out of range",
+				file: "police.json",
+				tags: []string{"app=web"},
+				port: "65536",
+				err:  "Invalid port 65536",
+			},
+			{
+				name: "a tag without a value",
+				file: "police.json",
+				tags: []string{"app"},
+				port: "80",
+				err:  "Invalid tag app",
+			},
+			{
+				name: "an invalid source address",
+				file: "police.json",
+				ip:   "10.1.2",
+				port: "80",
+				err:  "Invalid source address 10.1.2",
+			},
+			{
+				name:     "an unsupported protocol",
+				file:     "police.json",
+				ip:       "10.1.2.3",
+				port:     "80",
+				protocol: "icmp",
+				err:      "Unsupported protocol icmp",
+			},
+		}
+
+		for _, test := range tests {
+			Convey("When I simulate "+test.name, func() {
+				var output bytes.Buffer
+				err := SimulateWithParameters(&output, filepath.Join(dir, test.file), test.tags, test.ip, test.port, test.protocol)
+
+				if test.err != "" {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldStartWith, test.err)
+					So(output.String(), ShouldBeEmpty)
+				} else {
+					So(err, ShouldBeNil)
+					So(output.String(), ShouldEqual, test.output)
+				}
+			})
+		}
+	})
+}
+
+func TestSimulate(t *testing.T) {
+
+	Convey("When I simulate with the arguments of the command line, they should be passed along", t, func() {
+		err := Simulate(map[string]interface{}{
+			"<policy>":   "missing.json",
+			"--tag":      []string{"app=web"},
+			"--ip":       nil,
+			"--port":     "80",
+			"--protocol": nil,
+		})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldStartWith, "Unable to read policy file")
+	})
+
+	Convey("When I simulate with an invalid port on the command line, it should be rejected", t, func() {
+		dir, err := ioutil.TempDir("", "policysim")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir) // nolint
+
+		file := filepath.Join(dir, "police.json")
+		So(ioutil.WriteFile(file, []byte(policedFile), 0600), ShouldBeNil)
+
+		err = Simulate(map[string]interface{}{
+			"<policy>": file,
+			"--port":   "http",
+		})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "Invalid port http")
+	})
+}
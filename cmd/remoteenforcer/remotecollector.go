@@ -3,21 +3,30 @@ package remoteenforcer
 import (
 	"sync"
 
+	"go.uber.org/zap"
+
 	"github.com/aporeto-inc/trireme/collector"
 )
 
+// maxContainerRecords is the number of container records buffered between two
+// reports. The oldest records are dropped beyond it.
+const maxContainerRecords = 1000
+
 // CollectorImpl : This is a local implementation for the collector interface
 // It has a flow entries cache which contains unique flows that are reported back to the
-// controller/launcher process
+// controller/launcher process, and the container records and errors of the enforcer
+// that are reported with them
 type CollectorImpl struct {
-	Flows map[string]*collector.FlowRecord
+	Flows      map[string]*collector.FlowRecord
+	Containers []*collector.ContainerRecord
 	sync.Mutex
 }
 
 // NewCollector creates a new remote collector for statistics
 func NewCollector() *CollectorImpl {
 	return &CollectorImpl{
-		Flows: map[string]*collector.FlowRecord{},
+		Flows:      map[string]*collector.FlowRecord{},
+		Containers: []*collector.ContainerRecord{},
 	}
 }
 
@@ -44,6 +53,40 @@ func (c *CollectorImpl) CollectFlowEvent(record *collector.FlowRecord) {
 	c.Flows[hash].Tags = record.Tags
 }
 
-//CollectContainerEvent exported
-//This event should not be expected here in the enforcer process inside a particular container context
-func (c *CollectorImpl) CollectContainerEvent(record *collector.ContainerRecord) {}
+// CollectContainerEvent collects a container event that happened in the enforcer and
+// adds it to a local list it shares with SendStats
+func (c *CollectorImpl) CollectContainerEvent(record *collector.ContainerRecord) {
+
+	c.Lock()
+	defer c.Unlock()
+
+	if len(c.Containers) >= maxContainerRecords {
+		zap.L().Warn("Dropping container record: too many records pending", zap.String("contextID", c.Containers[0].ContextID))
+		c.Containers = c.Containers[1:]
+	}
+
+	c.Containers = append(c.Containers, record)
+}
+
+// CollectError collects an error of the enforcer as a container record
+func (c *CollectorImpl) CollectError(contextID string, err error) {
+
+	c.CollectContainerEvent(&collector.ContainerRecord{
+		ContextID: contextID,
+		Event:     collector.ContainerError,
+		Error:     err.Error(),
+	})
+}
+
+// collected returns the collected flows and container records and resets the collector
+func (c *CollectorImpl) collected() (map[string]*collector.FlowRecord, []*collector.ContainerRecord) {
+
+	c.Lock()
+	defer c.Unlock()
+
+	flows, containers := c.Flows, c.Containers
+	c.Flows = map[string]*collector.FlowRecord{}
+	c.Containers = []*collector.ContainerRecord{}
+
+	return flows, containers
+}
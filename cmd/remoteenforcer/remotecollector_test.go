@@ -1,6 +1,8 @@
 package remoteenforcer
 
 import (
+	"fmt"
+	"strconv"
 	"testing"
 
 	"github.com/aporeto-inc/trireme/collector"
@@ -101,3 +103,47 @@ func TestCollectFlowEvent(t *testing.T) {
 		})
 	})
 }
+
+func TestCollectContainerEvent(t *testing.T) {
+	Convey("Given a stats collector", t, func() {
+		c := NewCollector()
+
+		Convey("When I add a container event and an error", func() {
+			r := &collector.ContainerRecord{
+				ContextID: "1",
+				Event:     collector.ContainerDrift,
+			}
+			c.CollectContainerEvent(r)
+			c.CollectError("2", fmt.Errorf("Unable to program the ACLs"))
+
+			Convey("They should be buffered in order", func() {
+				So(len(c.Containers), ShouldEqual, 2)
+				So(c.Containers[0], ShouldEqual, r)
+				So(c.Containers[1].ContextID, ShouldEqual, "2")
+				So(c.Containers[1].Event, ShouldEqual, collector.ContainerError)
+				So(c.Containers[1].Error, ShouldEqual, "Unable to program the ACLs")
+			})
+
+			Convey("When I collect the events, the collector should be reset", func() {
+				c.CollectFlowEvent(&collector.FlowRecord{
+					ContextID:   "1",
+					Source:      &collector.EndPoint{ID: "A"},
+					Destination: &collector.EndPoint{ID: "B"},
+				})
+				flows, containers := c.collected()
+				So(len(flows), ShouldEqual, 1)
+				So(len(containers), ShouldEqual, 2)
+				So(c.Flows, ShouldBeEmpty)
+				So(c.Containers, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When I add too many container events, the oldest should be dropped", func() {
+			for i := 0; i <= maxContainerRecords; i++ {
+				c.CollectContainerEvent(&collector.ContainerRecord{ContextID: strconv.Itoa(i)})
+			}
+			So(len(c.Containers), ShouldEqual, maxContainerRecords)
+			So(c.Containers[0].ContextID, ShouldEqual, "1")
+		})
+	})
+}
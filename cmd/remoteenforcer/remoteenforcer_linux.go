@@ -305,6 +305,7 @@ func (s *Server) Supervise(req rpcwrapper.Request, resp *rpcwrapper.Response) er
 			zap.String("ContextID", payload.ContextID),
 			zap.Error(err),
 		)
+		s.reportError(payload.ContextID, err)
 		resp.Status = err.Error()
 		return err
 	}
@@ -325,7 +326,12 @@ func (s *Server) Unenforce(req rpcwrapper.Request, resp *rpcwrapper.Response) er
 	defer cmdLock.Unlock()
 
 	payload := req.Payload.(rpcwrapper.UnEnforcePayload)
-	return s.Enforcer.Unenforce(payload.ContextID)
+	if err := s.Enforcer.Unenforce(payload.ContextID); err != nil {
+		s.reportError(payload.ContextID, err)
+		return err
+	}
+
+	return nil
 }
 
 //Unsupervise This method calls the unsupervise method on the supervisor created during initsupervisor
@@ -340,7 +346,12 @@ func (s *Server) Unsupervise(req rpcwrapper.Request, resp *rpcwrapper.Response)
 	defer cmdLock.Unlock()
 
 	payload := req.Payload.(rpcwrapper.UnSupervisePayload)
-	return s.Supervisor.Unsupervise(payload.ContextID)
+	if err := s.Supervisor.Unsupervise(payload.ContextID); err != nil {
+		s.reportError(payload.ContextID, err)
+		return err
+	}
+
+	return nil
 }
 
 //Enforce this method calls the enforce method on the enforcer created during initenforcer
@@ -368,6 +379,8 @@ func (s *Server) Enforce(req rpcwrapper.Request, resp *rpcwrapper.Response) erro
 		payload.TriremeNetworks,
 		payload.ExcludedNetworks)
 
+	pupolicy.SetFrozen(payload.Frozen)
+
 	runtime := policy.NewPURuntimeWithDefaults()
 	puInfo := policy.PUInfoFromPolicyAndRuntime(payload.ContextID, pupolicy, runtime)
 	if puInfo == nil {
@@ -377,6 +390,7 @@ func (s *Server) Enforce(req rpcwrapper.Request, resp *rpcwrapper.Response) erro
 		zap.L().Fatal("Enforcer not inited")
 	}
 	if err := s.Enforcer.Enforce(payload.ContextID, puInfo); err != nil {
+		s.reportError(payload.ContextID, err)
 		resp.Status = err.Error()
 		return err
 	}
@@ -388,6 +402,17 @@ func (s *Server) Enforce(req rpcwrapper.Request, resp *rpcwrapper.Response) erro
 	return nil
 }
 
+// reportError reports an error of the enforcer to the controller with the
+// statistics
+func (s *Server) reportError(contextID string, err error) {
+
+	if s.statsclient == nil {
+		return
+	}
+
+	s.statsclient.collector.CollectError(contextID, err)
+}
+
 // EnforcerExit this method is called when  we received a killrpocess message from the controller
 // This allows a graceful exit of the enforcer
 func (s *Server) EnforcerExit(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
@@ -8,7 +8,6 @@ import (
 
 	"go.uber.org/zap"
 
-	"github.com/aporeto-inc/trireme/collector"
 	"github.com/aporeto-inc/trireme/enforcer/utils/rpcwrapper"
 )
 
@@ -60,7 +59,7 @@ func NewStatsClient() (*StatsClient, error) {
 	}, nil
 }
 
-//SendStats  async function which makes a rpc call to send stats every STATS_INTERVAL
+//SendStats  async function which makes a rpc call to send stats and container events every STATS_INTERVAL
 func (s *StatsClient) SendStats() {
 
 	ticker := time.NewTicker(s.statsInterval)
@@ -69,21 +68,14 @@ func (s *StatsClient) SendStats() {
 		select {
 		case <-ticker.C:
 
-			s.collector.Lock()
-			if len(s.collector.Flows) == 0 {
-				s.collector.Unlock()
-				break
-			}
-			collected := s.collector.Flows
-			s.collector.Flows = map[string]*collector.FlowRecord{}
-			s.collector.Unlock()
-
-			if len(collected) == 0 {
+			flows, containers := s.collector.collected()
+			if len(flows) == 0 && len(containers) == 0 {
 				continue
 			}
 
 			rpcPayload := &rpcwrapper.StatsPayload{
-				Flows: collected,
+				Flows:      flows,
+				Containers: containers,
 			}
 
 			request := rpcwrapper.Request{
@@ -98,7 +90,7 @@ func (s *StatsClient) SendStats() {
 			)
 
 			if err != nil {
-				zap.L().Error("RPC failure in sending statistics: Unable to send flows")
+				zap.L().Error("RPC failure in sending statistics: Unable to send flows and container events")
 			}
 
 		case <-s.stop:
@@ -0,0 +1,157 @@
+package combinator
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aporeto-inc/trireme/collector"
+)
+
+// Aggregator is a collector that merges the identical flow records collected
+// during a window and forwards a single record per flow at the end of the
+// window, with the sum of their counts. Two records are identical when they
+// only differ by their source port, timestamp and TCP flags. The merged
+// record keeps the timestamp of the first record. The records with
+// statistics and the container records are forwarded immediately.
+type Aggregator struct {
+	next     collector.EventCollector
+	window   time.Duration
+	flows    map[string]*collector.FlowRecord
+	order    []string
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+	sync.Mutex
+}
+
+// NewAggregator returns an Aggregator that forwards the merged records at
+// every window
+func NewAggregator(next collector.EventCollector, window time.Duration) *Aggregator {
+
+	a := &Aggregator{
+		next:   next,
+		window: window,
+		flows:  map[string]*collector.FlowRecord{},
+		order:  []string{},
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	go a.run()
+
+	return a
+}
+
+// endPointKey returns the key of an end point, without its port
+func endPointKey(e *collector.EndPoint) string {
+
+	if e == nil {
+		return ":"
+	}
+
+	return e.ID + ":" + e.IP
+}
+
+// aggregationKey returns the key of the flow of a record
+func aggregationKey(r *collector.FlowRecord) string {
+
+	port := ""
+	if r.Destination != nil {
+		port = strconv.Itoa(int(r.Destination.Port))
+	}
+
+	return r.ContextID + "|" +
+		endPointKey(r.Source) + "|" +
+		endPointKey(r.Destination) + ":" + port + "|" +
+		strconv.Itoa(int(r.Protocol)) + "|" +
+		r.ObservationPoint + "|" +
+		r.Action.String() + "|" +
+		r.DropReason + "|" +
+		r.PolicyID
+}
+
+// CollectFlowEvent merges the flow record with the identical records of the
+// window
+func (a *Aggregator) CollectFlowEvent(r *collector.FlowRecord) {
+
+	if r.Stats != nil {
+		a.next.CollectFlowEvent(r)
+		return
+	}
+
+	key := aggregationKey(r)
+
+	a.Lock()
+	defer a.Unlock()
+
+	merged, ok := a.flows[key]
+	if !ok {
+		merged = &collector.FlowRecord{}
+		*merged = *r
+		merged.Count = count(r)
+		if r.Source != nil {
+			source := *r.Source
+			merged.Source = &source
+		}
+		a.flows[key] = merged
+		a.order = append(a.order, key)
+		return
+	}
+
+	merged.Count += count(r)
+	if merged.Source != nil && r.Source != nil && merged.Source.Port != r.Source.Port {
+		merged.Source.Port = 0
+	}
+}
+
+// CollectContainerEvent forwards the container record
+func (a *Aggregator) CollectContainerEvent(r *collector.ContainerRecord) {
+	a.next.CollectContainerEvent(r)
+}
+
+// Flush forwards the merged records of the window
+func (a *Aggregator) Flush() {
+
+	a.Lock()
+	flows := make([]*collector.FlowRecord, 0, len(a.order))
+	for _, key := range a.order {
+		flows = append(flows, a.flows[key])
+	}
+	a.flows = map[string]*collector.FlowRecord{}
+	a.order = []string{}
+	a.Unlock()
+
+	for _, r := range flows {
+		a.next.CollectFlowEvent(r)
+	}
+}
+
+// Stop forwards the merged records of the current window and stops
+func (a *Aggregator) Stop() {
+
+	a.stopOnce.Do(func() {
+		close(a.stop)
+	})
+
+	<-a.done
+}
+
+// run forwards the merged records at every window
+func (a *Aggregator) run() {
+
+	defer close(a.done)
+
+	ticker := time.NewTicker(a.window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			a.Flush()
+		case <-a.stop:
+			a.Flush()
+			return
+		}
+	}
+}
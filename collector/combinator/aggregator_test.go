@@ -0,0 +1,91 @@
+package combinator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAggregator(t *testing.T) {
+
+	Convey("Given an aggregator with a long window", t, func() {
+		next := &recordingCollector{}
+		a := NewAggregator(next, time.Hour)
+
+		flow := func(sport uint16, dport uint16) *collector.FlowRecord {
+			return &collector.FlowRecord{
+				ContextID:   "pu",
+				Count:       1,
+				Source:      &collector.EndPoint{ID: "src", IP: "10.0.0.1", Port: sport},
+				Destination: &collector.EndPoint{ID: "pu", IP: "10.0.0.2", Port: dport},
+				Action:      policy.Accept,
+				PolicyID:    "policy",
+			}
+		}
+
+		Convey("When I collect identical records, a single merged record should be forwarded on stop", func() {
+			first := flow(1000, 80)
+			a.CollectFlowEvent(first)
+			a.CollectFlowEvent(flow(1000, 80))
+			a.CollectFlowEvent(flow(1001, 80))
+			So(next.flowRecords(), ShouldBeEmpty)
+
+			a.Stop()
+			flows := next.flowRecords()
+			So(len(flows), ShouldEqual, 1)
+			So(flows[0].Count, ShouldEqual, 3)
+			So(flows[0].Source.Port, ShouldEqual, 0)
+			So(flows[0].Destination.Port, ShouldEqual, 80)
+			So(first.Count, ShouldEqual, 1)
+			So(first.Source.Port, ShouldEqual, 1000)
+		})
+
+		Convey("When I collect different records, they should be forwarded in order on flush", func() {
+			a.CollectFlowEvent(flow(1000, 80))
+			a.CollectFlowEvent(flow(1000, 443))
+			a.CollectFlowEvent(flow(1000, 80))
+			a.Flush()
+			flows := next.flowRecords()
+			So(len(flows), ShouldEqual, 2)
+			So(flows[0].Destination.Port, ShouldEqual, 80)
+			So(flows[0].Count, ShouldEqual, 2)
+			So(flows[1].Destination.Port, ShouldEqual, 443)
+			So(flows[1].Count, ShouldEqual, 1)
+			a.Stop()
+			So(len(next.flowRecords()), ShouldEqual, 2)
+		})
+
+		Convey("When I collect a record with statistics or a container record, it should be forwarded immediately", func() {
+			r := flow(1000, 80)
+			r.Stats = &collector.FlowStats{}
+			a.CollectFlowEvent(r)
+			a.CollectContainerEvent(&collector.ContainerRecord{ContextID: "pu"})
+			So(next.flowRecords(), ShouldResemble, []*collector.FlowRecord{r})
+			So(len(next.containerRecords()), ShouldEqual, 1)
+			a.Stop()
+		})
+	})
+
+	Convey("Given an aggregator with a short window", t, func() {
+		next := &recordingCollector{}
+		a := NewAggregator(next, 10*time.Millisecond)
+		defer a.Stop()
+
+		Convey("When I collect a record, it should be forwarded at the end of the window", func() {
+			a.CollectFlowEvent(&collector.FlowRecord{ContextID: "pu"})
+			So(func() bool {
+				for i := 0; i < 100; i++ {
+					if len(next.flowRecords()) == 1 {
+						return true
+					}
+					time.Sleep(10 * time.Millisecond)
+				}
+				return false
+			}(), ShouldBeTrue)
+			So(next.flowRecords()[0].Count, ShouldEqual, 1)
+		})
+	})
+}
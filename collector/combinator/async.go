@@ -0,0 +1,154 @@
+// Package combinator provides wrappers of collector.EventCollector that can
+// be composed: an asynchronous buffer, a fan-out, a sampler and an aggregator.
+// The records given to the collectors are shared and must not be modified.
+package combinator
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/aporeto-inc/trireme/collector"
+)
+
+// OverflowPolicy selects the flow record that is dropped when the buffer of
+// an Async collector is full
+type OverflowPolicy int
+
+const (
+	// DropNewest drops the record that does not fit in the buffer
+	DropNewest OverflowPolicy = iota
+	// DropOldest drops the oldest record of the buffer to make room
+	DropOldest
+)
+
+// Async is a collector that buffers the records and hands them to the next
+// collector in the background, so that a slow collector never blocks the
+// packet path. The flow records that do not fit in the buffer are dropped
+// and counted. The container records have their own buffer and are never
+// dropped: they wait for room in it. The container records are handed to
+// the next collector before the flow records buffered at the same time.
+type Async struct {
+	next       collector.EventCollector
+	flows      chan *collector.FlowRecord
+	containers chan *collector.ContainerRecord
+	policy     OverflowPolicy
+	dropped    uint64
+	stop       chan struct{}
+	done       chan struct{}
+	stopOnce   sync.Once
+}
+
+// NewAsync returns an Async collector with buffers of size flow records and
+// size container records
+func NewAsync(next collector.EventCollector, size int, policy OverflowPolicy) *Async {
+
+	a := &Async{
+		next:       next,
+		flows:      make(chan *collector.FlowRecord, size),
+		containers: make(chan *collector.ContainerRecord, size),
+		policy:     policy,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+
+	go a.run()
+
+	return a
+}
+
+// CollectFlowEvent buffers the flow record or drops it if the buffer is full
+func (a *Async) CollectFlowEvent(r *collector.FlowRecord) {
+
+	select {
+	case a.flows <- r:
+		return
+	default:
+	}
+
+	if a.policy == DropOldest {
+		select {
+		case <-a.flows:
+			atomic.AddUint64(&a.dropped, 1)
+		default:
+		}
+
+		select {
+		case a.flows <- r:
+			return
+		default:
+		}
+	}
+
+	atomic.AddUint64(&a.dropped, 1)
+}
+
+// CollectContainerEvent buffers the container record
+func (a *Async) CollectContainerEvent(r *collector.ContainerRecord) {
+
+	select {
+	case a.containers <- r:
+	case <-a.stop:
+	}
+}
+
+// Dropped returns the number of flow records dropped so far
+func (a *Async) Dropped() uint64 {
+	return atomic.LoadUint64(&a.dropped)
+}
+
+// Stop hands the buffered records to the next collector and stops. The
+// records collected after Stop are dropped.
+func (a *Async) Stop() {
+
+	a.stopOnce.Do(func() {
+		close(a.stop)
+	})
+
+	<-a.done
+}
+
+// run hands the buffered records to the next collector
+func (a *Async) run() {
+
+	defer close(a.done)
+
+	for {
+		// The container records go first
+		select {
+		case r := <-a.containers:
+			a.next.CollectContainerEvent(r)
+			continue
+		default:
+		}
+
+		select {
+		case r := <-a.containers:
+			a.next.CollectContainerEvent(r)
+		case r := <-a.flows:
+			a.next.CollectFlowEvent(r)
+		case <-a.stop:
+			a.drain()
+			return
+		}
+	}
+}
+
+// drain hands the records left in the buffers to the next collector
+func (a *Async) drain() {
+
+	for {
+		select {
+		case r := <-a.containers:
+			a.next.CollectContainerEvent(r)
+			continue
+		default:
+		}
+
+		select {
+		case r := <-a.flows:
+			a.next.CollectFlowEvent(r)
+		default:
+			return
+		}
+	}
+}
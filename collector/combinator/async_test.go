@@ -0,0 +1,95 @@
+package combinator
+
+import (
+	"testing"
+
+	"github.com/aporeto-inc/trireme/collector"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// blockingCollector blocks on its first flow record until it is released
+type blockingCollector struct {
+	recordingCollector
+	started  chan struct{}
+	released chan struct{}
+}
+
+func (c *blockingCollector) CollectFlowEvent(r *collector.FlowRecord) {
+	if r.ContextID == "block" {
+		close(c.started)
+		<-c.released
+	}
+	c.recordingCollector.CollectFlowEvent(r)
+}
+
+func TestAsync(t *testing.T) {
+
+	Convey("Given an async collector with a buffer of 2 records", t, func() {
+		next := &blockingCollector{
+			started:  make(chan struct{}),
+			released: make(chan struct{}),
+		}
+
+		contexts := func() []string {
+			ids := []string{}
+			for _, r := range next.flowRecords() {
+				ids = append(ids, r.ContextID)
+			}
+			return ids
+		}
+
+		fill := func(a *Async) {
+			a.CollectFlowEvent(&collector.FlowRecord{ContextID: "block"})
+			<-next.started
+			for _, id := range []string{"1", "2", "3", "4"} {
+				a.CollectFlowEvent(&collector.FlowRecord{ContextID: id})
+			}
+			close(next.released)
+			a.Stop()
+		}
+
+		Convey("When the buffer overflows with the drop newest policy, the newest records should be dropped", func() {
+			a := NewAsync(next, 2, DropNewest)
+			fill(a)
+			So(contexts(), ShouldResemble, []string{"block", "1", "2"})
+			So(a.Dropped(), ShouldEqual, 2)
+		})
+
+		Convey("When the buffer overflows with the drop oldest policy, the oldest records should be dropped", func() {
+			a := NewAsync(next, 2, DropOldest)
+			fill(a)
+			So(contexts(), ShouldResemble, []string{"block", "3", "4"})
+			So(a.Dropped(), ShouldEqual, 2)
+		})
+
+		Convey("When the buffer overflows after a container record with the drop oldest policy, only flow records should be dropped", func() {
+			a := NewAsync(next, 2, DropOldest)
+			r := &collector.ContainerRecord{ContextID: "pu", Event: collector.ContainerStart}
+			a.CollectFlowEvent(&collector.FlowRecord{ContextID: "block"})
+			<-next.started
+			a.CollectContainerEvent(r)
+			for _, id := range []string{"1", "2", "3", "4"} {
+				a.CollectFlowEvent(&collector.FlowRecord{ContextID: id})
+			}
+			close(next.released)
+			a.Stop()
+			So(next.containerRecords(), ShouldResemble, []*collector.ContainerRecord{r})
+			So(contexts(), ShouldResemble, []string{"block", "3", "4"})
+			So(a.Dropped(), ShouldEqual, 2)
+		})
+
+		Convey("When I collect a container record and stop, it should be delivered", func() {
+			a := NewAsync(next, 2, DropNewest)
+			close(next.released)
+			r := &collector.ContainerRecord{ContextID: "pu", Event: collector.ContainerStart}
+			a.CollectContainerEvent(r)
+			a.Stop()
+			So(next.containerRecords(), ShouldResemble, []*collector.ContainerRecord{r})
+
+			Convey("The records collected after stop should be dropped", func() {
+				a.CollectContainerEvent(r)
+				So(len(next.containerRecords()), ShouldEqual, 1)
+			})
+		})
+	})
+}
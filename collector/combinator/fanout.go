@@ -0,0 +1,36 @@
+package combinator
+
+import "github.com/aporeto-inc/trireme/collector"
+
+// fanOut hands every record to all its collectors
+type fanOut []collector.EventCollector
+
+// NewFanOut returns a collector that hands every record to all the collectors,
+// in order
+func NewFanOut(collectors ...collector.EventCollector) collector.EventCollector {
+
+	f := fanOut{}
+	for _, c := range collectors {
+		if c != nil {
+			f = append(f, c)
+		}
+	}
+
+	return f
+}
+
+// CollectFlowEvent hands the flow record to all the collectors
+func (f fanOut) CollectFlowEvent(r *collector.FlowRecord) {
+
+	for _, c := range f {
+		c.CollectFlowEvent(r)
+	}
+}
+
+// CollectContainerEvent hands the container record to all the collectors
+func (f fanOut) CollectContainerEvent(r *collector.ContainerRecord) {
+
+	for _, c := range f {
+		c.CollectContainerEvent(r)
+	}
+}
@@ -0,0 +1,63 @@
+package combinator
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/aporeto-inc/trireme/collector"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// recordingCollector records the records it collects
+type recordingCollector struct {
+	flows      []*collector.FlowRecord
+	containers []*collector.ContainerRecord
+	sync.Mutex
+}
+
+func (c *recordingCollector) CollectFlowEvent(r *collector.FlowRecord) {
+	c.Lock()
+	defer c.Unlock()
+	c.flows = append(c.flows, r)
+}
+
+func (c *recordingCollector) CollectContainerEvent(r *collector.ContainerRecord) {
+	c.Lock()
+	defer c.Unlock()
+	c.containers = append(c.containers, r)
+}
+
+func (c *recordingCollector) flowRecords() []*collector.FlowRecord {
+	c.Lock()
+	defer c.Unlock()
+	return append([]*collector.FlowRecord{}, c.flows...)
+}
+
+func (c *recordingCollector) containerRecords() []*collector.ContainerRecord {
+	c.Lock()
+	defer c.Unlock()
+	return append([]*collector.ContainerRecord{}, c.containers...)
+}
+
+func TestFanOut(t *testing.T) {
+
+	Convey("Given a fan-out to two collectors", t, func() {
+		first := &recordingCollector{}
+		second := &recordingCollector{}
+		f := NewFanOut(first, nil, second)
+
+		Convey("When I collect a flow record, both collectors should receive it", func() {
+			r := &collector.FlowRecord{ContextID: "pu"}
+			f.CollectFlowEvent(r)
+			So(first.flowRecords(), ShouldResemble, []*collector.FlowRecord{r})
+			So(second.flowRecords(), ShouldResemble, []*collector.FlowRecord{r})
+		})
+
+		Convey("When I collect a container record, both collectors should receive it", func() {
+			r := &collector.ContainerRecord{ContextID: "pu", Event: collector.ContainerStart}
+			f.CollectContainerEvent(r)
+			So(first.containerRecords(), ShouldResemble, []*collector.ContainerRecord{r})
+			So(second.containerRecords(), ShouldResemble, []*collector.ContainerRecord{r})
+		})
+	})
+}
@@ -0,0 +1,114 @@
+package combinator
+
+import (
+	"sync"
+
+	"github.com/aporeto-inc/trireme/collector"
+)
+
+// Sampler is a collector that forwards one flow record out of N for every
+// PU and reason. The reason of a record is its drop reason or, for the
+// records without a drop reason, its action. The Count of a forwarded record
+// is the sum of the counts of the records it represents. The container
+// records are always forwarded.
+type Sampler struct {
+	next  collector.EventCollector
+	rate  int
+	rates map[string]int
+	state map[string]map[string]int
+	sync.Mutex
+}
+
+// NewSampler returns a Sampler that forwards one record out of rate. The
+// rates override the rate of some reasons. A rate of 1 or less forwards all
+// the records.
+func NewSampler(next collector.EventCollector, rate int, rates map[string]int) *Sampler {
+
+	if rates == nil {
+		rates = map[string]int{}
+	}
+
+	return &Sampler{
+		next:  next,
+		rate:  rate,
+		rates: rates,
+		state: map[string]map[string]int{},
+	}
+}
+
+// reason returns the reason of a flow record
+func reason(r *collector.FlowRecord) string {
+
+	if r.DropReason != "" {
+		return r.DropReason
+	}
+
+	return r.Action.String()
+}
+
+// count returns the number of flows reported by a record
+func count(r *collector.FlowRecord) int {
+
+	if r.Count <= 0 {
+		return 1
+	}
+
+	return r.Count
+}
+
+// CollectFlowEvent forwards the record if it is sampled
+func (s *Sampler) CollectFlowEvent(r *collector.FlowRecord) {
+
+	key := reason(r)
+
+	rate, ok := s.rates[key]
+	if !ok {
+		rate = s.rate
+	}
+
+	if rate <= 1 {
+		s.next.CollectFlowEvent(r)
+		return
+	}
+
+	s.Lock()
+
+	reasons, ok := s.state[r.ContextID]
+	if !ok {
+		reasons = map[string]int{}
+		s.state[r.ContextID] = reasons
+	}
+
+	// The first record of a reason is forwarded, then one record out of rate
+	seen, ok := reasons[key]
+	skipped := seen + count(r)
+	if ok && skipped < rate {
+		reasons[key] = skipped
+		s.Unlock()
+		return
+	}
+
+	reasons[key] = 0
+	s.Unlock()
+
+	sampled := *r
+	sampled.Count = skipped
+	if !ok {
+		sampled.Count = count(r)
+	}
+
+	s.next.CollectFlowEvent(&sampled)
+}
+
+// CollectContainerEvent forwards the container record. The sampling state of
+// a deleted PU is released.
+func (s *Sampler) CollectContainerEvent(r *collector.ContainerRecord) {
+
+	if r.Event == collector.ContainerDelete {
+		s.Lock()
+		delete(s.state, r.ContextID)
+		s.Unlock()
+	}
+
+	s.next.CollectContainerEvent(r)
+}
@@ -0,0 +1,63 @@
+package combinator
+
+import (
+	"testing"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSampler(t *testing.T) {
+
+	Convey("Given a sampler of one record out of 3 and all the invalid tokens", t, func() {
+		next := &recordingCollector{}
+		s := NewSampler(next, 3, map[string]int{collector.InvalidToken: 1})
+
+		accepted := func(id string) *collector.FlowRecord {
+			return &collector.FlowRecord{ContextID: id, Count: 1, Action: policy.Accept}
+		}
+
+		counts := func() []int {
+			c := []int{}
+			for _, r := range next.flowRecords() {
+				c = append(c, r.Count)
+			}
+			return c
+		}
+
+		Convey("When I collect 7 accepted records, the first and one out of 3 should be forwarded with the counts", func() {
+			for i := 0; i < 7; i++ {
+				s.CollectFlowEvent(accepted("pu"))
+			}
+			So(counts(), ShouldResemble, []int{1, 3, 3})
+		})
+
+		Convey("When I collect the records of two PUs, they should be sampled separately", func() {
+			s.CollectFlowEvent(accepted("pu1"))
+			s.CollectFlowEvent(accepted("pu2"))
+			So(counts(), ShouldResemble, []int{1, 1})
+		})
+
+		Convey("When I collect the records of two reasons, they should be sampled separately", func() {
+			s.CollectFlowEvent(accepted("pu"))
+			s.CollectFlowEvent(&collector.FlowRecord{ContextID: "pu", Count: 1, Action: policy.Reject, DropReason: collector.PolicyDrop})
+			So(counts(), ShouldResemble, []int{1, 1})
+		})
+
+		Convey("When I collect invalid tokens, they should all be forwarded", func() {
+			for i := 0; i < 3; i++ {
+				s.CollectFlowEvent(&collector.FlowRecord{ContextID: "pu", Count: 1, Action: policy.Reject, DropReason: collector.InvalidToken})
+			}
+			So(counts(), ShouldResemble, []int{1, 1, 1})
+		})
+
+		Convey("When the PU is deleted, its sampling should restart", func() {
+			s.CollectFlowEvent(accepted("pu"))
+			s.CollectContainerEvent(&collector.ContainerRecord{ContextID: "pu", Event: collector.ContainerDelete})
+			s.CollectFlowEvent(accepted("pu"))
+			So(counts(), ShouldResemble, []int{1, 1})
+			So(len(next.containerRecords()), ShouldEqual, 1)
+		})
+	})
+}
@@ -0,0 +1,192 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+// FlowRecordVersion is the version of the JSON encoding of the flow records.
+// It changes when a field is removed or changes meaning; fields are added
+// without changing it.
+const FlowRecordVersion = 1
+
+// endPointJSON is the JSON encoding of an EndPoint
+type endPointJSON struct {
+	ID   string `json:"id,omitempty"`
+	IP   string `json:"ip,omitempty"`
+	Port uint16 `json:"port,omitempty"`
+	Type string `json:"type"`
+}
+
+// flowStatsJSON is the JSON encoding of FlowStats
+type flowStatsJSON struct {
+	StartTime          time.Time `json:"start_time"`
+	EndTime            time.Time `json:"end_time"`
+	EndReason          string    `json:"end_reason,omitempty"`
+	SourcePackets      uint64    `json:"source_packets"`
+	SourceBytes        uint64    `json:"source_bytes"`
+	DestinationPackets uint64    `json:"destination_packets"`
+	DestinationBytes   uint64    `json:"destination_bytes"`
+}
+
+// flowRecordJSON is the JSON encoding of a FlowRecord. The action is the
+// policy.ActionType mask.
+type flowRecordJSON struct {
+	Version          int            `json:"version"`
+	Timestamp        *time.Time     `json:"timestamp,omitempty"`
+	ContextID        string         `json:"context_id"`
+	Count            int            `json:"count"`
+	Protocol         uint8          `json:"protocol,omitempty"`
+	TCPFlags         uint8          `json:"tcp_flags,omitempty"`
+	ObservationPoint string         `json:"observation_point,omitempty"`
+	Source           *endPointJSON  `json:"source,omitempty"`
+	Destination      *endPointJSON  `json:"destination,omitempty"`
+	Tags             []string       `json:"tags"`
+	Action           uint8          `json:"action"`
+	DropReason       string         `json:"drop_reason,omitempty"`
+	PolicyID         string         `json:"policy_id,omitempty"`
+	Stats            *flowStatsJSON `json:"stats,omitempty"`
+}
+
+func encodeEndPoint(e *EndPoint) *endPointJSON {
+
+	if e == nil {
+		return nil
+	}
+
+	return &endPointJSON{
+		ID:   e.ID,
+		IP:   e.IP,
+		Port: e.Port,
+		Type: e.Type.String(),
+	}
+}
+
+func decodeEndPoint(e *endPointJSON) (*EndPoint, error) {
+
+	if e == nil {
+		return nil, nil
+	}
+
+	endPoint := &EndPoint{
+		ID:   e.ID,
+		IP:   e.IP,
+		Port: e.Port,
+	}
+
+	switch e.Type {
+	case "ext":
+		endPoint.Type = Address
+	case "pu":
+		endPoint.Type = PU
+	default:
+		return nil, fmt.Errorf("Invalid end point type %s", e.Type)
+	}
+
+	return endPoint, nil
+}
+
+// MarshalJSON encodes the flow record with the versioned JSON encoding
+func (f *FlowRecord) MarshalJSON() ([]byte, error) {
+
+	r := &flowRecordJSON{
+		Version:          FlowRecordVersion,
+		ContextID:        f.ContextID,
+		Count:            f.Count,
+		Protocol:         f.Protocol,
+		TCPFlags:         f.TCPFlags,
+		ObservationPoint: f.ObservationPoint,
+		Source:           encodeEndPoint(f.Source),
+		Destination:      encodeEndPoint(f.Destination),
+		Action:           uint8(f.Action),
+		DropReason:       f.DropReason,
+		PolicyID:         f.PolicyID,
+	}
+
+	if !f.Timestamp.IsZero() {
+		r.Timestamp = &f.Timestamp
+	}
+
+	if f.Tags != nil {
+		r.Tags = f.Tags.Tags
+		if r.Tags == nil {
+			r.Tags = []string{}
+		}
+	}
+
+	if f.Stats != nil {
+		r.Stats = &flowStatsJSON{
+			StartTime:          f.Stats.StartTime,
+			EndTime:            f.Stats.EndTime,
+			EndReason:          f.Stats.EndReason,
+			SourcePackets:      f.Stats.SourcePackets,
+			SourceBytes:        f.Stats.SourceBytes,
+			DestinationPackets: f.Stats.DestinationPackets,
+			DestinationBytes:   f.Stats.DestinationBytes,
+		}
+	}
+
+	return json.Marshal(r)
+}
+
+// UnmarshalJSON decodes a flow record of the versioned JSON encoding. The
+// records of a later version are rejected.
+func (f *FlowRecord) UnmarshalJSON(data []byte) error {
+
+	r := &flowRecordJSON{}
+	if err := json.Unmarshal(data, r); err != nil {
+		return err
+	}
+
+	if r.Version < 1 || r.Version > FlowRecordVersion {
+		return fmt.Errorf("Unsupported flow record version %d", r.Version)
+	}
+
+	source, err := decodeEndPoint(r.Source)
+	if err != nil {
+		return err
+	}
+
+	destination, err := decodeEndPoint(r.Destination)
+	if err != nil {
+		return err
+	}
+
+	*f = FlowRecord{
+		ContextID:        r.ContextID,
+		Count:            r.Count,
+		Source:           source,
+		Destination:      destination,
+		Action:           policy.ActionType(r.Action),
+		DropReason:       r.DropReason,
+		PolicyID:         r.PolicyID,
+		Protocol:         r.Protocol,
+		TCPFlags:         r.TCPFlags,
+		ObservationPoint: r.ObservationPoint,
+	}
+
+	if r.Timestamp != nil {
+		f.Timestamp = *r.Timestamp
+	}
+
+	if r.Tags != nil {
+		f.Tags = &policy.TagStore{Tags: r.Tags}
+	}
+
+	if r.Stats != nil {
+		f.Stats = &FlowStats{
+			StartTime:          r.Stats.StartTime,
+			EndTime:            r.Stats.EndTime,
+			EndReason:          r.Stats.EndReason,
+			SourcePackets:      r.Stats.SourcePackets,
+			SourceBytes:        r.Stats.SourceBytes,
+			DestinationPackets: r.Stats.DestinationPackets,
+			DestinationBytes:   r.Stats.DestinationBytes,
+		}
+	}
+
+	return nil
+}
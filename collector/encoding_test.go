@@ -0,0 +1,75 @@
+package collector
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFlowRecordJSON(t *testing.T) {
+
+	Convey("Given a flow record", t, func() {
+		start := time.Date(2017, 7, 1, 10, 0, 0, 0, time.UTC)
+		r := &FlowRecord{
+			ContextID:        "pu",
+			Count:            2,
+			Source:           &EndPoint{ID: "src", IP: "10.0.0.1", Port: 1000, Type: Address},
+			Destination:      &EndPoint{ID: "pu", IP: "10.0.0.2", Port: 80, Type: PU},
+			Tags:             &policy.TagStore{Tags: []string{"app=web"}},
+			Action:           policy.Accept | policy.Encrypt,
+			PolicyID:         "policy",
+			Timestamp:        start,
+			Protocol:         6,
+			TCPFlags:         0x02,
+			ObservationPoint: ObservationNetwork,
+			Stats: &FlowStats{
+				StartTime:     start,
+				EndTime:       start.Add(time.Second),
+				EndReason:     FlowEndFin,
+				SourcePackets: 4,
+				SourceBytes:   400,
+			},
+		}
+
+		Convey("When I encode it, it should use the versioned field names", func() {
+			data, err := json.Marshal(r)
+			So(err, ShouldBeNil)
+
+			fields := map[string]interface{}{}
+			So(json.Unmarshal(data, &fields), ShouldBeNil)
+			So(fields["version"], ShouldEqual, FlowRecordVersion)
+			So(fields["timestamp"], ShouldEqual, "2017-07-01T10:00:00Z")
+			So(fields["context_id"], ShouldEqual, "pu")
+			So(fields["protocol"], ShouldEqual, 6)
+			So(fields["tcp_flags"], ShouldEqual, 2)
+			So(fields["observation_point"], ShouldEqual, "net")
+			So(fields["action"], ShouldEqual, int(policy.Accept|policy.Encrypt))
+			So(fields["source"], ShouldResemble, map[string]interface{}{"id": "src", "ip": "10.0.0.1", "port": float64(1000), "type": "ext"})
+			So(fields["stats"].(map[string]interface{})["end_reason"], ShouldEqual, FlowEndFin)
+
+			Convey("When I decode it, I should get the same record", func() {
+				decoded := &FlowRecord{}
+				So(json.Unmarshal(data, decoded), ShouldBeNil)
+				So(decoded, ShouldResemble, r)
+			})
+		})
+
+		Convey("When I encode a record without optional fields, it should be decoded the same", func() {
+			r = &FlowRecord{ContextID: "pu", Tags: policy.NewTagStore()}
+			data, err := json.Marshal(r)
+			So(err, ShouldBeNil)
+			decoded := &FlowRecord{}
+			So(json.Unmarshal(data, decoded), ShouldBeNil)
+			So(decoded, ShouldResemble, r)
+		})
+
+		Convey("When I decode a record of an unsupported version or end point type, it should fail", func() {
+			So(json.Unmarshal([]byte(`{"context_id":"pu"}`), &FlowRecord{}), ShouldNotBeNil)
+			So(json.Unmarshal([]byte(`{"version":2,"context_id":"pu"}`), &FlowRecord{}), ShouldNotBeNil)
+			So(json.Unmarshal([]byte(`{"version":1,"source":{"type":"other"}}`), &FlowRecord{}), ShouldNotBeNil)
+		})
+	})
+}
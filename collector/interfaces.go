@@ -2,6 +2,7 @@ package collector
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/aporeto-inc/trireme/policy"
 )
@@ -27,6 +28,19 @@ const (
 	InvalidNonse = "nonse"
 	// PolicyDrop indicates that the flow is rejected because of the policy decision
 	PolicyDrop = "policy"
+	// EncryptionMismatch indicates that the policy requires encryption but the
+	// other end did not negotiate it
+	EncryptionMismatch = "encryption"
+	// FlowEnd indicates that the record reports the end of an accepted flow
+	FlowEnd = "end"
+	// FlowEndFin indicates that the flow was closed by both ends
+	FlowEndFin = "fin"
+	// FlowEndReset indicates that the flow was reset
+	FlowEndReset = "rst"
+	// FlowEndTimeout indicates that the flow was not closed before the timeout
+	FlowEndTimeout = "timeout"
+	// FlowEndRevoked indicates that the flow was reset because the policy changed
+	FlowEndRevoked = "revoked"
 	// ContainerStart indicates a container start event
 	ContainerStart = "start"
 	// ContainerStop indicates a container stop event
@@ -37,16 +51,32 @@ const (
 	ContainerDelete = "delete"
 	// ContainerUpdate indicates a container policy update event
 	ContainerUpdate = "update"
+	// ContainerPause indicates that the policy of a paused container was frozen
+	ContainerPause = "pause"
+	// ContainerUnpause indicates that the policy of an unpaused container was restored
+	ContainerUnpause = "unpause"
 	// ContainerFailed indicates an event that a container was stopped because of policy issues
 	ContainerFailed = "forcestop"
 	// ContainerIgnored indicates that the container will be ignored by Trireme
 	ContainerIgnored = "ignore"
+	// ContainerDrift indicates that the rules of a container did not match its
+	// policy and were programmed again
+	ContainerDrift = "drift"
+	// ContainerError indicates that the enforcer failed to apply the policy
+	// of a container. The record carries the error.
+	ContainerError = "error"
 	// UnknownContainerDelete indicates that policy for an unknown  container was deleted
 	UnknownContainerDelete = "unknowncontainer"
 	// PolicyValid Normal flow accept
 	PolicyValid = "V"
 	// DefaultEndPoint  provides a string for unknown container sources
 	DefaultEndPoint = "default"
+	// ObservationApplication indicates that the flow was observed on a packet
+	// sent by the PU
+	ObservationApplication = "app"
+	// ObservationNetwork indicates that the flow was observed on a packet
+	// received from the network
+	ObservationNetwork = "net"
 )
 
 // EventCollector is the interface for collecting events.
@@ -84,7 +114,10 @@ type EndPoint struct {
 	Type EndPointType
 }
 
-// FlowRecord describes a flow record for statistis
+// FlowRecord describes a flow record for statistis. The source of a flow is
+// the source of the packet on which it was observed: for the records of a
+// SYN, the source port is the ephemeral port of the client and the retries of
+// the SYN share it.
 type FlowRecord struct {
 	ContextID   string
 	Count       int
@@ -94,6 +127,32 @@ type FlowRecord struct {
 	Action      policy.ActionType
 	DropReason  string
 	PolicyID    string
+	// Timestamp is the time at which the flow was observed
+	Timestamp time.Time
+	// Protocol is the IP protocol of the flow
+	Protocol uint8
+	// TCPFlags are the flags of the TCP packet on which the flow was observed
+	TCPFlags uint8
+	// ObservationPoint is ObservationApplication or ObservationNetwork
+	ObservationPoint string
+	// Stats is only provided in the records that report the end of a flow
+	Stats *FlowStats
+}
+
+// FlowStats describes the lifetime and the volume of a flow that ended
+type FlowStats struct {
+	StartTime          time.Time
+	EndTime            time.Time
+	EndReason          string
+	SourcePackets      uint64
+	SourceBytes        uint64
+	DestinationPackets uint64
+	DestinationBytes   uint64
+}
+
+// Duration returns the lifetime of the flow
+func (s *FlowStats) Duration() time.Duration {
+	return s.EndTime.Sub(s.StartTime)
 }
 
 func (f *FlowRecord) String() string {
@@ -116,4 +175,6 @@ type ContainerRecord struct {
 	IPAddress string
 	Tags      *policy.TagStore
 	Event     string
+	// Error is the error reported by the ContainerError events
+	Error string
 }
@@ -0,0 +1,300 @@
+package ipfix
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+
+	"github.com/aporeto-inc/trireme/collector"
+)
+
+const (
+	// Version is the version of the IPFIX protocol
+	Version = 10
+
+	// TemplateSetID is the ID of the sets of templates
+	TemplateSetID = 2
+
+	// IPv4TemplateID is the ID of the template of the IPv4 flows
+	IPv4TemplateID = 256
+
+	// IPv6TemplateID is the ID of the template of the IPv6 flows
+	IPv6TemplateID = 257
+
+	// ReverseEnterpriseNumber is the enterprise number of the reverse
+	// information elements of the bidirectional flows (RFC 5103)
+	ReverseEnterpriseNumber = 29305
+
+	// VariableLength is the length of the variable length fields in templates
+	VariableLength = 65535
+
+	// headerLength is the length of the header of the messages
+	headerLength = 16
+
+	// setHeaderLength is the length of the header of the sets
+	setHeaderLength = 4
+)
+
+// The enterprise specific information elements of the flow records
+const (
+	// ContextIDElement is the ID of the PU that reported the flow
+	ContextIDElement = 1 + iota
+	// SourcePUIDElement is the ID of the source PU
+	SourcePUIDElement
+	// DestinationPUIDElement is the ID of the destination PU
+	DestinationPUIDElement
+	// PolicyIDElement is the ID of the policy that matched the flow
+	PolicyIDElement
+	// DropReasonElement is the reason why the flow was rejected
+	DropReasonElement
+	// ActionElement is the policy.ActionType of the flow
+	ActionElement
+	// EndReasonElement is the reason why the flow ended
+	EndReasonElement
+)
+
+// Field is a field of a template
+type Field struct {
+	ID         uint16
+	Length     uint16
+	Enterprise uint32
+}
+
+// templateFields returns the fields of the template of a family of addresses
+func templateFields(enterprise uint32, addressLength uint16) []Field {
+
+	source, destination := uint16(8), uint16(12)
+	if addressLength == net.IPv6len {
+		source, destination = 27, 28
+	}
+
+	return []Field{
+		{ID: 152, Length: 8}, // flowStartMilliseconds
+		{ID: 153, Length: 8}, // flowEndMilliseconds
+		{ID: source, Length: addressLength},
+		{ID: destination, Length: addressLength},
+		{ID: 4, Length: 1},  // protocolIdentifier
+		{ID: 6, Length: 2},  // tcpControlBits
+		{ID: 7, Length: 2},  // sourceTransportPort
+		{ID: 11, Length: 2}, // destinationTransportPort
+		{ID: 3, Length: 8},  // deltaFlowCount
+		{ID: 2, Length: 8},  // packetDeltaCount
+		{ID: 1, Length: 8},  // octetDeltaCount
+		{ID: 2, Length: 8, Enterprise: ReverseEnterpriseNumber},
+		{ID: 1, Length: 8, Enterprise: ReverseEnterpriseNumber},
+		{ID: ContextIDElement, Length: VariableLength, Enterprise: enterprise},
+		{ID: SourcePUIDElement, Length: VariableLength, Enterprise: enterprise},
+		{ID: DestinationPUIDElement, Length: VariableLength, Enterprise: enterprise},
+		{ID: PolicyIDElement, Length: VariableLength, Enterprise: enterprise},
+		{ID: DropReasonElement, Length: VariableLength, Enterprise: enterprise},
+		{ID: ActionElement, Length: 1, Enterprise: enterprise},
+		{ID: EndReasonElement, Length: VariableLength, Enterprise: enterprise},
+	}
+}
+
+// appendTemplateSet appends the set of the templates of the flows
+func appendTemplateSet(b []byte, enterprise uint32) []byte {
+
+	start := len(b)
+	b = appendUint16(b, TemplateSetID)
+	b = appendUint16(b, 0)
+
+	for _, template := range []struct {
+		id     uint16
+		fields []Field
+	}{
+		{IPv4TemplateID, templateFields(enterprise, net.IPv4len)},
+		{IPv6TemplateID, templateFields(enterprise, net.IPv6len)},
+	} {
+		b = appendUint16(b, template.id)
+		b = appendUint16(b, uint16(len(template.fields)))
+		for _, f := range template.fields {
+			if f.Enterprise == 0 {
+				b = appendUint16(b, f.ID)
+				b = appendUint16(b, f.Length)
+				continue
+			}
+			b = appendUint16(b, f.ID|0x8000)
+			b = appendUint16(b, f.Length)
+			b = appendUint32(b, f.Enterprise)
+		}
+	}
+
+	binary.BigEndian.PutUint16(b[start+2:], uint16(len(b)-start))
+
+	return b
+}
+
+// addresses returns the source and destination addresses of a record and the
+// template they belong to
+func addresses(r *collector.FlowRecord) (uint16, net.IP, net.IP) {
+
+	source, destination := endPointIP(r.Source), endPointIP(r.Destination)
+
+	if source.To4() != nil && destination.To4() != nil {
+		return IPv4TemplateID, source.To4(), destination.To4()
+	}
+
+	if source.To4() == nil && destination.To4() == nil && (source != nil || destination != nil) {
+		return IPv6TemplateID, ip16(source), ip16(destination)
+	}
+
+	// Mixed or missing addresses are reported as IPv4, the missing or
+	// mismatching address being unspecified
+	return IPv4TemplateID, ip4(source), ip4(destination)
+}
+
+// appendDataSet appends a set of the flow records of a template
+func appendDataSet(b []byte, templateID uint16, records []*collector.FlowRecord, now time.Time) []byte {
+
+	start := len(b)
+	b = appendUint16(b, templateID)
+	b = appendUint16(b, 0)
+
+	for _, r := range records {
+		b = appendRecord(b, r, now)
+	}
+
+	binary.BigEndian.PutUint16(b[start+2:], uint16(len(b)-start))
+
+	return b
+}
+
+// appendRecord appends the fields of a flow record
+func appendRecord(b []byte, r *collector.FlowRecord, now time.Time) []byte {
+
+	_, source, destination := addresses(r)
+
+	start, end := now, now
+	if !r.Timestamp.IsZero() {
+		start, end = r.Timestamp, r.Timestamp
+	}
+	var sourcePackets, sourceBytes, destinationPackets, destinationBytes uint64
+	endReason := ""
+	if r.Stats != nil {
+		start, end = r.Stats.StartTime, r.Stats.EndTime
+		sourcePackets, sourceBytes = r.Stats.SourcePackets, r.Stats.SourceBytes
+		destinationPackets, destinationBytes = r.Stats.DestinationPackets, r.Stats.DestinationBytes
+		endReason = r.Stats.EndReason
+	}
+
+	flows := r.Count
+	if flows <= 0 {
+		flows = 1
+	}
+
+	b = appendUint64(b, uint64(start.UnixNano()/int64(time.Millisecond)))
+	b = appendUint64(b, uint64(end.UnixNano()/int64(time.Millisecond)))
+	b = append(b, source...)
+	b = append(b, destination...)
+	b = append(b, r.Protocol)
+	b = appendUint16(b, uint16(r.TCPFlags))
+	b = appendUint16(b, endPointPort(r.Source))
+	b = appendUint16(b, endPointPort(r.Destination))
+	b = appendUint64(b, uint64(flows))
+	b = appendUint64(b, sourcePackets)
+	b = appendUint64(b, sourceBytes)
+	b = appendUint64(b, destinationPackets)
+	b = appendUint64(b, destinationBytes)
+	b = appendString(b, r.ContextID)
+	b = appendString(b, endPointID(r.Source))
+	b = appendString(b, endPointID(r.Destination))
+	b = appendString(b, r.PolicyID)
+	b = appendString(b, r.DropReason)
+	b = append(b, byte(r.Action))
+	b = appendString(b, endReason)
+
+	return b
+}
+
+// appendHeader appends the header of a message. The length is set by
+// setLength once the sets are appended.
+func appendHeader(b []byte, now time.Time, sequence uint32, domain uint32) []byte {
+
+	b = appendUint16(b, Version)
+	b = appendUint16(b, 0)
+	b = appendUint32(b, uint32(now.Unix()))
+	b = appendUint32(b, sequence)
+	b = appendUint32(b, domain)
+
+	return b
+}
+
+// setLength sets the length of a message
+func setLength(b []byte) {
+	binary.BigEndian.PutUint16(b[2:], uint16(len(b)))
+}
+
+// appendString appends a variable length string
+func appendString(b []byte, s string) []byte {
+
+	if len(s) > VariableLength-1 {
+		s = s[:VariableLength-1]
+	}
+
+	if len(s) < 255 {
+		b = append(b, byte(len(s)))
+		return append(b, s...)
+	}
+
+	b = append(b, 255)
+	b = appendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(v>>32)), uint32(v))
+}
+
+func endPointIP(e *collector.EndPoint) net.IP {
+
+	if e == nil {
+		return nil
+	}
+
+	return net.ParseIP(e.IP)
+}
+
+func endPointPort(e *collector.EndPoint) uint16 {
+
+	if e == nil {
+		return 0
+	}
+
+	return e.Port
+}
+
+func endPointID(e *collector.EndPoint) string {
+
+	if e == nil {
+		return ""
+	}
+
+	return e.ID
+}
+
+func ip4(ip net.IP) net.IP {
+
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+
+	return net.IPv4zero.To4()
+}
+
+func ip16(ip net.IP) net.IP {
+
+	if ip == nil {
+		return net.IPv6unspecified
+	}
+
+	return ip.To16()
+}
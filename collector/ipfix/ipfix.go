@@ -0,0 +1,125 @@
+// Package ipfix provides a collector.EventCollector that exports the flow
+// records as IPFIX (RFC 7011) messages over UDP. The flow records are
+// described by two templates, one for IPv4 and one for IPv6 flows, that
+// carry the trireme specific fields as enterprise specific information
+// elements.
+package ipfix
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme/collector"
+)
+
+const (
+	// DefaultTemplateRefresh is the default interval at which the templates
+	// are sent again
+	DefaultTemplateRefresh = 10 * time.Minute
+
+	// maxMessageLength is the maximum length of an IPFIX message
+	maxMessageLength = 65535
+)
+
+// Config is the configuration of an IPFIX collector
+type Config struct {
+	// Address is the host:port of the IPFIX collector
+	Address string
+	// EnterpriseNumber is the private enterprise number of the trireme
+	// specific information elements
+	EnterpriseNumber uint32
+	// ObservationDomain is the observation domain of the messages
+	ObservationDomain uint32
+	// TemplateRefresh is the interval at which the templates are sent again,
+	// as the UDP collectors may have missed them
+	TemplateRefresh time.Duration
+}
+
+// Collector exports the flow records to an IPFIX collector. The container
+// records are ignored.
+type Collector struct {
+	conn         net.Conn
+	config       Config
+	sequence     uint32
+	lastTemplate time.Time
+	sync.Mutex
+}
+
+// NewCollector returns a Collector that exports the flow records to the
+// IPFIX collector of the configuration
+func NewCollector(config *Config) (*Collector, error) {
+
+	if config == nil || config.Address == "" {
+		return nil, fmt.Errorf("The address of the IPFIX collector is required")
+	}
+
+	if config.EnterpriseNumber == 0 {
+		return nil, fmt.Errorf("An enterprise number is required for the IPFIX templates")
+	}
+
+	conn, err := net.Dial("udp", config.Address)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to connect to the IPFIX collector %s: %s", config.Address, err)
+	}
+
+	c := &Collector{
+		conn:   conn,
+		config: *config,
+	}
+
+	if c.config.TemplateRefresh <= 0 {
+		c.config.TemplateRefresh = DefaultTemplateRefresh
+	}
+
+	return c, nil
+}
+
+// CollectFlowEvent exports the flow record
+func (c *Collector) CollectFlowEvent(record *collector.FlowRecord) {
+
+	c.Lock()
+	defer c.Unlock()
+
+	now := time.Now()
+
+	message := appendHeader(make([]byte, 0, 512), now, c.sequence, c.config.ObservationDomain)
+
+	if now.Sub(c.lastTemplate) >= c.config.TemplateRefresh {
+		message = appendTemplateSet(message, c.config.EnterpriseNumber)
+		c.lastTemplate = now
+	}
+
+	templateID, _, _ := addresses(record)
+	message = appendDataSet(message, templateID, []*collector.FlowRecord{record}, now)
+
+	if len(message) > maxMessageLength {
+		zap.L().Warn("Flow record too large for IPFIX", zap.String("contextID", record.ContextID))
+		return
+	}
+
+	setLength(message)
+
+	if _, err := c.conn.Write(message); err != nil {
+		zap.L().Warn("Unable to export flow record to IPFIX collector",
+			zap.String("address", c.config.Address),
+			zap.Error(err),
+		)
+		// The collector may have missed the templates
+		c.lastTemplate = time.Time{}
+		return
+	}
+
+	c.sequence++
+}
+
+// CollectContainerEvent ignores the container record
+func (c *Collector) CollectContainerEvent(record *collector.ContainerRecord) {}
+
+// Close closes the connection to the IPFIX collector
+func (c *Collector) Close() error {
+	return c.conn.Close()
+}
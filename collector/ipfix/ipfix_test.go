@@ -0,0 +1,248 @@
+package ipfix
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const testEnterprise = 12345
+
+// decoder decodes the IPFIX messages with the templates it received
+type decoder struct {
+	templates map[uint16][]Field
+}
+
+// message is a decoded IPFIX message
+type message struct {
+	sequence  uint32
+	domain    uint32
+	templates []uint16
+	records   []map[Field][]byte
+}
+
+func (d *decoder) decode(b []byte) (*message, error) {
+
+	if len(b) < headerLength || binary.BigEndian.Uint16(b) != Version {
+		return nil, fmt.Errorf("Invalid header")
+	}
+
+	if int(binary.BigEndian.Uint16(b[2:])) != len(b) {
+		return nil, fmt.Errorf("Invalid length")
+	}
+
+	m := &message{
+		sequence: binary.BigEndian.Uint32(b[8:]),
+		domain:   binary.BigEndian.Uint32(b[12:]),
+	}
+
+	b = b[headerLength:]
+	for len(b) > 0 {
+		if len(b) < setHeaderLength {
+			return nil, fmt.Errorf("Invalid set header")
+		}
+		id, length := binary.BigEndian.Uint16(b), int(binary.BigEndian.Uint16(b[2:]))
+		if length < setHeaderLength || length > len(b) {
+			return nil, fmt.Errorf("Invalid set length")
+		}
+		set := b[setHeaderLength:length]
+		b = b[length:]
+
+		if id == TemplateSetID {
+			for len(set) > 0 {
+				templateID, count := binary.BigEndian.Uint16(set), int(binary.BigEndian.Uint16(set[2:]))
+				set = set[4:]
+				fields := []Field{}
+				for i := 0; i < count; i++ {
+					f := Field{ID: binary.BigEndian.Uint16(set), Length: binary.BigEndian.Uint16(set[2:])}
+					set = set[4:]
+					if f.ID&0x8000 != 0 {
+						f.ID &^= 0x8000
+						f.Enterprise = binary.BigEndian.Uint32(set)
+						set = set[4:]
+					}
+					fields = append(fields, f)
+				}
+				d.templates[templateID] = fields
+				m.templates = append(m.templates, templateID)
+			}
+			continue
+		}
+
+		fields, ok := d.templates[id]
+		if !ok {
+			return nil, fmt.Errorf("Unknown template %d", id)
+		}
+
+		for len(set) > 0 {
+			record := map[Field][]byte{}
+			for _, f := range fields {
+				length := int(f.Length)
+				if f.Length == VariableLength {
+					length = int(set[0])
+					set = set[1:]
+					if length == 255 {
+						length = int(binary.BigEndian.Uint16(set))
+						set = set[2:]
+					}
+				}
+				record[Field{ID: f.ID, Enterprise: f.Enterprise}] = set[:length]
+				set = set[length:]
+			}
+			m.records = append(m.records, record)
+		}
+	}
+
+	return m, nil
+}
+
+func TestCollector(t *testing.T) {
+
+	Convey("Given an IPFIX collector listening on UDP", t, func() {
+		listener, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		So(err, ShouldBeNil)
+		defer listener.Close() // nolint
+
+		d := &decoder{templates: map[uint16][]Field{}}
+		receive := func() *message {
+			b := make([]byte, maxMessageLength)
+			listener.SetReadDeadline(time.Now().Add(5 * time.Second)) // nolint
+			n, _, err := listener.ReadFromUDP(b)
+			So(err, ShouldBeNil)
+			m, err := d.decode(b[:n])
+			So(err, ShouldBeNil)
+			return m
+		}
+
+		Convey("When I create an exporter without an address or an enterprise number, it should fail", func() {
+			_, err := NewCollector(nil)
+			So(err, ShouldNotBeNil)
+			_, err = NewCollector(&Config{Address: listener.LocalAddr().String()})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When I export flow records", func() {
+			c, err := NewCollector(&Config{
+				Address:           listener.LocalAddr().String(),
+				EnterpriseNumber:  testEnterprise,
+				ObservationDomain: 7,
+			})
+			So(err, ShouldBeNil)
+			defer c.Close() // nolint
+
+			start := time.Unix(1500000000, 0)
+			c.CollectFlowEvent(&collector.FlowRecord{
+				ContextID:   "pu",
+				Count:       3,
+				Source:      &collector.EndPoint{ID: "src", IP: "10.0.0.1", Port: 1000},
+				Destination: &collector.EndPoint{ID: "pu", IP: "10.0.0.2", Port: 80},
+				Action:      policy.Accept | policy.Encrypt,
+				PolicyID:    "policy",
+				Protocol:    6,
+				TCPFlags:    0x12,
+				Stats: &collector.FlowStats{
+					StartTime:          start,
+					EndTime:            start.Add(time.Second),
+					EndReason:          "fin",
+					SourcePackets:      10,
+					SourceBytes:        1000,
+					DestinationPackets: 20,
+					DestinationBytes:   2000,
+				},
+			})
+			c.CollectContainerEvent(&collector.ContainerRecord{ContextID: "pu"})
+			c.CollectFlowEvent(&collector.FlowRecord{
+				ContextID:   "pu",
+				Source:      &collector.EndPoint{ID: "default", IP: "fd00::1", Port: 1000},
+				Destination: &collector.EndPoint{ID: "pu", IP: "fd00::2", Port: 443},
+				Action:      policy.Reject,
+				DropReason:  collector.PolicyDrop,
+				PolicyID:    strings.Repeat("p", 300),
+				Timestamp:   start,
+				Protocol:    17,
+			})
+
+			first := receive()
+			second := receive()
+
+			Convey("The templates should be sent with the first record only", func() {
+				So(first.templates, ShouldResemble, []uint16{IPv4TemplateID, IPv6TemplateID})
+				So(second.templates, ShouldBeEmpty)
+				So(d.templates[IPv4TemplateID], ShouldContain, Field{ID: PolicyIDElement, Length: VariableLength, Enterprise: testEnterprise})
+				So(d.templates[IPv6TemplateID], ShouldContain, Field{ID: 27, Length: 16})
+			})
+
+			Convey("The headers should carry the sequence numbers and the observation domain", func() {
+				So(first.sequence, ShouldEqual, 0)
+				So(second.sequence, ShouldEqual, 1)
+				So(first.domain, ShouldEqual, 7)
+			})
+
+			Convey("The IPv4 record should be decoded with its statistics", func() {
+				So(len(first.records), ShouldEqual, 1)
+				r := first.records[0]
+				So(binary.BigEndian.Uint64(r[Field{ID: 152}]), ShouldEqual, 1500000000000)
+				So(binary.BigEndian.Uint64(r[Field{ID: 153}]), ShouldEqual, 1500000001000)
+				So(net.IP(r[Field{ID: 8}]).String(), ShouldEqual, "10.0.0.1")
+				So(net.IP(r[Field{ID: 12}]).String(), ShouldEqual, "10.0.0.2")
+				So(r[Field{ID: 4}], ShouldResemble, []byte{6})
+				So(binary.BigEndian.Uint16(r[Field{ID: 6}]), ShouldEqual, 0x12)
+				So(binary.BigEndian.Uint16(r[Field{ID: 7}]), ShouldEqual, 1000)
+				So(binary.BigEndian.Uint16(r[Field{ID: 11}]), ShouldEqual, 80)
+				So(binary.BigEndian.Uint64(r[Field{ID: 3}]), ShouldEqual, 3)
+				So(binary.BigEndian.Uint64(r[Field{ID: 2}]), ShouldEqual, 10)
+				So(binary.BigEndian.Uint64(r[Field{ID: 1}]), ShouldEqual, 1000)
+				So(binary.BigEndian.Uint64(r[Field{ID: 2, Enterprise: ReverseEnterpriseNumber}]), ShouldEqual, 20)
+				So(binary.BigEndian.Uint64(r[Field{ID: 1, Enterprise: ReverseEnterpriseNumber}]), ShouldEqual, 2000)
+				So(string(r[Field{ID: ContextIDElement, Enterprise: testEnterprise}]), ShouldEqual, "pu")
+				So(string(r[Field{ID: SourcePUIDElement, Enterprise: testEnterprise}]), ShouldEqual, "src")
+				So(string(r[Field{ID: DestinationPUIDElement, Enterprise: testEnterprise}]), ShouldEqual, "pu")
+				So(string(r[Field{ID: PolicyIDElement, Enterprise: testEnterprise}]), ShouldEqual, "policy")
+				So(string(r[Field{ID: DropReasonElement, Enterprise: testEnterprise}]), ShouldEqual, "")
+				So(policy.ActionType(r[Field{ID: ActionElement, Enterprise: testEnterprise}][0]), ShouldEqual, policy.Accept|policy.Encrypt)
+				So(string(r[Field{ID: EndReasonElement, Enterprise: testEnterprise}]), ShouldEqual, "fin")
+			})
+
+			Convey("The IPv6 record should be decoded with its long policy ID", func() {
+				So(len(second.records), ShouldEqual, 1)
+				r := second.records[0]
+				So(net.IP(r[Field{ID: 27}]).String(), ShouldEqual, "fd00::1")
+				So(net.IP(r[Field{ID: 28}]).String(), ShouldEqual, "fd00::2")
+				So(binary.BigEndian.Uint64(r[Field{ID: 152}]), ShouldEqual, 1500000000000)
+				So(r[Field{ID: 4}], ShouldResemble, []byte{17})
+				So(binary.BigEndian.Uint16(r[Field{ID: 6}]), ShouldEqual, 0)
+				So(binary.BigEndian.Uint64(r[Field{ID: 3}]), ShouldEqual, 1)
+				So(binary.BigEndian.Uint64(r[Field{ID: 2}]), ShouldEqual, 0)
+				So(string(r[Field{ID: PolicyIDElement, Enterprise: testEnterprise}]), ShouldEqual, strings.Repeat("p", 300))
+				So(string(r[Field{ID: DropReasonElement, Enterprise: testEnterprise}]), ShouldEqual, collector.PolicyDrop)
+				So(policy.ActionType(r[Field{ID: ActionElement, Enterprise: testEnterprise}][0]), ShouldEqual, policy.Reject)
+			})
+		})
+
+		Convey("When the template refresh interval elapsed, the templates should be sent again", func() {
+			c, err := NewCollector(&Config{
+				Address:          listener.LocalAddr().String(),
+				EnterpriseNumber: testEnterprise,
+				TemplateRefresh:  time.Nanosecond,
+			})
+			So(err, ShouldBeNil)
+			defer c.Close() // nolint
+
+			r := &collector.FlowRecord{ContextID: "pu"}
+			c.CollectFlowEvent(r)
+			time.Sleep(time.Millisecond)
+			c.CollectFlowEvent(r)
+			So(len(receive().templates), ShouldEqual, 2)
+			m := receive()
+			So(len(m.templates), ShouldEqual, 2)
+			So(net.IP(m.records[0][Field{ID: 8}]).String(), ShouldEqual, "0.0.0.0")
+		})
+	})
+}
@@ -0,0 +1,301 @@
+// Package journal provides a collector.EventCollector that appends the flow
+// and container records to a journal on the local disk, and a Reader that
+// replays and tails the journal, so that the records can be backfilled when
+// their consumer was not reachable.
+//
+// The journal is a directory of segments. Every segment is a file of
+// checksummed JSON lines and a new segment is started when the current one
+// is too large or too old. The oldest segments are removed beyond a maximum
+// number of segments.
+package journal
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme/collector"
+)
+
+const (
+	// DefaultMaxSize is the default maximum size of a segment
+	DefaultMaxSize = 64 * 1024 * 1024
+
+	// DefaultMaxAge is the default maximum age of a segment
+	DefaultMaxAge = time.Hour
+
+	// DefaultMaxSegments is the default maximum number of segments
+	DefaultMaxSegments = 16
+
+	// segmentSuffix is the suffix of the files of the segments
+	segmentSuffix = ".journal"
+)
+
+// Config is the configuration of a journal
+type Config struct {
+	// Directory is the directory of the segments
+	Directory string
+	// MaxSize is the size beyond which a new segment is started
+	MaxSize int64
+	// MaxAge is the age beyond which a new segment is started
+	MaxAge time.Duration
+	// MaxSegments is the number of segments kept. The oldest segments are
+	// removed beyond it.
+	MaxSegments int
+	// Sync flushes every record to the disk before returning
+	Sync bool
+}
+
+// Offset is the position of an entry in the journal
+type Offset struct {
+	Segment  uint64
+	Position int64
+}
+
+// Entry is a record of the journal. Either Flow or Container is set.
+type Entry struct {
+	Time      time.Time                  `json:"time"`
+	Flow      *collector.FlowRecord      `json:"flow,omitempty"`
+	Container *collector.ContainerRecord `json:"container,omitempty"`
+
+	// Offset is the position of the entry and Next the position of the
+	// entry that follows it, where a replay can resume
+	Offset Offset `json:"-"`
+	Next   Offset `json:"-"`
+}
+
+// Journal is a collector that appends the records to the journal
+type Journal struct {
+	config  Config
+	file    *os.File
+	segment uint64
+	size    int64
+	created time.Time
+	sync.Mutex
+}
+
+// NewJournal opens the journal of the configuration. The records are
+// appended to a new segment.
+func NewJournal(config *Config) (*Journal, error) {
+
+	if config == nil || config.Directory == "" {
+		return nil, fmt.Errorf("The directory of the journal is required")
+	}
+
+	j := &Journal{config: *config}
+
+	if j.config.MaxSize <= 0 {
+		j.config.MaxSize = DefaultMaxSize
+	}
+
+	if j.config.MaxAge <= 0 {
+		j.config.MaxAge = DefaultMaxAge
+	}
+
+	if j.config.MaxSegments <= 0 {
+		j.config.MaxSegments = DefaultMaxSegments
+	}
+
+	if err := os.MkdirAll(j.config.Directory, 0700); err != nil {
+		return nil, fmt.Errorf("Unable to create the journal directory %s: %s", j.config.Directory, err)
+	}
+
+	segments, err := listSegments(j.config.Directory)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(segments) > 0 {
+		j.segment = segments[len(segments)-1]
+	}
+
+	if err := j.rotate(); err != nil {
+		return nil, err
+	}
+
+	return j, nil
+}
+
+// CollectFlowEvent appends the flow record to the journal
+func (j *Journal) CollectFlowEvent(record *collector.FlowRecord) {
+
+	if err := j.Append(&Entry{Time: time.Now(), Flow: record}); err != nil {
+		zap.L().Warn("Unable to append flow record to journal", zap.Error(err))
+	}
+}
+
+// CollectContainerEvent appends the container record to the journal
+func (j *Journal) CollectContainerEvent(record *collector.ContainerRecord) {
+
+	if err := j.Append(&Entry{Time: time.Now(), Container: record}); err != nil {
+		zap.L().Warn("Unable to append container record to journal", zap.Error(err))
+	}
+}
+
+// Append appends an entry to the journal
+func (j *Journal) Append(entry *Entry) error {
+
+	line, err := encode(entry)
+	if err != nil {
+		return err
+	}
+
+	j.Lock()
+	defer j.Unlock()
+
+	if j.file == nil {
+		return fmt.Errorf("The journal is closed")
+	}
+
+	if (j.size > 0 && j.size+int64(len(line)) > j.config.MaxSize) || time.Since(j.created) > j.config.MaxAge {
+		if err := j.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := j.file.Write(line)
+	j.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("Unable to write to the journal: %s", err)
+	}
+
+	if j.config.Sync {
+		return j.file.Sync()
+	}
+
+	return nil
+}
+
+// Close closes the journal
+func (j *Journal) Close() error {
+
+	j.Lock()
+	defer j.Unlock()
+
+	if j.file == nil {
+		return nil
+	}
+
+	err := j.file.Close()
+	j.file = nil
+
+	return err
+}
+
+// rotate starts a new segment and removes the oldest segments
+func (j *Journal) rotate() error {
+
+	if j.file != nil {
+		if err := j.file.Close(); err != nil {
+			zap.L().Warn("Unable to close journal segment", zap.Error(err))
+		}
+		j.file = nil
+	}
+
+	j.segment++
+
+	file, err := os.OpenFile(segmentPath(j.config.Directory, j.segment), os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("Unable to create journal segment: %s", err)
+	}
+
+	j.file = file
+	j.size = 0
+	j.created = time.Now()
+
+	segments, err := listSegments(j.config.Directory)
+	if err != nil {
+		return err
+	}
+
+	for len(segments) > j.config.MaxSegments {
+		if err := os.Remove(segmentPath(j.config.Directory, segments[0])); err != nil {
+			zap.L().Warn("Unable to remove journal segment", zap.Error(err))
+		}
+		segments = segments[1:]
+	}
+
+	return nil
+}
+
+// encode returns the line of an entry: the checksum of its JSON encoding
+// followed by the JSON encoding
+func encode(entry *Entry) ([]byte, error) {
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to encode journal entry: %s", err)
+	}
+
+	line := make([]byte, 0, len(data)+10)
+	line = append(line, fmt.Sprintf("%08x ", crc32.ChecksumIEEE(data))...)
+	line = append(line, data...)
+
+	return append(line, '\n'), nil
+}
+
+// decode returns the entry of a line without its trailing newline
+func decode(line []byte) (*Entry, error) {
+
+	if len(line) < 9 || line[8] != ' ' {
+		return nil, fmt.Errorf("Invalid journal entry")
+	}
+
+	checksum, err := strconv.ParseUint(string(line[:8]), 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid journal entry checksum: %s", err)
+	}
+
+	data := line[9:]
+	if crc32.ChecksumIEEE(data) != uint32(checksum) {
+		return nil, fmt.Errorf("Journal entry checksum mismatch")
+	}
+
+	entry := &Entry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil, fmt.Errorf("Invalid journal entry: %s", err)
+	}
+
+	return entry, nil
+}
+
+// segmentPath returns the path of a segment
+func segmentPath(directory string, segment uint64) string {
+	return filepath.Join(directory, fmt.Sprintf("%020d%s", segment, segmentSuffix))
+}
+
+// listSegments returns the segments of a directory, oldest first
+func listSegments(directory string) ([]uint64, error) {
+
+	files, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list the journal segments: %s", err)
+	}
+
+	segments := []uint64{}
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		segment, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, segment)
+	}
+
+	sort.Slice(segments, func(i, k int) bool { return segments[i] < segments[k] })
+
+	return segments, nil
+}
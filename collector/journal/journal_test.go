@@ -0,0 +1,133 @@
+package journal
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// replayAll returns the context IDs of the entries of a journal from an offset
+func replayAll(dir string, from Offset) ([]string, Offset) {
+
+	ids := []string{}
+	next, err := NewReader(dir).Replay(from, func(e *Entry) error {
+		if e.Flow != nil {
+			ids = append(ids, e.Flow.ContextID)
+		} else {
+			ids = append(ids, "container:"+e.Container.ContextID)
+		}
+		return nil
+	})
+	So(err, ShouldBeNil)
+
+	return ids, next
+}
+
+func TestJournal(t *testing.T) {
+
+	Convey("Given a journal directory", t, func() {
+		dir, err := ioutil.TempDir("", "journal")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir) // nolint
+
+		Convey("When I open a journal without a directory, it should fail", func() {
+			_, err := NewJournal(&Config{})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When I collect records, they should be replayed in order", func() {
+			j, err := NewJournal(&Config{Directory: dir})
+			So(err, ShouldBeNil)
+
+			flow := &collector.FlowRecord{
+				ContextID:   "pu1",
+				Count:       1,
+				Source:      &collector.EndPoint{ID: "src", IP: "10.0.0.1", Port: 1000},
+				Destination: &collector.EndPoint{ID: "pu1", IP: "10.0.0.2", Port: 80, Type: collector.PU},
+				Tags:        &policy.TagStore{Tags: []string{"app=web"}},
+				Action:      policy.Accept,
+				PolicyID:    "policy",
+			}
+			j.CollectFlowEvent(flow)
+			j.CollectContainerEvent(&collector.ContainerRecord{ContextID: "pu1", Event: collector.ContainerStart})
+			j.CollectFlowEvent(&collector.FlowRecord{ContextID: "pu2"})
+
+			entries := []*Entry{}
+			next, err := NewReader(dir).Replay(Offset{}, func(e *Entry) error {
+				entries = append(entries, e)
+				return nil
+			})
+			So(err, ShouldBeNil)
+			So(len(entries), ShouldEqual, 3)
+			So(entries[0].Flow, ShouldResemble, flow)
+			So(entries[1].Container.Event, ShouldEqual, collector.ContainerStart)
+			So(entries[1].Offset, ShouldResemble, entries[0].Next)
+			So(next, ShouldResemble, entries[2].Next)
+
+			Convey("A replay from the offset of an entry should resume at it", func() {
+				ids, _ := replayAll(dir, entries[1].Offset)
+				So(ids, ShouldResemble, []string{"container:pu1", "pu2"})
+			})
+
+			Convey("A replay from the end should resume with the new records", func() {
+				j.CollectFlowEvent(&collector.FlowRecord{ContextID: "pu3"})
+				ids, _ := replayAll(dir, next)
+				So(ids, ShouldResemble, []string{"pu3"})
+			})
+
+			Convey("When I reopen the journal, the new records should be appended to a new segment", func() {
+				So(j.Close(), ShouldBeNil)
+				So(j.Append(&Entry{}), ShouldNotBeNil)
+
+				j, err = NewJournal(&Config{Directory: dir})
+				So(err, ShouldBeNil)
+				j.CollectFlowEvent(&collector.FlowRecord{ContextID: "pu3"})
+
+				ids, end := replayAll(dir, next)
+				So(ids, ShouldResemble, []string{"pu3"})
+				So(end.Segment, ShouldEqual, next.Segment+1)
+			})
+		})
+
+		Convey("When the segments are full, the journal should rotate and remove the oldest segments", func() {
+			j, err := NewJournal(&Config{Directory: dir, MaxSize: 1, MaxSegments: 2, Sync: true})
+			So(err, ShouldBeNil)
+			defer j.Close() // nolint
+
+			for _, id := range []string{"pu1", "pu2", "pu3"} {
+				j.CollectFlowEvent(&collector.FlowRecord{ContextID: id})
+			}
+
+			segments, err := listSegments(dir)
+			So(err, ShouldBeNil)
+			So(segments, ShouldResemble, []uint64{2, 3})
+
+			ids, _ := replayAll(dir, Offset{Segment: 1})
+			So(ids, ShouldResemble, []string{"pu2", "pu3"})
+		})
+
+		Convey("When a segment is corrupted, the corrupted entries should be skipped", func() {
+			j, err := NewJournal(&Config{Directory: dir})
+			So(err, ShouldBeNil)
+			for _, id := range []string{"pu1", "pu2", "pu3"} {
+				j.CollectFlowEvent(&collector.FlowRecord{ContextID: id})
+			}
+			So(j.Close(), ShouldBeNil)
+
+			path := segmentPath(dir, 1)
+			data, err := ioutil.ReadFile(path)
+			So(err, ShouldBeNil)
+			i := len(data) / 2
+			data[i] = data[i] + 1
+			So(ioutil.WriteFile(path, append(data, "0000"...), 0600), ShouldBeNil)
+
+			ids, next := replayAll(dir, Offset{})
+			So(ids, ShouldResemble, []string{"pu1", "pu3"})
+			So(next.Position, ShouldEqual, len(data))
+		})
+	})
+}
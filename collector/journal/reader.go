@@ -0,0 +1,134 @@
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DefaultPollInterval is the default interval at which a tailing reader
+// looks for new entries
+const DefaultPollInterval = time.Second
+
+// Reader reads the entries of a journal
+type Reader struct {
+	directory    string
+	pollInterval time.Duration
+}
+
+// NewReader returns a Reader of the journal of a directory
+func NewReader(directory string) *Reader {
+
+	return &Reader{
+		directory:    directory,
+		pollInterval: DefaultPollInterval,
+	}
+}
+
+// Replay calls fn with the entries of the journal from an offset, in order,
+// until the end of the journal. The corrupted entries are skipped. If the
+// segment of the offset was removed, the replay starts at the oldest
+// segment. Replay returns the offset of the end of the journal, where a
+// later replay can resume, or the offset of the entry for which fn failed.
+func (r *Reader) Replay(from Offset, fn func(*Entry) error) (Offset, error) {
+
+	segments, err := listSegments(r.directory)
+	if err != nil {
+		return from, err
+	}
+
+	for i, segment := range segments {
+		if segment < from.Segment {
+			continue
+		}
+
+		if segment > from.Segment {
+			from = Offset{Segment: segment}
+		}
+
+		last := i == len(segments)-1
+		if from, err = r.replaySegment(from, last, fn); err != nil {
+			return from, err
+		}
+	}
+
+	return from, nil
+}
+
+// Tail replays the journal from an offset and then calls fn with the new
+// entries as they are appended, until stop is closed or fn fails
+func (r *Reader) Tail(stop <-chan struct{}, from Offset, fn func(*Entry) error) (Offset, error) {
+
+	for {
+		var err error
+		if from, err = r.Replay(from, fn); err != nil {
+			return from, err
+		}
+
+		select {
+		case <-stop:
+			return from, nil
+		case <-time.After(r.pollInterval):
+		}
+	}
+}
+
+// replaySegment replays a segment from an offset. An incomplete entry at the
+// end of the last segment may still be being written: the replay stops
+// before it.
+func (r *Reader) replaySegment(from Offset, last bool, fn func(*Entry) error) (Offset, error) {
+
+	file, err := os.Open(segmentPath(r.directory, from.Segment))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return from, nil
+		}
+		return from, fmt.Errorf("Unable to open journal segment: %s", err)
+	}
+	defer file.Close() // nolint
+
+	if _, err := file.Seek(from.Position, io.SeekStart); err != nil {
+		return from, fmt.Errorf("Unable to seek journal segment: %s", err)
+	}
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 && !last {
+				zap.L().Warn("Skipping incomplete journal entry", zap.Uint64("segment", from.Segment))
+			}
+			return from, nil
+		}
+		if err != nil {
+			return from, fmt.Errorf("Unable to read journal segment: %s", err)
+		}
+
+		next := Offset{Segment: from.Segment, Position: from.Position + int64(len(line))}
+
+		entry, err := decode(bytes.TrimSuffix(line, []byte{'\n'}))
+		if err != nil {
+			zap.L().Warn("Skipping corrupted journal entry",
+				zap.Uint64("segment", from.Segment),
+				zap.Int64("position", from.Position),
+				zap.Error(err),
+			)
+			from = next
+			continue
+		}
+
+		entry.Offset = from
+		entry.Next = next
+
+		if err := fn(entry); err != nil {
+			return from, err
+		}
+
+		from = next
+	}
+}
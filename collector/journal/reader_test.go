@@ -0,0 +1,80 @@
+package journal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme/collector"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTail(t *testing.T) {
+
+	Convey("Given a journal and a tailing reader", t, func() {
+		dir, err := ioutil.TempDir("", "journal")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir) // nolint
+
+		j, err := NewJournal(&Config{Directory: dir, MaxSize: 200})
+		So(err, ShouldBeNil)
+		defer j.Close() // nolint
+
+		j.CollectFlowEvent(&collector.FlowRecord{ContextID: "pu0"})
+
+		r := NewReader(dir)
+		r.pollInterval = time.Millisecond
+
+		entries := make(chan string, 10)
+		stop := make(chan struct{})
+		done := make(chan error, 1)
+		go func() {
+			_, err := r.Tail(stop, Offset{}, func(e *Entry) error {
+				entries <- e.Flow.ContextID
+				return nil
+			})
+			done <- err
+		}()
+
+		receive := func() string {
+			select {
+			case id := <-entries:
+				return id
+			case <-time.After(5 * time.Second):
+				return ""
+			}
+		}
+
+		Convey("When I collect records across segments, they should be tailed in order", func() {
+			So(receive(), ShouldEqual, "pu0")
+			for i := 1; i < 5; i++ {
+				j.CollectFlowEvent(&collector.FlowRecord{ContextID: fmt.Sprintf("pu%d", i)})
+			}
+			for i := 1; i < 5; i++ {
+				So(receive(), ShouldEqual, fmt.Sprintf("pu%d", i))
+			}
+
+			segments, err := listSegments(dir)
+			So(err, ShouldBeNil)
+			So(len(segments), ShouldBeGreaterThan, 1)
+
+			close(stop)
+			So(<-done, ShouldBeNil)
+		})
+
+		Convey("When the callback fails, the tail should stop with its error", func() {
+			So(receive(), ShouldEqual, "pu0")
+			r2 := NewReader(dir)
+			next, err := r2.Tail(nil, Offset{}, func(e *Entry) error {
+				return fmt.Errorf("failed")
+			})
+			So(err, ShouldNotBeNil)
+			So(next, ShouldResemble, Offset{Segment: 1})
+
+			close(stop)
+			So(<-done, ShouldBeNil)
+		})
+	})
+}
@@ -6,6 +6,9 @@ const (
 
 	// DefaultDockerSocketType is unix
 	DefaultDockerSocketType = "unix"
+
+	// DefaultCRISocket is the default socket to use to communicate with a CRI runtime
+	DefaultCRISocket = "/run/containerd/containerd.sock"
 )
 
 // ModeType defines the mode of the enforcement and supervisor.
@@ -30,6 +33,8 @@ const (
 	IPSets ImplementationType = iota
 	// IPTables mandates an IPTable supervisor implementation
 	IPTables
+	// NFTables mandates a native nftables supervisor implementation
+	NFTables
 	// Remote indicates that this is a remote supervisor
 )
 
@@ -48,4 +53,10 @@ const (
 	DefaultRemoteArg = "enforce"
 	// DefaultConnMark is the default conn mark for all data packets
 	DefaultConnMark = uint32(0xEEEE)
+	// EncryptConnMark is the conn mark for data packets of encrypted flows.
+	// These packets are always processed by the enforcer
+	EncryptConnMark = uint32(0xEEEF)
+	// RevokedConnMark is the conn mark for flows revoked by a policy update.
+	// Their packets are processed by the enforcer and reset.
+	RevokedConnMark = uint32(0xEEED)
 )
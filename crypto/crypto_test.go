@@ -1,6 +1,7 @@
 package crypto
 
 import (
+	"crypto/elliptic"
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
@@ -45,6 +46,35 @@ func TestComputeVerifyHMAC(t *testing.T) {
 	})
 }
 
+// TestEphemeralKeyExchange tests the creation of ephemeral keys and the shared secret computation
+func TestEphemeralKeyExchange(t *testing.T) {
+	Convey("Given two ephemeral keys", t, func() {
+		local, localPub := CreateEphemeralKey(elliptic.P256, nil)
+		remote, remotePub := CreateEphemeralKey(elliptic.P256, nil)
+		So(local, ShouldNotBeNil)
+		So(remote, ShouldNotBeNil)
+
+		Convey("Both sides should compute the same shared secret", func() {
+			secret1, err1 := ComputeSharedSecret(local, remotePub)
+			secret2, err2 := ComputeSharedSecret(remote, localPub)
+			So(err1, ShouldBeNil)
+			So(err2, ShouldBeNil)
+			So(len(secret1), ShouldEqual, 32)
+			So(secret1, ShouldResemble, secret2)
+		})
+
+		Convey("If I provide an invalid remote key, I should get an error", func() {
+			_, err := ComputeSharedSecret(local, []byte("bad key"))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("If I provide no private key, I should get an error", func() {
+			_, err := ComputeSharedSecret(nil, remotePub)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
 // TestRandomString tests the random string generation function and the random byte generation
 func TestRandomString(t *testing.T) {
 	Convey("Given a string length of 16", t, func() {
@@ -0,0 +1,8 @@
+package enforcer
+
+// conntrackAccounting retrieves the accounting counters of a flow. The
+// kernel only maintains them when net.netfilter.nf_conntrack_acct is
+// enabled.
+type conntrackAccounting interface {
+	ConntrackTableGetCounters(ipSrc, ipDst string, protonum uint8, srcport, dstport uint16) (origPackets, origBytes, replyPackets, replyBytes uint64, err error)
+}
@@ -15,6 +15,7 @@ type PortAction struct {
 	min    uint16
 	max    uint16
 	policy *policy.FlowPolicy
+	rule   policy.IPRule
 }
 
 // PortActionList is a list of Port Actions
@@ -22,14 +23,25 @@ type PortActionList []*PortAction
 
 // ACLCache holds all the ACLS in an internal DB
 // map[prefixes][subnets] -> list of ports with their actions
+// IPv6 rules are kept in a separate map indexed by prefix length
 type ACLCache struct {
-	prefixMap map[uint32]map[uint32]PortActionList
+	protocol   string
+	prefixMap  map[uint32]map[uint32]PortActionList
+	prefixMap6 map[int]map[[net.IPv6len]byte]PortActionList
 }
 
-// NewACLCache creates a new ACL cache
+// NewACLCache creates a new ACL cache for TCP rules
 func NewACLCache() *ACLCache {
+	return NewACLCacheWithProtocol("tcp")
+}
+
+// NewACLCacheWithProtocol creates a new ACL cache that only holds the
+// rules of the given protocol (tcp or udp)
+func NewACLCacheWithProtocol(protocol string) *ACLCache {
 	return &ACLCache{
-		prefixMap: make(map[uint32]map[uint32]PortActionList),
+		protocol:   strings.ToLower(protocol),
+		prefixMap:  make(map[uint32]map[uint32]PortActionList),
+		prefixMap6: make(map[int]map[[net.IPv6len]byte]PortActionList),
 	}
 }
 
@@ -71,6 +83,7 @@ func createPortAction(rule policy.IPRule) *PortAction {
 	}
 
 	p.policy = rule.Policy
+	p.rule = rule
 
 	return p
 }
@@ -79,7 +92,7 @@ func createPortAction(rule policy.IPRule) *PortAction {
 func (c *ACLCache) AddRule(rule policy.IPRule) (err error) {
 	var subnet, mask uint32
 
-	if strings.ToLower(rule.Protocol) != "tcp" {
+	if strings.ToLower(rule.Protocol) != c.protocol {
 		return nil
 	}
 
@@ -90,6 +103,10 @@ func (c *ACLCache) AddRule(rule policy.IPRule) (err error) {
 		return fmt.Errorf("Invalid address")
 	}
 
+	if subnetSlice.To4() == nil {
+		return c.addIPv6Rule(subnetSlice, parts, rule)
+	}
+
 	subnet = binary.BigEndian.Uint32(subnetSlice.To4())
 
 	switch len(parts) {
@@ -121,6 +138,48 @@ func (c *ACLCache) AddRule(rule policy.IPRule) (err error) {
 	return nil
 }
 
+// addIPv6Rule adds a single IPv6 rule to the ACL Cache
+func (c *ACLCache) addIPv6Rule(subnetSlice net.IP, parts []string, rule policy.IPRule) error {
+
+	prefix := 8 * net.IPv6len
+
+	switch len(parts) {
+	case 1:
+	case 2:
+		maskvalue, err := strconv.Atoi(parts[1])
+		if err != nil || maskvalue < 0 || maskvalue > 8*net.IPv6len {
+			return fmt.Errorf("Invalid address")
+		}
+		prefix = maskvalue
+	default:
+		return fmt.Errorf("Invalid address")
+	}
+
+	a := createPortAction(rule)
+	if a == nil {
+		return fmt.Errorf("Invalid port")
+	}
+
+	if _, ok := c.prefixMap6[prefix]; !ok {
+		c.prefixMap6[prefix] = make(map[[net.IPv6len]byte]PortActionList)
+	}
+
+	subnet := maskIPv6(subnetSlice, prefix)
+
+	c.prefixMap6[prefix][subnet] = append(c.prefixMap6[prefix][subnet], a)
+
+	return nil
+}
+
+// maskIPv6 returns the subnet of the address for the given prefix length
+func maskIPv6(ip net.IP, prefix int) [net.IPv6len]byte {
+
+	var subnet [net.IPv6len]byte
+	copy(subnet[:], ip.Mask(net.CIDRMask(prefix, 8*net.IPv6len)))
+
+	return subnet
+}
+
 // AddRuleList adds a list of rules to the cache
 func (c *ACLCache) AddRuleList(rules policy.IPRuleList) (err error) {
 
@@ -137,21 +196,61 @@ func (c *ACLCache) AddRuleList(rules policy.IPRuleList) (err error) {
 // GetMatchingAction gets the matching action
 func (c *ACLCache) GetMatchingAction(ip []byte, port uint16) (*policy.FlowPolicy, error) {
 
-	addr := binary.BigEndian.Uint32(ip)
-	// Iterate over all the bitmasks we have
-	for bitmask, pmap := range c.prefixMap {
+	if p := c.matchingPortAction(ip, port); p != nil {
+		return p.policy, nil
+	}
+
+	return &policy.FlowPolicy{Action: policy.Reject, PolicyID: "default", ServiceID: "default"}, fmt.Errorf("No match")
+}
+
+// GetMatchingRule returns the rule that provides the matching action
+func (c *ACLCache) GetMatchingRule(ip []byte, port uint16) (*policy.IPRule, error) {
+
+	if p := c.matchingPortAction(ip, port); p != nil {
+		rule := p.rule
+		return &rule, nil
+	}
+
+	return nil, fmt.Errorf("No match")
+}
 
-		// Do a lookup as a hash to see if we have a match
-		if actionList, ok := pmap[addr&bitmask]; ok {
+// matchingPortAction returns the action of the rule that matches the address and port
+func (c *ACLCache) matchingPortAction(ip []byte, port uint16) *PortAction {
 
-			// Scan the ports - TODO: better algorithm needed hefe
-			for _, p := range actionList {
-				if port >= p.min && port <= p.max {
-					return p.policy, nil
+	if ip4 := net.IP(ip).To4(); ip4 != nil {
+		addr := binary.BigEndian.Uint32(ip4)
+		// Iterate over all the bitmasks we have
+		for bitmask, pmap := range c.prefixMap {
+
+			// Do a lookup as a hash to see if we have a match
+			if actionList, ok := pmap[addr&bitmask]; ok {
+				if p := actionList.match(port); p != nil {
+					return p
+				}
+			}
+		}
+	} else if len(ip) == net.IPv6len {
+		for prefix, pmap := range c.prefixMap6 {
+			if actionList, ok := pmap[maskIPv6(net.IP(ip), prefix)]; ok {
+				if p := actionList.match(port); p != nil {
+					return p
 				}
 			}
 		}
 	}
 
-	return &policy.FlowPolicy{Action: policy.Reject, PolicyID: "default", ServiceID: "default"}, fmt.Errorf("No match")
+	return nil
+}
+
+// match returns the first action that includes the port
+func (l PortActionList) match(port uint16) *PortAction {
+
+	// Scan the ports - TODO: better algorithm needed hefe
+	for _, p := range l {
+		if port >= p.min && port <= p.max {
+			return p
+		}
+	}
+
+	return nil
 }
@@ -100,3 +100,105 @@ func TestLookup(t *testing.T) {
 
 	})
 }
+
+func TestUDPLookup(t *testing.T) {
+
+	Convey("Given a good DB for UDP rules", t, func() {
+		c := NewACLCacheWithProtocol("udp")
+		err := c.AddRuleList(rules)
+		So(err, ShouldBeNil)
+		So(len(c.prefixMap), ShouldEqual, 1)
+
+		Convey("When I lookup for a matching UDP rule, I should get the right action", func() {
+			ip := net.ParseIP("192.168.100.1")
+			port := uint16(443)
+			a, err := c.GetMatchingAction(ip.To4(), port)
+			So(err, ShouldBeNil)
+			So(a.Action, ShouldEqual, policy.Accept)
+			So(a.PolicyID, ShouldEqual, "5")
+		})
+
+		Convey("When I lookup for an address that only matches a TCP rule, I should get reject", func() {
+			ip := net.ParseIP("10.1.1.1")
+			port := uint16(80)
+			a, err := c.GetMatchingAction(ip.To4(), port)
+			So(err, ShouldNotBeNil)
+			So(a.Action, ShouldEqual, policy.Reject)
+		})
+	})
+}
+
+func TestIPv6Lookup(t *testing.T) {
+
+	Convey("Given a DB with IPv6 rules", t, func() {
+		c := NewACLCache()
+		err := c.AddRuleList(policy.IPRuleList{
+			policy.IPRule{
+				Address:  "2001:db8::/32",
+				Protocol: "tcp",
+				Port:     "80",
+				Policy: &policy.FlowPolicy{
+					Action:   policy.Accept,
+					PolicyID: "1"},
+			},
+			policy.IPRule{
+				Address:  "2001:db9::1",
+				Protocol: "tcp",
+				Port:     "443",
+				Policy: &policy.FlowPolicy{
+					Action:   policy.Accept,
+					PolicyID: "2"},
+			},
+		})
+		So(err, ShouldBeNil)
+		So(len(c.prefixMap), ShouldEqual, 0)
+		So(len(c.prefixMap6), ShouldEqual, 2)
+
+		Convey("When I lookup for an address in a matching subnet, I should get the right action", func() {
+			a, err := c.GetMatchingAction(net.ParseIP("2001:db8:1::5"), 80)
+			So(err, ShouldBeNil)
+			So(a.PolicyID, ShouldEqual, "1")
+		})
+
+		Convey("When I lookup for a matching exact address, I should get the right action", func() {
+			a, err := c.GetMatchingAction(net.ParseIP("2001:db9::1"), 443)
+			So(err, ShouldBeNil)
+			So(a.PolicyID, ShouldEqual, "2")
+		})
+
+		Convey("When I lookup for a non matching address, I should get reject", func() {
+			a, err := c.GetMatchingAction(net.ParseIP("2001:db9::2"), 443)
+			So(err, ShouldNotBeNil)
+			So(a.Action, ShouldEqual, policy.Reject)
+		})
+
+		Convey("When I lookup for an IPv4 address, I should get reject", func() {
+			a, err := c.GetMatchingAction(net.ParseIP("10.1.1.1").To4(), 80)
+			So(err, ShouldNotBeNil)
+			So(a.Action, ShouldEqual, policy.Reject)
+		})
+	})
+}
+
+func TestGetMatchingRule(t *testing.T) {
+
+	Convey("Given a good DB", t, func() {
+		c := NewACLCache()
+		err := c.AddRuleList(rules)
+		So(err, ShouldBeNil)
+
+		Convey("When I lookup for a matching address and port, I should get the rule", func() {
+			r, err := c.GetMatchingRule(net.ParseIP("192.168.100.1"), 80)
+			So(err, ShouldBeNil)
+			So(r.Address, ShouldEqual, "192.168.100.0/24")
+			So(r.Port, ShouldEqual, "80")
+			So(r.Policy.PolicyID, ShouldEqual, "2")
+		})
+
+		Convey("When I lookup for a non matching address, I should get an error", func() {
+			r, err := c.GetMatchingRule(net.ParseIP("192.168.200.1"), 80)
+			So(err, ShouldNotBeNil)
+			So(r, ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,158 @@
+package enforcer
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme/enforcer/utils/packet"
+	"github.com/aporeto-inc/trireme/enforcer/utils/pcap"
+)
+
+const (
+	// stageReceived annotates the packets as received from the queues
+	stageReceived = "received"
+	// stageAccepted annotates the packets accepted by the datapath
+	stageAccepted = "accepted"
+	// stageDropped annotates the packets dropped by the datapath
+	stageDropped = "dropped"
+)
+
+// CaptureFilter selects the packets captured by the datapath. The fields that
+// are not set match all the packets.
+type CaptureFilter struct {
+	// ContextID selects the packets of a PU
+	ContextID string
+	// SourceIP, DestinationIP, SourcePort, DestinationPort and Protocol
+	// select the packets of a flow
+	SourceIP        string
+	DestinationIP   string
+	SourcePort      uint16
+	DestinationPort uint16
+	Protocol        uint8
+}
+
+// packetCapture writes the packets selected by the filter
+type packetCapture struct {
+	filter *CaptureFilter
+	writer *pcap.Writer
+	sync.Mutex
+}
+
+// StartCapture writes the packets selected by the filter to w in the pcapng
+// format. Every packet is written twice: as received from the queue, before
+// the tokens are attached or removed, and with the verdict of the datapath.
+// The comment of the packets is the direction ("application" or "network")
+// followed by the stage ("received", "accepted" or "dropped: <reason>").
+func (d *Datapath) StartCapture(filter *CaptureFilter, w io.Writer) error {
+
+	d.captureLock.Lock()
+	defer d.captureLock.Unlock()
+
+	if d.capture != nil {
+		return fmt.Errorf("A capture is already running")
+	}
+
+	writer, err := pcap.NewWriter(w)
+	if err != nil {
+		return fmt.Errorf("Unable to start capture: %s", err)
+	}
+
+	if filter == nil {
+		filter = &CaptureFilter{}
+	}
+
+	d.capture = &packetCapture{
+		filter: filter,
+		writer: writer,
+	}
+
+	return nil
+}
+
+// StopCapture stops the running capture. The writer is not closed.
+func (d *Datapath) StopCapture() error {
+
+	d.captureLock.Lock()
+	defer d.captureLock.Unlock()
+
+	if d.capture == nil {
+		return fmt.Errorf("No capture is running")
+	}
+
+	d.capture = nil
+
+	return nil
+}
+
+// capturePacket writes the packet as received if it is selected by the filter
+// of the running capture. It returns the capture that must be given the
+// verdict of the packet, or nil if the packet is not captured.
+func (d *Datapath) capturePacket(app bool, p *packet.Packet) *packetCapture {
+
+	d.captureLock.RLock()
+	c := d.capture
+	d.captureLock.RUnlock()
+
+	if c == nil || p == nil {
+		return nil
+	}
+
+	f := c.filter
+
+	if (f.SourceIP != "" && f.SourceIP != p.SourceAddress.String()) ||
+		(f.DestinationIP != "" && f.DestinationIP != p.DestinationAddress.String()) ||
+		(f.SourcePort != 0 && f.SourcePort != p.SourcePort) ||
+		(f.DestinationPort != 0 && f.DestinationPort != p.DestinationPort) ||
+		(f.Protocol != 0 && f.Protocol != p.IPProto) {
+		return nil
+	}
+
+	if f.ContextID != "" {
+		var context *PUContext
+		var err error
+		if app {
+			context, err = d.contextFromIP(true, p.SourceAddress.String(), p.Mark, strconv.Itoa(int(p.SourcePort)))
+		} else {
+			context, err = d.contextFromIP(false, p.DestinationAddress.String(), p.Mark, strconv.Itoa(int(p.DestinationPort)))
+		}
+		if err != nil || context.ID != f.ContextID {
+			return nil
+		}
+	}
+
+	c.write(app, p, stageReceived)
+
+	return c
+}
+
+// write writes a packet with its direction and stage
+func (c *packetCapture) write(app bool, p *packet.Packet, stage string) {
+
+	direction := "network"
+	if app {
+		direction = "application"
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if err := c.writer.WritePacket(time.Now(), p.GetBytes(), direction+" "+stage); err != nil {
+		zap.L().Warn("Unable to capture packet", zap.Error(err))
+	}
+}
+
+// verdict writes the packet with the verdict of the datapath
+func (c *packetCapture) verdict(app bool, p *packet.Packet, err error) {
+
+	if err != nil {
+		c.write(app, p, stageDropped+": "+err.Error())
+		return
+	}
+
+	c.write(app, p, stageAccepted)
+}
@@ -0,0 +1,99 @@
+package enforcer
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/aporeto-inc/trireme/enforcer/utils/packetgen"
+	"github.com/aporeto-inc/trireme/enforcer/utils/pcap"
+	"github.com/aporeto-inc/trireme/enforcer/utils/secrets"
+	"github.com/aporeto-inc/trireme/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCapture(t *testing.T) {
+
+	Convey("Given a harness with a flow between a client and a server PU", t, func() {
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+		flow := packetgen.NewTCPPacketFlow("aa:ff:aa:ff:aa:ff", "ff:aa:ff:aa:ff:aa", "10.1.10.76", "164.67.228.152", 666, 80).GenerateTCPFlow(packetgen.PacketFlowTypeGoodFlow)
+
+		h, err := NewHarness(harnessPU("client", "10.1.10.76", policy.Accept), harnessPU("server", "164.67.228.152", policy.Accept), nil, nil, secret)
+		So(err, ShouldBeNil)
+
+		Convey("When I stop a capture that was not started, I should get an error", func() {
+			So(h.Client.StopCapture(), ShouldNotBeNil)
+		})
+
+		Convey("When I capture the packets of the client PU", func() {
+			var buffer bytes.Buffer
+			So(h.Client.StartCapture(&CaptureFilter{ContextID: "client"}, &buffer), ShouldBeNil)
+			So(h.Client.StartCapture(&CaptureFilter{}, &buffer), ShouldNotBeNil)
+
+			_, err := h.Run(flow)
+			So(err, ShouldBeNil)
+			So(h.Client.StopCapture(), ShouldBeNil)
+
+			records, err := pcap.ReadAll(bytes.NewReader(buffer.Bytes()))
+			So(err, ShouldBeNil)
+
+			Convey("Every packet should be captured before and after the processing", func() {
+				So(len(records), ShouldEqual, 6)
+				So(records[0].Comment, ShouldEqual, "application received")
+				So(records[1].Comment, ShouldEqual, "application accepted")
+				So(records[2].Comment, ShouldEqual, "network received")
+				So(records[3].Comment, ShouldEqual, "network accepted")
+				So(records[4].Comment, ShouldEqual, "application received")
+				So(records[5].Comment, ShouldEqual, "application accepted")
+			})
+
+			Convey("The token should be visible on the wire only", func() {
+				So(len(records[1].Data), ShouldBeGreaterThan, len(records[0].Data))
+				So(len(records[2].Data), ShouldBeGreaterThan, len(records[3].Data))
+			})
+
+			Convey("When I replay the capture in another harness, the flow should be accepted", func() {
+				replay, err := NewHarness(harnessPU("client", "10.1.10.76", policy.Accept), harnessPU("server", "164.67.228.152", policy.Accept), nil, nil, secret)
+				So(err, ShouldBeNil)
+
+				result, err := replay.Replay(bytes.NewReader(buffer.Bytes()))
+				So(err, ShouldBeNil)
+				So(len(result.Packets), ShouldEqual, 3)
+				So(result.Accepted(), ShouldBeTrue)
+				So(len(result.Flows), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When I capture the packets of the rejected flows of the server", func() {
+			reject, err := NewHarness(harnessPU("client", "10.1.10.76", policy.Accept), harnessPU("server", "164.67.228.152", policy.Reject), nil, nil, secret)
+			So(err, ShouldBeNil)
+
+			var buffer bytes.Buffer
+			So(reject.Server.StartCapture(&CaptureFilter{DestinationPort: 80}, &buffer), ShouldBeNil)
+
+			_, err = reject.Run(flow)
+			So(err, ShouldBeNil)
+
+			records, err := pcap.ReadAll(&buffer)
+			So(err, ShouldBeNil)
+
+			Convey("The verdict should explain the drop", func() {
+				So(len(records), ShouldEqual, 2)
+				So(records[1].Comment, ShouldStartWith, "network dropped: ")
+				So(strings.Contains(records[1].Comment, "policy"), ShouldBeTrue)
+			})
+		})
+
+		Convey("When the filter does not match the flow, nothing should be captured", func() {
+			var buffer bytes.Buffer
+			So(h.Client.StartCapture(&CaptureFilter{SourcePort: 1000}, &buffer), ShouldBeNil)
+
+			_, err := h.Run(flow)
+			So(err, ShouldBeNil)
+
+			records, err := pcap.ReadAll(&buffer)
+			So(err, ShouldBeNil)
+			So(records, ShouldBeEmpty)
+		})
+	})
+}
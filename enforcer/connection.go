@@ -1,6 +1,7 @@
 package enforcer
 
 import (
+	"crypto/ecdsa"
 	"fmt"
 	"sync"
 	"time"
@@ -8,6 +9,7 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/aporeto-inc/trireme/cache"
+	"github.com/aporeto-inc/trireme/collector"
 	"github.com/aporeto-inc/trireme/policy"
 )
 
@@ -60,6 +62,10 @@ type AuthInfo struct {
 	RemotePublicKey interface{}
 	RemoteIP        string
 	RemotePort      string
+	// LocalEphemeralKey is the ephemeral key used for the encryption key exchange
+	LocalEphemeralKey *ecdsa.PrivateKey
+	// RemoteEphemeralKey is the public ephemeral key received from the remote
+	RemoteEphemeralKey []byte
 }
 
 // TCPConnection is information regarding TCP Connection
@@ -91,6 +97,26 @@ type TCPConnection struct {
 
 	// FlowPolicy holds the last matched policy
 	FlowPolicy *policy.FlowPolicy
+
+	// txCipher and rxCipher encrypt the payload of encrypted connections
+	txCipher *flowCipher
+	rxCipher *flowCipher
+
+	// startTime is the time the connection was first seen
+	startTime time.Time
+
+	// lifecycle tracks the connection after the negotiation is completed
+	lifecycle *flowLifecycle
+
+	// remoteTags are the claims of the remote used for the policy decision
+	remoteTags *policy.TagStore
+
+	// revoked indicates that the policy does not allow the connection any more
+	revoked bool
+
+	// audited indicates that the policy rejected the connection, but the PU
+	// is in audit mode
+	audited bool
 }
 
 // TCPConnectionExpirationNotifier handles processing the expiration of an element
@@ -193,9 +219,113 @@ func (c *TCPConnection) Cleanup(expiration bool) {
 func NewTCPConnection() *TCPConnection {
 
 	c := &TCPConnection{
-		state: TCPSynSend,
-		logs:  []string{"Initialized"},
+		state:     TCPSynSend,
+		logs:      []string{"Initialized"},
+		startTime: time.Now(),
 	}
 
 	return c
 }
+
+// UDPFlowState identifies the constants of the state of a UDP flow
+type UDPFlowState int
+
+const (
+
+	// UDPStart is the state of a flow where no datagram has been processed
+	UDPStart UDPFlowState = iota
+
+	// UDPSynSend is the state where the Syn token has been send, but no response has been received
+	UDPSynSend
+
+	// UDPSynReceived indicates that a datagram with a Syn token has been received
+	UDPSynReceived
+
+	// UDPSynAckSend indicates that the SynAck token has been send
+	UDPSynAckSend
+
+	// UDPSynAckReceived is the state where the SynAck token has been received
+	UDPSynAckReceived
+
+	// UDPAckSend indicates that the Ack token has been send
+	UDPAckSend
+
+	// UDPData indicates that the negotiation has been completed and the datagrams carry no tokens
+	UDPData
+)
+
+// UDPConnection is information regarding a UDP flow. UDP has no flags to
+// carry the negotiation, so the tokens are piggybacked on the first datagrams
+// of the flow in both directions.
+type UDPConnection struct {
+	sync.Mutex
+
+	state UDPFlowState
+	Auth  AuthInfo
+
+	// Context is the PUContext that is associated with this connection
+	Context *PUContext
+
+	// FlowPolicy holds the last matched policy
+	FlowPolicy *policy.FlowPolicy
+
+	// token is the last token generated for this flow. It is re-transmitted
+	// until the other side moves the negotiation forward so that the nonces
+	// stay stable when datagrams are lost.
+	token []byte
+
+	// reported indicates that the flow has been reported to the collector
+	reported bool
+
+	// marked indicates that the conntrack entry has been marked
+	marked bool
+
+	// source and destination are the addresses and ports of the initiator
+	// and the responder of the flow
+	source      collector.EndPoint
+	destination collector.EndPoint
+
+	// remoteTags are the claims of the remote used for the policy decision
+	remoteTags *policy.TagStore
+
+	// revoked indicates that the policy does not allow the flow any more
+	revoked bool
+}
+
+// String returns a printable version of connection
+func (c *UDPConnection) String() string {
+
+	return fmt.Sprintf("state:%d auth: %+v", c.state, c.Auth)
+}
+
+// GetState is used to return the state
+func (c *UDPConnection) GetState() UDPFlowState {
+
+	return c.state
+}
+
+// SetState is used to setup the state for the UDP connection
+func (c *UDPConnection) SetState(state UDPFlowState) {
+
+	c.state = state
+}
+
+// SetReported is used to track if a flow is reported
+func (c *UDPConnection) SetReported() {
+
+	c.reported = true
+}
+
+// Reported returns true if the flow has been reported
+func (c *UDPConnection) Reported() bool {
+
+	return c.reported
+}
+
+// NewUDPConnection returns a UDPConnection information struct
+func NewUDPConnection() *UDPConnection {
+
+	return &UDPConnection{
+		state: UDPStart,
+	}
+}
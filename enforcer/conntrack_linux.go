@@ -0,0 +1,311 @@
+// +build linux
+
+package enforcer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// The ctnetlink constants of linux/netfilter/nfnetlink_conntrack.h
+const (
+	netlinkNetfilter      = 12
+	nfnlSubsysCtnetlink   = 1
+	ipctnlMsgCtNew        = 0
+	ipctnlMsgCtGet        = 1
+	ipctnlMsgCtDelete     = 2
+	nfnetlinkV0           = 0
+	nlaFNested            = 0x8000
+	nlaTypeMask           = 0x3fff
+	ctaTupleOrig          = 1
+	ctaTupleIP            = 1
+	ctaTupleProto         = 2
+	ctaIPv4Src            = 1
+	ctaIPv4Dst            = 2
+	ctaIPv6Src            = 3
+	ctaIPv6Dst            = 4
+	ctaProtoNum           = 1
+	ctaProtoSrcPort       = 2
+	ctaProtoDstPort       = 3
+	ctaCountersOrig       = 9
+	ctaCountersReply      = 10
+	ctaCountersPackets    = 1
+	ctaCountersBytes      = 2
+	conntrackReplyTimeout = 2 * time.Second
+)
+
+// nativeEndian is the byte order of the netlink headers
+var nativeEndian binary.ByteOrder = binary.LittleEndian
+
+func init() {
+
+	v := uint16(1)
+	if *(*byte)(unsafe.Pointer(&v)) == 0 {
+		nativeEndian = binary.BigEndian
+	}
+}
+
+// netlinkConntrackDeleter deletes the conntrack entries of the network
+// namespace of the enforcer with ctnetlink
+type netlinkConntrackDeleter struct {
+	seq uint32
+}
+
+// newConntrackDeleter returns the conntrack deleter of the platform
+func newConntrackDeleter() conntrackDeleter {
+
+	return &netlinkConntrackDeleter{}
+}
+
+// ConntrackTableDeleteFlow deletes the conntrack entry of the flow initiated
+// from the source
+func (c *netlinkConntrackDeleter) ConntrackTableDeleteFlow(ipSrc, ipDst string, protonum uint8, srcport, dstport uint16) error {
+
+	msg, err := conntrackDeleteMessage(ipSrc, ipDst, protonum, srcport, dstport, atomic.AddUint32(&c.seq, 1))
+	if err != nil {
+		return err
+	}
+
+	reply, err := conntrackRequest(msg)
+	if err != nil {
+		return err
+	}
+
+	return parseNetlinkAck(reply)
+}
+
+// netlinkConntrackAccounting retrieves the accounting counters of the
+// conntrack entries of the network namespace of the enforcer with ctnetlink
+type netlinkConntrackAccounting struct {
+	seq uint32
+}
+
+// newConntrackAccounting returns the conntrack accounting of the platform
+func newConntrackAccounting() conntrackAccounting {
+
+	return &netlinkConntrackAccounting{}
+}
+
+// ConntrackTableGetCounters returns the counters of the entry of the flow
+// initiated from the source
+func (c *netlinkConntrackAccounting) ConntrackTableGetCounters(ipSrc, ipDst string, protonum uint8, srcport, dstport uint16) (uint64, uint64, uint64, uint64, error) {
+
+	msg, err := conntrackMessage(ipctnlMsgCtGet, 0, ipSrc, ipDst, protonum, srcport, dstport, atomic.AddUint32(&c.seq, 1))
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+
+	reply, err := conntrackRequest(msg)
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+
+	return parseConntrackCounters(reply)
+}
+
+// conntrackRequest sends a ctnetlink request and returns the reply
+func conntrackRequest(msg []byte) ([]byte, error) {
+
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, netlinkNetfilter)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open the netfilter netlink socket: %s", err)
+	}
+	defer syscall.Close(fd) // nolint
+
+	timeout := syscall.NsecToTimeval(conntrackReplyTimeout.Nanoseconds())
+	if err = syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &timeout); err != nil {
+		return nil, fmt.Errorf("Unable to set the timeout of the netlink socket: %s", err)
+	}
+
+	if err = syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return nil, fmt.Errorf("Unable to bind the netlink socket: %s", err)
+	}
+
+	if err = syscall.Sendto(fd, msg, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return nil, fmt.Errorf("Unable to send the conntrack request: %s", err)
+	}
+
+	buf := make([]byte, syscall.Getpagesize())
+	n, _, err := syscall.Recvfrom(fd, buf, 0)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to receive the conntrack reply: %s", err)
+	}
+
+	return buf[:n], nil
+}
+
+// conntrackMessage returns the ctnetlink request of a type for the entry of
+// the original tuple of a flow
+func conntrackMessage(msgType uint16, flags uint16, ipSrc, ipDst string, protonum uint8, srcport, dstport uint16, seq uint32) ([]byte, error) {
+
+	src, dst := net.ParseIP(ipSrc), net.ParseIP(ipDst)
+	if src == nil || dst == nil {
+		return nil, fmt.Errorf("Invalid flow addresses %s %s", ipSrc, ipDst)
+	}
+
+	family, srcType, dstType := uint8(syscall.AF_INET), uint16(ctaIPv4Src), uint16(ctaIPv4Dst)
+	if src.To4() == nil || dst.To4() == nil {
+		family, srcType, dstType = syscall.AF_INET6, ctaIPv6Src, ctaIPv6Dst
+		src, dst = src.To16(), dst.To16()
+	} else {
+		src, dst = src.To4(), dst.To4()
+	}
+
+	ports := make([]byte, 4)
+	binary.BigEndian.PutUint16(ports, srcport)
+	binary.BigEndian.PutUint16(ports[2:], dstport)
+
+	tuple := netlinkAttribute(ctaTupleOrig|nlaFNested,
+		netlinkAttribute(ctaTupleIP|nlaFNested,
+			netlinkAttribute(srcType, src),
+			netlinkAttribute(dstType, dst),
+		),
+		netlinkAttribute(ctaTupleProto|nlaFNested,
+			netlinkAttribute(ctaProtoNum, []byte{protonum}),
+			netlinkAttribute(ctaProtoSrcPort, ports[:2]),
+			netlinkAttribute(ctaProtoDstPort, ports[2:]),
+		),
+	)
+
+	// nlmsghdr followed by nfgenmsg
+	msg := make([]byte, syscall.NLMSG_HDRLEN+4, syscall.NLMSG_HDRLEN+4+len(tuple))
+	nativeEndian.PutUint16(msg[4:], nfnlSubsysCtnetlink<<8|msgType)
+	nativeEndian.PutUint16(msg[6:], syscall.NLM_F_REQUEST|flags)
+	nativeEndian.PutUint32(msg[8:], seq)
+	msg[syscall.NLMSG_HDRLEN] = family
+	msg[syscall.NLMSG_HDRLEN+1] = nfnetlinkV0
+
+	msg = append(msg, tuple...)
+	nativeEndian.PutUint32(msg, uint32(len(msg)))
+
+	return msg, nil
+}
+
+// conntrackDeleteMessage returns the ctnetlink request deleting the entry of
+// the original tuple of a flow
+func conntrackDeleteMessage(ipSrc, ipDst string, protonum uint8, srcport, dstport uint16, seq uint32) ([]byte, error) {
+
+	return conntrackMessage(ipctnlMsgCtDelete, syscall.NLM_F_ACK, ipSrc, ipDst, protonum, srcport, dstport, seq)
+}
+
+// netlinkAttribute returns an attribute with the concatenation of the values
+// padded to 4 bytes
+func netlinkAttribute(attrType uint16, values ...[]byte) []byte {
+
+	attr := make([]byte, syscall.NLA_HDRLEN)
+	for _, v := range values {
+		attr = append(attr, v...)
+	}
+
+	nativeEndian.PutUint16(attr, uint16(len(attr)))
+	nativeEndian.PutUint16(attr[2:], attrType)
+
+	for len(attr)%syscall.NLA_ALIGNTO != 0 {
+		attr = append(attr, 0)
+	}
+
+	return attr
+}
+
+// parseNetlinkAck returns the error of the acknowledgement of a request
+func parseNetlinkAck(b []byte) error {
+
+	if len(b) < syscall.NLMSG_HDRLEN+4 {
+		return fmt.Errorf("Invalid netlink reply")
+	}
+
+	if nativeEndian.Uint16(b[4:]) != syscall.NLMSG_ERROR {
+		return fmt.Errorf("Unexpected netlink reply %d", nativeEndian.Uint16(b[4:]))
+	}
+
+	if code := int32(nativeEndian.Uint32(b[syscall.NLMSG_HDRLEN:])); code != 0 {
+		return fmt.Errorf("Conntrack delete failed: %s", syscall.Errno(-code))
+	}
+
+	return nil
+}
+
+// netlinkAttributes returns the values of the attributes of a buffer by type
+func netlinkAttributes(b []byte) map[uint16][]byte {
+
+	attrs := map[uint16][]byte{}
+	for len(b) >= syscall.NLA_HDRLEN {
+		length := int(nativeEndian.Uint16(b))
+		if length < syscall.NLA_HDRLEN || length > len(b) {
+			break
+		}
+
+		attrs[nativeEndian.Uint16(b[2:])&nlaTypeMask] = b[syscall.NLA_HDRLEN:length]
+
+		aligned := (length + syscall.NLA_ALIGNTO - 1) &^ (syscall.NLA_ALIGNTO - 1)
+		if aligned > len(b) {
+			break
+		}
+		b = b[aligned:]
+	}
+
+	return attrs
+}
+
+// parseConntrackCounters returns the counters of the original and reply
+// directions of the conntrack entry of a reply to a get request
+func parseConntrackCounters(b []byte) (uint64, uint64, uint64, uint64, error) {
+
+	if len(b) < syscall.NLMSG_HDRLEN+4 {
+		return 0, 0, 0, 0, fmt.Errorf("Invalid netlink reply")
+	}
+
+	if nativeEndian.Uint16(b[4:]) == syscall.NLMSG_ERROR {
+		if err := parseNetlinkAck(b); err != nil {
+			return 0, 0, 0, 0, err
+		}
+		return 0, 0, 0, 0, fmt.Errorf("Conntrack entry not returned")
+	}
+
+	if nativeEndian.Uint16(b[4:]) != nfnlSubsysCtnetlink<<8|ipctnlMsgCtNew {
+		return 0, 0, 0, 0, fmt.Errorf("Unexpected netlink reply %d", nativeEndian.Uint16(b[4:]))
+	}
+
+	length := int(nativeEndian.Uint32(b))
+	if length > len(b) || length < syscall.NLMSG_HDRLEN+4 {
+		return 0, 0, 0, 0, fmt.Errorf("Invalid netlink reply")
+	}
+
+	attrs := netlinkAttributes(b[syscall.NLMSG_HDRLEN+4 : length])
+
+	orig, origOK := attrs[ctaCountersOrig]
+	reply, replyOK := attrs[ctaCountersReply]
+	if !origOK || !replyOK {
+		return 0, 0, 0, 0, fmt.Errorf("Conntrack accounting is not enabled")
+	}
+
+	origPackets, origBytes, err := counters(orig)
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+
+	replyPackets, replyBytes, err := counters(reply)
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+
+	return origPackets, origBytes, replyPackets, replyBytes, nil
+}
+
+// counters returns the packets and bytes of a nested counters attribute
+func counters(b []byte) (uint64, uint64, error) {
+
+	attrs := netlinkAttributes(b)
+
+	packets, bytes := attrs[ctaCountersPackets], attrs[ctaCountersBytes]
+	if len(packets) != 8 || len(bytes) != 8 {
+		return 0, 0, fmt.Errorf("Invalid conntrack counters")
+	}
+
+	return binary.BigEndian.Uint64(packets), binary.BigEndian.Uint64(bytes), nil
+}
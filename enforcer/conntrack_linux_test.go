@@ -0,0 +1,151 @@
+// +build linux
+
+package enforcer
+
+import (
+	"encoding/binary"
+	"syscall"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestConntrackDeleteMessage(t *testing.T) {
+
+	Convey("Given the flow of an IPv4 TCP connection", t, func() {
+
+		Convey("When I create the delete request, it should carry the original tuple", func() {
+			msg, err := conntrackDeleteMessage("10.0.0.1", "10.0.0.2", 6, 1000, 80, 7)
+			So(err, ShouldBeNil)
+
+			So(int(nativeEndian.Uint32(msg)), ShouldEqual, len(msg))
+			So(nativeEndian.Uint16(msg[4:]), ShouldEqual, 0x0102)
+			So(nativeEndian.Uint16(msg[6:]), ShouldEqual, syscall.NLM_F_REQUEST|syscall.NLM_F_ACK)
+			So(nativeEndian.Uint32(msg[8:]), ShouldEqual, 7)
+			So(msg[16], ShouldEqual, syscall.AF_INET)
+
+			tuple := msg[20:]
+			So(nativeEndian.Uint16(tuple), ShouldEqual, len(tuple))
+			So(nativeEndian.Uint16(tuple[2:]), ShouldEqual, ctaTupleOrig|nlaFNested)
+
+			ip := tuple[4:]
+			So(nativeEndian.Uint16(ip), ShouldEqual, 20)
+			So(ip[8:12], ShouldResemble, []byte{10, 0, 0, 1})
+			So(ip[16:20], ShouldResemble, []byte{10, 0, 0, 2})
+
+			proto := ip[20:]
+			So(nativeEndian.Uint16(proto), ShouldEqual, 28)
+			So(proto[8], ShouldEqual, 6)
+			So(proto[16:18], ShouldResemble, []byte{0x03, 0xe8})
+			So(proto[24:26], ShouldResemble, []byte{0x00, 0x50})
+		})
+
+		Convey("When I create the delete request of an IPv6 flow, it should use the IPv6 attributes", func() {
+			msg, err := conntrackDeleteMessage("fd00::1", "fd00::2", 17, 1000, 53, 1)
+			So(err, ShouldBeNil)
+			So(msg[16], ShouldEqual, syscall.AF_INET6)
+			So(nativeEndian.Uint16(msg[24:]), ShouldEqual, 44)
+			So(nativeEndian.Uint16(msg[30:]), ShouldEqual, ctaIPv6Src)
+		})
+
+		Convey("When I create the delete request of invalid addresses, it should fail", func() {
+			_, err := conntrackDeleteMessage("a", "10.0.0.2", 6, 1000, 80, 1)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestParseNetlinkAck(t *testing.T) {
+
+	Convey("Given the acknowledgements of a request", t, func() {
+		ack := func(code int32) []byte {
+			b := make([]byte, 36)
+			nativeEndian.PutUint32(b, 36)
+			nativeEndian.PutUint16(b[4:], syscall.NLMSG_ERROR)
+			nativeEndian.PutUint32(b[16:], uint32(code))
+			return b
+		}
+
+		Convey("A successful acknowledgement should not return an error", func() {
+			So(parseNetlinkAck(ack(0)), ShouldBeNil)
+		})
+
+		Convey("A failed acknowledgement should return the error", func() {
+			So(parseNetlinkAck(ack(-int32(syscall.ENOENT))), ShouldNotBeNil)
+		})
+
+		Convey("A truncated reply should return an error", func() {
+			So(parseNetlinkAck(ack(0)[:10]), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestConntrackGetCounters(t *testing.T) {
+
+	Convey("Given the flow of an IPv4 TCP connection", t, func() {
+
+		Convey("When I create the get request, it should not ask for an acknowledgement", func() {
+			msg, err := conntrackMessage(ipctnlMsgCtGet, 0, "10.0.0.1", "10.0.0.2", 6, 1000, 80, 3)
+			So(err, ShouldBeNil)
+			So(nativeEndian.Uint16(msg[4:]), ShouldEqual, 0x0101)
+			So(nativeEndian.Uint16(msg[6:]), ShouldEqual, syscall.NLM_F_REQUEST)
+			So(nativeEndian.Uint16(msg[22:]), ShouldEqual, ctaTupleOrig|nlaFNested)
+		})
+	})
+
+	Convey("Given the replies to a get request", t, func() {
+		counter := func(v uint64) []byte {
+			b := make([]byte, 8)
+			binary.BigEndian.PutUint64(b, v)
+			return b
+		}
+		reply := func(attrs ...[]byte) []byte {
+			b := make([]byte, syscall.NLMSG_HDRLEN+4)
+			nativeEndian.PutUint16(b[4:], nfnlSubsysCtnetlink<<8|ipctnlMsgCtNew)
+			b[syscall.NLMSG_HDRLEN] = syscall.AF_INET
+			for _, attr := range attrs {
+				b = append(b, attr...)
+			}
+			nativeEndian.PutUint32(b, uint32(len(b)))
+			return b
+		}
+		tuple := netlinkAttribute(ctaTupleOrig|nlaFNested, netlinkAttribute(ctaTupleProto|nlaFNested, netlinkAttribute(ctaProtoNum, []byte{6})))
+		orig := netlinkAttribute(ctaCountersOrig|nlaFNested, netlinkAttribute(ctaCountersPackets, counter(10)), netlinkAttribute(ctaCountersBytes, counter(1000)))
+		replied := netlinkAttribute(ctaCountersReply|nlaFNested, netlinkAttribute(ctaCountersPackets, counter(20)), netlinkAttribute(ctaCountersBytes, counter(2000)))
+
+		Convey("The counters of both directions should be returned", func() {
+			origPackets, origBytes, replyPackets, replyBytes, err := parseConntrackCounters(reply(tuple, orig, replied))
+			So(err, ShouldBeNil)
+			So(origPackets, ShouldEqual, 10)
+			So(origBytes, ShouldEqual, 1000)
+			So(replyPackets, ShouldEqual, 20)
+			So(replyBytes, ShouldEqual, 2000)
+		})
+
+		Convey("An entry without counters should return an error", func() {
+			_, _, _, _, err := parseConntrackCounters(reply(tuple))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("An entry with truncated counters should return an error", func() {
+			truncated := netlinkAttribute(ctaCountersReply|nlaFNested, netlinkAttribute(ctaCountersPackets, counter(20)[:4]))
+			_, _, _, _, err := parseConntrackCounters(reply(tuple, orig, truncated))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("An error reply should return the error", func() {
+			b := make([]byte, 36)
+			nativeEndian.PutUint32(b, 36)
+			nativeEndian.PutUint16(b[4:], syscall.NLMSG_ERROR)
+			errno := -int32(syscall.ENOENT)
+			nativeEndian.PutUint32(b[16:], uint32(errno))
+			_, _, _, _, err := parseConntrackCounters(b)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("A truncated reply should return an error", func() {
+			_, _, _, _, err := parseConntrackCounters(reply(tuple, orig, replied)[:10])
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
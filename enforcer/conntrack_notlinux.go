@@ -0,0 +1,35 @@
+// +build darwin !linux
+
+package enforcer
+
+import "fmt"
+
+// unsupportedConntrackDeleter fails to delete the conntrack entries
+type unsupportedConntrackDeleter struct{}
+
+// newConntrackDeleter returns the conntrack deleter of the platform
+func newConntrackDeleter() conntrackDeleter {
+
+	return &unsupportedConntrackDeleter{}
+}
+
+// ConntrackTableDeleteFlow is not supported
+func (u *unsupportedConntrackDeleter) ConntrackTableDeleteFlow(ipSrc, ipDst string, protonum uint8, srcport, dstport uint16) error {
+
+	return fmt.Errorf("Conntrack delete is not supported on this platform")
+}
+
+// unsupportedConntrackAccounting fails to retrieve the counters
+type unsupportedConntrackAccounting struct{}
+
+// newConntrackAccounting returns the conntrack accounting of the platform
+func newConntrackAccounting() conntrackAccounting {
+
+	return &unsupportedConntrackAccounting{}
+}
+
+// ConntrackTableGetCounters is not supported
+func (u *unsupportedConntrackAccounting) ConntrackTableGetCounters(ipSrc, ipDst string, protonum uint8, srcport, dstport uint16) (uint64, uint64, uint64, uint64, error) {
+
+	return 0, 0, 0, 0, fmt.Errorf("Conntrack accounting is not supported on this platform")
+}
@@ -12,3 +12,19 @@ const (
 	// DefaultNetwork to be used
 	DefaultNetwork = "0.0.0.0/0"
 )
+
+// UDP authentication trailer. The token of a UDP flow is appended to the
+// payload of the datagram followed by a fixed size trailer:
+// [token][token length (2 bytes)][token type (1 byte)][UDPAuthMarker]
+const (
+	// UDPAuthMarker identifies datagrams that carry a token
+	UDPAuthMarker = "\xa5\x0e\xc0\x7e"
+	// UDPAuthTrailerLen is the length of the trailer following the token
+	UDPAuthTrailerLen = 3 + len(UDPAuthMarker)
+	// UDPSynToken identifies the token send by the initiator of the flow
+	UDPSynToken = uint8(1)
+	// UDPSynAckToken identifies the token send by the responder of the flow
+	UDPSynAckToken = uint8(2)
+	// UDPAckToken identifies the token that completes the negotiation
+	UDPAckToken = uint8(3)
+)
@@ -3,8 +3,12 @@ package enforcer
 // Go libraries
 import (
 	"fmt"
+	"net"
 	"os/exec"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
@@ -17,10 +21,15 @@ import (
 	"github.com/aporeto-inc/trireme/enforcer/utils/fqconfig"
 	"github.com/aporeto-inc/trireme/enforcer/utils/secrets"
 	"github.com/aporeto-inc/trireme/enforcer/utils/tokens"
+	"github.com/aporeto-inc/trireme/metrics"
 	"github.com/aporeto-inc/trireme/monitor/linuxmonitor/cgnetcls"
 	"github.com/aporeto-inc/trireme/policy"
 )
 
+// instances counts the enforcers created by the process. It labels the
+// metrics of each enforcer.
+var instances uint32
+
 // Datapath is the structure holding all information about a connection filter
 type Datapath struct {
 
@@ -53,12 +62,31 @@ type Datapath struct {
 	netOrigConnectionTracker  cache.DataStore
 	netReplyConnectionTracker cache.DataStore
 
+	// Hash on the five-tuple of UDP flows initiated by the application or
+	// from the network. Replies are looked up with the reverse hash.
+	udpAppConnectionTracker cache.DataStore
+	udpNetConnectionTracker cache.DataStore
+
+	// Hash on the five-tuple of the application direction of the TCP
+	// connections that completed the negotiation. They are kept until the
+	// connection is closed so that the end of the flow can be reported.
+	establishedConnectionTracker cache.DataStore
+
 	// connctrack handle
 	conntrackHdl conntrack.Conntrack
 
+	// conntrackAcct retrieves the counters of the flows when they end
+	conntrackAcct conntrackAccounting
+
+	// conntrackDel deletes the conntrack entries of the revoked flows
+	conntrackDel conntrackDeleter
+
 	// mode captures the mode of the enforcer
 	mode constants.ModeType
 
+	// instance labels the cache metrics of the enforcer
+	instance string
+
 	// stop signals
 	netStop []chan bool
 	appStop []chan bool
@@ -67,6 +95,10 @@ type Datapath struct {
 	ackSize uint32
 
 	mutualAuthorization bool
+
+	// capture holds the debug capture of the packets, if one is running
+	capture     *packetCapture
+	captureLock sync.RWMutex
 }
 
 // New will create a new data path structure. It instantiates the data stores
@@ -99,6 +131,11 @@ func New(
 
 	}
 
+	// Accounting is only needed for the flow statistics
+	if err := exec.Command("sysctl", "-w", "net.netfilter.nf_conntrack_acct=1").Run(); err != nil {
+		zap.L().Warn("Failed to enable conntrack accounting", zap.Error(err))
+	}
+
 	tokenEngine, err := tokens.NewJWT(validity, serverID, secrets)
 	if err != nil {
 		zap.L().Fatal("Unable to create TokenEngine in enforcer", zap.Error(err))
@@ -119,24 +156,66 @@ func New(
 		filterQueue:               filterQueue,
 		mutualAuthorization:       mutualAuth,
 		service:                   service,
-		collector:                 collector,
-		tokenEngine:               tokenEngine,
+		collector:                 metrics.NewCollector(collector),
+		tokenEngine:               metrics.NewTokenEngine(tokenEngine),
 		secrets:                   secrets,
 		ackSize:                   secrets.AckSize(),
 		mode:                      mode,
+		instance:                  strconv.FormatUint(uint64(atomic.AddUint32(&instances, 1)), 10),
 		procMountPoint:            procMountPoint,
 		conntrackHdl:              conntrack.NewHandle(),
+		conntrackAcct:             newConntrackAccounting(),
+		conntrackDel:              newConntrackDeleter(),
 	}
 
 	if d.tokenEngine == nil {
 		zap.L().Fatal("Unable to create enforcer")
 	}
 
-	d.nflogger = newNFLogger(11, 10, d.puInfoDelegate, collector)
+	d.establishedConnectionTracker = cache.NewCacheWithExpirationNotifier(establishedConnectionTimeout, d.establishedConnectionExpirationNotifier)
+	d.udpAppConnectionTracker = cache.NewCacheWithExpirationNotifier(time.Second*24, d.udpConnectionExpirationNotifier)
+	d.udpNetConnectionTracker = cache.NewCacheWithExpirationNotifier(time.Second*24, d.udpConnectionExpirationNotifier)
+
+	d.nflogger = newNFLogger(11, 10, d.puInfoDelegate, d.collector)
+
+	d.registerMetrics()
 
 	return d
 }
 
+// metricsCaches returns the caches whose size is exported, by name
+func (d *Datapath) metricsCaches() map[string]cache.DataStore {
+
+	return map[string]cache.DataStore{
+		"pu_from_ip":             d.puFromIP,
+		"pu_from_mark":           d.puFromMark,
+		"pu_from_port":           d.puFromPort,
+		"context":                d.contextTracker,
+		"source_port_connection": d.sourcePortConnectionCache,
+		"app_orig_connection":    d.appOrigConnectionTracker,
+		"app_reply_connection":   d.appReplyConnectionTracker,
+		"net_orig_connection":    d.netOrigConnectionTracker,
+		"net_reply_connection":   d.netReplyConnectionTracker,
+		"udp_app_connection":     d.udpAppConnectionTracker,
+		"udp_net_connection":     d.udpNetConnectionTracker,
+		"established_connection": d.establishedConnectionTracker,
+	}
+}
+
+// registerMetrics exports the size of the caches and the packets of the queues
+func (d *Datapath) registerMetrics() {
+
+	for name, c := range d.metricsCaches() {
+		if sizer, ok := c.(metrics.Sizer); ok {
+			metrics.RegisterCache(d.instance, name, sizer)
+		}
+	}
+
+	if d.filterQueue != nil {
+		metrics.RegisterFilterQueue(d.filterQueue)
+	}
+}
+
 // NewWithDefaults create a new data path with most things used by default
 func NewWithDefaults(
 	serverID string,
@@ -192,11 +271,22 @@ func (d *Datapath) Unenforce(contextID string) error {
 	defer puContext.(*PUContext).Unlock()
 
 	pu := puContext.(*PUContext)
-	if err := d.puFromIP.Remove(pu.IP); err != nil {
-		zap.L().Warn("Unable to remove cache entry during unenforcement",
-			zap.String("IP", pu.IP),
-			zap.Error(err),
-		)
+	if pu.IP != "" {
+		if err := d.puFromIP.Remove(pu.IP); err != nil {
+			zap.L().Warn("Unable to remove cache entry during unenforcement",
+				zap.String("IP", pu.IP),
+				zap.Error(err),
+			)
+		}
+	}
+
+	if pu.IPv6 != "" {
+		if err := d.puFromIP.Remove(pu.IPv6); err != nil {
+			zap.L().Warn("Unable to remove cache entry during unenforcement",
+				zap.String("IPv6", pu.IPv6),
+				zap.Error(err),
+			)
+		}
 	}
 
 	if err := d.puFromIP.Remove(pu.Mark); err != nil {
@@ -222,6 +312,8 @@ func (d *Datapath) Unenforce(contextID string) error {
 		)
 	}
 
+	metrics.ForgetContext(contextID)
+
 	return nil
 }
 
@@ -260,6 +352,10 @@ func (d *Datapath) Stop() error {
 		d.netStop[i] <- true
 	}
 
+	for name := range d.metricsCaches() {
+		metrics.UnregisterCache(d.instance, name)
+	}
+
 	return nil
 }
 
@@ -283,11 +379,22 @@ func (d *Datapath) getProcessKeys(puInfo *policy.PUInfo) (string, []string) {
 func (d *Datapath) doCreatePU(contextID string, puInfo *policy.PUInfo) error {
 
 	ip, ok := puInfo.Runtime.DefaultIPAddress()
+	ipv6, ok6 := puInfo.Runtime.DefaultIPv6Address()
 	if !ok {
-		if d.mode == constants.LocalContainer {
-			return fmt.Errorf("No IP provided for Local Container")
+		ip = ""
+		if !ok6 {
+			if d.mode == constants.LocalContainer {
+				return fmt.Errorf("No IP provided for Local Container")
+			}
+			ip = DefaultNetwork
+		}
+	}
+
+	// Packets carry the canonical form of IPv6 addresses
+	if ok6 {
+		if parsed := net.ParseIP(ipv6); parsed != nil {
+			ipv6 = parsed.String()
 		}
-		ip = DefaultNetwork
 	}
 
 	pu := &PUContext{
@@ -295,7 +402,10 @@ func (d *Datapath) doCreatePU(contextID string, puInfo *policy.PUInfo) error {
 		ManagementID:    puInfo.Policy.ManagementID(),
 		PUType:          puInfo.Runtime.PUType(),
 		IP:              ip,
+		IPv6:            ipv6,
 		externalIPCache: cache.NewCacheWithExpiration(time.Second * 900),
+		connections:     newConnectionSet(),
+		udpFlows:        newUDPFlowSet(),
 	}
 
 	// Cache PUs for retrieval based on packet information
@@ -306,10 +416,11 @@ func (d *Datapath) doCreatePU(contextID string, puInfo *policy.PUInfo) error {
 			d.puFromPort.AddOrUpdate(port, pu)
 		}
 	} else {
-		if ip, ok := puInfo.Runtime.DefaultIPAddress(); ok {
+		if ip != "" {
 			d.puFromIP.AddOrUpdate(ip, pu)
-		} else {
-			d.puFromIP.AddOrUpdate(DefaultNetwork, pu)
+		}
+		if ok6 {
+			d.puFromIP.AddOrUpdate(ipv6, pu)
 		}
 	}
 
@@ -321,6 +432,26 @@ func (d *Datapath) doCreatePU(contextID string, puInfo *policy.PUInfo) error {
 
 func (d *Datapath) doUpdatePU(puContext *PUContext, containerInfo *policy.PUInfo) error {
 
+	if err := d.updatePUPolicy(puContext, containerInfo); err != nil {
+		return err
+	}
+
+	// Connections that are not allowed by the new policy are terminated. This
+	// is not optional: the packets of established flows bypass the datapath,
+	// so an update is the only time a revoked flow can be stopped, and the
+	// flows that are still allowed are left untouched. The checks only walk
+	// the flows of the PU. The frozen policy of a paused PU keeps the
+	// established flows.
+	if !containerInfo.Policy.Frozen() {
+		d.revalidateConnections(puContext)
+	}
+
+	return nil
+}
+
+// updatePUPolicy rebuilds the policy databases of a PU
+func (d *Datapath) updatePUPolicy(puContext *PUContext, containerInfo *policy.PUInfo) error {
+
 	puContext.Lock()
 	defer puContext.Unlock()
 
@@ -332,13 +463,30 @@ func (d *Datapath) doUpdatePU(puContext *PUContext, containerInfo *policy.PUInfo
 
 	puContext.Annotations = containerInfo.Policy.Annotations()
 
+	puContext.audit = containerInfo.Policy.TriremeAction() == policy.Audit
+
+	puContext.encrypt = encrypts(containerInfo.Policy.ReceiverRules()) || encrypts(containerInfo.Policy.TransmitterRules())
+
+	// The cached syn token carries the previous identity
+	puContext.synToken = nil
+
 	puContext.ApplicationACLs = acls.NewACLCache()
 	if err := puContext.ApplicationACLs.AddRuleList(containerInfo.Policy.ApplicationACLs()); err != nil {
 		return err
 	}
 
 	puContext.NetworkACLS = acls.NewACLCache()
-	return puContext.NetworkACLS.AddRuleList(containerInfo.Policy.NetworkACLs())
+	if err := puContext.NetworkACLS.AddRuleList(containerInfo.Policy.NetworkACLs()); err != nil {
+		return err
+	}
+
+	puContext.UDPApplicationACLs = acls.NewACLCacheWithProtocol("udp")
+	if err := puContext.UDPApplicationACLs.AddRuleList(containerInfo.Policy.ApplicationACLs()); err != nil {
+		return err
+	}
+
+	puContext.UDPNetworkACLs = acls.NewACLCacheWithProtocol("udp")
+	return puContext.UDPNetworkACLs.AddRuleList(containerInfo.Policy.NetworkACLs())
 }
 
 func (d *Datapath) puInfoDelegate(contextID string) (ID string, tags *policy.TagStore) {
@@ -60,6 +60,9 @@ func (d *Datapath) processNetworkTCPPackets(p *packet.Packet) (err error) {
 	default:
 		context, conn, err = d.netRetrieveState(p)
 		if err != nil {
+			if isTeardownPacket(p) {
+				return nil
+			}
 			zap.L().Debug("Packet rejected",
 				zap.String("flow", p.L4FlowHash()),
 				zap.String("Flags", packet.TCPFlagsToStr(p.TCPFlags)),
@@ -152,6 +155,9 @@ func (d *Datapath) processApplicationTCPPackets(p *packet.Packet) (err error) {
 	default:
 		context, conn, err = d.appRetrieveState(p)
 		if err != nil {
+			if isTeardownPacket(p) {
+				return nil
+			}
 			zap.L().Debug("Packet rejected",
 				zap.String("flow", p.L4FlowHash()),
 				zap.String("Flags", packet.TCPFlagsToStr(p.TCPFlags)),
@@ -225,6 +231,7 @@ func (d *Datapath) processApplicationTCPPacket(tcpPacket *packet.Packet, context
 		action, err := d.processApplicationSynAckPacket(tcpPacket, context, conn)
 		return action, err
 	default:
+		d.updateFlowLifecycle(conn, tcpPacket, true)
 		return nil, nil
 	}
 }
@@ -275,7 +282,7 @@ func (d *Datapath) processApplicationSynAckPacket(tcpPacket *packet.Packet, cont
 			tcpPacket.IPProto,
 			tcpPacket.DestinationPort,
 			tcpPacket.SourcePort,
-			constants.DefaultConnMark,
+			conn.connMark(),
 		); err != nil {
 			zap.L().Error("Failed to update conntrack entry for flow",
 				zap.String("context", string(conn.Auth.LocalContext)),
@@ -291,6 +298,11 @@ func (d *Datapath) processApplicationSynAckPacket(tcpPacket *packet.Packet, cont
 			zap.L().Debug("Failed to remove cache entries")
 		}
 
+		// The flow was accepted by the network ACLs
+		if conn.lifecycle == nil {
+			d.externalFlowEstablished(conn, tcpPacket, true, conn.FlowPolicy)
+		}
+
 		return nil, nil
 	}
 	// Process the packet at the right state. I should have either received a Syn packet or
@@ -327,7 +339,15 @@ func (d *Datapath) processApplicationSynAckPacket(tcpPacket *packet.Packet, cont
 // processApplicationAckPacket processes an application ack packet
 func (d *Datapath) processApplicationAckPacket(tcpPacket *packet.Packet, context *PUContext, conn *TCPConnection) (interface{}, error) {
 
+	if conn.revoked {
+		return nil, d.resetRevokedConnection(conn, tcpPacket)
+	}
+
 	if conn.GetState() == TCPData {
+		if err := d.sealTCPPayload(conn.txCipher, tcpPacket); err != nil {
+			return nil, err
+		}
+		d.updateFlowLifecycle(conn, tcpPacket, true)
 		return nil, nil
 	}
 
@@ -357,6 +377,8 @@ func (d *Datapath) processApplicationAckPacket(tcpPacket *packet.Packet, context
 
 		conn.SetState(TCPAckSend)
 
+		d.connectionEstablished(conn, tcpPacket, true, true)
+
 		if !conn.ServiceConnection && tcpPacket.SourceAddress.String() != tcpPacket.DestinationAddress.String() {
 			if err := d.conntrackHdl.ConntrackTableUpdateMark(
 				tcpPacket.SourceAddress.String(),
@@ -364,7 +386,7 @@ func (d *Datapath) processApplicationAckPacket(tcpPacket *packet.Packet, context
 				tcpPacket.IPProto,
 				tcpPacket.SourcePort,
 				tcpPacket.DestinationPort,
-				constants.DefaultConnMark,
+				conn.connMark(),
 			); err != nil {
 				zap.L().Error("Failed to update conntrack table for flow",
 					zap.String("context", string(conn.Auth.LocalContext)),
@@ -391,6 +413,10 @@ func (d *Datapath) processApplicationAckPacket(tcpPacket *packet.Packet, context
 		}
 
 		conn.SetState(TCPData)
+		if err := d.sealTCPPayload(conn.txCipher, tcpPacket); err != nil {
+			return nil, err
+		}
+		d.updateFlowLifecycle(conn, tcpPacket, true)
 		return nil, nil
 	}
 
@@ -417,6 +443,7 @@ func (d *Datapath) processNetworkTCPPacket(tcpPacket *packet.Packet, context *PU
 		return d.processNetworkSynAckPacket(context, conn, tcpPacket)
 
 	default: // Ignore any other packet
+		d.updateFlowLifecycle(conn, tcpPacket, false)
 		return nil, nil, nil
 	}
 }
@@ -430,13 +457,14 @@ func (d *Datapath) processNetworkSynPacket(context *PUContext, conn *TCPConnecti
 	if err = tcpPacket.CheckTCPAuthenticationOption(TCPAuthenticationOptionBaseLen); err != nil {
 
 		// If there is no auth option, attempt the ACLs
-		plc, perr := context.NetworkACLS.GetMatchingAction(tcpPacket.SourceAddress.To4(), tcpPacket.DestinationPort)
+		plc, perr := context.NetworkACLS.GetMatchingAction(tcpPacket.SourceAddress, tcpPacket.DestinationPort)
 		d.reportExternalServiceFlow(context, plc, false, tcpPacket)
-		if perr != nil || plc.Action == policy.Reject {
+		if (perr != nil || plc.Action == policy.Reject) && !context.audit {
 			return nil, nil, fmt.Errorf("Drop it")
 		}
 
 		conn.SetState(TCPData)
+		conn.FlowPolicy = plc
 		d.netOrigConnectionTracker.AddOrUpdate(tcpPacket.L4FlowHash(), conn)
 		d.appReplyConnectionTracker.AddOrUpdate(tcpPacket.L4ReverseFlowHash(), conn)
 
@@ -448,23 +476,33 @@ func (d *Datapath) processNetworkSynPacket(context *PUContext, conn *TCPConnecti
 
 	// If the token signature is not valid or there are no claims
 	// we must drop the connection and we drop the Syn packet. The source will
-	// retry but we have no state to maintain here.
+	// retry but we have no state to maintain here. Audited PUs report the
+	// flow and accept it without authentication.
 	if err != nil || claims == nil {
 		d.reportRejectedFlow(tcpPacket, conn, collector.DefaultEndPoint, context.ManagementID, context, collector.InvalidToken, nil)
-		return nil, nil, fmt.Errorf("Syn packet dropped because of invalid token %v %+v", err, claims)
+		if !context.audit {
+			return nil, nil, fmt.Errorf("Syn packet dropped because of invalid token %v %+v", err, claims)
+		}
+		return d.acceptAuditedSynPacket(conn, tcpPacket)
 	}
 
 	txLabel, ok := claims.T.Get(TransmitterLabel)
 	if err := tcpPacket.CheckTCPAuthenticationOption(TCPAuthenticationOptionBaseLen); !ok || err != nil {
 		d.reportRejectedFlow(tcpPacket, conn, txLabel, context.ManagementID, context, collector.InvalidFormat, nil)
-		return nil, nil, fmt.Errorf("TCP Authentication Option not found %v", err)
+		if !context.audit {
+			return nil, nil, fmt.Errorf("TCP Authentication Option not found %v", err)
+		}
+		return d.acceptAuditedSynPacket(conn, tcpPacket)
 	}
 
 	// Remove any of our data from the packet. No matter what we don't need the
 	// metadata any more.
 	if err := tcpPacket.TCPDataDetach(TCPAuthenticationOptionBaseLen); err != nil {
 		d.reportRejectedFlow(tcpPacket, conn, txLabel, context.ManagementID, context, collector.InvalidFormat, nil)
-		return nil, nil, fmt.Errorf("Syn packet dropped because of invalid format %v", err)
+		if !context.audit {
+			return nil, nil, fmt.Errorf("Syn packet dropped because of invalid format %v", err)
+		}
+		return d.acceptAuditedSynPacket(conn, tcpPacket)
 	}
 
 	tcpPacket.DropDetachedBytes()
@@ -477,30 +515,73 @@ func (d *Datapath) processNetworkSynPacket(context *PUContext, conn *TCPConnecti
 	if index, plc := context.RejectRcvRules.Search(claims.T); index >= 0 {
 		// Reject the connection
 		d.reportRejectedFlow(tcpPacket, conn, txLabel, context.ManagementID, context, collector.PolicyDrop, plc.(*policy.FlowPolicy))
-		return nil, nil, fmt.Errorf("Connection rejected because of policy %+v", claims.T)
+		if !context.audit {
+			return nil, nil, fmt.Errorf("Connection rejected because of policy %+v", claims.T)
+		}
+		conn.audited = true
+		return d.acceptNetworkSynPacket(context, conn, tcpPacket, txLabel, claims, auditFlowPolicy(plc.(*policy.FlowPolicy)))
 	}
 
 	// Search the policy rules for a matching rule.
 	if index, action := context.AcceptRcvRules.Search(claims.T); index >= 0 {
+		return d.acceptNetworkSynPacket(context, conn, tcpPacket, txLabel, claims, action.(*policy.FlowPolicy))
+	}
 
-		hash := tcpPacket.L4FlowHash()
-		// Update the connection state and store the Nonse send to us by the host.
-		// We use the nonse in the subsequent packets to achieve randomization.
-		conn.SetState(TCPSynReceived)
+	d.reportRejectedFlow(tcpPacket, conn, txLabel, context.ManagementID, context, collector.PolicyDrop, nil)
+	if !context.audit {
+		return nil, nil, fmt.Errorf("No matched tags - reject %+v", claims.T)
+	}
+	conn.audited = true
+	return d.acceptNetworkSynPacket(context, conn, tcpPacket, txLabel, claims, auditFlowPolicy(nil))
+}
 
-		// conntrack
-		d.netOrigConnectionTracker.AddOrUpdate(hash, conn)
-		d.appReplyConnectionTracker.AddOrUpdate(tcpPacket.L4ReverseFlowHash(), conn)
+// acceptNetworkSynPacket updates the state of a connection accepted by the
+// receiver policy
+func (d *Datapath) acceptNetworkSynPacket(context *PUContext, conn *TCPConnection, tcpPacket *packet.Packet, txLabel string, claims *tokens.ConnectionClaims, plc *policy.FlowPolicy) (action interface{}, c *tokens.ConnectionClaims, err error) {
 
-		// Cache the action
-		conn.FlowPolicy = action.(*policy.FlowPolicy)
+	hash := tcpPacket.L4FlowHash()
+	// Update the connection state and store the Nonse send to us by the host.
+	// We use the nonse in the subsequent packets to achieve randomization.
+	conn.SetState(TCPSynReceived)
 
-		// Accept the connection
-		return action, claims, nil
+	// conntrack
+	d.netOrigConnectionTracker.AddOrUpdate(hash, conn)
+	d.appReplyConnectionTracker.AddOrUpdate(tcpPacket.L4ReverseFlowHash(), conn)
+
+	// Cache the action and the claims for policy updates
+	conn.FlowPolicy = plc
+	conn.remoteTags = claims.T
+
+	// Negotiate the encryption key if the policy requires it
+	if conn.FlowPolicy.Action.Encrypted() {
+		if err := d.negotiateEncryption(conn, false); err != nil {
+			d.reportRejectedFlow(tcpPacket, conn, txLabel, context.ManagementID, context, collector.EncryptionMismatch, conn.FlowPolicy)
+			return nil, nil, fmt.Errorf("Syn packet dropped because encryption failed: %s", err)
+		}
+		// Leave room for the authentication tag in the segments of the local PU
+		tcpPacket.DecreaseTCPMss(TCPEncryptionOptionLen)
 	}
 
-	d.reportRejectedFlow(tcpPacket, conn, txLabel, context.ManagementID, context, collector.PolicyDrop, nil)
-	return nil, nil, fmt.Errorf("No matched tags - reject %+v", claims.T)
+	// Accept the connection
+	return plc, claims, nil
+}
+
+// acceptAuditedSynPacket accepts the Syn packet of an audited PU that failed
+// the authentication. The connection continues as an unauthenticated flow,
+// like the flows accepted by the network ACLs.
+func (d *Datapath) acceptAuditedSynPacket(conn *TCPConnection, tcpPacket *packet.Packet) (action interface{}, claims *tokens.ConnectionClaims, err error) {
+
+	stripTCPAuthentication(tcpPacket)
+
+	plc := auditFlowPolicy(nil)
+
+	conn.audited = true
+	conn.SetState(TCPData)
+	conn.FlowPolicy = plc
+	d.netOrigConnectionTracker.AddOrUpdate(tcpPacket.L4FlowHash(), conn)
+	d.appReplyConnectionTracker.AddOrUpdate(tcpPacket.L4ReverseFlowHash(), conn)
+
+	return plc, nil, nil
 }
 
 // processNetworkSynAckPacket processes a SynAck packet arriving from the network
@@ -529,6 +610,12 @@ func (d *Datapath) processNetworkSynAckPacket(context *PUContext, conn *TCPConne
 				zap.L().Error("Failed to update conntrack table")
 			}
 			d.reportReverseExternalServiceFlow(context, plc, true, tcpPacket)
+
+			// The flow was accepted by the application ACLs
+			if err == nil && conn.lifecycle == nil {
+				conn.SetState(TCPData)
+				d.externalFlowEstablished(conn, tcpPacket, false, plc)
+			}
 		}()
 
 		flowHash := tcpPacket.SourceAddress.String() + ":" + strconv.Itoa(int(tcpPacket.SourcePort))
@@ -538,9 +625,14 @@ func (d *Datapath) processNetworkSynAckPacket(context *PUContext, conn *TCPConne
 		}
 
 		// Never seen this IP before, let's parse them.
-		plc, err = context.ApplicationACLs.GetMatchingAction(tcpPacket.SourceAddress.To4(), tcpPacket.SourcePort)
+		plc, err = context.ApplicationACLs.GetMatchingAction(tcpPacket.SourceAddress, tcpPacket.SourcePort)
 		if err != nil || plc.Action&policy.Reject > 0 {
-			return nil, nil, fmt.Errorf("Drop it")
+			if !context.audit {
+				return nil, nil, fmt.Errorf("Drop it")
+			}
+			// Audited flows are not cached so that they are reported again
+			conn.SetState(TCPData)
+			return plc, nil, nil
 		}
 
 		// Added to the cache if we can accept it
@@ -554,10 +646,15 @@ func (d *Datapath) processNetworkSynAckPacket(context *PUContext, conn *TCPConne
 		return plc, nil, nil
 	}
 
+	// Audited PUs report the flows that fail the authentication and accept
+	// them without authentication
 	tcpData := tcpPacket.ReadTCPData()
 	if len(tcpData) == 0 {
 		d.reportRejectedFlow(tcpPacket, nil, collector.DefaultEndPoint, context.ManagementID, context, collector.MissingToken, nil)
-		return nil, nil, fmt.Errorf("SynAck packet dropped because of missing token")
+		if !context.audit {
+			return nil, nil, fmt.Errorf("SynAck packet dropped because of missing token")
+		}
+		return d.acceptAuditedSynAckPacket(conn, tcpPacket)
 	}
 
 	claims, err = d.parsePacketToken(&conn.Auth, tcpPacket.ReadTCPData())
@@ -565,20 +662,29 @@ func (d *Datapath) processNetworkSynAckPacket(context *PUContext, conn *TCPConne
 	// claims, nonce, cert, err := d.tokenEngine.Decode(false, tcpData, nil)
 	if err != nil || claims == nil {
 		d.reportRejectedFlow(tcpPacket, nil, collector.DefaultEndPoint, context.ManagementID, context, collector.MissingToken, nil)
-		return nil, nil, fmt.Errorf("Synack packet dropped because of bad claims %v", claims)
+		if !context.audit {
+			return nil, nil, fmt.Errorf("Synack packet dropped because of bad claims %v", claims)
+		}
+		return d.acceptAuditedSynAckPacket(conn, tcpPacket)
 	}
 
 	tcpPacket.ConnectionMetadata = &conn.Auth
 
 	if err := tcpPacket.CheckTCPAuthenticationOption(TCPAuthenticationOptionBaseLen); err != nil {
 		d.reportRejectedFlow(tcpPacket, conn, context.ManagementID, conn.Auth.RemoteContextID, context, collector.InvalidFormat, nil)
-		return nil, nil, fmt.Errorf("TCP Authentication Option not found")
+		if !context.audit {
+			return nil, nil, fmt.Errorf("TCP Authentication Option not found")
+		}
+		return d.acceptAuditedSynAckPacket(conn, tcpPacket)
 	}
 
 	// Remove any of our data
 	if err := tcpPacket.TCPDataDetach(TCPAuthenticationOptionBaseLen); err != nil {
 		d.reportRejectedFlow(tcpPacket, conn, context.ManagementID, conn.Auth.RemoteContextID, context, collector.InvalidFormat, nil)
-		return nil, nil, fmt.Errorf("SynAck packet dropped because of invalid format")
+		if !context.audit {
+			return nil, nil, fmt.Errorf("SynAck packet dropped because of invalid format")
+		}
+		return d.acceptAuditedSynAckPacket(conn, tcpPacket)
 	}
 
 	tcpPacket.DropDetachedBytes()
@@ -587,27 +693,99 @@ func (d *Datapath) processNetworkSynAckPacket(context *PUContext, conn *TCPConne
 	// is matched in both directions. We have to make this optional as it can
 	// become a very strong condition
 
+	// Audited PUs report the rejected flows and continue the negotiation
+	rejected := false
 	if index, _ := context.RejectTxtRules.Search(claims.T); d.mutualAuthorization && index >= 0 {
 		d.reportRejectedFlow(tcpPacket, conn, context.ManagementID, conn.Auth.RemoteContextID, context, collector.PolicyDrop, nil)
-		return nil, nil, fmt.Errorf("Dropping because of reject rule on transmitter")
+		if !context.audit {
+			return nil, nil, fmt.Errorf("Dropping because of reject rule on transmitter")
+		}
+		rejected = true
 	}
 
-	if index, action := context.AcceptTxtRules.Search(claims.T); !d.mutualAuthorization || index >= 0 {
-		conn.SetState(TCPSynAckReceived)
+	index, action := context.AcceptTxtRules.Search(claims.T)
+	if d.mutualAuthorization && index < 0 && !rejected {
+		d.reportRejectedFlow(tcpPacket, conn, context.ManagementID, conn.Auth.RemoteContextID, context, collector.PolicyDrop, nil)
+		if !context.audit {
+			return nil, nil, fmt.Errorf("Dropping packet SYNACK at the network ")
+		}
+	}
 
-		// conntrack
-		d.netReplyConnectionTracker.AddOrUpdate(tcpPacket.L4FlowHash(), conn)
-		return action, claims, nil
+	// The remote offers encryption when its policy requires it. Our
+	// policy may require it as well.
+	encrypt := len(claims.EK) > 0
+	if plc, ok := action.(*policy.FlowPolicy); ok && index >= 0 && plc.Action.Encrypted() {
+		encrypt = true
+	}
+
+	if encrypt {
+		if err := d.negotiateEncryption(conn, true); err != nil {
+			d.reportRejectedFlow(tcpPacket, conn, context.ManagementID, conn.Auth.RemoteContextID, context, collector.EncryptionMismatch, nil)
+			return nil, nil, fmt.Errorf("SynAck packet dropped because encryption failed: %s", err)
+		}
+		// Leave room for the authentication tag in the segments of the local PU
+		tcpPacket.DecreaseTCPMss(TCPEncryptionOptionLen)
 	}
 
-	d.reportRejectedFlow(tcpPacket, conn, context.ManagementID, conn.Auth.RemoteContextID, context, collector.PolicyDrop, nil)
-	return nil, nil, fmt.Errorf("Dropping packet SYNACK at the network ")
+	// Cache the action for the report of the end of the flow. The flow
+	// is accepted without a transmitter rule if the authorization is not
+	// mutual.
+	conn.FlowPolicy = &policy.FlowPolicy{Action: policy.Accept}
+	if plc, ok := action.(*policy.FlowPolicy); ok && index >= 0 && !rejected {
+		conn.FlowPolicy = plc
+	}
+
+	conn.SetState(TCPSynAckReceived)
+	conn.remoteTags = claims.T
+
+	// conntrack
+	d.netReplyConnectionTracker.AddOrUpdate(tcpPacket.L4FlowHash(), conn)
+	return action, claims, nil
+}
+
+// acceptAuditedSynAckPacket accepts the SynAck packet of an audited PU that
+// failed the authentication. The connection continues as an unauthenticated
+// flow, like the flows accepted by the application ACLs.
+func (d *Datapath) acceptAuditedSynAckPacket(conn *TCPConnection, tcpPacket *packet.Packet) (action interface{}, claims *tokens.ConnectionClaims, err error) {
+
+	stripTCPAuthentication(tcpPacket)
+
+	if err := d.conntrackHdl.ConntrackTableUpdateMark(
+		tcpPacket.DestinationAddress.String(),
+		tcpPacket.SourceAddress.String(),
+		tcpPacket.IPProto,
+		tcpPacket.DestinationPort,
+		tcpPacket.SourcePort,
+		constants.DefaultConnMark,
+	); err != nil {
+		zap.L().Error("Failed to update conntrack table")
+	}
+
+	plc := auditFlowPolicy(nil)
+
+	conn.audited = true
+	conn.SetState(TCPData)
+	conn.FlowPolicy = plc
+	d.netReplyConnectionTracker.AddOrUpdate(tcpPacket.L4FlowHash(), conn)
+	if conn.lifecycle == nil {
+		d.externalFlowEstablished(conn, tcpPacket, false, plc)
+	}
+
+	return plc, nil, nil
 }
 
 // processNetworkAckPacket processes an Ack packet arriving from the network
 func (d *Datapath) processNetworkAckPacket(context *PUContext, conn *TCPConnection, tcpPacket *packet.Packet) (action interface{}, claims *tokens.ConnectionClaims, err error) {
 
+	if conn.revoked {
+		return nil, nil, d.resetRevokedConnection(conn, tcpPacket)
+	}
+
 	if conn.GetState() == TCPData || conn.GetState() == TCPAckSend {
+		if err := d.openTCPPayload(conn.rxCipher, tcpPacket); err != nil {
+			return nil, nil, err
+		}
+		d.updateFlowLifecycle(conn, tcpPacket, false)
 		return nil, nil, nil
 	}
 
@@ -637,10 +815,14 @@ func (d *Datapath) processNetworkAckPacket(context *PUContext, conn *TCPConnecti
 
 		tcpPacket.DropDetachedBytes()
 
-		// We accept the packet as a new flow
-		d.reportAcceptedFlow(tcpPacket, conn, conn.Auth.RemoteContextID, context.ManagementID, context, conn.FlowPolicy)
+		// We accept the packet as a new flow. Audited flows were already
+		// reported as rejected.
+		if !conn.audited {
+			d.reportAcceptedFlow(tcpPacket, conn, conn.Auth.RemoteContextID, context.ManagementID, context, conn.FlowPolicy)
+		}
 
 		conn.SetState(TCPData)
+		d.connectionEstablished(conn, tcpPacket, false, false)
 
 		if !conn.ServiceConnection {
 			if err := d.conntrackHdl.ConntrackTableUpdateMark(
@@ -649,7 +831,7 @@ func (d *Datapath) processNetworkAckPacket(context *PUContext, conn *TCPConnecti
 				tcpPacket.IPProto,
 				tcpPacket.SourcePort,
 				tcpPacket.DestinationPort,
-				constants.DefaultConnMark,
+				conn.connMark(),
 			); err != nil {
 				zap.L().Error("Failed to update conntrack table after ack packet")
 			}
@@ -690,10 +872,14 @@ func (d *Datapath) createAckPacketToken(context *PUContext, auth *AuthInfo) ([]b
 	return token, nil
 }
 
-// createSynPacketToken creates the authentication token
+// createSynPacketToken creates the authentication token. If the policy of the
+// PU encrypts flows, the token carries a new ephemeral key for every
+// connection and it is not cached, so that the encryption keys of the
+// connections are never reused. Otherwise the token is cached and only its
+// nonce is randomized.
 func (d *Datapath) createSynPacketToken(context *PUContext, auth *AuthInfo) (token []byte, err error) {
 
-	if context.synExpiration.After(time.Now()) && len(context.synToken) > 0 {
+	if !context.encrypt && context.synExpiration.After(time.Now()) && len(context.synToken) > 0 {
 		// Randomize the nonce and send it
 		auth.LocalContext, err = d.tokenEngine.Randomize(context.synToken)
 		if err == nil {
@@ -706,14 +892,23 @@ func (d *Datapath) createSynPacketToken(context *PUContext, auth *AuthInfo) (tok
 		T: context.Identity,
 	}
 
-	if context.synToken, auth.LocalContext, err = d.tokenEngine.CreateAndSign(false, claims); err != nil {
-		return []byte{}, nil
+	// Offer an ephemeral key so that the remote can enable encryption
+	if context.encrypt {
+		if auth.LocalEphemeralKey, claims.EK, err = createEphemeralKey(); err != nil {
+			return []byte{}, fmt.Errorf("Unable to create the ephemeral key: %s", err)
+		}
 	}
 
-	context.synExpiration = time.Now().Add(time.Millisecond * 500)
+	if token, auth.LocalContext, err = d.tokenEngine.CreateAndSign(false, claims); err != nil {
+		return []byte{}, fmt.Errorf("Unable to create the syn token: %s", err)
+	}
 
-	return context.synToken, nil
+	if !context.encrypt {
+		context.synToken = token
+		context.synExpiration = time.Now().Add(time.Millisecond * 500)
+	}
 
+	return token, nil
 }
 
 // createSynAckPacketToken  creates the authentication token for SynAck packets
@@ -725,11 +920,16 @@ func (d *Datapath) createSynAckPacketToken(context *PUContext, auth *AuthInfo) (
 		RMT: auth.RemoteContext,
 	}
 
-	if context.synToken, auth.LocalContext, err = d.tokenEngine.CreateAndSign(false, claims); err != nil {
-		return []byte{}, nil
+	// The ephemeral key is only present if the connection is encrypted
+	if auth.LocalEphemeralKey != nil {
+		claims.EK = marshalEphemeralKey(auth.LocalEphemeralKey)
 	}
 
-	return context.synToken, nil
+	if token, auth.LocalContext, err = d.tokenEngine.CreateAndSign(false, claims); err != nil {
+		return []byte{}, fmt.Errorf("Unable to create the synack token: %s", err)
+	}
+
+	return token, nil
 
 }
 
@@ -752,6 +952,7 @@ func (d *Datapath) parsePacketToken(auth *AuthInfo, data []byte) (*tokens.Connec
 	auth.RemotePublicKey = cert
 	auth.RemoteContext = nonce
 	auth.RemoteContextID = remoteContextID
+	auth.RemoteEphemeralKey = claims.EK
 
 	return claims, nil
 }
@@ -776,6 +977,26 @@ func (d *Datapath) parseAckToken(auth *AuthInfo, data []byte) (*tokens.Connectio
 	return claims, nil
 }
 
+// negotiateEncryption creates the ciphers of an encrypted connection. The
+// responder creates its ephemeral key once per connection and sends it back
+// in the SynAck token.
+func (d *Datapath) negotiateEncryption(conn *TCPConnection, initiator bool) error {
+
+	if len(conn.Auth.RemoteEphemeralKey) == 0 {
+		return fmt.Errorf("Remote did not offer an encryption key")
+	}
+
+	if !initiator && conn.Auth.LocalEphemeralKey == nil {
+		key, _, err := createEphemeralKey()
+		if err != nil {
+			return err
+		}
+		conn.Auth.LocalEphemeralKey = key
+	}
+
+	return conn.enableEncryption(initiator)
+}
+
 // createTCPAuthenticationOption creates the TCP authentication option -
 func (d *Datapath) createTCPAuthenticationOption(token []byte) []byte {
 
@@ -819,6 +1040,10 @@ func (d *Datapath) appRetrieveState(p *packet.Packet) (*PUContext, *TCPConnectio
 	if err != nil {
 		conn, err = d.appOrigConnectionTracker.GetReset(hash, 0)
 		if err != nil {
+			// Established connections outlive the negotiation state
+			if context, econn, eerr := d.establishedRetrieveState(p, true); eerr == nil {
+				return context, econn, nil
+			}
 			return nil, nil, fmt.Errorf("App state not found")
 		}
 		if uerr := updateTimer(d.appOrigConnectionTracker, hash, conn.(*TCPConnection)); uerr != nil {
@@ -892,6 +1117,10 @@ func (d *Datapath) netRetrieveState(p *packet.Packet) (*PUContext, *TCPConnectio
 	if err != nil {
 		conn, err = d.netOrigConnectionTracker.GetReset(hash, 0)
 		if err != nil {
+			// Established connections outlive the negotiation state
+			if context, econn, eerr := d.establishedRetrieveState(p, false); eerr == nil {
+				return context, econn, nil
+			}
 			return nil, nil, fmt.Errorf("Net state not found")
 		}
 		if uerr := updateTimer(d.netOrigConnectionTracker, hash, conn.(*TCPConnection)); uerr != nil {
@@ -144,6 +144,11 @@ func TestInvalidTokenContext(t *testing.T) {
 
 func setupProcessingUnitsInDatapathAndEnforce() (puInfo1, puInfo2 *policy.PUInfo, enforcer *Datapath, err1, err2 error) {
 
+	return setupProcessingUnitsInDatapathWithAction(policy.Accept)
+}
+
+func setupProcessingUnitsInDatapathWithAction(action policy.ActionType) (puInfo1, puInfo2 *policy.PUInfo, enforcer *Datapath, err1, err2 error) {
+
 	tagSelector := policy.TagSelector{
 
 		Clause: []policy.KeyValueOperator{
@@ -153,7 +158,7 @@ func setupProcessingUnitsInDatapathAndEnforce() (puInfo1, puInfo2 *policy.PUInfo
 				Operator: policy.Equal,
 			},
 		},
-		Policy: &policy.FlowPolicy{Action: policy.Accept},
+		Policy: &policy.FlowPolicy{Action: action},
 	}
 
 	iteration = iteration + 1
@@ -290,6 +295,259 @@ func TestPacketHandlingEndToEndPacketsMatch(t *testing.T) {
 	})
 }
 
+func TestPacketHandlingEndToEndEncryptedPayload(t *testing.T) {
+
+	Convey("Given I create a new enforcer instance and have a valid processing unit context", t, func() {
+
+		Convey("Given I create a two processing unit instances with an encryption policy", func() {
+			puInfo1, puInfo2, enforcer, err1, err2 := setupProcessingUnitsInDatapathWithAction(policy.Accept | policy.Encrypt)
+
+			So(puInfo1, ShouldNotBeNil)
+			So(puInfo2, ShouldNotBeNil)
+			So(err1, ShouldBeNil)
+			So(err2, ShouldBeNil)
+
+			Convey("When I pass multiple packets through the enforcer", func() {
+
+				encrypted := 0
+
+				for i, p := range TCPFlow {
+
+					input := make([]byte, len(p))
+					start := make([]byte, len(p))
+
+					copy(input, p)
+					copy(start, p)
+
+					oldPacket, err := packet.New(0, start, "0")
+					So(err, ShouldBeNil)
+					oldPacket.UpdateIPChecksum()
+					oldPacket.UpdateTCPChecksum()
+
+					tcpPacket, err := packet.New(0, input, "0")
+					So(err, ShouldBeNil)
+					tcpPacket.UpdateIPChecksum()
+					tcpPacket.UpdateTCPChecksum()
+
+					err = enforcer.processApplicationTCPPackets(tcpPacket)
+					So(err, ShouldBeNil)
+
+					output := make([]byte, len(tcpPacket.GetBytes()))
+					copy(output, tcpPacket.GetBytes())
+
+					outPacket, errp := packet.New(0, output, "0")
+					So(errp, ShouldBeNil)
+
+					// Data packets must not be visible on the wire
+					if i > 2 && len(oldPacket.ReadTCPData()) > 0 {
+						So(outPacket.ReadTCPData(), ShouldNotResemble, oldPacket.ReadTCPData())
+						So(outPacket.VerifyTCPChecksum(), ShouldBeTrue)
+						encrypted++
+					}
+
+					err = enforcer.processNetworkTCPPackets(outPacket)
+					So(err, ShouldBeNil)
+
+					// The receiver leaves room for the authentication tag in the MSS
+					if oldPacket.TCPFlags&packet.TCPSynMask != 0 {
+						oldPacket.DecreaseTCPMss(TCPEncryptionOptionLen)
+						oldPacket.UpdateTCPChecksum()
+					}
+
+					if !reflect.DeepEqual(oldPacket.GetBytes(), outPacket.GetBytes()) {
+						t.Errorf("Packet %d Input and output packet do not match", i)
+						t.FailNow()
+					}
+				}
+
+				Convey("Then I expect the payload to be encrypted on the wire and decrypted by the receiver", func() {
+
+					So(encrypted, ShouldBeGreaterThan, 0)
+				})
+			})
+		})
+	})
+}
+
+// policyDropCollector keeps the flows rejected by the policy
+type policyDropCollector struct {
+	collector.DefaultCollector
+	records []*collector.FlowRecord
+}
+
+func (c *policyDropCollector) CollectFlowEvent(record *collector.FlowRecord) {
+	if record.DropReason == collector.PolicyDrop {
+		c.records = append(c.records, record)
+	}
+}
+
+func TestPacketHandlingEndToEndAudit(t *testing.T) {
+
+	Convey("Given I create a new enforcer instance and have a valid processing unit context", t, func() {
+
+		Convey("Given I create a two processing unit instances with a reject policy", func() {
+			puInfo1, puInfo2, enforcer, err1, err2 := setupProcessingUnitsInDatapathWithAction(policy.Reject)
+
+			So(puInfo1, ShouldNotBeNil)
+			So(puInfo2, ShouldNotBeNil)
+			So(err1, ShouldBeNil)
+			So(err2, ShouldBeNil)
+
+			records := &policyDropCollector{}
+			enforcer.collector = records
+
+			Convey("When the server is policed, the Syn packet should be dropped", func() {
+				tcpPacket, err := packet.New(0, append([]byte{}, TCPFlow[0]...), "0")
+				So(err, ShouldBeNil)
+				So(enforcer.processApplicationTCPPackets(tcpPacket), ShouldBeNil)
+
+				outPacket, err := packet.New(0, append([]byte{}, tcpPacket.GetBytes()...), "0")
+				So(err, ShouldBeNil)
+				So(enforcer.processNetworkTCPPackets(outPacket), ShouldNotBeNil)
+				So(len(records.records), ShouldEqual, 1)
+
+				record := records.records[0]
+				So(record.Timestamp.IsZero(), ShouldBeFalse)
+				So(record.Protocol, ShouldEqual, packet.IPProtocolTCP)
+				So(record.TCPFlags, ShouldEqual, packet.TCPSynMask)
+				So(record.ObservationPoint, ShouldEqual, collector.ObservationNetwork)
+				So(record.Source.Port, ShouldEqual, outPacket.SourcePort)
+				So(record.Destination.Port, ShouldEqual, outPacket.DestinationPort)
+			})
+
+			Convey("When the server is audited", func() {
+				puInfo1.Policy.SetTriremeAction(policy.Audit)
+				So(enforcer.Enforce(puInfo1.ContextID, puInfo1), ShouldBeNil)
+
+				for i, p := range TCPFlow {
+
+					start := append([]byte{}, p...)
+					oldPacket, err := packet.New(0, start, "0")
+					So(err, ShouldBeNil)
+					oldPacket.UpdateIPChecksum()
+					oldPacket.UpdateTCPChecksum()
+
+					tcpPacket, err := packet.New(0, append([]byte{}, p...), "0")
+					So(err, ShouldBeNil)
+					tcpPacket.UpdateIPChecksum()
+					tcpPacket.UpdateTCPChecksum()
+
+					So(enforcer.processApplicationTCPPackets(tcpPacket), ShouldBeNil)
+
+					outPacket, err := packet.New(0, append([]byte{}, tcpPacket.GetBytes()...), "0")
+					So(err, ShouldBeNil)
+					So(enforcer.processNetworkTCPPackets(outPacket), ShouldBeNil)
+
+					if !reflect.DeepEqual(oldPacket.GetBytes(), outPacket.GetBytes()) {
+						t.Errorf("Packet %d Input and output packet do not match", i)
+						t.FailNow()
+					}
+				}
+
+				Convey("Then all the packets should be accepted and the decision should be reported once", func() {
+					So(len(records.records), ShouldEqual, 1)
+					So(records.records[0].Action, ShouldEqual, policy.Reject)
+					So(records.records[0].Destination.ID, ShouldEqual, puInfo1.Policy.ManagementID())
+				})
+			})
+		})
+	})
+}
+
+func TestPacketHandlingTokenlessAudit(t *testing.T) {
+
+	Convey("Given I create a new enforcer instance and have a valid processing unit context", t, func() {
+
+		Convey("Given I create a two processing unit instances with an accept policy", func() {
+			puInfo1, puInfo2, enforcer, err1, err2 := setupProcessingUnitsInDatapathWithAction(policy.Accept)
+
+			So(puInfo1, ShouldNotBeNil)
+			So(puInfo2, ShouldNotBeNil)
+			So(err1, ShouldBeNil)
+			So(err2, ShouldBeNil)
+
+			records := &recordingCollector{}
+			enforcer.collector = records
+
+			// tokenless returns the packet with the authentication option but
+			// without any token
+			tokenless := func(p []byte) *packet.Packet {
+				tcpPacket, err := packet.New(0, append([]byte{}, p...), "0")
+				So(err, ShouldBeNil)
+				So(tcpPacket.TCPDataAttach(enforcer.createTCPAuthenticationOption([]byte{}), []byte{}), ShouldBeNil)
+
+				outPacket, err := packet.New(0, append([]byte{}, tcpPacket.GetBytes()...), "0")
+				So(err, ShouldBeNil)
+				return outPacket
+			}
+
+			Convey("When the policed server receives a Syn packet without token, it should be dropped", func() {
+				So(enforcer.processNetworkTCPPackets(tokenless(TCPFlow[0])), ShouldNotBeNil)
+				So(len(records.records), ShouldEqual, 1)
+				So(records.records[0].DropReason, ShouldEqual, collector.InvalidToken)
+			})
+
+			Convey("When the audited server receives a Syn packet without token", func() {
+				puInfo1.Policy.SetTriremeAction(policy.Audit)
+				So(enforcer.Enforce(puInfo1.ContextID, puInfo1), ShouldBeNil)
+
+				synPacket := tokenless(TCPFlow[0])
+				err := enforcer.processNetworkTCPPackets(synPacket)
+
+				Convey("Then the packet should be accepted without the option and reported", func() {
+					So(err, ShouldBeNil)
+					So(synPacket.CheckTCPAuthenticationOption(TCPAuthenticationOptionBaseLen), ShouldNotBeNil)
+					So(len(records.records), ShouldEqual, 1)
+					So(records.records[0].DropReason, ShouldEqual, collector.InvalidToken)
+				})
+
+				Convey("Then the SynAck packet of the server should not carry a token", func() {
+					synAckPacket, err := packet.New(0, append([]byte{}, TCPFlow[1]...), "0")
+					So(err, ShouldBeNil)
+					So(enforcer.processApplicationTCPPackets(synAckPacket), ShouldBeNil)
+					So(len(synAckPacket.GetBytes()), ShouldEqual, len(TCPFlow[1]))
+				})
+			})
+
+			Convey("When the policed client receives a SynAck packet without token, it should be dropped", func() {
+				synPacket, err := packet.New(0, append([]byte{}, TCPFlow[0]...), "0")
+				So(err, ShouldBeNil)
+				So(enforcer.processApplicationTCPPackets(synPacket), ShouldBeNil)
+
+				So(enforcer.processNetworkTCPPackets(tokenless(TCPFlow[1])), ShouldNotBeNil)
+				So(len(records.records), ShouldEqual, 1)
+				So(records.records[0].DropReason, ShouldEqual, collector.MissingToken)
+			})
+
+			Convey("When the audited client receives a SynAck packet without token", func() {
+				puInfo2.Policy.SetTriremeAction(policy.Audit)
+				So(enforcer.Enforce(puInfo2.ContextID, puInfo2), ShouldBeNil)
+
+				synPacket, err := packet.New(0, append([]byte{}, TCPFlow[0]...), "0")
+				So(err, ShouldBeNil)
+				So(enforcer.processApplicationTCPPackets(synPacket), ShouldBeNil)
+
+				synAckPacket := tokenless(TCPFlow[1])
+				err = enforcer.processNetworkTCPPackets(synAckPacket)
+
+				Convey("Then the packet should be accepted without the option and reported", func() {
+					So(err, ShouldBeNil)
+					So(synAckPacket.CheckTCPAuthenticationOption(TCPAuthenticationOptionBaseLen), ShouldNotBeNil)
+					So(len(records.records), ShouldEqual, 1)
+					So(records.records[0].DropReason, ShouldEqual, collector.MissingToken)
+				})
+
+				Convey("Then the Ack packet of the client should not carry a token", func() {
+					ackPacket, err := packet.New(0, append([]byte{}, TCPFlow[2]...), "0")
+					So(err, ShouldBeNil)
+					So(enforcer.processApplicationTCPPackets(ackPacket), ShouldBeNil)
+					So(len(ackPacket.GetBytes()), ShouldEqual, len(TCPFlow[2]))
+				})
+			})
+		})
+	})
+}
+
 func TestPacketHandlingFirstThreePacketsHavePayload(t *testing.T) {
 
 	SIP := net.IPv4zero
@@ -777,6 +1035,19 @@ func TestCacheState(t *testing.T) {
 	}
 }
 
+func TestMetricsInstance(t *testing.T) {
+
+	secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+	collector := &collector.DefaultCollector{}
+	first := NewWithDefaults("SomeServerId", collector, nil, secret, constants.LocalContainer, "/proc").(*Datapath)
+	second := NewWithDefaults("SomeServerId", collector, nil, secret, constants.LocalContainer, "/proc").(*Datapath)
+
+	// Should not collide: the caches of both enforcers are exported
+	if first.instance == second.instance {
+		t.Errorf("Expected distinct instances, got %s twice", first.instance)
+	}
+}
+
 func TestDoCreatePU(t *testing.T) {
 
 	Convey("Given an initialized enforcer for Linux Processes", t, func() {
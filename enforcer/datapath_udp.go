@@ -0,0 +1,631 @@
+package enforcer
+
+// Go libraries
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/enforcer/utils/packet"
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+// UDP flows are authorized with the same tokens as TCP connections. Since UDP
+// has no flags or options, the tokens are appended to the payload of the first
+// datagrams of a flow (see createUDPAuthTrailer) and removed by the enforcer on
+// the other side before the datagram is delivered to the application:
+//
+//   initiator                                   responder
+//   datagram + Syn token      ------------>    validate against receiver rules
+//                             <------------    datagram + SynAck token
+//   validate transmitter rules
+//   datagram + Ack token      ------------>    validate nonces
+//                             <------------    datagram (no token)
+//   mark conntrack
+//   datagram (no token)       ------------>    mark conntrack
+//
+// The initiator keeps sending the Syn token until it sees a reply, so a
+// unidirectional flow is validated on every datagram. Destinations matched by
+// the UDP application ACLs are considered external services and never receive
+// a token.
+
+// processNetworkUDPPackets processes UDP packets arriving from the network and are destined to the application
+func (d *Datapath) processNetworkUDPPackets(p *packet.Packet) (err error) {
+
+	zap.L().Debug("Processing network UDP packet ",
+		zap.String("flow", p.L4FlowHash()),
+	)
+
+	defer zap.L().Debug("Finished Processing network UDP packet ",
+		zap.String("flow", p.L4FlowHash()),
+		zap.Error(err),
+	)
+
+	context, conn, reply, err := d.netUDPRetrieveState(p)
+	if err != nil {
+		zap.L().Debug("Packet rejected",
+			zap.String("flow", p.L4FlowHash()),
+			zap.Error(err),
+		)
+		return err
+	}
+
+	conn.Lock()
+	defer conn.Unlock()
+
+	// The policy does not allow the flow any more
+	if conn.revoked {
+		return fmt.Errorf("Dropping datagram of revoked flow %s", p.L4FlowHash())
+	}
+
+	p.Print(packet.PacketStageIncoming)
+	p.Print(packet.PacketStageAuth)
+
+	if reply {
+		err = d.processNetworkUDPReplyPacket(p, context, conn)
+	} else {
+		err = d.processNetworkUDPPacket(p, context, conn)
+	}
+
+	if err != nil {
+		p.Print(packet.PacketFailureAuth)
+		zap.L().Debug("Rejecting packet ",
+			zap.String("flow", p.L4FlowHash()),
+			zap.Error(err),
+		)
+		return fmt.Errorf("Packet processing failed for network packet: %s", err.Error())
+	}
+
+	p.Print(packet.PacketStageOutgoing)
+
+	return nil
+}
+
+// processApplicationUDPPackets processes UDP packets arriving from an application and are destined to the network
+func (d *Datapath) processApplicationUDPPackets(p *packet.Packet) (err error) {
+
+	zap.L().Debug("Processing application UDP packet ",
+		zap.String("flow", p.L4FlowHash()),
+	)
+
+	defer zap.L().Debug("Finished Processing application UDP packet ",
+		zap.String("flow", p.L4FlowHash()),
+		zap.Error(err),
+	)
+
+	context, conn, reply, err := d.appUDPRetrieveState(p)
+	if err != nil {
+		zap.L().Debug("Packet rejected",
+			zap.String("flow", p.L4FlowHash()),
+			zap.Error(err),
+		)
+		return err
+	}
+
+	conn.Lock()
+	defer conn.Unlock()
+
+	// The policy does not allow the flow any more
+	if conn.revoked {
+		return fmt.Errorf("Dropping datagram of revoked flow %s", p.L4FlowHash())
+	}
+
+	p.Print(packet.PacketStageIncoming)
+	p.Print(packet.PacketStageAuth)
+
+	if reply {
+		err = d.processApplicationUDPReplyPacket(p, context, conn)
+	} else {
+		err = d.processApplicationUDPPacket(p, context, conn)
+	}
+
+	if err != nil {
+		p.Print(packet.PacketFailureAuth)
+		zap.L().Debug("Dropping packet  ",
+			zap.String("flow", p.L4FlowHash()),
+			zap.Error(err),
+		)
+		return fmt.Errorf("Processing failed for application packet: %s", err.Error())
+	}
+
+	p.Print(packet.PacketStageOutgoing)
+
+	return nil
+}
+
+// processApplicationUDPPacket processes a datagram of a flow initiated by the application
+func (d *Datapath) processApplicationUDPPacket(udpPacket *packet.Packet, context *PUContext, conn *UDPConnection) error {
+
+	switch conn.GetState() {
+
+	case UDPStart:
+		context.Lock()
+
+		// If the destination is a known external service, allow
+		externalKey := udpPacket.DestinationAddress.String() + ":" + strconv.Itoa(int(udpPacket.DestinationPort))
+		if plc, err := context.externalIPCache.Get(externalKey); err == nil {
+			context.Unlock()
+			conn.FlowPolicy = plc.(*policy.FlowPolicy)
+			conn.SetState(UDPData)
+			d.trackUDPConnection(udpPacket.L4FlowHash(), conn, true)
+			return nil
+		}
+
+		// Destinations covered by the ACLs are external services. They never get a token.
+		if plc, err := context.UDPApplicationACLs.GetMatchingAction(udpPacket.DestinationAddress, udpPacket.DestinationPort); err == nil {
+			d.reportExternalServiceFlow(context, plc, true, udpPacket)
+			conn.SetReported()
+
+			if plc.Action&policy.Reject > 0 && !context.audit {
+				context.Unlock()
+				return fmt.Errorf("Drop it")
+			}
+
+			// Audited flows are not cached so that they are reported again
+			if plc.Action&policy.Reject > 0 {
+				plc = auditFlowPolicy(plc)
+			} else {
+				context.externalIPCache.AddOrUpdate(externalKey, plc)
+			}
+			context.Unlock()
+
+			conn.FlowPolicy = plc
+			conn.SetState(UDPData)
+			d.trackUDPConnection(udpPacket.L4FlowHash(), conn, true)
+			return nil
+		}
+
+		token, err := d.createSynPacketToken(context, &conn.Auth)
+		context.Unlock()
+		if err != nil {
+			return err
+		}
+
+		// The cached syn token of the context is randomized in place for
+		// every flow. Keep our own copy.
+		conn.token = append([]byte{}, token...)
+		conn.SetState(UDPSynSend)
+		d.trackUDPConnection(udpPacket.L4FlowHash(), conn, true)
+
+		return udpPacket.UDPDataAttach(createUDPAuthTrailer(conn.token, UDPSynToken))
+
+	case UDPSynSend:
+		// No reply yet. Keep sending the same token.
+		return udpPacket.UDPDataAttach(createUDPAuthTrailer(conn.token, UDPSynToken))
+
+	case UDPSynAckReceived:
+		context.Lock()
+		token, err := d.createAckPacketToken(context, &conn.Auth)
+		context.Unlock()
+		if err != nil {
+			return err
+		}
+
+		conn.token = token
+		conn.SetState(UDPAckSend)
+
+		return udpPacket.UDPDataAttach(createUDPAuthTrailer(conn.token, UDPAckToken))
+
+	case UDPAckSend:
+		// The other side has not confirmed the Ack yet
+		return udpPacket.UDPDataAttach(createUDPAuthTrailer(conn.token, UDPAckToken))
+
+	case UDPData:
+		return nil
+	}
+
+	return fmt.Errorf("Received application UDP packet in the wrong state! %v", conn.GetState())
+}
+
+// processApplicationUDPReplyPacket processes a datagram of a flow initiated from the network
+func (d *Datapath) processApplicationUDPReplyPacket(udpPacket *packet.Packet, context *PUContext, conn *UDPConnection) error {
+
+	switch conn.GetState() {
+
+	case UDPSynReceived, UDPSynAckSend:
+		if len(conn.token) == 0 {
+			context.Lock()
+			token, err := d.createSynAckPacketToken(context, &conn.Auth)
+			context.Unlock()
+			if err != nil {
+				return err
+			}
+			conn.token = append([]byte{}, token...)
+		}
+
+		conn.SetState(UDPSynAckSend)
+
+		return udpPacket.UDPDataAttach(createUDPAuthTrailer(conn.token, UDPSynAckToken))
+
+	case UDPData:
+		return nil
+	}
+
+	return fmt.Errorf("Received application UDP reply in the wrong state! %v", conn.GetState())
+}
+
+// processNetworkUDPPacket processes a datagram of a flow initiated from the network
+func (d *Datapath) processNetworkUDPPacket(udpPacket *packet.Packet, context *PUContext, conn *UDPConnection) error {
+
+	context.Lock()
+	defer context.Unlock()
+
+	token, tokenType, err := parseUDPAuthTrailer(udpPacket.ReadUDPData())
+	if err != nil {
+
+		switch conn.GetState() {
+
+		case UDPData:
+			// The initiator has completed the negotiation
+			d.markUDPConnection(udpPacket, conn, false)
+			return nil
+
+		case UDPStart:
+			// If there is no token, attempt the ACLs
+			plc, perr := context.UDPNetworkACLs.GetMatchingAction(udpPacket.SourceAddress, udpPacket.DestinationPort)
+			d.reportExternalServiceFlow(context, plc, false, udpPacket)
+			conn.SetReported()
+			if perr != nil || plc.Action&policy.Reject > 0 {
+				if !context.audit {
+					return fmt.Errorf("Drop it")
+				}
+				plc = auditFlowPolicy(plc)
+			}
+
+			conn.FlowPolicy = plc
+			conn.SetState(UDPData)
+			d.trackUDPConnection(udpPacket.L4FlowHash(), conn, false)
+			return nil
+		}
+
+		d.reportUDPRejectedFlow(udpPacket, conn, collector.DefaultEndPoint, context.ManagementID, context, collector.MissingToken, nil)
+		if !context.audit {
+			return fmt.Errorf("UDP packet dropped because of missing token in state %v", conn.GetState())
+		}
+		return d.acceptAuditedUDPPacket(udpPacket, conn, false)
+	}
+
+	// Remove our data from the packet. No matter what we don't need it any more.
+	if err = udpPacket.UDPDataDetach(uint16(len(token) + UDPAuthTrailerLen)); err != nil {
+		d.reportUDPRejectedFlow(udpPacket, conn, collector.DefaultEndPoint, context.ManagementID, context, collector.InvalidFormat, nil)
+		if !context.audit {
+			return fmt.Errorf("UDP packet dropped because of invalid format %v", err)
+		}
+		return d.acceptAuditedUDPPacket(udpPacket, conn, false)
+	}
+
+	switch tokenType {
+
+	case UDPSynToken:
+		return d.processNetworkUDPSynToken(udpPacket, context, conn, token)
+
+	case UDPAckToken:
+		if conn.GetState() != UDPSynAckSend && conn.GetState() != UDPData {
+			d.reportUDPRejectedFlow(udpPacket, conn, conn.Auth.RemoteContextID, context.ManagementID, context, collector.InvalidState, nil)
+			if !context.audit {
+				return fmt.Errorf("UDP Ack token dropped - Invalid State: %v", conn.GetState())
+			}
+			return d.acceptAuditedUDPPacket(udpPacket, conn, false)
+		}
+
+		if _, err = d.parseAckToken(&conn.Auth, token); err != nil {
+			d.reportUDPRejectedFlow(udpPacket, conn, conn.Auth.RemoteContextID, context.ManagementID, context, collector.InvalidFormat, nil)
+			if !context.audit {
+				return fmt.Errorf("UDP Ack token dropped because signature validation failed %v", err)
+			}
+			return d.acceptAuditedUDPPacket(udpPacket, conn, false)
+		}
+
+		conn.token = nil
+		conn.SetState(UDPData)
+		return nil
+	}
+
+	d.reportUDPRejectedFlow(udpPacket, conn, collector.DefaultEndPoint, context.ManagementID, context, collector.InvalidFormat, nil)
+	if !context.audit {
+		return fmt.Errorf("UDP packet dropped because of unexpected token type %d", tokenType)
+	}
+	return d.acceptAuditedUDPPacket(udpPacket, conn, false)
+}
+
+// processNetworkUDPSynToken validates the token of the initiator of a flow against the receiver rules
+func (d *Datapath) processNetworkUDPSynToken(udpPacket *packet.Packet, context *PUContext, conn *UDPConnection, token []byte) error {
+
+	claims, err := d.parsePacketToken(&conn.Auth, token)
+	if err != nil || claims == nil {
+		d.reportUDPRejectedFlow(udpPacket, conn, collector.DefaultEndPoint, context.ManagementID, context, collector.InvalidToken, nil)
+		if !context.audit {
+			return fmt.Errorf("UDP packet dropped because of invalid token %v %+v", err, claims)
+		}
+		return d.acceptAuditedUDPPacket(udpPacket, conn, false)
+	}
+
+	txLabel, _ := claims.T.Get(TransmitterLabel)
+	conn.remoteTags = claims.T
+
+	// Add the port as a label with an @ prefix. These labels are invalid otherwise
+	// If all policies are restricted by port numbers this will allow port-specific policies
+	claims.T.AppendKeyValue(PortNumberLabelString, strconv.Itoa(int(udpPacket.DestinationPort)))
+
+	// Validate against reject rules first - We always process reject with higher priority
+	// Audited PUs report the rejected flows and accept them
+	if index, plc := context.RejectRcvRules.Search(claims.T); index >= 0 {
+		d.reportUDPRejectedFlow(udpPacket, conn, txLabel, context.ManagementID, context, collector.PolicyDrop, plc.(*policy.FlowPolicy))
+		if !context.audit {
+			return fmt.Errorf("Flow rejected because of policy %+v", claims.T)
+		}
+		conn.FlowPolicy = auditFlowPolicy(plc.(*policy.FlowPolicy))
+	} else if index, action := context.AcceptRcvRules.Search(claims.T); index >= 0 {
+		conn.FlowPolicy = action.(*policy.FlowPolicy)
+	} else {
+		d.reportUDPRejectedFlow(udpPacket, conn, txLabel, context.ManagementID, context, collector.PolicyDrop, nil)
+		if !context.audit {
+			return fmt.Errorf("No matched tags - reject %+v", claims.T)
+		}
+		conn.FlowPolicy = auditFlowPolicy(nil)
+	}
+
+	// Unlike TCP there is no guarantee that the initiator will ever send an Ack.
+	// The flow is reported as soon as it is accepted.
+	if !conn.Reported() {
+		d.reportUDPAcceptedFlow(udpPacket, conn, txLabel, context.ManagementID, context, conn.FlowPolicy)
+	}
+
+	// A Syn token on an established flow means that the initiator started over
+	if conn.GetState() != UDPSynAckSend {
+		conn.token = nil
+		conn.SetState(UDPSynReceived)
+	}
+
+	d.trackUDPConnection(udpPacket.L4FlowHash(), conn, false)
+
+	return nil
+}
+
+// processNetworkUDPReplyPacket processes a datagram of a flow initiated by the application
+func (d *Datapath) processNetworkUDPReplyPacket(udpPacket *packet.Packet, context *PUContext, conn *UDPConnection) error {
+
+	context.Lock()
+	defer context.Unlock()
+
+	token, tokenType, err := parseUDPAuthTrailer(udpPacket.ReadUDPData())
+	if err != nil {
+
+		// A reply without a token means that the other side has completed the
+		// negotiation or that this is an external service.
+		if conn.GetState() == UDPAckSend || conn.GetState() == UDPData {
+			conn.token = nil
+			conn.SetState(UDPData)
+			d.markUDPConnection(udpPacket, conn, true)
+			return nil
+		}
+
+		d.reportUDPRejectedFlow(udpPacket, conn, collector.DefaultEndPoint, context.ManagementID, context, collector.MissingToken, nil)
+		if !context.audit {
+			return fmt.Errorf("UDP reply dropped because of missing token in state %v", conn.GetState())
+		}
+		return d.acceptAuditedUDPPacket(udpPacket, conn, true)
+	}
+
+	// Audited PUs report the replies that fail the authentication and
+	// accept them without our data
+	auditReply := func() error {
+		if err := udpPacket.UDPDataDetach(uint16(len(token) + UDPAuthTrailerLen)); err != nil {
+			return fmt.Errorf("UDP reply dropped because of invalid format %v", err)
+		}
+		return d.acceptAuditedUDPPacket(udpPacket, conn, true)
+	}
+
+	if tokenType != UDPSynAckToken {
+		d.reportUDPRejectedFlow(udpPacket, conn, collector.DefaultEndPoint, context.ManagementID, context, collector.InvalidFormat, nil)
+		if !context.audit {
+			return fmt.Errorf("UDP reply dropped because of unexpected token type %d", tokenType)
+		}
+		return auditReply()
+	}
+
+	switch conn.GetState() {
+
+	case UDPSynSend:
+		claims, err := d.parsePacketToken(&conn.Auth, token)
+		if err != nil || claims == nil {
+			d.reportUDPRejectedFlow(udpPacket, conn, collector.DefaultEndPoint, context.ManagementID, context, collector.MissingToken, nil)
+			if !context.audit {
+				return fmt.Errorf("UDP reply dropped because of bad claims %v", claims)
+			}
+			return auditReply()
+		}
+		conn.remoteTags = claims.T
+
+		// We can now verify the reverse policy. The system requires that policy
+		// is matched in both directions. We have to make this optional as it can
+		// become a very strong condition
+		if index, _ := context.RejectTxtRules.Search(claims.T); d.mutualAuthorization && index >= 0 {
+			d.reportUDPRejectedFlow(udpPacket, conn, context.ManagementID, conn.Auth.RemoteContextID, context, collector.PolicyDrop, nil)
+			if !context.audit {
+				return fmt.Errorf("Dropping because of reject rule on transmitter")
+			}
+		} else if index, _ := context.AcceptTxtRules.Search(claims.T); d.mutualAuthorization && index < 0 {
+			d.reportUDPRejectedFlow(udpPacket, conn, context.ManagementID, conn.Auth.RemoteContextID, context, collector.PolicyDrop, nil)
+			if !context.audit {
+				return fmt.Errorf("Dropping UDP reply at the network")
+			}
+		}
+
+		conn.SetState(UDPSynAckReceived)
+
+	case UDPSynAckReceived, UDPAckSend:
+		// Duplicate SynAck. The other side has not received our Ack yet.
+
+	default:
+		d.reportUDPRejectedFlow(udpPacket, conn, conn.Auth.RemoteContextID, context.ManagementID, context, collector.InvalidState, nil)
+		if !context.audit {
+			return fmt.Errorf("UDP reply dropped - Invalid State: %v", conn.GetState())
+		}
+		return auditReply()
+	}
+
+	if err := udpPacket.UDPDataDetach(uint16(len(token) + UDPAuthTrailerLen)); err != nil {
+		d.reportUDPRejectedFlow(udpPacket, conn, context.ManagementID, conn.Auth.RemoteContextID, context, collector.InvalidFormat, nil)
+		if !context.audit {
+			return fmt.Errorf("UDP reply dropped because of invalid format %v", err)
+		}
+		return d.acceptAuditedUDPPacket(udpPacket, conn, true)
+	}
+
+	return nil
+}
+
+// acceptAuditedUDPPacket accepts a datagram of an audited PU that failed the
+// authentication. The flow continues without tokens, like the flows accepted
+// by the ACLs.
+func (d *Datapath) acceptAuditedUDPPacket(udpPacket *packet.Packet, conn *UDPConnection, reply bool) error {
+
+	conn.token = nil
+	conn.FlowPolicy = auditFlowPolicy(nil)
+	conn.SetState(UDPData)
+
+	if reply {
+		d.markUDPConnection(udpPacket, conn, true)
+		return nil
+	}
+
+	d.trackUDPConnection(udpPacket.L4FlowHash(), conn, false)
+	return nil
+}
+
+// markUDPConnection marks the conntrack entry of the flow so that the rest of
+// the datagrams bypass the data path. It is only called for datagrams in the
+// network direction when the conntrack entry is guaranteed to exist.
+func (d *Datapath) markUDPConnection(udpPacket *packet.Packet, conn *UDPConnection, reply bool) {
+
+	if conn.marked {
+		return
+	}
+
+	src, dst := udpPacket.SourceAddress.String(), udpPacket.DestinationAddress.String()
+	sport, dport := udpPacket.SourcePort, udpPacket.DestinationPort
+	if reply {
+		src, dst = dst, src
+		sport, dport = dport, sport
+	}
+
+	if err := d.conntrackHdl.ConntrackTableUpdateMark(
+		src,
+		dst,
+		udpPacket.IPProto,
+		sport,
+		dport,
+		constants.DefaultConnMark,
+	); err != nil {
+		zap.L().Error("Failed to update conntrack table for UDP flow",
+			zap.String("flow", udpPacket.L4FlowHash()),
+			zap.Error(err),
+		)
+		return
+	}
+
+	conn.marked = true
+}
+
+// createUDPAuthTrailer returns the token followed by the trailer that identifies it
+func createUDPAuthTrailer(token []byte, tokenType uint8) []byte {
+
+	data := make([]byte, len(token)+UDPAuthTrailerLen)
+
+	copy(data, token)
+	binary.BigEndian.PutUint16(data[len(token):], uint16(len(token)))
+	data[len(token)+2] = tokenType
+	copy(data[len(token)+3:], UDPAuthMarker)
+
+	return data
+}
+
+// parseUDPAuthTrailer extracts the token and its type from the end of a UDP payload.
+// It returns an error if the payload doesn't carry a token.
+func parseUDPAuthTrailer(data []byte) ([]byte, uint8, error) {
+
+	if len(data) < UDPAuthTrailerLen || string(data[len(data)-len(UDPAuthMarker):]) != UDPAuthMarker {
+		return nil, 0, fmt.Errorf("No token found")
+	}
+
+	trailer := data[len(data)-UDPAuthTrailerLen:]
+
+	tokenLen := int(binary.BigEndian.Uint16(trailer[0:2]))
+	if tokenLen > len(data)-UDPAuthTrailerLen {
+		return nil, 0, fmt.Errorf("Invalid token length %d", tokenLen)
+	}
+
+	start := len(data) - UDPAuthTrailerLen - tokenLen
+
+	return data[start : start+tokenLen], trailer[2], nil
+}
+
+// appUDPRetrieveState retrieves the state for application datagrams. It
+// returns true if the datagram is a reply to a flow initiated from the network.
+// It creates a new connection by default.
+func (d *Datapath) appUDPRetrieveState(p *packet.Packet) (*PUContext, *UDPConnection, bool, error) {
+
+	if conn, err := d.udpNetConnectionTracker.GetReset(p.L4ReverseFlowHash(), 0); err == nil {
+		return conn.(*UDPConnection).Context, conn.(*UDPConnection), true, nil
+	}
+
+	if conn, err := d.udpAppConnectionTracker.GetReset(p.L4FlowHash(), 0); err == nil {
+		return conn.(*UDPConnection).Context, conn.(*UDPConnection), false, nil
+	}
+
+	context, err := d.contextFromIP(true, p.SourceAddress.String(), p.Mark, strconv.Itoa(int(p.SourcePort)))
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("No Context in App Processing")
+	}
+
+	conn := NewUDPConnection()
+	conn.Context = context
+	setUDPFlowEndPoints(conn, p)
+
+	return context, conn, false, nil
+}
+
+// netUDPRetrieveState retrieves the state for network datagrams. It returns
+// true if the datagram is a reply to a flow initiated by the application.
+// It creates a new connection by default.
+func (d *Datapath) netUDPRetrieveState(p *packet.Packet) (*PUContext, *UDPConnection, bool, error) {
+
+	if conn, err := d.udpAppConnectionTracker.GetReset(p.L4ReverseFlowHash(), 0); err == nil {
+		return conn.(*UDPConnection).Context, conn.(*UDPConnection), true, nil
+	}
+
+	if conn, err := d.udpNetConnectionTracker.GetReset(p.L4FlowHash(), 0); err == nil {
+		return conn.(*UDPConnection).Context, conn.(*UDPConnection), false, nil
+	}
+
+	context, err := d.contextFromIP(false, p.DestinationAddress.String(), p.Mark, strconv.Itoa(int(p.DestinationPort)))
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("No Context in Net Processing")
+	}
+
+	conn := NewUDPConnection()
+	conn.Context = context
+	setUDPFlowEndPoints(conn, p)
+
+	return context, conn, false, nil
+}
+
+// setUDPFlowEndPoints records the end points of a new flow from its first
+// datagram, which is sent by the initiator
+func setUDPFlowEndPoints(conn *UDPConnection, p *packet.Packet) {
+
+	conn.source = collector.EndPoint{
+		IP:   p.SourceAddress.String(),
+		Port: p.SourcePort,
+	}
+
+	conn.destination = collector.EndPoint{
+		IP:   p.DestinationAddress.String(),
+		Port: p.DestinationPort,
+	}
+}
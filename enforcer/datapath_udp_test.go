@@ -0,0 +1,179 @@
+package enforcer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/aporeto-inc/trireme/enforcer/utils/packet"
+	"github.com/aporeto-inc/trireme/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// createUDPPacket creates a valid IPv4/UDP datagram
+func createUDPPacket(src, dst string, sport, dport uint16, payload []byte) *packet.Packet {
+
+	buf := make([]byte, 28+len(payload))
+
+	buf[0] = 0x45
+	binary.BigEndian.PutUint16(buf[2:4], uint16(len(buf)))
+	buf[8] = 64
+	buf[9] = packet.IPProtocolUDP
+	copy(buf[12:16], net.ParseIP(src).To4())
+	copy(buf[16:20], net.ParseIP(dst).To4())
+	binary.BigEndian.PutUint16(buf[20:22], sport)
+	binary.BigEndian.PutUint16(buf[22:24], dport)
+	binary.BigEndian.PutUint16(buf[24:26], uint16(8+len(payload)))
+	copy(buf[28:], payload)
+
+	p, err := packet.New(0, buf, "0")
+	if err != nil {
+		return nil
+	}
+
+	p.UpdateIPChecksum()
+	p.UpdateUDPChecksum()
+
+	return p
+}
+
+// passUDPPacket sends a datagram through the application and the network
+// processing and returns the datagram delivered to the other side
+func passUDPPacket(enforcer *Datapath, p *packet.Packet) (*packet.Packet, bool, error) {
+
+	if err := enforcer.processApplicationUDPPackets(p); err != nil {
+		return nil, false, err
+	}
+
+	_, _, terr := parseUDPAuthTrailer(p.ReadUDPData())
+
+	output := make([]byte, len(p.GetBytes()))
+	copy(output, p.GetBytes())
+
+	outPacket, err := packet.New(0, output, "0")
+	if err != nil {
+		return nil, false, err
+	}
+
+	if !outPacket.VerifyIPChecksum() || !outPacket.VerifyUDPChecksum() {
+		return nil, false, fmt.Errorf("Bad checksum")
+	}
+
+	return outPacket, terr == nil, enforcer.processNetworkUDPPackets(outPacket)
+}
+
+func TestUDPAuthTrailer(t *testing.T) {
+
+	Convey("Given a token", t, func() {
+		token := []byte("sometoken")
+
+		Convey("When I create and parse a trailer, I should get the same token", func() {
+			data := append([]byte("payload"), createUDPAuthTrailer(token, UDPSynAckToken)...)
+			parsed, tokenType, err := parseUDPAuthTrailer(data)
+			So(err, ShouldBeNil)
+			So(parsed, ShouldResemble, token)
+			So(tokenType, ShouldEqual, UDPSynAckToken)
+		})
+
+		Convey("When I parse a payload without a trailer, I should get an error", func() {
+			_, _, err := parseUDPAuthTrailer([]byte("payload"))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When I parse a trailer with a bad length, I should get an error", func() {
+			data := createUDPAuthTrailer(token, UDPSynToken)
+			_, _, err := parseUDPAuthTrailer(data[2:])
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestUDPPacketHandlingEndToEnd(t *testing.T) {
+
+	Convey("Given I create a new enforcer instance and have two processing units", t, func() {
+		puInfo1, puInfo2, enforcer, err1, err2 := setupProcessingUnitsInDatapathAndEnforce()
+		So(puInfo1, ShouldNotBeNil)
+		So(puInfo2, ShouldNotBeNil)
+		So(err1, ShouldBeNil)
+		So(err2, ShouldBeNil)
+
+		client, server := "10.1.10.76", "164.67.228.152"
+
+		Convey("When I exchange datagrams between the two processing units", func() {
+
+			flow := []struct {
+				fromClient bool
+				payload    string
+				token      bool
+			}{
+				{true, "query1", true},
+				{false, "response1", true},
+				{true, "query2", true},
+				{false, "response2", false},
+				{true, "query3", false},
+				{false, "response3", false},
+			}
+
+			for _, f := range flow {
+				var p *packet.Packet
+				if f.fromClient {
+					p = createUDPPacket(client, server, 3456, 53, []byte(f.payload))
+				} else {
+					p = createUDPPacket(server, client, 53, 3456, []byte(f.payload))
+				}
+				So(p, ShouldNotBeNil)
+
+				out, hasToken, err := passUDPPacket(enforcer, p)
+				So(err, ShouldBeNil)
+				So(hasToken, ShouldEqual, f.token)
+				So(string(out.ReadUDPData()), ShouldEqual, f.payload)
+			}
+
+			Convey("Then both sides of the flow should be in the data state", func() {
+				conn, err := enforcer.udpAppConnectionTracker.Get(client + ":" + server + ":3456:53")
+				So(err, ShouldBeNil)
+				So(conn.(*UDPConnection).GetState(), ShouldEqual, UDPData)
+
+				conn, err = enforcer.udpNetConnectionTracker.Get(client + ":" + server + ":3456:53")
+				So(err, ShouldBeNil)
+				So(conn.(*UDPConnection).GetState(), ShouldEqual, UDPData)
+			})
+		})
+
+		Convey("When I send a datagram without a token and there is no ACL, it should be dropped", func() {
+			p := createUDPPacket(client, server, 3457, 53, []byte("query"))
+			So(p, ShouldNotBeNil)
+
+			err := enforcer.processNetworkUDPPackets(p)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When I send a datagram with an invalid token, it should be dropped", func() {
+			p := createUDPPacket(client, server, 3458, 53, append([]byte("query"), createUDPAuthTrailer([]byte("badtoken"), UDPSynToken)...))
+			So(p, ShouldNotBeNil)
+
+			err := enforcer.processNetworkUDPPackets(p)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When I send a datagram with an invalid token to an audited processing unit", func() {
+			puInfo1.Policy.SetTriremeAction(policy.Audit)
+			So(enforcer.Enforce(puInfo1.ContextID, puInfo1), ShouldBeNil)
+
+			p := createUDPPacket(client, server, 3459, 53, append([]byte("query"), createUDPAuthTrailer([]byte("badtoken"), UDPSynToken)...))
+			So(p, ShouldNotBeNil)
+
+			err := enforcer.processNetworkUDPPackets(p)
+
+			Convey("Then the datagram should be accepted without the token", func() {
+				So(err, ShouldBeNil)
+				So(string(p.ReadUDPData()), ShouldEqual, "query")
+
+				conn, err := enforcer.udpNetConnectionTracker.Get(client + ":" + server + ":3459:53")
+				So(err, ShouldBeNil)
+				So(conn.(*UDPConnection).GetState(), ShouldEqual, UDPData)
+			})
+		})
+	})
+}
@@ -0,0 +1,267 @@
+package enforcer
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/crypto"
+	"github.com/aporeto-inc/trireme/enforcer/utils/packet"
+)
+
+const (
+	// clientToServerLabel is used to derive the keys of the initiator direction
+	clientToServerLabel = "trireme-client-to-server"
+
+	// serverToClientLabel is used to derive the keys of the responder direction
+	serverToClientLabel = "trireme-server-to-client"
+
+	// tagLength is the length of the authentication tag of the payload
+	tagLength = 16
+
+	// TCPEncryptionOptionLen is the length of the TCP option that carries
+	// the authentication tag of the payload
+	TCPEncryptionOptionLen = TCPAuthenticationOptionBaseLen + tagLength
+)
+
+// flowCipher encrypts and authenticates the payload of one direction of a
+// TCP connection with AES-GCM. The key is derived from the ephemeral keys
+// of the connection. The nonce is derived from the position of the payload
+// in the sequence number space and from its length, so that a nonce is
+// only reused for a retransmission of the same bytes, which produces the
+// same cipher text. The payload length is preserved and no sequence number
+// adjustments are needed: the authentication tag is carried in a TCP option.
+type flowCipher struct {
+	aead cipher.AEAD
+
+	// epoch counts the wraps of the sequence number space
+	epoch   uint32
+	highest uint32
+	started bool
+}
+
+// newFlowCipher derives a key for the direction identified by the label
+func newFlowCipher(secret []byte, label string) (*flowCipher, error) {
+
+	key, err := crypto.ComputeHmac256([]byte(label+"-key"), secret)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &flowCipher{
+		aead: aead,
+	}, nil
+}
+
+// newFlowCiphers creates the transmit and receive ciphers of a connection
+// from the shared secret of the two enforcers
+func newFlowCiphers(secret []byte, initiator bool) (tx *flowCipher, rx *flowCipher, err error) {
+
+	c2s, err := newFlowCipher(secret, clientToServerLabel)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s2c, err := newFlowCipher(secret, serverToClientLabel)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if initiator {
+		return c2s, s2c, nil
+	}
+
+	return s2c, c2s, nil
+}
+
+// offset returns the position of the sequence number in the byte stream.
+// Sequence numbers that wrap around move to the next epoch and late
+// packets of the previous epoch are placed accordingly.
+func (f *flowCipher) offset(seq uint32) uint64 {
+
+	epoch := f.epoch
+
+	switch {
+	case !f.started:
+		f.started = true
+		f.highest = seq
+
+	case seq < f.highest && f.highest-seq > 1<<31:
+		// The sequence number wrapped around
+		f.epoch++
+		epoch = f.epoch
+		f.highest = seq
+
+	case seq > f.highest && seq-f.highest > 1<<31:
+		// Late packet from the previous epoch
+		if epoch > 0 {
+			epoch--
+		}
+
+	case seq > f.highest:
+		f.highest = seq
+	}
+
+	return uint64(epoch)<<32 | uint64(seq)
+}
+
+// nonce returns the nonce of the data starting at the sequence number
+func (f *flowCipher) nonce(seq uint32, length int) []byte {
+
+	nonce := make([]byte, f.aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce, f.offset(seq))
+	binary.BigEndian.PutUint32(nonce[8:], uint32(length))
+
+	return nonce
+}
+
+// seal encrypts the data in place and returns their authentication tag.
+// The data start at the provided sequence number.
+func (f *flowCipher) seal(seq uint32, data []byte) []byte {
+
+	sealed := f.aead.Seal(nil, f.nonce(seq, len(data)), data, nil)
+	copy(data, sealed)
+
+	return sealed[len(data):]
+}
+
+// open authenticates the data with their tag and decrypts them in place.
+// The data are left untouched if the authentication fails.
+func (f *flowCipher) open(seq uint32, data []byte, tag []byte) error {
+
+	sealed := make([]byte, 0, len(data)+len(tag))
+	sealed = append(sealed, data...)
+	sealed = append(sealed, tag...)
+
+	plain, err := f.aead.Open(nil, f.nonce(seq, len(data)), sealed, nil)
+	if err != nil {
+		return fmt.Errorf("Payload authentication failed: %s", err)
+	}
+
+	copy(data, plain)
+
+	return nil
+}
+
+// sealTCPPayload encrypts the payload of a TCP packet and attaches its
+// authentication tag in a TCP option. Packets without payload and
+// connections without encryption are left untouched.
+func (d *Datapath) sealTCPPayload(f *flowCipher, p *packet.Packet) error {
+
+	if f == nil || len(p.ReadTCPData()) == 0 {
+		return nil
+	}
+
+	if err := p.TCPDataDetach(0); err != nil {
+		return err
+	}
+
+	data := p.GetTCPData()
+	tag := f.seal(p.TCPSeq, data)
+
+	p.DropDetachedDataBytes()
+
+	return p.TCPDataAttach(d.createTCPAuthenticationOption(tag), data)
+}
+
+// openTCPPayload authenticates and decrypts the payload of a TCP packet and
+// removes the TCP option of its authentication tag. Packets without payload
+// and connections without encryption are left untouched. Packets that fail
+// the authentication must be dropped.
+func (d *Datapath) openTCPPayload(f *flowCipher, p *packet.Packet) error {
+
+	if f == nil || len(p.ReadTCPData()) == 0 {
+		return nil
+	}
+
+	start := int(p.TCPDataStartBytes())
+	if start < TCPEncryptionOptionLen || len(p.Buffer) < start {
+		return fmt.Errorf("Authentication tag not found")
+	}
+
+	if err := p.CheckTCPAuthenticationOption(TCPEncryptionOptionLen); err != nil {
+		return fmt.Errorf("Authentication tag not found: %s", err)
+	}
+
+	if p.Buffer[start-TCPEncryptionOptionLen+1] != TCPEncryptionOptionLen {
+		return fmt.Errorf("Invalid authentication tag length")
+	}
+
+	tag := append([]byte{}, p.Buffer[start-tagLength:start]...)
+
+	if err := p.TCPDataDetach(TCPEncryptionOptionLen); err != nil {
+		return err
+	}
+
+	data := p.GetTCPData()
+	if err := f.open(p.TCPSeq, data, tag); err != nil {
+		return err
+	}
+
+	p.DropDetachedBytes()
+
+	return p.TCPDataAttach([]byte{}, data)
+}
+
+// createEphemeralKey creates a new ephemeral key for the key exchange and
+// returns it together with the marshalled public key
+func createEphemeralKey() (*ecdsa.PrivateKey, []byte, error) {
+
+	key, public := crypto.CreateEphemeralKey(elliptic.P256, nil)
+	if key == nil {
+		return nil, nil, fmt.Errorf("Unable to create ephemeral key")
+	}
+
+	return key, public, nil
+}
+
+// marshalEphemeralKey returns the public part of an ephemeral key
+func marshalEphemeralKey(key *ecdsa.PrivateKey) []byte {
+
+	return elliptic.Marshal(key.Curve, key.X, key.Y)
+}
+
+// enableEncryption computes the shared secret of the connection and creates
+// the ciphers for the payload
+func (c *TCPConnection) enableEncryption(initiator bool) error {
+
+	secret, err := crypto.ComputeSharedSecret(c.Auth.LocalEphemeralKey, c.Auth.RemoteEphemeralKey)
+	if err != nil {
+		return err
+	}
+
+	c.txCipher, c.rxCipher, err = newFlowCiphers(secret, initiator)
+
+	return err
+}
+
+// Encrypted returns true if the payload of the connection is encrypted
+func (c *TCPConnection) Encrypted() bool {
+
+	return c.txCipher != nil
+}
+
+// connMark returns the conntrack mark for the connection. Encrypted
+// connections must keep all the packets in the datapath.
+func (c *TCPConnection) connMark() uint32 {
+
+	if c.Encrypted() {
+		return constants.EncryptConnMark
+	}
+
+	return constants.DefaultConnMark
+}
@@ -0,0 +1,160 @@
+package enforcer
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/aporeto-inc/trireme/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFlowCiphers(t *testing.T) {
+
+	Convey("Given two enforcers that computed the same shared secret", t, func() {
+
+		clientKey, clientPublic, err := createEphemeralKey()
+		So(err, ShouldBeNil)
+		serverKey, serverPublic, err := createEphemeralKey()
+		So(err, ShouldBeNil)
+
+		client := &TCPConnection{}
+		client.Auth.LocalEphemeralKey = clientKey
+		client.Auth.RemoteEphemeralKey = serverPublic
+
+		server := &TCPConnection{}
+		server.Auth.LocalEphemeralKey = serverKey
+		server.Auth.RemoteEphemeralKey = clientPublic
+
+		So(client.enableEncryption(true), ShouldBeNil)
+		So(server.enableEncryption(false), ShouldBeNil)
+		So(client.Encrypted(), ShouldBeTrue)
+		So(server.Encrypted(), ShouldBeTrue)
+
+		plain := []byte("The quick brown fox jumps over the lazy dog")
+
+		Convey("When the client encrypts data, the server should decrypt it", func() {
+			data := append([]byte{}, plain...)
+			tag := client.txCipher.seal(1000, data)
+			So(len(tag), ShouldEqual, tagLength)
+			So(bytes.Equal(data, plain), ShouldBeFalse)
+
+			So(server.rxCipher.open(1000, data, tag), ShouldBeNil)
+			So(bytes.Equal(data, plain), ShouldBeTrue)
+		})
+
+		Convey("When the server encrypts data, the client should decrypt it", func() {
+			data := append([]byte{}, plain...)
+			tag := server.txCipher.seal(5000, data)
+			So(bytes.Equal(data, plain), ShouldBeFalse)
+
+			So(client.rxCipher.open(5000, data, tag), ShouldBeNil)
+			So(bytes.Equal(data, plain), ShouldBeTrue)
+		})
+
+		Convey("When the cipher text is modified, the authentication should fail and the data should be left untouched", func() {
+			data := append([]byte{}, plain...)
+			tag := client.txCipher.seal(1000, data)
+			data[3] ^= 0x01
+			modified := append([]byte{}, data...)
+
+			So(server.rxCipher.open(1000, data, tag), ShouldNotBeNil)
+			So(bytes.Equal(data, modified), ShouldBeTrue)
+		})
+
+		Convey("When the data is replayed at another sequence number, the authentication should fail", func() {
+			data := append([]byte{}, plain...)
+			tag := client.txCipher.seal(1000, data)
+
+			So(server.rxCipher.open(2000, data, tag), ShouldNotBeNil)
+		})
+
+		Convey("When the two directions use the same sequence numbers, the cipher text should differ", func() {
+			c2s := append([]byte{}, plain...)
+			s2c := append([]byte{}, plain...)
+			client.txCipher.seal(1000, c2s)
+			server.txCipher.seal(1000, s2c)
+			So(bytes.Equal(c2s, s2c), ShouldBeFalse)
+		})
+
+		Convey("When data is retransmitted, the cipher text should not change", func() {
+			whole := append([]byte{}, plain...)
+			tag := client.txCipher.seal(1000, whole)
+
+			retransmit := append([]byte{}, plain...)
+			So(client.txCipher.seal(1000, retransmit), ShouldResemble, tag)
+			So(bytes.Equal(whole, retransmit), ShouldBeTrue)
+		})
+
+		Convey("When the same sequence number is used with another length, the nonce should differ", func() {
+			So(client.txCipher.nonce(1000, 7), ShouldNotResemble, client.txCipher.nonce(1000, 8))
+		})
+
+		Convey("When the sequence numbers wrap around, the nonces should not be reused", func() {
+			So(client.txCipher.offset(0xFFFFFF00), ShouldEqual, uint64(0xFFFFFF00))
+			So(client.txCipher.offset(16), ShouldEqual, uint64(1)<<32|16)
+			So(client.txCipher.offset(0xFFFFFFF8), ShouldEqual, uint64(0xFFFFFFF8))
+			So(client.txCipher.offset(32), ShouldEqual, uint64(1)<<32|32)
+
+			wrapped := append([]byte{}, plain...)
+			tag := client.txCipher.seal(16, wrapped)
+
+			So(server.rxCipher.open(16, wrapped, tag), ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given an enforcer with a processing unit", t, func() {
+		puInfo, _, enforcer, err, _ := setupProcessingUnitsInDatapathWithAction(policy.Accept | policy.Encrypt)
+		So(err, ShouldBeNil)
+
+		item, err := enforcer.contextTracker.Get(puInfo.ContextID)
+		So(err, ShouldBeNil)
+		context := item.(*PUContext)
+
+		Convey("When it creates the Syn tokens of two connections, the ephemeral keys should differ", func() {
+			auth1, auth2 := &AuthInfo{}, &AuthInfo{}
+			_, err := enforcer.createSynPacketToken(context, auth1)
+			So(err, ShouldBeNil)
+			_, err = enforcer.createSynPacketToken(context, auth2)
+			So(err, ShouldBeNil)
+
+			So(auth1.LocalEphemeralKey, ShouldNotBeNil)
+			So(auth2.LocalEphemeralKey, ShouldNotBeNil)
+			So(marshalEphemeralKey(auth1.LocalEphemeralKey), ShouldNotResemble, marshalEphemeralKey(auth2.LocalEphemeralKey))
+		})
+	})
+
+	Convey("Given an enforcer with a processing unit that does not encrypt", t, func() {
+		puInfo, _, enforcer, err, _ := setupProcessingUnitsInDatapathWithAction(policy.Accept)
+		So(err, ShouldBeNil)
+
+		item, err := enforcer.contextTracker.Get(puInfo.ContextID)
+		So(err, ShouldBeNil)
+		context := item.(*PUContext)
+
+		Convey("When it creates the Syn tokens of two connections, the token should be cached without ephemeral key", func() {
+			auth1, auth2 := &AuthInfo{}, &AuthInfo{}
+			token1, err := enforcer.createSynPacketToken(context, auth1)
+			So(err, ShouldBeNil)
+			token2, err := enforcer.createSynPacketToken(context, auth2)
+			So(err, ShouldBeNil)
+
+			So(auth1.LocalEphemeralKey, ShouldBeNil)
+			So(auth2.LocalEphemeralKey, ShouldBeNil)
+			So(&token2[0], ShouldEqual, &token1[0])
+			So(auth1.LocalContext, ShouldNotResemble, auth2.LocalContext)
+		})
+	})
+
+	Convey("Given a connection without a remote ephemeral key", t, func() {
+		key, _, err := createEphemeralKey()
+		So(err, ShouldBeNil)
+
+		conn := &TCPConnection{}
+		conn.Auth.LocalEphemeralKey = key
+
+		Convey("Encryption should fail", func() {
+			So(conn.enableEncryption(true), ShouldNotBeNil)
+			So(conn.Encrypted(), ShouldBeFalse)
+		})
+	})
+}
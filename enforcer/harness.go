@@ -0,0 +1,270 @@
+package enforcer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/enforcer/utils/packet"
+	"github.com/aporeto-inc/trireme/enforcer/utils/packetgen"
+	"github.com/aporeto-inc/trireme/enforcer/utils/pcap"
+	"github.com/aporeto-inc/trireme/enforcer/utils/secrets"
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+// PacketVerdict holds the decisions of the enforcers for a packet that went
+// through the harness
+type PacketVerdict struct {
+	// Index is the position of the packet in the flow or in the capture
+	Index int
+	// Flags are the TCP flags of the packet
+	Flags string
+	// FromClient is true for the packets sent by the client PU
+	FromClient bool
+	// Accepted is true if both enforcers accepted the packet
+	Accepted bool
+	// ApplicationError is the error of the enforcer of the sender
+	ApplicationError error
+	// NetworkError is the error of the enforcer of the receiver
+	NetworkError error
+	// Input is the packet as sent by the PU, without the link layer padding
+	Input []byte
+	// Wire is the packet as sent on the network by the enforcer of the sender
+	Wire []byte
+	// Output is the packet as delivered by the enforcer of the receiver. It
+	// is empty if the packet was dropped.
+	Output []byte
+}
+
+// Mutated returns true if the enforcer of the sender modified the packet
+func (v *PacketVerdict) Mutated() bool {
+
+	return !bytes.Equal(v.Input, v.Wire)
+}
+
+// Restored returns true if the packet was delivered as it was sent
+func (v *PacketVerdict) Restored() bool {
+
+	return v.Accepted && bytes.Equal(v.Input, v.Output)
+}
+
+// HarnessResult holds the verdicts of the packets of a flow and the flow
+// records that were collected while processing them
+type HarnessResult struct {
+	Packets []*PacketVerdict
+	Flows   []*collector.FlowRecord
+}
+
+// Accepted returns true if all the packets of the flow were accepted
+func (r *HarnessResult) Accepted() bool {
+
+	for _, v := range r.Packets {
+		if !v.Accepted {
+			return false
+		}
+	}
+
+	return true
+}
+
+// recordingCollector keeps the flow records reported by the enforcers
+type recordingCollector struct {
+	collector.DefaultCollector
+	records []*collector.FlowRecord
+	sync.Mutex
+}
+
+// CollectFlowEvent is part of the EventCollector interface
+func (c *recordingCollector) CollectFlowEvent(record *collector.FlowRecord) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.records = append(c.records, record)
+}
+
+// flush returns the records collected so far and forgets them
+func (c *recordingCollector) flush() []*collector.FlowRecord {
+	c.Lock()
+	defer c.Unlock()
+
+	records := c.records
+	c.records = nil
+
+	return records
+}
+
+// Harness connects the enforcers of a client and a server PU back to back
+// and processes the packets of TCP flows in userspace. It allows to test the
+// policies and the PacketProcessor plugins without NFQUEUE.
+type Harness struct {
+	Client *Datapath
+	Server *Datapath
+
+	clientIP string
+	records  *recordingCollector
+}
+
+// NewHarness creates the enforcers of the client and server PUs. The PUs
+// must have a default IP address. The services are optional.
+func NewHarness(client, server *policy.PUInfo, clientService, serverService PacketProcessor, s secrets.Secrets) (*Harness, error) {
+
+	clientIP, ok := client.Policy.DefaultIPAddress()
+	if !ok {
+		return nil, fmt.Errorf("No IP address for client PU %s", client.ContextID)
+	}
+
+	if _, ok = server.Policy.DefaultIPAddress(); !ok {
+		return nil, fmt.Errorf("No IP address for server PU %s", server.ContextID)
+	}
+
+	h := &Harness{
+		clientIP: clientIP,
+		records:  &recordingCollector{},
+	}
+
+	h.Client = NewWithDefaults("client", h.records, clientService, s, constants.LocalContainer, "/proc").(*Datapath)
+	h.Server = NewWithDefaults("server", h.records, serverService, s, constants.LocalContainer, "/proc").(*Datapath)
+
+	if err := h.Client.Enforce(client.ContextID, client); err != nil {
+		return nil, fmt.Errorf("Unable to enforce client PU: %s", err)
+	}
+
+	if err := h.Server.Enforce(server.ContextID, server); err != nil {
+		return nil, fmt.Errorf("Unable to enforce server PU: %s", err)
+	}
+
+	// The interceptors are not started, so the services are initialized here
+	for _, d := range []*Datapath{h.Client, h.Server} {
+		if d.service != nil {
+			d.service.Initialize(d.secrets, d.filterQueue)
+		}
+	}
+
+	return h, nil
+}
+
+// Run processes the packets of the flow in order. The packets sent by the
+// client go through the application path of the client enforcer and the
+// network path of the server enforcer, the replies the other way around.
+// Dropped packets are not retransmitted.
+func (h *Harness) Run(flow packetgen.PacketFlowManipulator) (*HarnessResult, error) {
+
+	h.records.flush()
+
+	result := &HarnessResult{}
+
+	for i := 0; i < flow.GetNumPackets(); i++ {
+
+		verdict, err := h.process(i, flow.GetNthPacket(i).ToBytes())
+		if err != nil {
+			return nil, err
+		}
+
+		result.Packets = append(result.Packets, verdict)
+	}
+
+	result.Flows = h.records.flush()
+
+	return result, nil
+}
+
+// Replay processes the TCP packets of a capture in the pcap or pcapng format.
+// For the captures written by the datapath, only the packets as seen by the
+// PU are replayed: the application packets as received and the network
+// packets as accepted.
+func (h *Harness) Replay(r io.Reader) (*HarnessResult, error) {
+
+	records, err := pcap.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	h.records.flush()
+
+	result := &HarnessResult{}
+
+	for i, record := range records {
+
+		// Skip the packets that are not IPv4 TCP packets
+		if len(record.Data) < 20 || record.Data[0]>>4 != 4 || record.Data[9] != packet.IPProtocolTCP {
+			continue
+		}
+
+		if record.Comment != "" &&
+			record.Comment != "application "+stageReceived &&
+			record.Comment != "network "+stageAccepted {
+			continue
+		}
+
+		verdict, err := h.process(i, record.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		result.Packets = append(result.Packets, verdict)
+	}
+
+	result.Flows = h.records.flush()
+
+	return result, nil
+}
+
+// process sends a single packet through the enforcers
+func (h *Harness) process(index int, input []byte) (*PacketVerdict, error) {
+
+	p, err := packet.New(0, append([]byte{}, input...), "0")
+	if err != nil {
+		return nil, fmt.Errorf("Invalid packet %d: %s", index, err)
+	}
+
+	if p.IPProto != packet.IPProtocolTCP {
+		return nil, fmt.Errorf("Packet %d is not a TCP packet", index)
+	}
+
+	verdict := &PacketVerdict{
+		Index:      index,
+		Flags:      packet.TCPFlagsToStr(p.TCPFlags),
+		FromClient: p.SourceAddress.String() == h.clientIP,
+		Input:      append([]byte{}, p.GetBytes()...),
+	}
+
+	sender, receiver := h.Server, h.Client
+	if verdict.FromClient {
+		sender, receiver = h.Client, h.Server
+	}
+
+	capture := sender.capturePacket(true, p)
+	verdict.ApplicationError = sender.processApplicationTCPPackets(p)
+	if capture != nil {
+		capture.verdict(true, p, verdict.ApplicationError)
+	}
+
+	if verdict.ApplicationError != nil {
+		return verdict, nil
+	}
+
+	verdict.Wire = append([]byte{}, p.GetBytes()...)
+
+	out, err := packet.New(0, append([]byte{}, verdict.Wire...), "0")
+	if err != nil {
+		return nil, fmt.Errorf("Invalid packet %d after application processing: %s", index, err)
+	}
+
+	capture = receiver.capturePacket(false, out)
+	verdict.NetworkError = receiver.processNetworkTCPPackets(out)
+	if capture != nil {
+		capture.verdict(false, out, verdict.NetworkError)
+	}
+
+	if verdict.NetworkError != nil {
+		return verdict, nil
+	}
+
+	verdict.Output = append([]byte{}, out.GetBytes()...)
+	verdict.Accepted = true
+
+	return verdict, nil
+}
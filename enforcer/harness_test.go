@@ -0,0 +1,154 @@
+package enforcer
+
+import (
+	"testing"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/enforcer/utils/fqconfig"
+	"github.com/aporeto-inc/trireme/enforcer/utils/packet"
+	"github.com/aporeto-inc/trireme/enforcer/utils/packetgen"
+	"github.com/aporeto-inc/trireme/enforcer/utils/secrets"
+	"github.com/aporeto-inc/trireme/enforcer/utils/tokens"
+	"github.com/aporeto-inc/trireme/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// harnessPU returns a PU with the given IP that applies the given action to
+// the PUs with the same label
+func harnessPU(contextID, ip string, action policy.ActionType) *policy.PUInfo {
+
+	puInfo := policy.NewPUInfo(contextID, constants.ContainerPU)
+	puInfo.Runtime.SetIPAddresses(policy.ExtendedMap{"bridge": ip})
+	puInfo.Policy.SetIPAddresses(policy.ExtendedMap{policy.DefaultNamespace: ip})
+	puInfo.Policy.AddIdentityTag(TransmitterLabel, "value")
+	puInfo.Policy.AddReceiverRules(policy.TagSelector{
+		Clause: []policy.KeyValueOperator{
+			{
+				Key:      TransmitterLabel,
+				Value:    []string{"value"},
+				Operator: policy.Equal,
+			},
+		},
+		Policy: &policy.FlowPolicy{Action: action},
+	})
+
+	return puInfo
+}
+
+// countingProcessor counts the packets it sees and drops the network packets
+// with the given flags
+type countingProcessor struct {
+	initialized bool
+	app         int
+	net         int
+	dropFlags   string
+}
+
+func (c *countingProcessor) Initialize(s secrets.Secrets, fq *fqconfig.FilterQueue) {
+	c.initialized = true
+}
+
+func (c *countingProcessor) PreProcessTCPAppPacket(p *packet.Packet, context *PUContext, conn *TCPConnection) bool {
+	c.app++
+	return true
+}
+
+func (c *countingProcessor) PostProcessTCPAppPacket(p *packet.Packet, action interface{}, context *PUContext, conn *TCPConnection) bool {
+	return true
+}
+
+func (c *countingProcessor) PreProcessTCPNetPacket(p *packet.Packet, context *PUContext, conn *TCPConnection) bool {
+	c.net++
+	return packet.TCPFlagsToStr(p.TCPFlags) != c.dropFlags
+}
+
+func (c *countingProcessor) PostProcessTCPNetPacket(p *packet.Packet, action interface{}, claims *tokens.ConnectionClaims, context *PUContext, conn *TCPConnection) bool {
+	return true
+}
+
+func TestHarness(t *testing.T) {
+
+	Convey("Given a flow between a client and a server PU", t, func() {
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+		flow := packetgen.NewTCPPacketFlow("aa:ff:aa:ff:aa:ff", "ff:aa:ff:aa:ff:aa", "10.1.10.76", "164.67.228.152", 666, 80).GenerateTCPFlow(packetgen.PacketFlowTypeGoodFlow)
+
+		Convey("When the server accepts the client", func() {
+			h, err := NewHarness(harnessPU("client", "10.1.10.76", policy.Accept), harnessPU("server", "164.67.228.152", policy.Accept), nil, nil, secret)
+			So(err, ShouldBeNil)
+
+			result, err := h.Run(flow)
+			So(err, ShouldBeNil)
+
+			Convey("All the packets should be accepted and delivered unchanged", func() {
+				So(result.Accepted(), ShouldBeTrue)
+				So(len(result.Packets), ShouldEqual, 3)
+				for _, v := range result.Packets {
+					So(v.Restored(), ShouldBeTrue)
+				}
+			})
+
+			Convey("The handshake packets should carry the identity on the wire", func() {
+				So(result.Packets[0].FromClient, ShouldBeTrue)
+				So(result.Packets[0].Mutated(), ShouldBeTrue)
+				So(result.Packets[1].FromClient, ShouldBeFalse)
+				So(result.Packets[1].Mutated(), ShouldBeTrue)
+				So(result.Packets[2].FromClient, ShouldBeTrue)
+			})
+
+			Convey("The server should report the accepted flow", func() {
+				So(len(result.Flows), ShouldEqual, 1)
+				So(result.Flows[0].ContextID, ShouldEqual, "server")
+				So(result.Flows[0].Action, ShouldEqual, policy.Accept)
+			})
+		})
+
+		Convey("When the server rejects the client", func() {
+			h, err := NewHarness(harnessPU("client", "10.1.10.76", policy.Accept), harnessPU("server", "164.67.228.152", policy.Reject), nil, nil, secret)
+			So(err, ShouldBeNil)
+
+			result, err := h.Run(flow)
+			So(err, ShouldBeNil)
+
+			Convey("The Syn packet should be dropped by the server", func() {
+				So(result.Accepted(), ShouldBeFalse)
+				So(result.Packets[0].ApplicationError, ShouldBeNil)
+				So(result.Packets[0].NetworkError, ShouldNotBeNil)
+				So(result.Packets[0].Output, ShouldBeEmpty)
+			})
+
+			Convey("The server should report the rejected flow", func() {
+				So(len(result.Flows), ShouldBeGreaterThan, 0)
+				So(result.Flows[0].ContextID, ShouldEqual, "server")
+				So(result.Flows[0].DropReason, ShouldEqual, collector.PolicyDrop)
+			})
+		})
+
+		Convey("When the server runs a service that drops the Syn packets", func() {
+			client := &countingProcessor{}
+			server := &countingProcessor{dropFlags: packet.TCPFlagsToStr(packet.TCPSynMask)}
+			h, err := NewHarness(harnessPU("client", "10.1.10.76", policy.Accept), harnessPU("server", "164.67.228.152", policy.Accept), client, server, secret)
+			So(err, ShouldBeNil)
+
+			result, err := h.Run(flow)
+			So(err, ShouldBeNil)
+
+			Convey("The services should be initialized and see the packets", func() {
+				So(client.initialized, ShouldBeTrue)
+				So(server.initialized, ShouldBeTrue)
+				So(client.app, ShouldBeGreaterThan, 0)
+				So(server.net, ShouldBeGreaterThan, 0)
+			})
+
+			Convey("The Syn packet should be dropped", func() {
+				So(result.Packets[0].Accepted, ShouldBeFalse)
+				So(result.Packets[0].NetworkError, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When a PU has no IP address, I should get an error", func() {
+			_, err := NewHarness(policy.NewPUInfo("client", constants.ContainerPU), harnessPU("server", "164.67.228.152", policy.Accept), nil, nil, secret)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
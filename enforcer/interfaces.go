@@ -61,23 +61,34 @@ type PacketProcessor interface {
 
 // PUContext holds data indexed by the PU ID
 type PUContext struct {
-	ID              string
-	ManagementID    string
-	Identity        *policy.TagStore
-	Annotations     *policy.TagStore
-	AcceptTxtRules  *lookup.PolicyDB
-	RejectTxtRules  *lookup.PolicyDB
-	AcceptRcvRules  *lookup.PolicyDB
-	RejectRcvRules  *lookup.PolicyDB
-	ApplicationACLs *acls.ACLCache
-	NetworkACLS     *acls.ACLCache
-	externalIPCache cache.DataStore
-	Extension       interface{}
-	IP              string
-	Mark            string
-	Ports           []string
-	PUType          constants.PUType
-	synToken        []byte
-	synExpiration   time.Time
+	ID                 string
+	ManagementID       string
+	Identity           *policy.TagStore
+	Annotations        *policy.TagStore
+	AcceptTxtRules     *lookup.PolicyDB
+	RejectTxtRules     *lookup.PolicyDB
+	AcceptRcvRules     *lookup.PolicyDB
+	RejectRcvRules     *lookup.PolicyDB
+	ApplicationACLs    *acls.ACLCache
+	NetworkACLS        *acls.ACLCache
+	UDPApplicationACLs *acls.ACLCache
+	UDPNetworkACLs     *acls.ACLCache
+	externalIPCache    cache.DataStore
+	Extension          interface{}
+	IP                 string
+	IPv6               string
+	Mark               string
+	Ports              []string
+	PUType             constants.PUType
+	synToken           []byte
+	synExpiration      time.Time
+	// encrypt is true if a rule of the policy of the PU encrypts the flows
+	encrypt bool
+	// connections are the established connections of the PU
+	connections *connectionSet
+	// udpFlows are the UDP flows of the PU in the trackers
+	udpFlows *udpFlowSet
+	// audit is true if the policy decisions are reported but not enforced
+	audit bool
 	sync.Mutex
 }
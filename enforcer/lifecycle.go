@@ -0,0 +1,309 @@
+package enforcer
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme/cache"
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/enforcer/utils/packet"
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+const (
+	// establishedConnectionTimeout is the time after which a connection that
+	// has not been closed is considered terminated. Packets of established
+	// connections mostly bypass the enforcer, so this is not an idle timeout.
+	establishedConnectionTimeout = 24 * time.Hour
+)
+
+// flowLifecycle tracks an established connection until it is terminated
+type flowLifecycle struct {
+	// hash is the key of the connection in the established connection tracker
+	hash string
+
+	// source and destination are the endpoints of the flow as seen by the
+	// initiator of the connection
+	source      collector.EndPoint
+	destination collector.EndPoint
+
+	// initiator is true if the local PU initiated the connection
+	initiator bool
+
+	// policy is the policy of the flows with external services. The policy
+	// of the other flows is the one of the connection.
+	policy *policy.FlowPolicy
+
+	// Counters of the packets processed by the enforcer. The application
+	// counters are for the packets sent by the local PU.
+	appPackets uint64
+	appBytes   uint64
+	netPackets uint64
+	netBytes   uint64
+
+	// appFin and netFin capture the FIN packets in each direction
+	appFin bool
+	netFin bool
+
+	ended bool
+}
+
+// count updates the counters of the flow and returns the reason if the
+// packet terminates the connection
+func (f *flowLifecycle) count(p *packet.Packet, app bool) string {
+
+	length := uint64(p.GetIPLength())
+
+	if app {
+		f.appPackets++
+		f.appBytes += length
+	} else {
+		f.netPackets++
+		f.netBytes += length
+	}
+
+	if p.TCPFlags&packet.TCPRstMask != 0 {
+		return collector.FlowEndReset
+	}
+
+	if p.TCPFlags&packet.TCPFinMask != 0 {
+		if app {
+			f.appFin = true
+		} else {
+			f.netFin = true
+		}
+	}
+
+	if f.appFin && f.netFin {
+		return collector.FlowEndFin
+	}
+
+	return ""
+}
+
+// establishedHash returns the key of the connection in the established
+// connection tracker. The application direction of the flow is used.
+func establishedHash(p *packet.Packet, app bool) string {
+
+	if app {
+		return p.L4FlowHash()
+	}
+
+	return p.L4ReverseFlowHash()
+}
+
+// connectionEstablished starts the tracking of a connection that reached the
+// data state. The packet is the one that completed the negotiation.
+func (d *Datapath) connectionEstablished(conn *TCPConnection, p *packet.Packet, app bool, initiator bool) {
+
+	f := &flowLifecycle{
+		hash:      establishedHash(p, app),
+		initiator: initiator,
+	}
+
+	// The packet that completes the negotiation is the client Ack
+	f.source = collector.EndPoint{
+		IP:   p.SourceAddress.String(),
+		Port: p.SourcePort,
+		Type: collector.PU,
+	}
+	f.destination = collector.EndPoint{
+		IP:   p.DestinationAddress.String(),
+		Port: p.DestinationPort,
+		Type: collector.PU,
+	}
+
+	if initiator {
+		f.source.ID = conn.Context.ManagementID
+		f.destination.ID = conn.Auth.RemoteContextID
+	} else {
+		f.source.ID = conn.Auth.RemoteContextID
+		f.destination.ID = conn.Context.ManagementID
+	}
+
+	d.trackFlowLifecycle(conn, f)
+}
+
+// externalFlowEstablished starts the tracking of a connection with an
+// external service that was accepted by the ACLs. The packet is the SynAck
+// of the connection and app is true if it was sent by the local PU.
+func (d *Datapath) externalFlowEstablished(conn *TCPConnection, p *packet.Packet, app bool, plc *policy.FlowPolicy) {
+
+	if plc == nil {
+		plc = &policy.FlowPolicy{
+			Action: policy.Reject,
+		}
+	}
+
+	f := &flowLifecycle{
+		hash:      establishedHash(p, app),
+		initiator: !app,
+		policy:    plc,
+	}
+
+	// The SynAck travels from the server to the client
+	f.source = collector.EndPoint{
+		IP:   p.DestinationAddress.String(),
+		Port: p.DestinationPort,
+	}
+	f.destination = collector.EndPoint{
+		IP:   p.SourceAddress.String(),
+		Port: p.SourcePort,
+	}
+
+	local, remote := &f.destination, &f.source
+	if f.initiator {
+		local, remote = &f.source, &f.destination
+	}
+	local.ID, local.Type = conn.Context.ManagementID, collector.PU
+	remote.ID, remote.Type = plc.ServiceID, collector.Address
+
+	d.trackFlowLifecycle(conn, f)
+}
+
+// trackFlowLifecycle tracks an established connection until its end is
+// reported
+func (d *Datapath) trackFlowLifecycle(conn *TCPConnection, f *flowLifecycle) {
+
+	conn.lifecycle = f
+
+	d.establishedConnectionTracker.AddOrUpdate(f.hash, conn)
+
+	if conn.Context.connections != nil {
+		conn.Context.connections.add(conn)
+	}
+}
+
+// establishedRetrieveState returns the connection from the established tracker
+func (d *Datapath) establishedRetrieveState(p *packet.Packet, app bool) (*PUContext, *TCPConnection, error) {
+
+	conn, err := d.establishedConnectionTracker.Get(establishedHash(p, app))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c := conn.(*TCPConnection)
+
+	c.Lock()
+	defer c.Unlock()
+
+	return c.Context, c, nil
+}
+
+// updateFlowLifecycle accounts a packet of an established connection and
+// terminates the connection on FIN or RST
+func (d *Datapath) updateFlowLifecycle(conn *TCPConnection, p *packet.Packet, app bool) {
+
+	f := conn.lifecycle
+	if f == nil || f.ended {
+		return
+	}
+
+	if reason := f.count(p, app); reason != "" {
+		if err := d.establishedConnectionTracker.Remove(f.hash); err != nil {
+			zap.L().Debug("Connection already removed from the established tracker", zap.String("flow", f.hash))
+		}
+		d.reportFlowEnd(conn, reason)
+	}
+}
+
+// reportFlowEnd reports the statistics of a terminated connection to the collector
+func (d *Datapath) reportFlowEnd(conn *TCPConnection, reason string) {
+
+	f := conn.lifecycle
+	if f == nil || f.ended {
+		return
+	}
+	f.ended = true
+
+	if conn.Context == nil {
+		return
+	}
+
+	if conn.Context.connections != nil {
+		conn.Context.connections.remove(conn)
+	}
+
+	stats := &collector.FlowStats{
+		StartTime: conn.startTime,
+		EndTime:   time.Now(),
+		EndReason: reason,
+	}
+
+	// Packets sent by the initiator are sent by the source of the flow
+	if f.initiator {
+		stats.SourcePackets, stats.SourceBytes = f.appPackets, f.appBytes
+		stats.DestinationPackets, stats.DestinationBytes = f.netPackets, f.netBytes
+	} else {
+		stats.SourcePackets, stats.SourceBytes = f.netPackets, f.netBytes
+		stats.DestinationPackets, stats.DestinationBytes = f.appPackets, f.appBytes
+	}
+
+	// The packets of established connections bypass the enforcer. Use the
+	// conntrack accounting if it is available.
+	if d.conntrackAcct != nil {
+		origPackets, origBytes, replyPackets, replyBytes, err := d.conntrackAcct.ConntrackTableGetCounters(
+			f.source.IP,
+			f.destination.IP,
+			packet.IPProtocolTCP,
+			f.source.Port,
+			f.destination.Port,
+		)
+		if err == nil {
+			stats.SourcePackets, stats.SourceBytes = origPackets, origBytes
+			stats.DestinationPackets, stats.DestinationBytes = replyPackets, replyBytes
+		} else {
+			zap.L().Debug("Unable to retrieve the conntrack counters", zap.String("flow", f.hash), zap.Error(err))
+		}
+	}
+
+	source := f.source
+	destination := f.destination
+
+	record := &collector.FlowRecord{
+		ContextID:   conn.Context.ID,
+		Count:       1,
+		Source:      &source,
+		Destination: &destination,
+		Tags:        conn.Context.Annotations,
+		DropReason:  collector.FlowEnd,
+		Timestamp:   stats.EndTime,
+		Protocol:    packet.IPProtocolTCP,
+		Stats:       stats,
+	}
+
+	plc := f.policy
+	if plc == nil {
+		plc = conn.FlowPolicy
+	}
+
+	if plc != nil {
+		record.Action = plc.Action
+		record.PolicyID = plc.PolicyID
+	}
+
+	d.collector.CollectFlowEvent(record)
+}
+
+// establishedConnectionExpirationNotifier reports the end of the connections
+// that were never closed. The notifier is called with the cache locked and
+// the datapath might hold the connection lock while removing it.
+func (d *Datapath) establishedConnectionExpirationNotifier(c cache.DataStore, id interface{}, item interface{}) {
+
+	if conn, ok := item.(*TCPConnection); ok {
+		go func() {
+			conn.Lock()
+			defer conn.Unlock()
+			d.reportFlowEnd(conn, collector.FlowEndTimeout)
+		}()
+	}
+}
+
+// isTeardownPacket returns true for FIN and RST packets. They are captured
+// for established connections and must be accepted even if the enforcer
+// lost the state of the connection.
+func isTeardownPacket(p *packet.Packet) bool {
+
+	return p.TCPFlags&(packet.TCPFinMask|packet.TCPRstMask) != 0
+}
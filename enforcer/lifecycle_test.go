@@ -0,0 +1,273 @@
+package enforcer
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/aporeto-inc/trireme/cache"
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/enforcer/utils/packet"
+	"github.com/aporeto-inc/trireme/enforcer/utils/secrets"
+	"github.com/aporeto-inc/trireme/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// flowEndCollector keeps the flow end records
+type flowEndCollector struct {
+	collector.DefaultCollector
+	sync.Mutex
+	records []*collector.FlowRecord
+}
+
+func (c *flowEndCollector) CollectFlowEvent(record *collector.FlowRecord) {
+	c.Lock()
+	defer c.Unlock()
+
+	if record.DropReason == collector.FlowEnd {
+		c.records = append(c.records, record)
+	}
+}
+
+// testAccounting returns fixed conntrack counters
+type testAccounting struct{}
+
+func (c *testAccounting) ConntrackTableGetCounters(ipSrc, ipDst string, protonum uint8, srcport, dstport uint16) (uint64, uint64, uint64, uint64, error) {
+	return 100, 10000, 200, 20000, nil
+}
+
+// establishedConnections returns the number of tracked connections of the test flow
+func establishedConnections(enforcer *Datapath) int {
+
+	count := 0
+	for _, p := range TCPFlow[:2] {
+		tcpPacket, err := packet.New(0, append([]byte{}, p...), "0")
+		So(err, ShouldBeNil)
+		if _, err := enforcer.establishedConnectionTracker.Get(tcpPacket.L4FlowHash()); err == nil {
+			count++
+		}
+	}
+
+	return count
+}
+
+// processTCPFlow passes the packets of the test flow through the enforcer
+func processTCPFlow(enforcer *Datapath, indexes ...int) {
+
+	for _, i := range indexes {
+		input := make([]byte, len(TCPFlow[i]))
+		copy(input, TCPFlow[i])
+
+		tcpPacket, err := packet.New(0, input, "0")
+		So(err, ShouldBeNil)
+		tcpPacket.UpdateIPChecksum()
+		tcpPacket.UpdateTCPChecksum()
+
+		So(enforcer.processApplicationTCPPackets(tcpPacket), ShouldBeNil)
+
+		output := make([]byte, len(tcpPacket.GetBytes()))
+		copy(output, tcpPacket.GetBytes())
+
+		outPacket, err := packet.New(0, output, "0")
+		So(err, ShouldBeNil)
+		So(enforcer.processNetworkTCPPackets(outPacket), ShouldBeNil)
+	}
+}
+
+func TestFlowLifecycle(t *testing.T) {
+
+	Convey("Given I create a new enforcer instance and two processing units", t, func() {
+		puInfo1, puInfo2, enforcer, err1, err2 := setupProcessingUnitsInDatapathAndEnforce()
+		So(err1, ShouldBeNil)
+		So(err2, ShouldBeNil)
+
+		records := &flowEndCollector{}
+		enforcer.collector = records
+
+		Convey("When the connection is closed by both ends", func() {
+			for i := range TCPFlow {
+				processTCPFlow(enforcer, i)
+			}
+
+			Convey("Then I should get a flow end record from the server and one from the client", func() {
+				So(len(records.records), ShouldEqual, 2)
+
+				contexts := []string{}
+				for _, record := range records.records {
+					contexts = append(contexts, record.ContextID)
+					So(record.Source.IP, ShouldEqual, "10.1.10.76")
+					So(record.Destination.IP, ShouldEqual, "164.67.228.152")
+					So(record.Destination.Port, ShouldEqual, 80)
+					So(record.Action, ShouldEqual, policy.Accept)
+					So(record.Stats, ShouldNotBeNil)
+					So(record.Stats.EndReason, ShouldEqual, collector.FlowEndFin)
+					So(record.Stats.Duration(), ShouldBeGreaterThanOrEqualTo, 0)
+					So(record.Timestamp, ShouldResemble, record.Stats.EndTime)
+					So(record.Protocol, ShouldEqual, packet.IPProtocolTCP)
+					So(record.Stats.SourcePackets, ShouldBeGreaterThan, 0)
+					So(record.Stats.DestinationPackets, ShouldBeGreaterThan, 0)
+					So(record.Stats.SourceBytes, ShouldBeGreaterThan, 0)
+					So(record.Stats.DestinationBytes, ShouldBeGreaterThan, 0)
+				}
+				So(contexts, ShouldContain, puInfo1.ContextID)
+				So(contexts, ShouldContain, puInfo2.ContextID)
+			})
+
+			Convey("Then the connection should not be tracked any more", func() {
+				So(establishedConnections(enforcer), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the connection is reset", func() {
+			processTCPFlow(enforcer, 0, 1, 2, 3, 4)
+			So(establishedConnections(enforcer), ShouldEqual, 2)
+
+			processTCPFlow(enforcer, 24)
+
+			Convey("Then I should get the flow end records with the reset reason", func() {
+				So(len(records.records), ShouldEqual, 2)
+				So(records.records[0].Stats.EndReason, ShouldEqual, collector.FlowEndReset)
+				So(records.records[1].Stats.EndReason, ShouldEqual, collector.FlowEndReset)
+			})
+		})
+
+		Convey("When the negotiation state expired", func() {
+			processTCPFlow(enforcer, 0, 1, 2, 3, 4)
+
+			for _, c := range []cache.DataStore{
+				enforcer.appOrigConnectionTracker,
+				enforcer.netOrigConnectionTracker,
+				enforcer.appReplyConnectionTracker,
+				enforcer.netReplyConnectionTracker,
+			} {
+				for _, p := range TCPFlow[:2] {
+					tcpPacket, err := packet.New(0, append([]byte{}, p...), "0")
+					So(err, ShouldBeNil)
+					_ = c.Remove(tcpPacket.L4FlowHash())
+				}
+			}
+
+			Convey("Then the data and teardown packets should still be tracked", func() {
+				processTCPFlow(enforcer, 5, 9, 20, 21, 23)
+
+				So(len(records.records), ShouldEqual, 2)
+				So(records.records[0].Stats.EndReason, ShouldEqual, collector.FlowEndFin)
+				So(records.records[1].Stats.EndReason, ShouldEqual, collector.FlowEndFin)
+			})
+		})
+
+		Convey("When conntrack provides accounting", func() {
+			enforcer.conntrackAcct = &testAccounting{}
+
+			for i := range TCPFlow {
+				processTCPFlow(enforcer, i)
+			}
+
+			Convey("Then the counters should come from conntrack", func() {
+				So(len(records.records), ShouldEqual, 2)
+				for _, record := range records.records {
+					So(record.Stats.SourcePackets, ShouldEqual, 100)
+					So(record.Stats.SourceBytes, ShouldEqual, 10000)
+					So(record.Stats.DestinationPackets, ShouldEqual, 200)
+					So(record.Stats.DestinationBytes, ShouldEqual, 20000)
+				}
+			})
+		})
+
+		Convey("When conntrack does not provide accounting", func() {
+			enforcer.conntrackAcct = nil
+
+			for i := range TCPFlow {
+				processTCPFlow(enforcer, i)
+			}
+
+			Convey("Then the counters should come from the packets processed by the enforcer", func() {
+				So(len(records.records), ShouldEqual, 2)
+				for _, record := range records.records {
+					if record.ContextID == puInfo1.ContextID {
+						So(record.Stats.SourcePackets, ShouldEqual, 11)
+						So(record.Stats.DestinationPackets, ShouldEqual, 10)
+					}
+				}
+			})
+		})
+	})
+}
+
+func TestTeardownPacketWithoutState(t *testing.T) {
+
+	Convey("Given I create a new enforcer instance and two processing units", t, func() {
+		_, _, enforcer, err1, err2 := setupProcessingUnitsInDatapathAndEnforce()
+		So(err1, ShouldBeNil)
+		So(err2, ShouldBeNil)
+
+		Convey("When I receive FIN and RST packets of unknown connections, they should be accepted", func() {
+			for _, i := range []int{20, 23, 24} {
+				tcpPacket, err := packet.New(0, append([]byte{}, TCPFlow[i]...), "0")
+				So(err, ShouldBeNil)
+				So(enforcer.processApplicationTCPPackets(tcpPacket), ShouldBeNil)
+				So(enforcer.processNetworkTCPPackets(tcpPacket), ShouldBeNil)
+			}
+		})
+
+		Convey("When I receive an ACK packet of an unknown connection, it should be dropped", func() {
+			tcpPacket, err := packet.New(0, append([]byte{}, TCPFlow[9]...), "0")
+			So(err, ShouldBeNil)
+			So(enforcer.processNetworkTCPPackets(tcpPacket), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestExternalFlowLifecycle(t *testing.T) {
+
+	Convey("Given I create a new enforcer instance and a processing unit that accepts a network by ACL", t, func() {
+		puIP := "164.67.228.152"
+		puInfo := policy.NewPUInfo("SomeExternalProcessingUnitId", constants.ContainerPU)
+		puInfo.Runtime.SetIPAddresses(policy.ExtendedMap{"bridge": puIP})
+
+		acls := policy.IPRuleList{
+			policy.IPRule{
+				Address:  "10.1.10.76/32",
+				Port:     "80",
+				Protocol: "TCP",
+				Policy:   &policy.FlowPolicy{Action: policy.Accept, ServiceID: "service", PolicyID: "acl"},
+			},
+		}
+		puInfo.Policy = policy.NewPUPolicy(puInfo.ContextID, policy.Police, nil, acls, nil, nil, nil, nil, policy.ExtendedMap{policy.DefaultNamespace: puIP}, []string{}, []string{})
+
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+		enforcer := NewWithDefaults("SomeServerId", &collector.DefaultCollector{}, nil, secret, constants.LocalContainer, "/proc").(*Datapath)
+		So(enforcer.Enforce(puInfo.ContextID, puInfo), ShouldBeNil)
+
+		records := &flowEndCollector{}
+		enforcer.collector = records
+
+		Convey("When the external client opens and closes a connection", func() {
+			for _, p := range TCPFlow {
+				tcpPacket, err := packet.New(0, append([]byte{}, p...), "0")
+				So(err, ShouldBeNil)
+
+				if tcpPacket.SourceAddress.String() == puIP {
+					So(enforcer.processApplicationTCPPackets(tcpPacket), ShouldBeNil)
+				} else {
+					So(enforcer.processNetworkTCPPackets(tcpPacket), ShouldBeNil)
+				}
+			}
+
+			Convey("Then I should get a flow end record with the ACL policy", func() {
+				So(len(records.records), ShouldEqual, 1)
+
+				record := records.records[0]
+				So(record.ContextID, ShouldEqual, puInfo.ContextID)
+				So(record.Source.IP, ShouldEqual, "10.1.10.76")
+				So(record.Source.Type, ShouldEqual, collector.Address)
+				So(record.Source.ID, ShouldEqual, "service")
+				So(record.Destination.IP, ShouldEqual, puIP)
+				So(record.Destination.Type, ShouldEqual, collector.PU)
+				So(record.Action, ShouldEqual, policy.Accept)
+				So(record.PolicyID, ShouldEqual, "acl")
+				So(record.Stats.EndReason, ShouldEqual, collector.FlowEndFin)
+			})
+		})
+	})
+}
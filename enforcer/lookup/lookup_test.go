@@ -147,6 +147,18 @@ func TestFuncAddPolicy(t *testing.T) {
 			}
 		})
 
+		Convey("When I add a policy with an empty value, it should be an exact match of the empty value", func() {
+			index := policyDB.AddPolicy(policy.TagSelector{
+				Clause: []policy.KeyValueOperator{
+					{Key: "app", Value: []string{""}, Operator: policy.Equal},
+				},
+			})
+
+			So(index, ShouldEqual, 1)
+			So(policyDB.equalMapTable["app"][""], ShouldNotBeNil)
+			So(policyDB.equalPrefixes, ShouldNotContainKey, "app")
+		})
+
 		Convey("When I add a policy with the KeyExists operator, it should be added as a prefix of 0", func() {
 			index := policyDB.AddPolicy(dcTagExists)
 
@@ -5,6 +5,7 @@ package enforcer
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/aporeto-inc/netlink-go/nflog"
 	"github.com/aporeto-inc/trireme/collector"
@@ -41,6 +42,7 @@ func (a *nfLog) sourceNFLogsHanlder(buf *nflog.NfPacket, data interface{}) {
 	record, err := a.recordFromNFLogBuffer(buf, false)
 	if err != nil {
 		zap.L().Error("sourceNFLogsHanlder: create flow record", zap.Error(err))
+		return
 	}
 
 	a.collector.CollectFlowEvent(record)
@@ -51,6 +53,7 @@ func (a *nfLog) destNFLogsHandler(buf *nflog.NfPacket, data interface{}) {
 	record, err := a.recordFromNFLogBuffer(buf, true)
 	if err != nil {
 		zap.L().Error("destNFLogsHandler: create flow record", zap.Error(err))
+		return
 	}
 
 	a.collector.CollectFlowEvent(record)
@@ -87,18 +90,23 @@ func (a *nfLog) recordFromNFLogBuffer(buf *nflog.NfPacket, puIsSource bool) (*co
 	record := &collector.FlowRecord{
 		ContextID: contextID,
 		Source: &collector.EndPoint{
-			IP: buf.SrcIP.String(),
+			IP:   buf.SrcIP.String(),
+			Port: uint16(buf.SrcPort),
 		},
 		Destination: &collector.EndPoint{
 			IP:   buf.DstIP.String(),
 			Port: uint16(buf.DstPort),
 		},
-		PolicyID: policyID,
-		Tags:     tags,
-		Action:   action,
+		PolicyID:         policyID,
+		Tags:             tags,
+		Action:           action,
+		Timestamp:        time.Now(),
+		Protocol:         uint8(buf.Protocol),
+		ObservationPoint: collector.ObservationNetwork,
 	}
 
 	if puIsSource {
+		record.ObservationPoint = collector.ObservationApplication
 		record.Source.Type = collector.PU
 		record.Source.ID = puID
 		record.Destination.Type = collector.Address
@@ -9,6 +9,7 @@ import (
 
 	nfqueue "github.com/aporeto-inc/netlink-go/nfqueue"
 	"github.com/aporeto-inc/trireme/enforcer/utils/packet"
+	"github.com/aporeto-inc/trireme/metrics"
 	"go.uber.org/zap"
 )
 
@@ -84,17 +85,30 @@ func (d *Datapath) processNetworkPacketsFromNFQ(p *nfqueue.NFPacket) {
 	// Parse the packet - drop if parsing fails
 	netPacket, err := packet.New(packet.PacketTypeNetwork, p.Buffer, strconv.Itoa(int(p.Mark)))
 
+	var capture *packetCapture
+	if err == nil {
+		capture = d.capturePacket(false, netPacket)
+	}
+
 	if err != nil {
 		netPacket.Print(packet.PacketFailureCreate)
 	} else if netPacket.IPProto == packet.IPProtocolTCP {
 		err = d.processNetworkTCPPackets(netPacket)
+	} else if netPacket.IPProto == packet.IPProtocolUDP {
+		err = d.processNetworkUDPPackets(netPacket)
 	} else {
 		err = fmt.Errorf("Invalid IP Protocol %d", netPacket.IPProto)
 	}
+
+	if capture != nil {
+		capture.verdict(false, netPacket, err)
+	}
+
 	if err != nil {
 		length := uint32(len(p.Buffer))
 		buffer := p.Buffer
 		p.QueueHandle.SetVerdict2(uint32(p.QueueHandle.QueueNum), 0, uint32(p.Mark), length, uint32(p.ID), buffer)
+		metrics.QueuePacket(metrics.DirectionNetwork, uint16(p.QueueHandle.QueueNum), false)
 		return
 	}
 
@@ -107,6 +121,7 @@ func (d *Datapath) processNetworkPacketsFromNFQ(p *nfqueue.NFPacket) {
 	// buffer = append(buffer, netPacket.GetTCPData()...)
 	// length = uint32(len(buffer))
 	p.QueueHandle.SetVerdict2(uint32(p.QueueHandle.QueueNum), 1, uint32(p.Mark), uint32(copyIndex), uint32(p.ID), buffer)
+	metrics.QueuePacket(metrics.DirectionNetwork, uint16(p.QueueHandle.QueueNum), true)
 
 }
 
@@ -118,18 +133,30 @@ func (d *Datapath) processApplicationPacketsFromNFQ(p *nfqueue.NFPacket) {
 	// lots of things at the ingress to the network
 	appPacket, err := packet.New(packet.PacketTypeApplication, p.Buffer, strconv.Itoa(int(p.Mark)))
 
+	var capture *packetCapture
+	if err == nil {
+		capture = d.capturePacket(true, appPacket)
+	}
+
 	if err != nil {
 		appPacket.Print(packet.PacketFailureCreate)
 	} else if appPacket.IPProto == packet.IPProtocolTCP {
 		err = d.processApplicationTCPPackets(appPacket)
+	} else if appPacket.IPProto == packet.IPProtocolUDP {
+		err = d.processApplicationUDPPackets(appPacket)
 	} else {
 		err = fmt.Errorf("Invalid IP Protocol %d", appPacket.IPProto)
 	}
 
+	if capture != nil {
+		capture.verdict(true, appPacket, err)
+	}
+
 	if err != nil {
 		length := uint32(len(p.Buffer))
 		buffer := p.Buffer
 		p.QueueHandle.SetVerdict2(uint32(p.QueueHandle.QueueNum), 0, uint32(p.Mark), length, uint32(p.ID), buffer)
+		metrics.QueuePacket(metrics.DirectionApplication, uint16(p.QueueHandle.QueueNum), false)
 		return
 	}
 
@@ -144,5 +171,6 @@ func (d *Datapath) processApplicationPacketsFromNFQ(p *nfqueue.NFPacket) {
 	// length = uint32(len(buffer))
 
 	p.QueueHandle.SetVerdict2(uint32(p.QueueHandle.QueueNum), 1, uint32(p.Mark), uint32(copyIndex), uint32(p.ID), buffer)
+	metrics.QueuePacket(metrics.DirectionApplication, uint16(p.QueueHandle.QueueNum), true)
 
 }
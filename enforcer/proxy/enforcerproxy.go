@@ -18,6 +18,7 @@ import (
 	"github.com/aporeto-inc/trireme/enforcer/utils/fqconfig"
 	"github.com/aporeto-inc/trireme/enforcer/utils/rpcwrapper"
 	"github.com/aporeto-inc/trireme/enforcer/utils/secrets"
+	"github.com/aporeto-inc/trireme/metrics"
 	"github.com/aporeto-inc/trireme/policy"
 	"github.com/aporeto-inc/trireme/processmon"
 )
@@ -126,6 +127,7 @@ func (s *ProxyInfo) Enforce(contextID string, puInfo *policy.PUInfo) error {
 			TransmitterRules: puInfo.Policy.TransmitterRules(),
 			TriremeNetworks:  puInfo.Policy.TriremeNetworks(),
 			ExcludedNetworks: puInfo.Policy.ExcludedNetworks(),
+			Frozen:           puInfo.Policy.Frozen(),
 		},
 	}
 
@@ -147,6 +149,8 @@ func (s *ProxyInfo) Unenforce(contextID string) error {
 	delete(s.initDone, contextID)
 	s.Unlock()
 
+	metrics.ForgetContext(contextID)
+
 	return nil
 }
 
@@ -203,7 +207,8 @@ func NewProxyEnforcer(mutualAuth bool,
 	zap.L().Debug("Called NewDataPathEnforcer")
 
 	statsServer := rpcwrapper.NewRPCWrapper()
-	rpcServer := &StatsServer{rpchdl: statsServer, collector: collector, secret: statsServersecret}
+	// The flows of the remote enforcers are counted when they are received
+	rpcServer := &StatsServer{rpchdl: statsServer, collector: metrics.NewCollector(collector), secret: statsServersecret}
 
 	// Start hte server for statistics collection
 	go statsServer.StartServer("unix", rpcwrapper.StatsChannel, rpcServer) // nolint
@@ -244,7 +249,7 @@ type StatsServer struct {
 	secret    string
 }
 
-//GetStats  is the function called from the remoteenforcer when it has new flow events to publish
+//GetStats  is the function called from the remoteenforcer when it has new flow or container events to publish
 func (r *StatsServer) GetStats(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
 
 	if !r.rpchdl.ProcessMessage(&req, r.secret) {
@@ -258,5 +263,9 @@ func (r *StatsServer) GetStats(req rpcwrapper.Request, resp *rpcwrapper.Response
 		r.collector.CollectFlowEvent(record)
 	}
 
+	for _, record := range payload.Containers {
+		r.collector.CollectContainerEvent(record)
+	}
+
 	return nil
 }
@@ -7,6 +7,7 @@ import (
 	gomock "github.com/aporeto-inc/mock/gomock"
 	"github.com/aporeto-inc/trireme/collector"
 	"github.com/aporeto-inc/trireme/enforcer/utils/fqconfig"
+	"github.com/aporeto-inc/trireme/enforcer/utils/rpcwrapper"
 	mockrpcwrapper "github.com/aporeto-inc/trireme/enforcer/utils/rpcwrapper/mock"
 	"github.com/aporeto-inc/trireme/enforcer/utils/secrets"
 	"github.com/aporeto-inc/trireme/policy"
@@ -262,3 +263,60 @@ func TestUnenforce(t *testing.T) {
 		})
 	})
 }
+
+// recordingCollector records the events it collects
+type recordingCollector struct {
+	flows      []*collector.FlowRecord
+	containers []*collector.ContainerRecord
+}
+
+func (c *recordingCollector) CollectFlowEvent(record *collector.FlowRecord) {
+	c.flows = append(c.flows, record)
+}
+
+func (c *recordingCollector) CollectContainerEvent(record *collector.ContainerRecord) {
+	c.containers = append(c.containers, record)
+}
+
+func TestGetStats(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given a stats server", t, func() {
+		c := &recordingCollector{}
+		rpchdl := mockrpcwrapper.NewMockRPCServer(ctrl)
+		s := &StatsServer{collector: c, rpchdl: rpchdl, secret: "secret"}
+
+		flow := &collector.FlowRecord{
+			ContextID:   "contextID",
+			Count:       1,
+			Source:      &collector.EndPoint{ID: "A"},
+			Destination: &collector.EndPoint{ID: "B"},
+		}
+		container := &collector.ContainerRecord{
+			ContextID: "contextID",
+			Event:     collector.ContainerError,
+			Error:     "Unable to program the ACLs",
+		}
+		req := rpcwrapper.Request{
+			Payload: rpcwrapper.StatsPayload{
+				Flows:      map[string]*collector.FlowRecord{"flow": flow},
+				Containers: []*collector.ContainerRecord{container},
+			},
+		}
+
+		Convey("When a remote enforcer sends its flows and container events, they should be forwarded", func() {
+			rpchdl.EXPECT().ProcessMessage(gomock.Any(), "secret").Return(true)
+			So(s.GetStats(req, &rpcwrapper.Response{}), ShouldBeNil)
+			So(c.flows, ShouldResemble, []*collector.FlowRecord{flow})
+			So(c.containers, ShouldResemble, []*collector.ContainerRecord{container})
+		})
+
+		Convey("When the sender cannot be verified, nothing should be forwarded", func() {
+			rpchdl.EXPECT().ProcessMessage(gomock.Any(), "secret").Return(false)
+			So(s.GetStats(req, &rpcwrapper.Response{}), ShouldNotBeNil)
+			So(c.flows, ShouldBeEmpty)
+			So(c.containers, ShouldBeEmpty)
+		})
+	})
+}
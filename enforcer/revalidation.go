@@ -0,0 +1,388 @@
+package enforcer
+
+import (
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme/cache"
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/enforcer/lookup"
+	"github.com/aporeto-inc/trireme/enforcer/utils/packet"
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+// connectionSet holds the established connections of a PU. It has its own
+// lock since it is updated while the connections are locked.
+type connectionSet struct {
+	connections map[*TCPConnection]struct{}
+	sync.Mutex
+}
+
+// newConnectionSet returns an empty connection set
+func newConnectionSet() *connectionSet {
+
+	return &connectionSet{
+		connections: map[*TCPConnection]struct{}{},
+	}
+}
+
+// add adds a connection to the set
+func (s *connectionSet) add(conn *TCPConnection) {
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.connections[conn] = struct{}{}
+}
+
+// remove removes a connection from the set
+func (s *connectionSet) remove(conn *TCPConnection) {
+
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.connections, conn)
+}
+
+// list returns the connections of the set
+func (s *connectionSet) list() []*TCPConnection {
+
+	s.Lock()
+	defer s.Unlock()
+
+	list := make([]*TCPConnection, 0, len(s.connections))
+	for conn := range s.connections {
+		list = append(list, conn)
+	}
+
+	return list
+}
+
+// udpFlow is a UDP flow of a PU in the trackers
+type udpFlow struct {
+	conn *UDPConnection
+	// initiator is true for the flows initiated by the PU
+	initiator bool
+}
+
+// udpFlowSet holds the UDP flows of a PU keyed by their hash in the trackers.
+// It has its own lock since it is updated while the flows are locked.
+type udpFlowSet struct {
+	flows map[string]udpFlow
+	sync.Mutex
+}
+
+// newUDPFlowSet returns an empty UDP flow set
+func newUDPFlowSet() *udpFlowSet {
+
+	return &udpFlowSet{
+		flows: map[string]udpFlow{},
+	}
+}
+
+// add adds a flow to the set
+func (s *udpFlowSet) add(hash string, conn *UDPConnection, initiator bool) {
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.flows[hash] = udpFlow{conn: conn, initiator: initiator}
+}
+
+// remove removes a flow from the set unless the hash is used by another
+// connection already
+func (s *udpFlowSet) remove(hash string, conn *UDPConnection) {
+
+	s.Lock()
+	defer s.Unlock()
+
+	if f, ok := s.flows[hash]; ok && f.conn == conn {
+		delete(s.flows, hash)
+	}
+}
+
+// list returns the flows of the set
+func (s *udpFlowSet) list() map[string]udpFlow {
+
+	s.Lock()
+	defer s.Unlock()
+
+	list := make(map[string]udpFlow, len(s.flows))
+	for hash, f := range s.flows {
+		list[hash] = f
+	}
+
+	return list
+}
+
+// trackUDPConnection adds a flow to the application or network tracker and to
+// the flows of its PU. The flows of the application tracker are initiated by
+// the PU.
+func (d *Datapath) trackUDPConnection(hash string, conn *UDPConnection, app bool) {
+
+	if app {
+		d.udpAppConnectionTracker.AddOrUpdate(hash, conn)
+	} else {
+		d.udpNetConnectionTracker.AddOrUpdate(hash, conn)
+	}
+
+	if conn.Context != nil && conn.Context.udpFlows != nil {
+		conn.Context.udpFlows.add(hash, conn, app)
+	}
+}
+
+// udpConnectionExpirationNotifier removes the expired flows from the flows of
+// their PU. The notifier is called with the cache locked.
+func (d *Datapath) udpConnectionExpirationNotifier(c cache.DataStore, id interface{}, item interface{}) {
+
+	conn, ok := item.(*UDPConnection)
+	if !ok || conn.Context == nil || conn.Context.udpFlows == nil {
+		return
+	}
+
+	if hash, ok := id.(string); ok {
+		conn.Context.udpFlows.remove(hash, conn)
+	}
+}
+
+// conntrackDeleter deletes the conntrack entry of a flow
+type conntrackDeleter interface {
+	ConntrackTableDeleteFlow(ipSrc, ipDst string, protonum uint8, srcport, dstport uint16) error
+}
+
+// revalidationRules are the rules of a PU the flows are checked against
+type revalidationRules struct {
+	acceptRcv *lookup.PolicyDB
+	rejectRcv *lookup.PolicyDB
+	acceptTxt *lookup.PolicyDB
+	rejectTxt *lookup.PolicyDB
+}
+
+// allows returns true if the rules allow a flow with the remote tags. The
+// policy is returned for the flows initiated by the remote.
+func (d *Datapath) allows(r *revalidationRules, initiator bool, tags *policy.TagStore) (*policy.FlowPolicy, bool) {
+
+	if initiator {
+		// The transmitter rules are only enforced with mutual authorization
+		return nil, !d.mutualAuthorization || policyAllows(r.acceptTxt, r.rejectTxt, tags) != nil
+	}
+
+	plc := policyAllows(r.acceptRcv, r.rejectRcv, tags)
+
+	return plc, plc != nil
+}
+
+// revalidateConnections checks the established TCP connections and the UDP
+// flows of a PU against its current policy and revokes the ones that are
+// not allowed any more. The context must not be locked since the datapath
+// locks connections first.
+func (d *Datapath) revalidateConnections(context *PUContext) {
+
+	context.Lock()
+	rules := &revalidationRules{
+		acceptRcv: context.AcceptRcvRules,
+		rejectRcv: context.RejectRcvRules,
+		acceptTxt: context.AcceptTxtRules,
+		rejectTxt: context.RejectTxtRules,
+	}
+	audit := context.audit
+	context.Unlock()
+
+	d.revalidateTCPConnections(context, rules, audit)
+	d.revalidateUDPConnections(context, rules, audit)
+}
+
+// revalidateTCPConnections revokes the established TCP connections of a PU
+// that are not allowed by the rules
+func (d *Datapath) revalidateTCPConnections(context *PUContext, rules *revalidationRules, audit bool) {
+
+	if context.connections == nil {
+		return
+	}
+
+	for _, conn := range context.connections.list() {
+
+		conn.Lock()
+
+		if conn.lifecycle == nil || conn.lifecycle.ended || conn.revoked || conn.remoteTags == nil {
+			conn.Unlock()
+			continue
+		}
+
+		plc, allowed := d.allows(rules, conn.lifecycle.initiator, conn.remoteTags)
+		if plc != nil {
+			conn.FlowPolicy = plc
+		}
+
+		if !allowed {
+			if audit {
+				// Audited PUs keep their connections
+				zap.L().Info("Connection not allowed by the new policy",
+					zap.String("contextID", context.ID),
+					zap.String("flow", conn.lifecycle.hash),
+				)
+			} else {
+				d.revokeConnection(conn)
+			}
+		}
+
+		conn.Unlock()
+	}
+}
+
+// revalidateUDPConnections revokes the negotiated UDP flows of a PU that are
+// not allowed by the rules
+func (d *Datapath) revalidateUDPConnections(context *PUContext, rules *revalidationRules, audit bool) {
+
+	if context.udpFlows == nil {
+		return
+	}
+
+	for hash, f := range context.udpFlows.list() {
+
+		conn := f.conn
+
+		conn.Lock()
+
+		if conn.revoked || conn.remoteTags == nil {
+			conn.Unlock()
+			continue
+		}
+
+		plc, allowed := d.allows(rules, f.initiator, conn.remoteTags)
+		if plc != nil {
+			conn.FlowPolicy = plc
+		}
+
+		if !allowed {
+			if audit {
+				zap.L().Info("UDP flow not allowed by the new policy",
+					zap.String("contextID", context.ID),
+					zap.String("flow", hash),
+				)
+			} else {
+				d.revokeUDPConnection(conn, hash)
+			}
+		}
+
+		conn.Unlock()
+	}
+}
+
+// policyAllows returns the matching policy if the tags are accepted by the
+// rules. Reject rules have a higher priority.
+func policyAllows(accept, reject *lookup.PolicyDB, tags *policy.TagStore) *policy.FlowPolicy {
+
+	if reject != nil {
+		if index, _ := reject.Search(tags); index >= 0 {
+			return nil
+		}
+	}
+
+	if accept != nil {
+		if index, action := accept.Search(tags); index >= 0 {
+			if plc, ok := action.(*policy.FlowPolicy); ok {
+				return plc
+			}
+		}
+	}
+
+	return nil
+}
+
+// revokeConnection reports the end of a connection that is not allowed any
+// more and deletes its conntrack entry, so that the next packet in either
+// direction is processed by the datapath and resets the connection. The
+// flow is marked as revoked instead if the entry cannot be deleted.
+func (d *Datapath) revokeConnection(conn *TCPConnection) {
+
+	f := conn.lifecycle
+
+	zap.L().Info("Revoking connection after policy update",
+		zap.String("context", conn.Context.ManagementID),
+		zap.String("flow", f.hash),
+	)
+
+	conn.revoked = true
+
+	// The counters are read from the conntrack entry before it is deleted
+	d.reportFlowEnd(conn, collector.FlowEndRevoked)
+
+	if conn.ServiceConnection {
+		return
+	}
+
+	d.deleteRevokedFlow(f.hash, f.source.IP, f.destination.IP, packet.IPProtocolTCP, f.source.Port, f.destination.Port)
+}
+
+// revokeUDPConnection deletes the conntrack entry of a UDP flow that is not
+// allowed any more. Its datagrams are then processed by the datapath and
+// dropped until the flow expires.
+func (d *Datapath) revokeUDPConnection(conn *UDPConnection, hash string) {
+
+	zap.L().Info("Revoking UDP flow after policy update",
+		zap.String("context", conn.Context.ManagementID),
+		zap.String("flow", hash),
+	)
+
+	conn.revoked = true
+
+	if !conn.marked {
+		return
+	}
+
+	d.deleteRevokedFlow(hash, conn.source.IP, conn.destination.IP, packet.IPProtocolUDP, conn.source.Port, conn.destination.Port)
+}
+
+// deleteRevokedFlow deletes the conntrack entry of a revoked flow. If it
+// fails, the entry is marked so that the packets of the flow are still
+// processed by the datapath.
+func (d *Datapath) deleteRevokedFlow(hash string, ipSrc, ipDst string, protonum uint8, srcport, dstport uint16) {
+
+	err := d.conntrackDel.ConntrackTableDeleteFlow(ipSrc, ipDst, protonum, srcport, dstport)
+	if err == nil {
+		return
+	}
+
+	zap.L().Warn("Failed to delete the conntrack entry of the revoked flow",
+		zap.String("flow", hash),
+		zap.Error(err),
+	)
+
+	if err := d.conntrackHdl.ConntrackTableUpdateMark(
+		ipSrc,
+		ipDst,
+		protonum,
+		srcport,
+		dstport,
+		constants.RevokedConnMark,
+	); err != nil {
+		zap.L().Error("Failed to update conntrack table for revoked flow",
+			zap.String("flow", hash),
+			zap.Error(err),
+		)
+	}
+}
+
+// resetRevokedConnection converts a packet of a revoked connection to a reset
+// so that both ends close the connection. Conntrack releases the flow when
+// it sees the reset.
+func (d *Datapath) resetRevokedConnection(conn *TCPConnection, p *packet.Packet) error {
+
+	if p.TCPFlags&packet.TCPRstMask == 0 {
+		if err := p.ConvertToReset(); err != nil {
+			return err
+		}
+	}
+
+	if f := conn.lifecycle; f != nil {
+		if err := d.establishedConnectionTracker.Remove(f.hash); err != nil {
+			zap.L().Debug("Connection already removed from the established tracker", zap.String("flow", f.hash))
+		}
+		d.reportFlowEnd(conn, collector.FlowEndRevoked)
+	}
+
+	return nil
+}
@@ -0,0 +1,268 @@
+package enforcer
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/enforcer/utils/packet"
+	"github.com/aporeto-inc/trireme/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// markRecorder records the conntrack mark updates
+type markRecorder struct {
+	sync.Mutex
+	marks []uint32
+}
+
+func (m *markRecorder) ConntrackTableUpdateMark(ipSrc, ipDst string, protonum uint8, srcport, dstport uint16, newmark uint32) error {
+	m.Lock()
+	defer m.Unlock()
+
+	m.marks = append(m.marks, newmark)
+	return nil
+}
+
+func (m *markRecorder) hasMark(mark uint32) bool {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, v := range m.marks {
+		if v == mark {
+			return true
+		}
+	}
+	return false
+}
+
+// flowDeleter records the deleted conntrack entries
+type flowDeleter struct {
+	sync.Mutex
+	fail    bool
+	deleted []string
+}
+
+func (f *flowDeleter) ConntrackTableDeleteFlow(ipSrc, ipDst string, protonum uint8, srcport, dstport uint16) error {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.fail {
+		return fmt.Errorf("delete failed")
+	}
+
+	f.deleted = append(f.deleted, fmt.Sprintf("%d:%s:%s:%d:%d", protonum, ipSrc, ipDst, srcport, dstport))
+	return nil
+}
+
+func (f *flowDeleter) list() []string {
+	f.Lock()
+	defer f.Unlock()
+
+	return append([]string{}, f.deleted...)
+}
+
+// updateReceiverRules enforces a new policy on the PU with the given receiver rules
+func updateReceiverRules(enforcer *Datapath, puInfo *policy.PUInfo, rules ...policy.TagSelector) error {
+
+	updated := policy.NewPUInfo(puInfo.ContextID, constants.ContainerPU)
+	updated.Runtime.SetIPAddresses(puInfo.Runtime.IPAddresses())
+	updated.Policy.SetIPAddresses(puInfo.Policy.IPAddresses())
+	updated.Policy.AddIdentityTag(TransmitterLabel, "value")
+	for _, rule := range rules {
+		updated.Policy.AddReceiverRules(rule)
+	}
+
+	return enforcer.Enforce(puInfo.ContextID, updated)
+}
+
+func TestRevalidateConnections(t *testing.T) {
+
+	Convey("Given I create a new enforcer instance and an established connection", t, func() {
+		server, _, enforcer, err1, err2 := setupProcessingUnitsInDatapathAndEnforce()
+		So(err1, ShouldBeNil)
+		So(err2, ShouldBeNil)
+
+		records := &flowEndCollector{}
+		enforcer.collector = records
+
+		marks := &markRecorder{}
+		enforcer.conntrackHdl = marks
+
+		deleter := &flowDeleter{}
+		enforcer.conntrackDel = deleter
+
+		processTCPFlow(enforcer, 0, 1, 2, 3, 4)
+		So(establishedConnections(enforcer), ShouldEqual, 2)
+
+		Convey("When the policy of the server still accepts the connection", func() {
+			err := updateReceiverRules(enforcer, server, policy.TagSelector{
+				Clause: []policy.KeyValueOperator{
+					{
+						Key:      TransmitterLabel,
+						Value:    []string{"value"},
+						Operator: policy.Equal,
+					},
+				},
+				Policy: &policy.FlowPolicy{Action: policy.Accept, PolicyID: "new"},
+			})
+			So(err, ShouldBeNil)
+
+			Convey("Then the data packets should still be forwarded", func() {
+				So(marks.hasMark(constants.RevokedConnMark), ShouldBeFalse)
+				So(len(deleter.list()), ShouldEqual, 0)
+
+				processTCPFlow(enforcer, 5, 9)
+				So(len(records.records), ShouldEqual, 0)
+				So(establishedConnections(enforcer), ShouldEqual, 2)
+			})
+		})
+
+		Convey("When the policy of the server is frozen", func() {
+			frozen := policy.NewPUInfo(server.ContextID, constants.ContainerPU)
+			frozen.Runtime.SetIPAddresses(server.Runtime.IPAddresses())
+			frozen.Policy.SetIPAddresses(server.Policy.IPAddresses())
+			frozen.Policy.AddIdentityTag(TransmitterLabel, "value")
+			frozen.Policy.SetFrozen(true)
+			So(enforcer.Enforce(server.ContextID, frozen), ShouldBeNil)
+
+			Convey("Then the established connection should be kept", func() {
+				So(len(deleter.list()), ShouldEqual, 0)
+				So(marks.hasMark(constants.RevokedConnMark), ShouldBeFalse)
+				So(len(records.records), ShouldEqual, 0)
+				So(establishedConnections(enforcer), ShouldEqual, 2)
+			})
+		})
+
+		Convey("When the policy of the server does not accept the connection any more", func() {
+			err := updateReceiverRules(enforcer, server)
+			So(err, ShouldBeNil)
+
+			Convey("Then the conntrack entry of the flow should be deleted", func() {
+				So(deleter.list(), ShouldResemble, []string{"6:10.1.10.76:164.67.228.152:57761:80"})
+				So(marks.hasMark(constants.RevokedConnMark), ShouldBeFalse)
+			})
+
+			Convey("Then the end of the flow should be reported before any other packet", func() {
+				So(len(records.records), ShouldEqual, 1)
+				So(records.records[0].Stats.EndReason, ShouldEqual, collector.FlowEndRevoked)
+			})
+
+			Convey("Then the next packet should be converted to a reset", func() {
+				tcpPacket, err := packet.New(0, append([]byte{}, TCPFlow[5]...), "0")
+				So(err, ShouldBeNil)
+
+				So(enforcer.processApplicationTCPPackets(tcpPacket), ShouldBeNil)
+				So(tcpPacket.TCPFlags&packet.TCPRstMask, ShouldNotEqual, 0)
+				So(tcpPacket.TCPDataLength(), ShouldEqual, 0)
+				So(len(tcpPacket.ReadTCPData()), ShouldEqual, 0)
+
+				So(len(records.records), ShouldEqual, 1)
+				So(records.records[0].Stats.EndReason, ShouldEqual, collector.FlowEndRevoked)
+
+				output, err := packet.New(0, append([]byte{}, tcpPacket.GetBytes()...), "0")
+				So(err, ShouldBeNil)
+				So(enforcer.processNetworkTCPPackets(output), ShouldBeNil)
+				So(establishedConnections(enforcer), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the conntrack entry of a revoked flow cannot be deleted", func() {
+			deleter.fail = true
+			err := updateReceiverRules(enforcer, server)
+			So(err, ShouldBeNil)
+
+			Convey("Then the flow should be marked as revoked", func() {
+				So(marks.hasMark(constants.RevokedConnMark), ShouldBeTrue)
+			})
+		})
+	})
+}
+
+func TestRevalidateUDPConnections(t *testing.T) {
+
+	Convey("Given I create a new enforcer instance and a negotiated UDP flow", t, func() {
+		server, clientPU, enforcer, err1, err2 := setupProcessingUnitsInDatapathAndEnforce()
+		So(err1, ShouldBeNil)
+		So(err2, ShouldBeNil)
+
+		enforcer.conntrackHdl = &markRecorder{}
+
+		deleter := &flowDeleter{}
+		enforcer.conntrackDel = deleter
+
+		client, serverIP := "10.1.10.76", "164.67.228.152"
+
+		exchange := func(fromClient bool) error {
+			p := createUDPPacket(client, serverIP, 3456, 53, []byte("query"))
+			if !fromClient {
+				p = createUDPPacket(serverIP, client, 53, 3456, []byte("response"))
+			}
+			_, _, err := passUDPPacket(enforcer, p)
+			return err
+		}
+
+		for i := 0; i < 3; i++ {
+			So(exchange(true), ShouldBeNil)
+			So(exchange(false), ShouldBeNil)
+		}
+
+		Convey("Then each processing unit should only index its side of the flow", func() {
+			hash := client + ":" + serverIP + ":3456:53"
+
+			serverContext, err := enforcer.contextTracker.Get(server.ContextID)
+			So(err, ShouldBeNil)
+			serverFlows := serverContext.(*PUContext).udpFlows.list()
+			So(len(serverFlows), ShouldEqual, 1)
+			So(serverFlows[hash].initiator, ShouldBeFalse)
+
+			clientContext, err := enforcer.contextTracker.Get(clientPU.ContextID)
+			So(err, ShouldBeNil)
+			clientFlows := clientContext.(*PUContext).udpFlows.list()
+			So(len(clientFlows), ShouldEqual, 1)
+			So(clientFlows[hash].initiator, ShouldBeTrue)
+
+			Convey("When the flow expires, it should be removed from the index", func() {
+				enforcer.udpConnectionExpirationNotifier(enforcer.udpNetConnectionTracker, hash, serverFlows[hash].conn)
+				So(len(serverContext.(*PUContext).udpFlows.list()), ShouldEqual, 0)
+				So(len(clientContext.(*PUContext).udpFlows.list()), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When the policy of the server still accepts the flow", func() {
+			err := updateReceiverRules(enforcer, server, policy.TagSelector{
+				Clause: []policy.KeyValueOperator{
+					{
+						Key:      TransmitterLabel,
+						Value:    []string{"value"},
+						Operator: policy.Equal,
+					},
+				},
+				Policy: &policy.FlowPolicy{Action: policy.Accept, PolicyID: "new"},
+			})
+			So(err, ShouldBeNil)
+
+			Convey("Then the datagrams should still be forwarded", func() {
+				So(len(deleter.list()), ShouldEqual, 0)
+				So(exchange(true), ShouldBeNil)
+				So(exchange(false), ShouldBeNil)
+			})
+		})
+
+		Convey("When the policy of the server does not accept the flow any more", func() {
+			err := updateReceiverRules(enforcer, server)
+			So(err, ShouldBeNil)
+
+			Convey("Then the conntrack entry of the flow should be deleted", func() {
+				So(deleter.list(), ShouldResemble, []string{"17:10.1.10.76:164.67.228.152:3456:53"})
+			})
+
+			Convey("Then the next datagrams should be dropped", func() {
+				So(exchange(true), ShouldNotBeNil)
+				So(exchange(true), ShouldNotBeNil)
+			})
+		})
+	})
+}
@@ -0,0 +1,316 @@
+// Package simulator evaluates flows against the policy of a processing unit
+// without a datapath. The result explains which rule provided the decision
+// and why the other rules did not match.
+package simulator
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/aporeto-inc/trireme/enforcer"
+	"github.com/aporeto-inc/trireme/enforcer/acls"
+	"github.com/aporeto-inc/trireme/enforcer/lookup"
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+// Mismatch explains why a receiver rule did not match a flow
+type Mismatch struct {
+	// Selector is the rule that did not match
+	Selector policy.TagSelector
+	// Clause is the first clause of the rule that the tags do not satisfy
+	Clause policy.KeyValueOperator
+	// Reason describes why the clause is not satisfied
+	Reason string
+}
+
+// Result is the decision of the enforcer for a flow
+type Result struct {
+	// Action is the action applied to the flow
+	Action policy.ActionType
+	// PolicyID is the ID of the policy that provided the action
+	PolicyID string
+	// Enforced is false if the destination only reports the decision
+	Enforced bool
+	// Selector is the receiver rule that matched a flow from a PU
+	Selector *policy.TagSelector
+	// Rule is the network ACL that matched a flow from an external network
+	Rule *policy.IPRule
+	// Reason explains the decision
+	Reason string
+	// Mismatches explains why the receiver rules did not match
+	Mismatches []Mismatch
+}
+
+// Simulator evaluates flows against the policy of a destination PU
+type Simulator struct {
+	puPolicy        *policy.PUPolicy
+	acceptRules     *lookup.PolicyDB
+	rejectRules     *lookup.PolicyDB
+	acceptSelectors policy.TagSelectorList
+	rejectSelectors policy.TagSelectorList
+	tcpACLs         *acls.ACLCache
+	udpACLs         *acls.ACLCache
+}
+
+// New creates a simulator for the policy of a destination PU
+func New(destination *policy.PUPolicy) (*Simulator, error) {
+
+	if destination == nil {
+		return nil, fmt.Errorf("No destination policy")
+	}
+
+	s := &Simulator{
+		puPolicy:    destination,
+		acceptRules: lookup.NewPolicyDB(),
+		rejectRules: lookup.NewPolicyDB(),
+		tcpACLs:     acls.NewACLCache(),
+		udpACLs:     acls.NewACLCacheWithProtocol("udp"),
+	}
+
+	// The rules are added in the same order as the enforcer does. The index
+	// returned by a search is the position of the rule in the list.
+	for _, rule := range destination.ReceiverRules() {
+		if rule.Policy == nil {
+			continue
+		}
+		if rule.Policy.Action&policy.Accept != 0 {
+			s.acceptRules.AddPolicy(rule)
+			s.acceptSelectors = append(s.acceptSelectors, rule)
+		} else if rule.Policy.Action&policy.Reject != 0 {
+			s.rejectRules.AddPolicy(rule)
+			s.rejectSelectors = append(s.rejectSelectors, rule)
+		}
+	}
+
+	if err := s.tcpACLs.AddRuleList(destination.NetworkACLs()); err != nil {
+		return nil, fmt.Errorf("Invalid network ACLs: %s", err)
+	}
+
+	if err := s.udpACLs.AddRuleList(destination.NetworkACLs()); err != nil {
+		return nil, fmt.Errorf("Invalid network ACLs: %s", err)
+	}
+
+	return s, nil
+}
+
+// Simulate evaluates a TCP flow from a PU with the given identity to the port
+// of the destination PU. Flows without a source identity are evaluated
+// against the network ACLs using the source address ip.
+func Simulate(source *policy.TagStore, destination *policy.PUPolicy, ip string, port uint16) (*Result, error) {
+
+	s, err := New(destination)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Simulate(source, ip, port, "tcp")
+}
+
+// Simulate evaluates a flow of the given protocol to the destination port
+func (s *Simulator) Simulate(source *policy.TagStore, ip string, port uint16, protocol string) (*Result, error) {
+
+	var r *Result
+	var err error
+
+	if source == nil {
+		r, err = s.simulateExternal(ip, port, protocol)
+	} else {
+		r = s.simulatePU(source, port)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch s.puPolicy.TriremeAction() {
+	case policy.AllowAll:
+		r.Reason = r.Reason + " (not enforced: the destination allows all traffic)"
+	case policy.Audit:
+		r.Reason = r.Reason + " (not enforced: the destination is audited)"
+	default:
+		r.Enforced = true
+	}
+
+	return r, nil
+}
+
+// simulatePU evaluates the receiver rules like the enforcer does for the
+// token of a Syn packet
+func (s *Simulator) simulatePU(source *policy.TagStore, port uint16) *Result {
+
+	tags := source.Copy()
+	tags.AppendKeyValue(enforcer.PortNumberLabelString, strconv.Itoa(int(port)))
+
+	// Reject rules are always processed first
+	if index, action := s.rejectRules.Search(tags); index > 0 {
+		selector := s.rejectSelectors[index-1]
+		return &Result{
+			Action:     policy.Reject,
+			PolicyID:   action.(*policy.FlowPolicy).PolicyID,
+			Selector:   &selector,
+			Reason:     "Rejected by a receiver rule",
+			Mismatches: explainSelectors(s.acceptSelectors, tags),
+		}
+	}
+
+	if index, action := s.acceptRules.Search(tags); index > 0 {
+		selector := s.acceptSelectors[index-1]
+		return &Result{
+			Action:     action.(*policy.FlowPolicy).Action,
+			PolicyID:   action.(*policy.FlowPolicy).PolicyID,
+			Selector:   &selector,
+			Reason:     "Accepted by a receiver rule",
+			Mismatches: explainSelectors(s.rejectSelectors, tags),
+		}
+	}
+
+	return &Result{
+		Action:     policy.Reject,
+		Reason:     "No receiver rule matched the tags",
+		Mismatches: explainSelectors(append(s.rejectSelectors.Copy(), s.acceptSelectors...), tags),
+	}
+}
+
+// simulateExternal evaluates the network ACLs like the enforcer does for
+// flows without a token
+func (s *Simulator) simulateExternal(ip string, port uint16, protocol string) (*Result, error) {
+
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return nil, fmt.Errorf("Invalid source address %s", ip)
+	}
+
+	var c *acls.ACLCache
+	switch strings.ToLower(protocol) {
+	case "tcp", "":
+		c = s.tcpACLs
+	case "udp":
+		c = s.udpACLs
+	default:
+		return nil, fmt.Errorf("Unsupported protocol %s", protocol)
+	}
+
+	if v4 := addr.To4(); v4 != nil {
+		addr = v4
+	}
+
+	rule, err := c.GetMatchingRule(addr, port)
+	if err != nil {
+		return &Result{
+			Action:   policy.Reject,
+			PolicyID: "default",
+			Reason:   fmt.Sprintf("No network ACL matched %s port %d", ip, port),
+		}, nil
+	}
+
+	if rule.Policy == nil {
+		return nil, fmt.Errorf("Network ACL %s port %s has no policy", rule.Address, rule.Port)
+	}
+
+	r := &Result{
+		Action:   rule.Policy.Action,
+		PolicyID: rule.Policy.PolicyID,
+		Rule:     rule,
+	}
+
+	if r.Action&policy.Reject > 0 {
+		r.Reason = "Rejected by a network ACL"
+	} else {
+		r.Reason = "Accepted by a network ACL"
+	}
+
+	return r, nil
+}
+
+// explainSelectors returns why each of the selectors does not match the tags
+func explainSelectors(selectors policy.TagSelectorList, tags *policy.TagStore) []Mismatch {
+
+	values := map[string][]string{}
+	for _, t := range tags.GetSlice() {
+		parts := strings.SplitN(t, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		values[parts[0]] = append(values[parts[0]], parts[1])
+	}
+
+	mismatches := []Mismatch{}
+	for _, selector := range selectors {
+		for _, clause := range selector.Clause {
+			if reason := explainClause(clause, values); reason != "" {
+				mismatches = append(mismatches, Mismatch{
+					Selector: selector,
+					Clause:   clause,
+					Reason:   reason,
+				})
+				break
+			}
+		}
+	}
+
+	return mismatches
+}
+
+// explainClause returns why the clause is not satisfied by the tags or an
+// empty string if it is
+func explainClause(clause policy.KeyValueOperator, values map[string][]string) string {
+
+	current, ok := values[clause.Key]
+
+	switch clause.Operator {
+
+	case policy.KeyExists:
+		if !ok {
+			return fmt.Sprintf("No tag with key %s", clause.Key)
+		}
+
+	case policy.KeyNotExists:
+		if ok {
+			return fmt.Sprintf("Tag %s=%s is present", clause.Key, current[0])
+		}
+
+	case policy.Equal:
+		if !ok {
+			return fmt.Sprintf("No tag with key %s", clause.Key)
+		}
+		for _, v := range current {
+			for _, expected := range clause.Value {
+				if matchValue(expected, v) {
+					return ""
+				}
+			}
+		}
+		return fmt.Sprintf("Tag %s has value %s, expected %s", clause.Key, strings.Join(current, ","), strings.Join(clause.Value, ","))
+
+	default: // policy.NotEqual
+		if !ok {
+			return fmt.Sprintf("No tag with key %s", clause.Key)
+		}
+		for _, v := range current {
+			excluded := false
+			for _, value := range clause.Value {
+				if v == value {
+					excluded = true
+				}
+			}
+			if !excluded {
+				return ""
+			}
+		}
+		return fmt.Sprintf("Tag %s has excluded value %s", clause.Key, strings.Join(current, ","))
+	}
+
+	return ""
+}
+
+// matchValue matches a value of a clause. A trailing * matches any suffix.
+func matchValue(expected, value string) bool {
+
+	if len(expected) > 0 && expected[len(expected)-1] == '*' {
+		return strings.HasPrefix(value, expected[:len(expected)-1])
+	}
+
+	return expected == value
+}
@@ -0,0 +1,149 @@
+package simulator
+
+import (
+	"testing"
+
+	"github.com/aporeto-inc/trireme/enforcer"
+	"github.com/aporeto-inc/trireme/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func destinationPolicy(action policy.PUAction) *policy.PUPolicy {
+
+	rxRules := policy.TagSelectorList{
+		policy.TagSelector{
+			Clause: []policy.KeyValueOperator{
+				{Key: "app", Value: []string{"web"}, Operator: policy.Equal},
+				{Key: "env", Value: []string{"dev"}, Operator: policy.NotEqual},
+			},
+			Policy: &policy.FlowPolicy{Action: policy.Accept, PolicyID: "web"},
+		},
+		policy.TagSelector{
+			Clause: []policy.KeyValueOperator{
+				{Key: "app", Value: []string{"web"}, Operator: policy.Equal},
+				{Key: enforcer.PortNumberLabelString, Value: []string{"22"}, Operator: policy.Equal},
+			},
+			Policy: &policy.FlowPolicy{Action: policy.Reject, PolicyID: "ssh"},
+		},
+		policy.TagSelector{
+			Clause: []policy.KeyValueOperator{
+				{Key: "team", Value: []string{"ops*"}, Operator: policy.Equal},
+			},
+			Policy: &policy.FlowPolicy{Action: policy.Accept, PolicyID: "ops"},
+		},
+	}
+
+	netACLs := policy.IPRuleList{
+		policy.IPRule{
+			Address:  "10.0.0.0/8",
+			Port:     "80",
+			Protocol: "tcp",
+			Policy:   &policy.FlowPolicy{Action: policy.Accept, PolicyID: "internal"},
+		},
+		policy.IPRule{
+			Address:  "192.168.1.0/24",
+			Port:     "53",
+			Protocol: "udp",
+			Policy:   &policy.FlowPolicy{Action: policy.Reject, PolicyID: "dns"},
+		},
+	}
+
+	return policy.NewPUPolicy("dst", action, nil, netACLs, nil, rxRules, nil, nil, nil, []string{}, []string{})
+}
+
+func TestSimulatePU(t *testing.T) {
+
+	Convey("Given a simulator for a destination policy", t, func() {
+		s, err := New(destinationPolicy(policy.Police))
+		So(err, ShouldBeNil)
+
+		Convey("When a PU matches an accept rule, I should get the rule", func() {
+			r, err := s.Simulate(policy.NewTagStoreFromMap(map[string]string{"app": "web", "env": "prod"}), "", 80, "tcp")
+			So(err, ShouldBeNil)
+			So(r.Action, ShouldEqual, policy.Accept)
+			So(r.PolicyID, ShouldEqual, "web")
+			So(r.Enforced, ShouldBeTrue)
+			So(r.Selector, ShouldNotBeNil)
+			So(r.Selector.Policy.PolicyID, ShouldEqual, "web")
+		})
+
+		Convey("When a PU matches a reject rule on the port, the reject rule should win", func() {
+			r, err := s.Simulate(policy.NewTagStoreFromMap(map[string]string{"app": "web", "env": "prod"}), "", 22, "tcp")
+			So(err, ShouldBeNil)
+			So(r.Action, ShouldEqual, policy.Reject)
+			So(r.PolicyID, ShouldEqual, "ssh")
+		})
+
+		Convey("When a PU matches a prefix, I should get the rule", func() {
+			r, err := s.Simulate(policy.NewTagStoreFromMap(map[string]string{"team": "ops-east"}), "", 80, "tcp")
+			So(err, ShouldBeNil)
+			So(r.Action, ShouldEqual, policy.Accept)
+			So(r.PolicyID, ShouldEqual, "ops")
+		})
+
+		Convey("When no rule matches, I should get the reason of every rule", func() {
+			r, err := s.Simulate(policy.NewTagStoreFromMap(map[string]string{"app": "web", "env": "dev"}), "", 80, "tcp")
+			So(err, ShouldBeNil)
+			So(r.Action, ShouldEqual, policy.Reject)
+			So(r.Selector, ShouldBeNil)
+			So(len(r.Mismatches), ShouldEqual, 3)
+
+			reasons := map[string]Mismatch{}
+			for _, m := range r.Mismatches {
+				reasons[m.Selector.Policy.PolicyID] = m
+			}
+			So(reasons["web"].Clause.Key, ShouldEqual, "env")
+			So(reasons["ssh"].Clause.Key, ShouldEqual, enforcer.PortNumberLabelString)
+			So(reasons["ops"].Clause.Key, ShouldEqual, "team")
+		})
+	})
+
+	Convey("Given a simulator for an audited destination", t, func() {
+		s, err := New(destinationPolicy(policy.Audit))
+		So(err, ShouldBeNil)
+
+		Convey("The decision should not be enforced", func() {
+			r, err := s.Simulate(policy.NewTagStoreFromMap(map[string]string{"app": "db"}), "", 80, "tcp")
+			So(err, ShouldBeNil)
+			So(r.Action, ShouldEqual, policy.Reject)
+			So(r.Enforced, ShouldBeFalse)
+		})
+	})
+}
+
+func TestSimulateExternal(t *testing.T) {
+
+	Convey("Given a destination policy", t, func() {
+		d := destinationPolicy(policy.Police)
+
+		Convey("When an address matches a network ACL, I should get the rule", func() {
+			r, err := Simulate(nil, d, "10.1.1.1", 80)
+			So(err, ShouldBeNil)
+			So(r.Action, ShouldEqual, policy.Accept)
+			So(r.Rule, ShouldNotBeNil)
+			So(r.Rule.Address, ShouldEqual, "10.0.0.0/8")
+		})
+
+		Convey("When an address does not match, I should get the default reject", func() {
+			r, err := Simulate(nil, d, "172.16.1.1", 80)
+			So(err, ShouldBeNil)
+			So(r.Action, ShouldEqual, policy.Reject)
+			So(r.Rule, ShouldBeNil)
+			So(r.PolicyID, ShouldEqual, "default")
+		})
+
+		Convey("When a UDP flow matches a reject ACL, I should get the rule", func() {
+			s, err := New(d)
+			So(err, ShouldBeNil)
+			r, err := s.Simulate(nil, "192.168.1.10", 53, "udp")
+			So(err, ShouldBeNil)
+			So(r.Action, ShouldEqual, policy.Reject)
+			So(r.PolicyID, ShouldEqual, "dns")
+		})
+
+		Convey("When the address is invalid, I should get an error", func() {
+			_, err := Simulate(nil, d, "invalid", 80)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -1,12 +1,26 @@
 package enforcer
 
 import (
+	"time"
+
 	"github.com/aporeto-inc/trireme/collector"
 	"github.com/aporeto-inc/trireme/enforcer/lookup"
 	"github.com/aporeto-inc/trireme/enforcer/utils/packet"
 	"github.com/aporeto-inc/trireme/policy"
+	"go.uber.org/zap"
 )
 
+// tcpFlags returns the TCP flags of a packet, or 0 for other protocols
+func tcpFlags(p *packet.Packet) uint8 {
+
+	if p.IPProto != packet.IPProtocolTCP {
+		return 0
+	}
+
+	return p.TCPFlags
+}
+
+// reportFlow reports a flow observed on a packet received from the network
 func (d *Datapath) reportFlow(p *packet.Packet, connection *TCPConnection, sourceID string, destID string, context *PUContext, mode string, plc *policy.FlowPolicy) {
 
 	c := &collector.FlowRecord{
@@ -23,9 +37,13 @@ func (d *Datapath) reportFlow(p *packet.Packet, connection *TCPConnection, sourc
 			Port: p.DestinationPort,
 			Type: collector.PU,
 		},
-		Tags:       context.Annotations,
-		Action:     plc.Action,
-		DropReason: mode,
+		Tags:             context.Annotations,
+		Action:           plc.Action,
+		DropReason:       mode,
+		Timestamp:        time.Now(),
+		Protocol:         p.IPProto,
+		TCPFlags:         tcpFlags(p),
+		ObservationPoint: collector.ObservationNetwork,
 	}
 
 	d.collector.CollectFlowEvent(c)
@@ -53,6 +71,27 @@ func (d *Datapath) reportRejectedFlow(p *packet.Packet, conn *TCPConnection, sou
 	d.reportFlow(p, conn, sourceID, destID, context, mode, plc)
 }
 
+func (d *Datapath) reportUDPAcceptedFlow(p *packet.Packet, conn *UDPConnection, sourceID string, destID string, context *PUContext, plc *policy.FlowPolicy) {
+	if conn != nil {
+		conn.SetReported()
+	}
+	d.reportFlow(p, nil, sourceID, destID, context, "NA", plc)
+}
+
+func (d *Datapath) reportUDPRejectedFlow(p *packet.Packet, conn *UDPConnection, sourceID string, destID string, context *PUContext, mode string, plc *policy.FlowPolicy) {
+	if conn != nil {
+		conn.SetReported()
+	}
+
+	if plc == nil {
+		plc = &policy.FlowPolicy{
+			Action: policy.Reject,
+		}
+	}
+
+	d.reportFlow(p, nil, sourceID, destID, context, mode, plc)
+}
+
 func (d *Datapath) reportExternalServiceFlow(context *PUContext, flowpolicy *policy.FlowPolicy, app bool, p *packet.Packet) {
 
 	src := &collector.EndPoint{
@@ -83,14 +122,23 @@ func (d *Datapath) reportExternalServiceFlow(context *PUContext, flowpolicy *pol
 		dst.Type = collector.PU
 	}
 
+	observation := collector.ObservationNetwork
+	if app {
+		observation = collector.ObservationApplication
+	}
+
 	record := &collector.FlowRecord{
-		ContextID:   context.ID,
-		Source:      src,
-		Destination: dst,
-		DropReason:  collector.PolicyDrop,
-		Action:      flowpolicy.Action,
-		Tags:        context.Annotations,
-		PolicyID:    flowpolicy.PolicyID,
+		ContextID:        context.ID,
+		Source:           src,
+		Destination:      dst,
+		DropReason:       collector.PolicyDrop,
+		Action:           flowpolicy.Action,
+		Tags:             context.Annotations,
+		PolicyID:         flowpolicy.PolicyID,
+		Timestamp:        time.Now(),
+		Protocol:         p.IPProto,
+		TCPFlags:         tcpFlags(p),
+		ObservationPoint: observation,
 	}
 
 	d.collector.CollectFlowEvent(record)
@@ -126,19 +174,65 @@ func (d *Datapath) reportReverseExternalServiceFlow(context *PUContext, flowpoli
 		dst.Type = collector.PU
 	}
 
+	// The packet is the reply to the flow: it travels the other way
+	observation := collector.ObservationApplication
+	if app {
+		observation = collector.ObservationNetwork
+	}
+
 	record := &collector.FlowRecord{
-		ContextID:   context.ID,
-		Source:      src,
-		Destination: dst,
-		DropReason:  collector.PolicyDrop,
-		Action:      flowpolicy.Action,
-		Tags:        context.Annotations,
-		PolicyID:    flowpolicy.PolicyID,
+		ContextID:        context.ID,
+		Source:           src,
+		Destination:      dst,
+		DropReason:       collector.PolicyDrop,
+		Action:           flowpolicy.Action,
+		Tags:             context.Annotations,
+		PolicyID:         flowpolicy.PolicyID,
+		Timestamp:        time.Now(),
+		Protocol:         p.IPProto,
+		TCPFlags:         tcpFlags(p),
+		ObservationPoint: observation,
 	}
 
 	d.collector.CollectFlowEvent(record)
 }
 
+// auditFlowPolicy returns the policy applied to the flows of audited PUs that
+// the rules reject. The flow is accepted and keeps the rejecting policy ID.
+func auditFlowPolicy(plc *policy.FlowPolicy) *policy.FlowPolicy {
+
+	audit := &policy.FlowPolicy{
+		Action: policy.Accept,
+	}
+
+	if plc != nil {
+		audit.ServiceID = plc.ServiceID
+		audit.PolicyID = plc.PolicyID
+	}
+
+	return audit
+}
+
+// stripTCPAuthentication removes the authentication option and the token of
+// a packet that is accepted without authentication. Packets without the
+// option are left untouched.
+func stripTCPAuthentication(p *packet.Packet) {
+
+	if err := p.CheckTCPAuthenticationOption(TCPAuthenticationOptionBaseLen); err != nil {
+		return
+	}
+
+	if err := p.TCPDataDetach(TCPAuthenticationOptionBaseLen); err != nil {
+		zap.L().Debug("Unable to remove the authentication data",
+			zap.String("flow", p.L4FlowHash()),
+			zap.Error(err),
+		)
+		return
+	}
+
+	p.DropDetachedBytes()
+}
+
 // createRuleDBs creates the database of rules from the policy
 func createRuleDBs(policyRules policy.TagSelectorList) (*lookup.PolicyDB, *lookup.PolicyDB) {
 
@@ -156,3 +250,15 @@ func createRuleDBs(policyRules policy.TagSelectorList) (*lookup.PolicyDB, *looku
 	}
 	return acceptRules, rejectRules
 }
+
+// encrypts returns true if an accept rule of the policy encrypts the flows
+func encrypts(policyRules policy.TagSelectorList) bool {
+
+	for _, rule := range policyRules {
+		if rule.Policy != nil && rule.Policy.Action&policy.Accept != 0 && rule.Policy.Action.Encrypted() {
+			return true
+		}
+	}
+
+	return false
+}
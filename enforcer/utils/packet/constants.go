@@ -4,6 +4,9 @@ const (
 	// minIPPacketLen is the min ip packet size
 	minIPPacketLen = 40
 
+	// minUDPPacketLen is the min ip packet size for UDP packets
+	minUDPPacketLen = 28
+
 	// minIPHdrSize
 	minIPHdrSize = 20
 
@@ -34,6 +37,27 @@ const (
 	ipDestAddrPos = 16
 )
 
+// IPv6 Header field position constants
+const (
+	// ipVersion6 is the value of the version field of IPv6 packets
+	ipVersion6 = 6
+
+	// ipv6HdrSize is the size of the fixed IPv6 header
+	ipv6HdrSize = 40
+
+	// ipv6PayloadLengthPos is the location of the IPv6 payload length
+	ipv6PayloadLengthPos = 4
+
+	// ipv6NextHeaderPos is the location of the IPv6 next header
+	ipv6NextHeaderPos = 6
+
+	// ipv6SourceAddrPos is location of source IPv6 address
+	ipv6SourceAddrPos = 8
+
+	// ipv6DestAddrPos is location of destination IPv6 address
+	ipv6DestAddrPos = 24
+)
+
 // IP Protocol numbers
 const (
 	// IPProtocolTCP defines the constant for UDP protocol number
@@ -72,6 +96,18 @@ const (
 	TCPChecksumPos = 36
 )
 
+// UDP Header field position constants
+const (
+	// udpLengthPos is the location of the UDP length
+	udpLengthPos = 24
+
+	// UDPChecksumPos is the location of UDP checksum
+	UDPChecksumPos = 26
+
+	// udpDataPos is the location of the UDP payload
+	udpDataPos = 28
+)
+
 // TCP Header masks
 const (
 	// tcpDataOffsetMask is a mask for TCP data offset field
@@ -103,4 +139,16 @@ const (
 
 	// TCPMssOptionLen is the type for MSS option
 	TCPMssOptionLen = uint8(4)
+
+	// tcpEndOfOptions is the type of the option that ends the option list
+	tcpEndOfOptions = uint8(0)
+
+	// tcpNoOperation is the type of the padding option
+	tcpNoOperation = uint8(1)
+
+	// tcpHeaderMinLen is the length of the TCP header without options
+	tcpHeaderMinLen = 20
+
+	// maxTCPDataOffset is the maximum length of the TCP header in 32-bit words
+	maxTCPDataOffset = 15
 )
@@ -8,6 +8,7 @@ import (
 	"strconv"
 
 	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
 )
 
 // Helpher functions for the package, mainly for debugging and validation
@@ -18,6 +19,11 @@ import (
 // modified.
 func (p *Packet) VerifyIPChecksum() bool {
 
+	// IPv6 has no header checksum
+	if p.ipv6 {
+		return true
+	}
+
 	sum := p.computeIPChecksum()
 
 	return sum == p.ipChecksum
@@ -27,6 +33,10 @@ func (p *Packet) VerifyIPChecksum() bool {
 // packet with the value.
 func (p *Packet) UpdateIPChecksum() {
 
+	if p.ipv6 {
+		return
+	}
+
 	p.ipChecksum = p.computeIPChecksum()
 
 	binary.BigEndian.PutUint16(p.Buffer[ipChecksumPos:ipChecksumPos+2], p.ipChecksum)
@@ -48,7 +58,30 @@ func (p *Packet) UpdateTCPChecksum() {
 
 	p.TCPChecksum = p.computeTCPChecksum()
 
-	binary.BigEndian.PutUint16(p.Buffer[TCPChecksumPos:TCPChecksumPos+2], p.TCPChecksum)
+	binary.BigEndian.PutUint16(p.l4Field(TCPChecksumPos, 2), p.TCPChecksum)
+}
+
+// VerifyUDPChecksum returns true if the UDP header checksum is correct
+// for this packet, false otherwise. A zero checksum means that the sender
+// did not compute one and it is always considered valid.
+func (p *Packet) VerifyUDPChecksum() bool {
+
+	if p.UDPChecksum == 0 {
+		return true
+	}
+
+	sum := p.computeUDPChecksum()
+
+	return sum == p.UDPChecksum
+}
+
+// UpdateUDPChecksum computes the UDP header checksum and updates the
+// packet with the value.
+func (p *Packet) UpdateUDPChecksum() {
+
+	p.UDPChecksum = p.computeUDPChecksum()
+
+	binary.BigEndian.PutUint16(p.l4Field(UDPChecksumPos, 2), p.UDPChecksum)
 }
 
 // String returns a string representation of fields contained in this packet.
@@ -57,7 +90,13 @@ func (p *Packet) String() string {
 	var buf bytes.Buffer
 	buf.WriteString("(error)")
 
-	header, err := ipv4.ParseHeader(p.Buffer)
+	var header fmt.Stringer
+	var err error
+	if p.ipv6 {
+		header, err = ipv6.ParseHeader(p.Buffer)
+	} else {
+		header, err = ipv4.ParseHeader(p.Buffer)
+	}
 
 	if err == nil {
 		buf.Reset()
@@ -92,30 +131,15 @@ func (p *Packet) computeIPChecksum() uint16 {
 // Computes the TCP header checksum. The packet is not modified.
 func (p *Packet) computeTCPChecksum() uint16 {
 
-	var pseudoHeaderLen uint16 = 12
 	tcpSize := uint16(len(p.Buffer)) - p.l4BeginPos
-	bufLen := pseudoHeaderLen + tcpSize
-	buf := make([]byte, bufLen)
-
-	// Construct the pseudo-header for TCP checksum computation:
-
-	// bytes 0-3: Source IP address
-	copy(buf[0:4], p.Buffer[ipSourceAddrPos:ipSourceAddrPos+4])
-
-	// bytes 4-7: Destination IP address
-	copy(buf[4:8], p.Buffer[ipDestAddrPos:ipDestAddrPos+4])
 
-	// byte 8: Constant zero
-	buf[8] = 0
+	// bytes 0-11 (IPv4) or 0-39 (IPv6): the pseudo-header, with the TCP
+	// buffer size (real header + payload)
+	buf := p.pseudoHeader(IPProtocolTCP, tcpSize+uint16(len(p.tcpData)+len(p.tcpOptions)))
+	pseudoHeaderLen := len(buf)
 
-	// byte 9: Protocol (6==TCP)
-	buf[9] = 6
-
-	// bytes 10,11: TCP buffer size (real header + payload)
-	binary.BigEndian.PutUint16(buf[10:12], tcpSize+uint16(len(p.tcpData)+len(p.tcpOptions)))
-
-	// bytes 12+: The TCP buffer (real header + payload)
-	copy(buf[12:], p.Buffer[p.l4BeginPos:])
+	// The TCP buffer (real header + payload)
+	buf = append(buf, p.Buffer[p.l4BeginPos:]...)
 
 	// Set current checksum to zero (in buf, not changing packet)
 	buf[pseudoHeaderLen+16] = 0
@@ -127,6 +151,53 @@ func (p *Packet) computeTCPChecksum() uint16 {
 	return checksum(buf)
 }
 
+// Computes the UDP header checksum. The packet is not modified.
+func (p *Packet) computeUDPChecksum() uint16 {
+
+	udpSize := uint16(len(p.Buffer)) - p.l4BeginPos
+
+	buf := p.pseudoHeader(IPProtocolUDP, udpSize)
+	pseudoHeaderLen := len(buf)
+
+	// The UDP buffer (header + payload)
+	buf = append(buf, p.Buffer[p.l4BeginPos:]...)
+
+	// Set current checksum to zero (in buf, not changing packet)
+	buf[pseudoHeaderLen+6] = 0
+	buf[pseudoHeaderLen+7] = 0
+
+	csum := checksum(buf)
+
+	// A computed checksum of zero is transmitted as all ones (RFC 768)
+	if csum == 0 {
+		csum = 0xFFFF
+	}
+
+	return csum
+}
+
+// pseudoHeader builds the pseudo-header used in the L4 checksum computation
+func (p *Packet) pseudoHeader(proto uint8, length uint16) []byte {
+
+	if p.ipv6 {
+		// Source and destination address, upper layer length, zeros and next header (RFC 2460)
+		buf := make([]byte, 40)
+		copy(buf[0:16], p.Buffer[ipv6SourceAddrPos:ipv6SourceAddrPos+16])
+		copy(buf[16:32], p.Buffer[ipv6DestAddrPos:ipv6DestAddrPos+16])
+		binary.BigEndian.PutUint32(buf[32:36], uint32(length))
+		buf[39] = proto
+		return buf
+	}
+
+	// Source and destination address, zero, protocol and length
+	buf := make([]byte, 12)
+	copy(buf[0:4], p.Buffer[ipSourceAddrPos:ipSourceAddrPos+4])
+	copy(buf[4:8], p.Buffer[ipDestAddrPos:ipDestAddrPos+4])
+	buf[9] = proto
+	binary.BigEndian.PutUint16(buf[10:12], length)
+	return buf
+}
+
 // incCsum16 implements rfc1624, equation 3.
 func incCsum16(start, old, new uint16) uint16 {
 
@@ -64,45 +64,148 @@ func New(context uint64, bytes []byte, mark string) (packet *Packet, err error)
 	p.tcpOptions = []byte{}
 	p.tcpData = []byte{}
 
+	if len(bytes) > 0 && bytes[ipHdrLenPos]>>4 == ipVersion6 {
+		if err := p.parseIPv6Header(); err != nil {
+			return nil, err
+		}
+	} else if err := p.parseIPv4Header(); err != nil {
+		return nil, err
+	}
+
+	p.context = context
+
+	// UDP Header Processing
+	if p.IPProto == IPProtocolUDP {
+		p.SourcePort = binary.BigEndian.Uint16(p.l4Field(tcpSourcePortPos, 2))
+		p.DestinationPort = binary.BigEndian.Uint16(p.l4Field(tcpDestPortPos, 2))
+		p.UDPLength = binary.BigEndian.Uint16(p.l4Field(udpLengthPos, 2))
+		p.UDPChecksum = binary.BigEndian.Uint16(p.l4Field(UDPChecksumPos, 2))
+
+		if p.UDPLength != p.IPTotalLength-p.l4BeginPos {
+			return nil, fmt.Errorf("Stated UDP length (%d) differs from IP payload length (%d)", p.UDPLength, p.IPTotalLength-p.l4BeginPos)
+		}
+
+		return &p, nil
+	}
+
+	// TCP Header Processing
+	p.TCPChecksum = binary.BigEndian.Uint16(p.l4Field(TCPChecksumPos, 2))
+	p.SourcePort = binary.BigEndian.Uint16(p.l4Field(tcpSourcePortPos, 2))
+	p.DestinationPort = binary.BigEndian.Uint16(p.l4Field(tcpDestPortPos, 2))
+	p.TCPAck = binary.BigEndian.Uint32(p.l4Field(tcpAckPos, 4))
+	p.TCPSeq = binary.BigEndian.Uint32(p.l4Field(tcpSeqPos, 4))
+	p.tcpDataOffset = (p.Buffer[p.l4Offset(tcpDataOffsetPos)] & tcpDataOffsetMask) >> 4
+	p.TCPFlags = p.Buffer[p.l4Offset(tcpFlagsOffsetPos)]
+
+	return &p, nil
+}
+
+// parseIPv4Header processes the IPv4 header of the packet
+func (p *Packet) parseIPv4Header() error {
+
+	if len(p.Buffer) < minIPHdrSize {
+		return fmt.Errorf("IP Packet too small (len=%d)", len(p.Buffer))
+	}
+
 	// IP Header Processing
-	p.ipHeaderLen = bytes[ipHdrLenPos] & ipHdrLenMask
-	p.IPProto = bytes[ipProtoPos]
-	p.IPTotalLength = binary.BigEndian.Uint16(bytes[ipLengthPos : ipLengthPos+2])
-	p.ipID = binary.BigEndian.Uint16(bytes[IPIDPos : IPIDPos+2])
-	p.ipChecksum = binary.BigEndian.Uint16(bytes[ipChecksumPos : ipChecksumPos+2])
-	p.SourceAddress = net.IP(bytes[ipSourceAddrPos : ipSourceAddrPos+4])
-	p.DestinationAddress = net.IP(bytes[ipDestAddrPos : ipDestAddrPos+4])
+	p.ipHeaderLen = p.Buffer[ipHdrLenPos] & ipHdrLenMask
+	p.IPProto = p.Buffer[ipProtoPos]
+	p.IPTotalLength = binary.BigEndian.Uint16(p.Buffer[ipLengthPos : ipLengthPos+2])
+	p.ipID = binary.BigEndian.Uint16(p.Buffer[IPIDPos : IPIDPos+2])
+	p.ipChecksum = binary.BigEndian.Uint16(p.Buffer[ipChecksumPos : ipChecksumPos+2])
+	p.SourceAddress = net.IP(p.Buffer[ipSourceAddrPos : ipSourceAddrPos+4])
+	p.DestinationAddress = net.IP(p.Buffer[ipDestAddrPos : ipDestAddrPos+4])
 
 	// Some sanity checking...
-	if p.IPTotalLength < minIPPacketLen {
-		return nil, fmt.Errorf("IP Packet too small (hdrlen=%d)", p.ipHeaderLen)
+	minLength := uint16(minIPPacketLen)
+	if p.IPProto == IPProtocolUDP {
+		minLength = minUDPPacketLen
+	}
+
+	if p.IPTotalLength < minLength {
+		return fmt.Errorf("IP Packet too small (hdrlen=%d)", p.ipHeaderLen)
 	}
 
 	if p.ipHeaderLen != minIPHdrWords {
-		return nil, fmt.Errorf("Packets with IP options not supported (hdrlen=%d)", p.ipHeaderLen)
+		return fmt.Errorf("Packets with IP options not supported (hdrlen=%d)", p.ipHeaderLen)
+	}
+
+	if err := p.truncateToIPLength(); err != nil {
+		return err
 	}
 
+	p.l4BeginPos = minIPHdrSize
+
+	return nil
+}
+
+// parseIPv6Header processes the IPv6 header of the packet. Extension
+// headers are not supported.
+func (p *Packet) parseIPv6Header() error {
+
+	if len(p.Buffer) < ipv6HdrSize {
+		return fmt.Errorf("IPv6 Packet too small (len=%d)", len(p.Buffer))
+	}
+
+	p.ipv6 = true
+	p.ipHeaderLen = ipv6HdrSize / 4
+	p.IPProto = p.Buffer[ipv6NextHeaderPos]
+	p.IPTotalLength = binary.BigEndian.Uint16(p.Buffer[ipv6PayloadLengthPos:ipv6PayloadLengthPos+2]) + ipv6HdrSize
+	p.SourceAddress = net.IP(p.Buffer[ipv6SourceAddrPos : ipv6SourceAddrPos+16])
+	p.DestinationAddress = net.IP(p.Buffer[ipv6DestAddrPos : ipv6DestAddrPos+16])
+
+	if p.IPProto != IPProtocolTCP && p.IPProto != IPProtocolUDP {
+		return fmt.Errorf("IPv6 extension headers not supported (nextheader=%d)", p.IPProto)
+	}
+
+	// Some sanity checking...
+	minLength := uint16(minIPPacketLen - minIPHdrSize + ipv6HdrSize)
+	if p.IPProto == IPProtocolUDP {
+		minLength = minUDPPacketLen - minIPHdrSize + ipv6HdrSize
+	}
+
+	if p.IPTotalLength < minLength {
+		return fmt.Errorf("IPv6 Packet too small (len=%d)", p.IPTotalLength)
+	}
+
+	if err := p.truncateToIPLength(); err != nil {
+		return err
+	}
+
+	p.l4BeginPos = ipv6HdrSize
+
+	return nil
+}
+
+// truncateToIPLength drops any bytes beyond the stated IP length
+func (p *Packet) truncateToIPLength() error {
+
 	if p.IPTotalLength != uint16(len(p.Buffer)) {
 		if p.IPTotalLength < uint16(len(p.Buffer)) {
 			p.Buffer = p.Buffer[:p.IPTotalLength]
 		} else {
-			return nil, fmt.Errorf("Stated IP packet length (%d) differs from bytes available (%d)", p.IPTotalLength, len(p.Buffer))
+			return fmt.Errorf("Stated IP packet length (%d) differs from bytes available (%d)", p.IPTotalLength, len(p.Buffer))
 		}
 	}
 
-	// TCP Header Processing
-	p.l4BeginPos = minIPHdrSize
-	p.TCPChecksum = binary.BigEndian.Uint16(bytes[TCPChecksumPos : TCPChecksumPos+2])
-	p.SourcePort = binary.BigEndian.Uint16(bytes[tcpSourcePortPos : tcpSourcePortPos+2])
-	p.DestinationPort = binary.BigEndian.Uint16(bytes[tcpDestPortPos : tcpDestPortPos+2])
-	p.TCPAck = binary.BigEndian.Uint32(bytes[tcpAckPos : tcpAckPos+4])
-	p.TCPSeq = binary.BigEndian.Uint32(bytes[tcpSeqPos : tcpSeqPos+4])
-	p.tcpDataOffset = (bytes[tcpDataOffsetPos] & tcpDataOffsetMask) >> 4
-	p.TCPFlags = bytes[tcpFlagsOffsetPos]
+	return nil
+}
 
-	p.context = context
+// l4Offset returns the position in the buffer of a TCP/UDP header field.
+// Field positions are defined for an IPv4 header without options.
+func (p *Packet) l4Offset(pos uint16) uint16 {
+	return p.l4BeginPos + pos - minIPHdrSize
+}
 
-	return &p, nil
+// l4Field returns the bytes of a TCP/UDP header field
+func (p *Packet) l4Field(pos uint16, size uint16) []byte {
+	start := p.l4Offset(pos)
+	return p.Buffer[start : start+size]
+}
+
+// IsIPv6 returns true if this is an IPv6 packet
+func (p *Packet) IsIPv6() bool {
+	return p.ipv6
 }
 
 // GetTCPData returns any additional data in the packet
@@ -172,10 +275,17 @@ func (p *Packet) Print(context uint64) {
 		}
 
 		expAck := p.TCPSeq + uint32(p.IPTotalLength-p.TCPDataStartBytes()) + uint32(offset)
-		ccsum := p.computeTCPChecksum()
+		csum := p.TCPChecksum
+		var ccsum uint16
+		if p.IPProto == IPProtocolUDP {
+			csum = p.UDPChecksum
+			ccsum = p.computeUDPChecksum()
+		} else {
+			ccsum = p.computeTCPChecksum()
+		}
 		csumValidationStr := ""
 
-		if p.TCPChecksum != ccsum {
+		if csum != ccsum {
 			csumValidationStr = "Bad Checksum"
 		}
 
@@ -183,17 +293,20 @@ func (p *Packet) Print(context uint64) {
 			p.ipID,
 			flagsToDir(p.context|context),
 			flagsToStr(p.context|context),
-			p.SourceAddress.To4().String(), p.SourcePort,
-			p.DestinationAddress.To4().String(), p.DestinationPort,
+			p.SourceAddress.String(), p.SourcePort,
+			p.DestinationAddress.String(), p.DestinationPort,
 			tcpFlagsToStr(p.TCPFlags),
 			p.TCPSeq, p.TCPAck, p.IPTotalLength-p.TCPDataStartBytes(),
 			expAck, expAck, p.tcpDataOffset,
-			p.TCPChecksum, ccsum, csumValidationStr)
+			csum, ccsum, csumValidationStr)
 		print = true
 	}
 
 	if detailed {
 		pktBytes := []byte{0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 2, 8, 0}
+		if p.ipv6 {
+			pktBytes[12], pktBytes[13] = 0x86, 0xdd
+		}
 		pktBytes = append(pktBytes, p.Buffer...)
 		pktBytes = append(pktBytes, p.tcpOptions...)
 		pktBytes = append(pktBytes, p.tcpData...)
@@ -206,7 +319,7 @@ func (p *Packet) Print(context uint64) {
 	}
 }
 
-//GetBytes returns the bytes in the packet. It consolidates in case of changes as well
+// GetBytes returns the bytes in the packet. It consolidates in case of changes as well
 func (p *Packet) GetBytes() []byte {
 
 	pktBytes := []byte{}
@@ -252,11 +365,18 @@ func (p *Packet) CheckTCPAuthenticationOption(iOptionLength int) (err error) {
 // FixupIPHdrOnDataModify modifies the IP header fields and checksum
 func (p *Packet) FixupIPHdrOnDataModify(old, new uint16) {
 
+	// Update IP Total Length.
+	p.IPTotalLength = p.IPTotalLength + new - old
+
+	// IPv6 has no header checksum and carries the payload length only
+	if p.ipv6 {
+		binary.BigEndian.PutUint16(p.Buffer[ipv6PayloadLengthPos:ipv6PayloadLengthPos+2], p.IPTotalLength-ipv6HdrSize)
+		return
+	}
+
 	// IP Header Processing
 	// IP chekcsum fixup.
 	p.ipChecksum = incCsum16(p.ipChecksum, old, new)
-	// Update IP Total Length.
-	p.IPTotalLength = p.IPTotalLength + new - old
 
 	binary.BigEndian.PutUint16(p.Buffer[ipLengthPos:ipLengthPos+2], p.IPTotalLength)
 	binary.BigEndian.PutUint16(p.Buffer[ipChecksumPos:ipChecksumPos+2], p.ipChecksum)
@@ -266,28 +386,63 @@ func (p *Packet) FixupIPHdrOnDataModify(old, new uint16) {
 func (p *Packet) IncreaseTCPSeq(incr uint32) {
 
 	p.TCPSeq = p.TCPSeq + incr
-	binary.BigEndian.PutUint32(p.Buffer[tcpSeqPos:tcpSeqPos+4], p.TCPSeq)
+	binary.BigEndian.PutUint32(p.l4Field(tcpSeqPos, 4), p.TCPSeq)
 }
 
 // DecreaseTCPSeq decreases TCP seq number by decr
 func (p *Packet) DecreaseTCPSeq(decr uint32) {
 
 	p.TCPSeq = p.TCPSeq - decr
-	binary.BigEndian.PutUint32(p.Buffer[tcpSeqPos:tcpSeqPos+4], p.TCPSeq)
+	binary.BigEndian.PutUint32(p.l4Field(tcpSeqPos, 4), p.TCPSeq)
 }
 
 // IncreaseTCPAck increases TCP ack number by incr
 func (p *Packet) IncreaseTCPAck(incr uint32) {
 
 	p.TCPAck = p.TCPAck + incr
-	binary.BigEndian.PutUint32(p.Buffer[tcpAckPos:tcpAckPos+4], p.TCPAck)
+	binary.BigEndian.PutUint32(p.l4Field(tcpAckPos, 4), p.TCPAck)
 }
 
 // DecreaseTCPAck decreases TCP ack number by decr
 func (p *Packet) DecreaseTCPAck(decr uint32) {
 
 	p.TCPAck = p.TCPAck - decr
-	binary.BigEndian.PutUint32(p.Buffer[tcpAckPos:tcpAckPos+4], p.TCPAck)
+	binary.BigEndian.PutUint32(p.l4Field(tcpAckPos, 4), p.TCPAck)
+}
+
+// DecreaseTCPMss decreases the MSS option of a Syn or SynAck packet by decr.
+// Packets without the MSS option are left untouched. The TCP checksum must be
+// updated by the caller.
+func (p *Packet) DecreaseTCPMss(decr uint16) {
+
+	end := int(p.TCPDataStartBytes())
+	if len(p.Buffer) < end {
+		return
+	}
+
+	for i := int(p.l4BeginPos) + tcpHeaderMinLen; i < end; {
+		switch p.Buffer[i] {
+		case tcpEndOfOptions:
+			return
+		case tcpNoOperation:
+			i++
+			continue
+		}
+
+		if i+1 >= end || p.Buffer[i+1] < 2 {
+			return
+		}
+
+		if p.Buffer[i] == TCPMssOption && p.Buffer[i+1] == TCPMssOptionLen && i+int(TCPMssOptionLen) <= end {
+			mss := binary.BigEndian.Uint16(p.Buffer[i+2:])
+			if mss > decr {
+				binary.BigEndian.PutUint16(p.Buffer[i+2:], mss-decr)
+			}
+			return
+		}
+
+		i += int(p.Buffer[i+1])
+	}
 }
 
 // FixupTCPHdrOnTCPDataDetach modifies the TCP header fields and checksum
@@ -295,7 +450,7 @@ func (p *Packet) FixupTCPHdrOnTCPDataDetach(dataLength uint16, optionLength uint
 
 	// Update DataOffset
 	p.tcpDataOffset = p.tcpDataOffset - uint8(optionLength/4)
-	p.Buffer[tcpDataOffsetPos] = p.tcpDataOffset << 4
+	p.Buffer[p.l4Offset(tcpDataOffsetPos)] = p.tcpDataOffset << 4
 }
 
 // tcpDataDetach splits the p.Buffer into p.Buffer (header + some options), p.tcpOptions (optionLength) and p.TCPData (dataLength)
@@ -348,6 +503,23 @@ func (p *Packet) TCPDataDetach(optionLength uint16) (err error) {
 	return
 }
 
+// ConvertToReset turns the packet into a TCP reset. The payload is removed
+// and the sequence numbers are kept so that the receiver accepts the reset.
+// The TCP checksum must be updated by the caller.
+func (p *Packet) ConvertToReset() (err error) {
+
+	if err = p.TCPDataDetach(0); err != nil {
+		return err
+	}
+
+	p.DropDetachedDataBytes()
+
+	p.TCPFlags = TCPRstMask | TCPAckMask
+	p.Buffer[p.l4Offset(tcpFlagsOffsetPos)] = p.TCPFlags
+
+	return nil
+}
+
 // FixupTCPHdrOnTCPDataAttach modifies the TCP header fields and checksum
 func (p *Packet) FixupTCPHdrOnTCPDataAttach(tcpOptions []byte, tcpData []byte) {
 
@@ -355,8 +527,8 @@ func (p *Packet) FixupTCPHdrOnTCPDataAttach(tcpOptions []byte, tcpData []byte) {
 
 	// Modify the fields
 	p.tcpDataOffset = p.tcpDataOffset + uint8(numberOfOptions)
-	binary.BigEndian.PutUint16(p.Buffer[TCPChecksumPos:TCPChecksumPos+2], p.TCPChecksum)
-	p.Buffer[tcpDataOffsetPos] = p.tcpDataOffset << 4
+	binary.BigEndian.PutUint16(p.l4Field(TCPChecksumPos, 2), p.TCPChecksum)
+	p.Buffer[p.l4Offset(tcpDataOffsetPos)] = p.tcpDataOffset << 4
 }
 
 // tcpDataAttach splits the p.Buffer into p.Buffer (header + some options), p.tcpOptions (optionLength) and p.TCPData (dataLength)
@@ -366,6 +538,10 @@ func (p *Packet) tcpDataAttach(options []byte, data []byte) (err error) {
 		return fmt.Errorf("Cannot insert options with existing data: optionLength=%d, IPTotalLength=%d", len(options), p.IPTotalLength)
 	}
 
+	if int(p.tcpDataOffset)+len(options)/4 > maxTCPDataOffset {
+		return fmt.Errorf("Cannot insert options beyond the maximum header length: optionLength=%d, dataOffset=%d", len(options), p.tcpDataOffset)
+	}
+
 	p.tcpOptions = append(p.tcpOptions, options...)
 	p.tcpData = data
 
@@ -392,6 +568,77 @@ func (p *Packet) TCPDataAttach(tcpOptions []byte, tcpData []byte) (err error) {
 	return
 }
 
+// ReadUDPData returns the payload of a UDP packet.
+// It does not remove the payload from the packet
+func (p *Packet) ReadUDPData() []byte {
+
+	if uint16(len(p.Buffer)) >= p.IPTotalLength {
+		return p.Buffer[p.l4Offset(udpDataPos):p.IPTotalLength]
+	}
+
+	return []byte{}
+}
+
+// fixupUDPHdrOnDataModify updates the UDP length and checksum after the payload changed
+func (p *Packet) fixupUDPHdrOnDataModify() {
+
+	p.UDPLength = p.IPTotalLength - p.l4BeginPos
+	binary.BigEndian.PutUint16(p.l4Field(udpLengthPos, 2), p.UDPLength)
+
+	p.UpdateUDPChecksum()
+}
+
+// UDPDataAttach appends data at the end of the UDP payload and updates
+// the IP and UDP headers (lengths, checksums). Unlike TCP, the data is
+// attached directly to the Buffer.
+func (p *Packet) UDPDataAttach(data []byte) (err error) {
+
+	if p.IPProto != IPProtocolUDP {
+		return fmt.Errorf("UDP Data Attach failed: not a UDP packet")
+	}
+
+	if int(p.IPTotalLength)+len(data) > 0xFFFF {
+		return fmt.Errorf("UDP Data Attach failed: packet too large: dataLength=%d, IPTotalLength=%d", len(data), p.IPTotalLength)
+	}
+
+	// Always copy so that we never write beyond the original buffer
+	buffer := make([]byte, int(p.IPTotalLength)+len(data))
+	copy(buffer, p.Buffer[:p.IPTotalLength])
+	copy(buffer[p.IPTotalLength:], data)
+	p.Buffer = buffer
+
+	// IP Header Processing
+	p.FixupIPHdrOnDataModify(p.IPTotalLength, p.IPTotalLength+uint16(len(data)))
+
+	// UDP Header Processing
+	p.fixupUDPHdrOnDataModify()
+
+	return nil
+}
+
+// UDPDataDetach removes dataLength bytes from the end of the UDP payload and
+// updates the IP and UDP headers (lengths, checksums)
+func (p *Packet) UDPDataDetach(dataLength uint16) (err error) {
+
+	if p.IPProto != IPProtocolUDP {
+		return fmt.Errorf("UDP Data Detach failed: not a UDP packet")
+	}
+
+	if dataLength > uint16(len(p.ReadUDPData())) {
+		return fmt.Errorf("UDP Data Detach failed: dataLength=%d exceeds payload length=%d", dataLength, len(p.ReadUDPData()))
+	}
+
+	p.Buffer = p.Buffer[:p.IPTotalLength-dataLength]
+
+	// IP Header Processing
+	p.FixupIPHdrOnDataModify(p.IPTotalLength, p.IPTotalLength-dataLength)
+
+	// UDP Header Processing
+	p.fixupUDPHdrOnDataModify()
+
+	return nil
+}
+
 // L4FlowHash calculate a hash string based on the 4-tuple
 func (p *Packet) L4FlowHash() string {
 	return p.SourceAddress.String() + ":" + p.DestinationAddress.String() + ":" + strconv.Itoa(int(p.SourcePort)) + ":" + strconv.Itoa(int(p.DestinationPort))
@@ -415,12 +662,12 @@ func (p *Packet) ID() string {
 	return strconv.Itoa(int(p.ipID))
 }
 
-//TCPOptionLength returns the length of tcpoptions
+// TCPOptionLength returns the length of tcpoptions
 func (p *Packet) TCPOptionLength() int {
 	return len(p.tcpOptions)
 }
 
-//TCPDataLength -- returns the length of tcp options
+// TCPDataLength -- returns the length of tcp options
 func (p *Packet) TCPDataLength() int {
 	return len(p.tcpData)
 }
@@ -375,3 +375,289 @@ func getTestPacketWithError(t *testing.T, id SamplePacketName) error {
 	_, err := New(0, tmp, "0")
 	return err
 }
+
+// UDP datagram 10.1.1.1:1234 -> 10.1.1.2:53 with payload "abcd"
+var udpTestPacket = []byte{0x45, 0x00, 0x00, 0x20, 0x00, 0x01, 0x00, 0x00, 0x40, 0x11,
+	0x00, 0x00, 0x0a, 0x01, 0x01, 0x01, 0x0a, 0x01, 0x01, 0x02, 0x04, 0xd2, 0x00, 0x35,
+	0x00, 0x0c, 0x00, 0x00, 0x61, 0x62, 0x63, 0x64}
+
+func getUDPTestPacket(t *testing.T) *Packet {
+
+	buf := make([]byte, len(udpTestPacket))
+	copy(buf, udpTestPacket)
+
+	pkt, err := New(0, buf, "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkt.UpdateIPChecksum()
+	pkt.UpdateUDPChecksum()
+
+	return pkt
+}
+
+func TestUDPPacket(t *testing.T) {
+
+	t.Parallel()
+	pkt := getUDPTestPacket(t)
+
+	if pkt.IPProto != IPProtocolUDP {
+		t.Error("Unexpected protocol")
+	}
+
+	if pkt.SourcePort != 1234 || pkt.DestinationPort != 53 {
+		t.Error("Unexpected ports")
+	}
+
+	if pkt.UDPLength != 12 {
+		t.Errorf("Unexpected UDP length %d", pkt.UDPLength)
+	}
+
+	if string(pkt.ReadUDPData()) != "abcd" {
+		t.Errorf("Unexpected UDP payload %s", string(pkt.ReadUDPData()))
+	}
+
+	if !pkt.VerifyIPChecksum() || !pkt.VerifyUDPChecksum() {
+		t.Error("Checksum failed")
+	}
+}
+
+func TestUDPBadLength(t *testing.T) {
+
+	t.Parallel()
+	buf := make([]byte, len(udpTestPacket))
+	copy(buf, udpTestPacket)
+	buf[25] = 0x10
+
+	if _, err := New(0, buf, "0"); err == nil {
+		t.Error("Expected failure with wrong UDP length")
+	}
+}
+
+func TestUDPDataAttachDetach(t *testing.T) {
+
+	t.Parallel()
+	pkt := getUDPTestPacket(t)
+
+	if err := pkt.UDPDataAttach([]byte("token")); err != nil {
+		t.Fatal(err)
+	}
+
+	if string(pkt.ReadUDPData()) != "abcdtoken" {
+		t.Errorf("Unexpected UDP payload after attach %s", string(pkt.ReadUDPData()))
+	}
+
+	if pkt.IPTotalLength != 37 || pkt.UDPLength != 17 {
+		t.Errorf("Unexpected lengths after attach %d %d", pkt.IPTotalLength, pkt.UDPLength)
+	}
+
+	if !pkt.VerifyIPChecksum() || !pkt.VerifyUDPChecksum() {
+		t.Error("Checksum failed after attach")
+	}
+
+	if err := pkt.UDPDataDetach(5); err != nil {
+		t.Fatal(err)
+	}
+
+	if string(pkt.GetBytes()) != string(getUDPTestPacket(t).GetBytes()) {
+		t.Error("Packet differs after detach")
+	}
+
+	if err := pkt.UDPDataDetach(5); err == nil {
+		t.Error("Expected failure when detaching more than the payload")
+	}
+}
+
+var ipv6UDPTestPacket = []byte{0x60, 0x00, 0x00, 0x00, 0x00, 0x0c, 0x11, 0x40, 0x20, 0x01,
+	0x0d, 0xb8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x01, 0x20, 0x01, 0x0d, 0xb8, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02,
+	0x0d, 0x80, 0x00, 0x35, 0x00, 0x0c, 0xd1, 0xe5, 0x61, 0x62,
+	0x63, 0x64}
+
+var ipv6TCPSynTestPacket = []byte{0x60, 0x00, 0x00, 0x00, 0x00, 0x14, 0x06, 0x40, 0x20, 0x01,
+	0x0d, 0xb8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x01, 0x20, 0x01, 0x0d, 0xb8, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02,
+	0x07, 0xd0, 0x00, 0x50, 0x00, 0x00, 0x10, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x50, 0x02, 0xff, 0xff, 0x3c, 0x4e, 0x00, 0x00}
+
+func getIPv6TestPacket(t *testing.T, bytes []byte) *Packet {
+
+	buf := make([]byte, len(bytes))
+	copy(buf, bytes)
+
+	pkt, err := New(0, buf, "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pkt
+}
+
+func TestIPv6TCPPacket(t *testing.T) {
+
+	t.Parallel()
+	pkt := getIPv6TestPacket(t, ipv6TCPSynTestPacket)
+
+	if !pkt.IsIPv6() || pkt.IPProto != IPProtocolTCP || pkt.IPTotalLength != 60 {
+		t.Errorf("Unexpected IPv6 header fields %v %d %d", pkt.IsIPv6(), pkt.IPProto, pkt.IPTotalLength)
+	}
+
+	if pkt.SourceAddress.String() != "2001:db8::1" || pkt.DestinationAddress.String() != "2001:db8::2" {
+		t.Errorf("Unexpected addresses %s %s", pkt.SourceAddress, pkt.DestinationAddress)
+	}
+
+	if pkt.SourcePort != 2000 || pkt.DestinationPort != 80 || pkt.TCPSeq != 0x1000 || pkt.TCPFlags != TCPSynMask {
+		t.Errorf("Unexpected TCP header fields %d %d %d %d", pkt.SourcePort, pkt.DestinationPort, pkt.TCPSeq, pkt.TCPFlags)
+	}
+
+	if !pkt.VerifyIPChecksum() || !pkt.VerifyTCPChecksum() {
+		t.Error("Checksum failed")
+	}
+
+	options := []byte{TCPAuthenticationOption, 4, 0, 0}
+	if err := pkt.TCPDataAttach(options, []byte("token")); err != nil {
+		t.Fatal(err)
+	}
+	pkt.UpdateTCPChecksum()
+
+	attached, err := New(0, pkt.GetBytes(), "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if attached.IPTotalLength != 69 || string(attached.ReadTCPData()) != "token" {
+		t.Errorf("Unexpected packet after attach %d %s", attached.IPTotalLength, string(attached.ReadTCPData()))
+	}
+
+	if !attached.VerifyTCPChecksum() {
+		t.Error("Checksum failed after attach")
+	}
+}
+
+func TestIPv6UDPPacket(t *testing.T) {
+
+	t.Parallel()
+	pkt := getIPv6TestPacket(t, ipv6UDPTestPacket)
+
+	if pkt.SourcePort != 3456 || pkt.DestinationPort != 53 || pkt.UDPLength != 12 {
+		t.Errorf("Unexpected UDP header fields %d %d %d", pkt.SourcePort, pkt.DestinationPort, pkt.UDPLength)
+	}
+
+	if !pkt.VerifyUDPChecksum() {
+		t.Error("Checksum failed")
+	}
+
+	if err := pkt.UDPDataAttach([]byte("token")); err != nil {
+		t.Fatal(err)
+	}
+
+	if string(pkt.ReadUDPData()) != "abcdtoken" || pkt.UDPLength != 17 || pkt.IPTotalLength != 57 {
+		t.Errorf("Unexpected packet after attach %s %d %d", string(pkt.ReadUDPData()), pkt.UDPLength, pkt.IPTotalLength)
+	}
+
+	if !pkt.VerifyUDPChecksum() {
+		t.Error("Checksum failed after attach")
+	}
+
+	reparsed, err := New(0, pkt.GetBytes(), "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reparsed.UDPLength != 17 {
+		t.Errorf("Unexpected UDP length after reparse %d", reparsed.UDPLength)
+	}
+}
+
+func TestIPv6ExtensionHeader(t *testing.T) {
+
+	t.Parallel()
+	buf := make([]byte, len(ipv6UDPTestPacket))
+	copy(buf, ipv6UDPTestPacket)
+
+	// Hop-by-hop options header
+	buf[6] = 0
+	if _, err := New(0, buf, "0"); err == nil {
+		t.Error("Expected failure for IPv6 extension headers")
+	}
+}
+
+func TestConvertToReset(t *testing.T) {
+
+	t.Parallel()
+	pkt := getTestPacket(t, synGoodTCPChecksum)
+	length := pkt.IPTotalLength
+
+	if err := pkt.TCPDataAttach([]byte{}, []byte("payload")); err != nil {
+		t.Fatal(err)
+	}
+	pkt.UpdateTCPChecksum()
+
+	data, err := New(0, pkt.GetBytes(), "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seq := data.TCPSeq
+
+	if err = data.ConvertToReset(); err != nil {
+		t.Fatal(err)
+	}
+	data.UpdateTCPChecksum()
+
+	reset, err := New(0, data.GetBytes(), "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reset.TCPFlags != TCPRstMask|TCPAckMask || reset.TCPSeq != seq {
+		t.Errorf("Unexpected TCP header fields %d %d", reset.TCPFlags, reset.TCPSeq)
+	}
+
+	if reset.IPTotalLength != length || len(reset.ReadTCPData()) != 0 {
+		t.Errorf("Unexpected payload after reset %d %d", reset.IPTotalLength, len(reset.ReadTCPData()))
+	}
+
+	if !reset.VerifyIPChecksum() || !reset.VerifyTCPChecksum() {
+		t.Error("Checksum failed after reset")
+	}
+}
+
+func TestDecreaseTCPMss(t *testing.T) {
+
+	t.Parallel()
+	pkt := getTestPacket(t, synGoodTCPChecksum)
+
+	pkt.DecreaseTCPMss(20)
+	pkt.UpdateTCPChecksum()
+
+	syn, err := New(0, pkt.GetBytes(), "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mss := syn.Buffer[42:44]; mss[0] != 0xff || mss[1] != 0xc3 {
+		t.Errorf("Unexpected MSS %v", mss)
+	}
+
+	if !syn.VerifyTCPChecksum() {
+		t.Error("Checksum failed after decreasing the MSS")
+	}
+}
+
+func TestTCPDataAttachHeaderTooLong(t *testing.T) {
+
+	t.Parallel()
+	pkt := getTestPacket(t, synGoodTCPChecksum)
+
+	// The header already has 20 bytes of options
+	if err := pkt.TCPDataAttach(make([]byte, 24), []byte{}); err == nil {
+		t.Error("Options beyond the maximum header length should be rejected")
+	}
+
+	if err := pkt.TCPDataAttach(make([]byte, 20), []byte{}); err != nil {
+		t.Error(err)
+	}
+}
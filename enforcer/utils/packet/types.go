@@ -149,6 +149,7 @@ type Packet struct {
 	tcpData    []byte
 
 	// IP Header fields
+	ipv6               bool
 	ipHeaderLen        uint8
 	IPProto            uint8
 	IPTotalLength      uint16
@@ -170,6 +171,10 @@ type Packet struct {
 	TCPFlags      uint8
 	TCPChecksum   uint16
 
+	// UDP Specific fields
+	UDPLength   uint16
+	UDPChecksum uint16
+
 	// Service Metadata
 	SvcMetadata interface{}
 	// Connection Metadata
@@ -0,0 +1,421 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+const (
+	// pcapng block types
+	blockSectionHeader       = 0x0A0D0D0A
+	blockInterfaceDescriptor = 0x00000001
+	blockSimplePacket        = 0x00000003
+	blockEnhancedPacket      = 0x00000006
+
+	// pcapng options
+	optionEndOfOptions = 0
+	optionComment      = 1
+	optionTsResolution = 9
+
+	byteOrderMagic = 0x1A2B3C4D
+
+	// maxBlockLength protects the reader from corrupted files
+	maxBlockLength = 16 * 1024 * 1024
+
+	ethernetHeaderLength = 14
+)
+
+// Record is a packet of a capture. The data starts with the IP header.
+type Record struct {
+	Timestamp time.Time
+	Data      []byte
+	Comment   string
+}
+
+// Writer writes IP packets and their comments in the pcapng format
+type Writer struct {
+	w io.Writer
+}
+
+// NewWriter writes the section header and the description of a raw IP
+// interface and returns a Writer for the packets
+func NewWriter(w io.Writer) (*Writer, error) {
+
+	section := make([]byte, 16)
+	binary.LittleEndian.PutUint32(section[0:], byteOrderMagic)
+	binary.LittleEndian.PutUint16(section[4:], 1)
+	binary.LittleEndian.PutUint16(section[6:], 0)
+	binary.LittleEndian.PutUint64(section[8:], math.MaxUint64)
+
+	if err := writeBlock(w, blockSectionHeader, section, nil); err != nil {
+		return nil, err
+	}
+
+	intf := make([]byte, 8)
+	binary.LittleEndian.PutUint16(intf[0:], uint16(layers.LinkTypeRaw))
+	binary.LittleEndian.PutUint32(intf[4:], 0)
+
+	// Nanosecond timestamps
+	resolution := option(optionTsResolution, []byte{9})
+	if err := writeBlock(w, blockInterfaceDescriptor, intf, resolution); err != nil {
+		return nil, err
+	}
+
+	return &Writer{w: w}, nil
+}
+
+// WritePacket writes an IP packet. The comment is omitted if it is empty.
+func (w *Writer) WritePacket(timestamp time.Time, data []byte, comment string) error {
+
+	ts := uint64(timestamp.UnixNano())
+
+	header := make([]byte, 20, 20+len(data)+3)
+	binary.LittleEndian.PutUint32(header[0:], 0)
+	binary.LittleEndian.PutUint32(header[4:], uint32(ts>>32))
+	binary.LittleEndian.PutUint32(header[8:], uint32(ts))
+	binary.LittleEndian.PutUint32(header[12:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(header[16:], uint32(len(data)))
+	body := pad(append(header, data...))
+
+	var options []byte
+	if comment != "" {
+		options = option(optionComment, []byte(comment))
+	}
+
+	return writeBlock(w.w, blockEnhancedPacket, body, options)
+}
+
+// pad appends zeroes to align the data on 32 bits
+func pad(data []byte) []byte {
+
+	for len(data)%4 != 0 {
+		data = append(data, 0)
+	}
+
+	return data
+}
+
+// option encodes an option of a block
+func option(code uint16, value []byte) []byte {
+
+	header := make([]byte, 4)
+	binary.LittleEndian.PutUint16(header[0:], code)
+	binary.LittleEndian.PutUint16(header[2:], uint16(len(value)))
+
+	return pad(append(header, value...))
+}
+
+// writeBlock writes a block with its options
+func writeBlock(w io.Writer, blockType uint32, body []byte, options []byte) error {
+
+	if len(options) > 0 {
+		options = append(options, option(optionEndOfOptions, nil)...)
+	}
+
+	length := 12 + len(body) + len(options)
+
+	block := make([]byte, 8, length)
+	binary.LittleEndian.PutUint32(block[0:], blockType)
+	binary.LittleEndian.PutUint32(block[4:], uint32(length))
+	block = append(block, body...)
+	block = append(block, options...)
+	block = append(block, block[4:8]...)
+
+	_, err := w.Write(block)
+	return err
+}
+
+// ReadAll reads the packets of a capture in the pcapng or in the pcap format.
+// The packets of Ethernet captures are returned without the Ethernet header.
+func ReadAll(r io.Reader) ([]*Record, error) {
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, fmt.Errorf("Unable to read capture: %s", err)
+	}
+
+	r = io.MultiReader(bytes.NewReader(magic), r)
+
+	if binary.LittleEndian.Uint32(magic) == blockSectionHeader {
+		return readNg(r)
+	}
+
+	return readClassic(r)
+}
+
+// readClassic reads a capture in the pcap format
+func readClassic(r io.Reader) ([]*Record, error) {
+
+	reader, err := pcapgo.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid capture: %s", err)
+	}
+
+	records := []*Record{}
+	for {
+		data, ci, err := reader.ReadPacketData()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Invalid packet in capture: %s", err)
+		}
+
+		ip, err := networkLayer(reader.LinkType(), data)
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, &Record{
+			Timestamp: ci.Timestamp,
+			Data:      ip,
+		})
+	}
+}
+
+// networkLayer returns the IP packet carried by a frame of the link type
+func networkLayer(linkType layers.LinkType, data []byte) ([]byte, error) {
+
+	switch linkType {
+	case layers.LinkTypeRaw, layers.LinkTypeIPv4:
+		return data, nil
+	case layers.LinkTypeEthernet:
+		if len(data) < ethernetHeaderLength {
+			return nil, fmt.Errorf("Truncated Ethernet frame")
+		}
+		return data[ethernetHeaderLength:], nil
+	default:
+		return nil, fmt.Errorf("Unsupported link type %s", linkType)
+	}
+}
+
+// ngInterface holds the link type and the timestamp resolution of an interface
+type ngInterface struct {
+	linkType   layers.LinkType
+	resolution byte
+}
+
+// ngReader reads the blocks of a capture in the pcapng format
+type ngReader struct {
+	r          io.Reader
+	order      binary.ByteOrder
+	interfaces []ngInterface
+}
+
+// readNg reads a capture in the pcapng format
+func readNg(r io.Reader) ([]*Record, error) {
+
+	ng := &ngReader{r: r, order: binary.LittleEndian}
+
+	records := []*Record{}
+	for {
+		blockType, body, err := ng.readBlock()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch blockType {
+		case blockSectionHeader:
+			ng.interfaces = nil
+
+		case blockInterfaceDescriptor:
+			if err := ng.readInterface(body); err != nil {
+				return nil, err
+			}
+
+		case blockEnhancedPacket:
+			record, err := ng.readEnhancedPacket(body)
+			if err != nil {
+				return nil, err
+			}
+			records = append(records, record)
+
+		case blockSimplePacket:
+			record, err := ng.readSimplePacket(body)
+			if err != nil {
+				return nil, err
+			}
+			records = append(records, record)
+		}
+	}
+}
+
+// readBlock returns the type and the body of the next block. The byte order
+// is updated by the section headers.
+func (ng *ngReader) readBlock() (uint32, []byte, error) {
+
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(ng.r, header); err != nil {
+		if err == io.EOF {
+			return 0, nil, err
+		}
+		return 0, nil, fmt.Errorf("Truncated block: %s", err)
+	}
+
+	blockType := ng.order.Uint32(header[0:])
+
+	if blockType == blockSectionHeader {
+		magic := make([]byte, 4)
+		if _, err := io.ReadFull(ng.r, magic); err != nil {
+			return 0, nil, fmt.Errorf("Truncated section header: %s", err)
+		}
+
+		switch uint32(byteOrderMagic) {
+		case binary.LittleEndian.Uint32(magic):
+			ng.order = binary.LittleEndian
+		case binary.BigEndian.Uint32(magic):
+			ng.order = binary.BigEndian
+		default:
+			return 0, nil, fmt.Errorf("Invalid byte order in section header")
+		}
+
+		header = append(header, magic...)
+	}
+
+	length := ng.order.Uint32(header[4:])
+	if length < 12 || length%4 != 0 || length > maxBlockLength || int(length) < len(header)+4 {
+		return 0, nil, fmt.Errorf("Invalid block length %d", length)
+	}
+
+	rest := make([]byte, int(length)-len(header))
+	if _, err := io.ReadFull(ng.r, rest); err != nil {
+		return 0, nil, fmt.Errorf("Truncated block: %s", err)
+	}
+
+	// The body excludes the header and the trailing length
+	body := append(header[8:], rest[:len(rest)-4]...)
+
+	return blockType, body, nil
+}
+
+// options parses the options of a block
+func (ng *ngReader) options(data []byte) map[uint16][]byte {
+
+	options := map[uint16][]byte{}
+
+	for len(data) >= 4 {
+		code := ng.order.Uint16(data[0:])
+		length := int(ng.order.Uint16(data[2:]))
+		if code == optionEndOfOptions || 4+length > len(data) {
+			break
+		}
+
+		options[code] = data[4 : 4+length]
+
+		data = data[4+length:]
+		if length%4 != 0 && len(data) >= 4-length%4 {
+			data = data[4-length%4:]
+		}
+	}
+
+	return options
+}
+
+// readInterface reads an interface description block
+func (ng *ngReader) readInterface(body []byte) error {
+
+	if len(body) < 8 {
+		return fmt.Errorf("Truncated interface description")
+	}
+
+	intf := ngInterface{
+		linkType:   layers.LinkType(ng.order.Uint16(body[0:])),
+		resolution: 6,
+	}
+
+	if resolution, ok := ng.options(body[8:])[optionTsResolution]; ok && len(resolution) == 1 {
+		intf.resolution = resolution[0]
+	}
+
+	ng.interfaces = append(ng.interfaces, intf)
+
+	return nil
+}
+
+// readEnhancedPacket reads an enhanced packet block
+func (ng *ngReader) readEnhancedPacket(body []byte) (*Record, error) {
+
+	if len(body) < 20 {
+		return nil, fmt.Errorf("Truncated packet block")
+	}
+
+	id := int(ng.order.Uint32(body[0:]))
+	if id >= len(ng.interfaces) {
+		return nil, fmt.Errorf("Packet of unknown interface %d", id)
+	}
+	intf := ng.interfaces[id]
+
+	captured := int(ng.order.Uint32(body[12:]))
+	if 20+captured > len(body) {
+		return nil, fmt.Errorf("Truncated packet data")
+	}
+
+	data, err := networkLayer(intf.linkType, body[20:20+captured])
+	if err != nil {
+		return nil, err
+	}
+
+	record := &Record{
+		Timestamp: ng.timestamp(intf, uint64(ng.order.Uint32(body[4:]))<<32|uint64(ng.order.Uint32(body[8:]))),
+		Data:      append([]byte{}, data...),
+	}
+
+	end := 20 + (captured+3)/4*4
+	if end < len(body) {
+		if comment, ok := ng.options(body[end:])[optionComment]; ok {
+			record.Comment = string(comment)
+		}
+	}
+
+	return record, nil
+}
+
+// readSimplePacket reads a simple packet block
+func (ng *ngReader) readSimplePacket(body []byte) (*Record, error) {
+
+	if len(body) < 4 || len(ng.interfaces) == 0 {
+		return nil, fmt.Errorf("Invalid simple packet block")
+	}
+
+	length := int(ng.order.Uint32(body[0:]))
+	if 4+length > len(body) {
+		length = len(body) - 4
+	}
+
+	data, err := networkLayer(ng.interfaces[0].linkType, body[4:4+length])
+	if err != nil {
+		return nil, err
+	}
+
+	return &Record{Data: append([]byte{}, data...)}, nil
+}
+
+// timestamp converts a timestamp in the resolution of the interface. The
+// resolution is a negative power of 10, or of 2 if the high bit is set.
+func (ng *ngReader) timestamp(intf ngInterface, ts uint64) time.Time {
+
+	if intf.resolution&0x80 != 0 {
+		seconds := float64(ts) / math.Pow(2, float64(intf.resolution&0x7f))
+		return time.Unix(0, int64(seconds*1e9))
+	}
+
+	nanoseconds := ts
+	for r := intf.resolution; r < 9; r++ {
+		nanoseconds *= 10
+	}
+	for r := intf.resolution; r > 9; r-- {
+		nanoseconds /= 10
+	}
+
+	return time.Unix(0, int64(nanoseconds))
+}
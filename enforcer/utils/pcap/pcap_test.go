@@ -0,0 +1,90 @@
+package pcap
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var ipPacket = []byte{0x45, 0x00, 0x00, 0x1d, 0x00, 0x00, 0x40, 0x00, 0x40, 0x06, 0x00, 0x00, 0x0a, 0x01, 0x0a, 0x4c, 0xa4, 0x43, 0xe4, 0x98, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09}
+
+func TestWriter(t *testing.T) {
+
+	Convey("Given a pcapng writer", t, func() {
+		var buffer bytes.Buffer
+		w, err := NewWriter(&buffer)
+		So(err, ShouldBeNil)
+
+		now := time.Unix(1500000000, 123456789)
+
+		Convey("When I write packets with and without comments", func() {
+			So(w.WritePacket(now, ipPacket, "application received"), ShouldBeNil)
+			So(w.WritePacket(now.Add(time.Second), ipPacket[:20], ""), ShouldBeNil)
+
+			Convey("The blocks should be aligned on 32 bits", func() {
+				So(buffer.Len()%4, ShouldEqual, 0)
+			})
+
+			Convey("I should read the same packets, timestamps and comments", func() {
+				records, err := ReadAll(&buffer)
+				So(err, ShouldBeNil)
+				So(len(records), ShouldEqual, 2)
+				So(records[0].Data, ShouldResemble, ipPacket)
+				So(records[0].Comment, ShouldEqual, "application received")
+				So(records[0].Timestamp.UnixNano(), ShouldEqual, now.UnixNano())
+				So(records[1].Data, ShouldResemble, ipPacket[:20])
+				So(records[1].Comment, ShouldBeEmpty)
+				So(records[1].Timestamp.UnixNano(), ShouldEqual, now.Add(time.Second).UnixNano())
+			})
+
+			Convey("A truncated capture should be reported", func() {
+				_, err := ReadAll(bytes.NewReader(buffer.Bytes()[:buffer.Len()-10]))
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+func TestReadClassic(t *testing.T) {
+
+	Convey("Given a pcap capture of Ethernet frames", t, func() {
+		var buffer bytes.Buffer
+		w := pcapgo.NewWriter(&buffer)
+		So(w.WriteFileHeader(65536, layers.LinkTypeEthernet), ShouldBeNil)
+
+		frame := append(make([]byte, 14), ipPacket...)
+		ci := gopacket.CaptureInfo{Timestamp: time.Unix(1500000000, 0), CaptureLength: len(frame), Length: len(frame)}
+		So(w.WritePacket(ci, frame), ShouldBeNil)
+
+		Convey("I should read the IP packets", func() {
+			records, err := ReadAll(&buffer)
+			So(err, ShouldBeNil)
+			So(len(records), ShouldEqual, 1)
+			So(records[0].Data, ShouldResemble, ipPacket)
+			So(records[0].Timestamp.Unix(), ShouldEqual, 1500000000)
+		})
+	})
+
+	Convey("Given a capture of an unsupported link type, I should get an error", t, func() {
+		var buffer bytes.Buffer
+		w := pcapgo.NewWriter(&buffer)
+		So(w.WriteFileHeader(65536, layers.LinkTypeIEEE802_11), ShouldBeNil)
+		So(w.WritePacket(gopacket.CaptureInfo{CaptureLength: 4, Length: 4}, []byte{1, 2, 3, 4}), ShouldBeNil)
+
+		_, err := ReadAll(&buffer)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Given data that is not a capture, I should get an error", t, func() {
+		_, err := ReadAll(bytes.NewReader([]byte("not a capture file")))
+		So(err, ShouldNotBeNil)
+
+		_, err = ReadAll(bytes.NewReader([]byte{}))
+		So(err, ShouldNotBeNil)
+	})
+}
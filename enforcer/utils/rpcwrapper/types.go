@@ -70,6 +70,7 @@ type EnforcePayload struct {
 	TransmitterRules policy.TagSelectorList `json:",omitempty"`
 	TriremeNetworks  []string               `json:",omitempty"`
 	ExcludedNetworks []string               `json:",omitempty"`
+	Frozen           bool                   `json:",omitempty"`
 }
 
 //SuperviseRequestPayload for Supervise request
@@ -120,7 +121,8 @@ type UnEnforceResponsePayload struct {
 
 //StatsPayload is the payload carries by the stats reporting form the remote enforcer
 type StatsPayload struct {
-	Flows map[string]*collector.FlowRecord `json:",omitempty"`
+	Flows      map[string]*collector.FlowRecord `json:",omitempty"`
+	Containers []*collector.ContainerRecord     `json:",omitempty"`
 }
 
 //ExcludeIPRequestPayload carries the list of excluded ips
@@ -0,0 +1,34 @@
+package metrics
+
+import "github.com/aporeto-inc/trireme/collector"
+
+// flowCollector counts the flows before handing them to the next collector
+type flowCollector struct {
+	next collector.EventCollector
+}
+
+// NewCollector returns a collector that counts the flow events and forwards
+// all the events to next
+func NewCollector(next collector.EventCollector) collector.EventCollector {
+
+	return &flowCollector{
+		next: next,
+	}
+}
+
+// CollectFlowEvent counts the flow and forwards it. Nil records are dropped.
+func (c *flowCollector) CollectFlowEvent(record *collector.FlowRecord) {
+
+	if record == nil {
+		return
+	}
+
+	FlowReported(record)
+	c.next.CollectFlowEvent(record)
+}
+
+// CollectContainerEvent forwards the container event
+func (c *flowCollector) CollectContainerEvent(record *collector.ContainerRecord) {
+
+	c.next.CollectContainerEvent(record)
+}
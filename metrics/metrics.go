@@ -0,0 +1,313 @@
+// Package metrics exposes the internal state of Trireme in the Prometheus
+// format. The counters are always maintained and are only exported if a
+// metrics Server is started.
+package metrics
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/enforcer/utils/fqconfig"
+)
+
+const (
+	namespace = "trireme"
+
+	// DirectionNetwork identifies the queues of the packets from the network
+	DirectionNetwork = "network"
+	// DirectionApplication identifies the queues of the packets from the application
+	DirectionApplication = "application"
+
+	// OperationEncode is the creation of a token
+	OperationEncode = "encode"
+	// OperationDecode is the validation of a token
+	OperationDecode = "decode"
+)
+
+var (
+	registry = prometheus.NewRegistry()
+
+	flows = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "flows_total",
+			Help:      "Flows reported to the collector per processing unit, action and drop reason.",
+		},
+		[]string{"context_id", "action", "drop_reason"},
+	)
+
+	queuePackets = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "nfqueue_packets_total",
+			Help:      "Packets processed per NFQUEUE and verdict.",
+		},
+		[]string{"direction", "queue", "verdict"},
+	)
+
+	tokenDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "token_duration_seconds",
+			Help:      "Latency of the token operations.",
+			Buckets:   prometheus.ExponentialBuckets(0.00001, 2, 16),
+		},
+		[]string{"operation"},
+	)
+
+	remoteEnforcerLaunches = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "remote_enforcer_launches_total",
+			Help:      "Remote enforcer processes launched.",
+		},
+	)
+
+	remoteEnforcerRestarts = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "remote_enforcer_restarts_total",
+			Help:      "Remote enforcer processes launched again for the same processing unit.",
+		},
+	)
+
+	remoteEnforcerExits = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "remote_enforcer_exits_total",
+			Help:      "Remote enforcer processes that exited per exit status.",
+		},
+		[]string{"status"},
+	)
+
+	// flowLabels keeps the action and drop reason labels used by every
+	// processing unit so that its counters can be deleted
+	flowLabels = struct {
+		contexts map[string]map[[2]string]struct{}
+		sync.Mutex
+	}{
+		contexts: map[string]map[[2]string]struct{}{},
+	}
+
+	// queueCounters caches the packet counters of every queue so that they
+	// are not looked up by their labels for every packet
+	queueCounters = struct {
+		counters map[queueKey]*queueVerdicts
+		sync.RWMutex
+	}{
+		counters: map[queueKey]*queueVerdicts{},
+	}
+
+	caches = &cacheCollector{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "cache_entries"),
+			"Entries in the caches and connection trackers.",
+			[]string{"instance", "cache"},
+			nil,
+		),
+		caches: map[cacheKey]Sizer{},
+	}
+)
+
+func init() {
+	registry.MustRegister(
+		flows,
+		queuePackets,
+		tokenDuration,
+		remoteEnforcerLaunches,
+		remoteEnforcerRestarts,
+		remoteEnforcerExits,
+		caches,
+		prometheus.NewGoCollector(),
+	)
+}
+
+// Sizer is implemented by the caches that can report their number of entries
+type Sizer interface {
+	SizeOf() int
+}
+
+// cacheKey identifies a cache of an enforcer instance
+type cacheKey struct {
+	instance string
+	name     string
+}
+
+// cacheCollector reports the size of the registered caches when scraped
+type cacheCollector struct {
+	desc   *prometheus.Desc
+	caches map[cacheKey]Sizer
+	sync.Mutex
+}
+
+// Describe implements prometheus.Collector
+func (c *cacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect implements prometheus.Collector
+func (c *cacheCollector) Collect(ch chan<- prometheus.Metric) {
+
+	c.Lock()
+	defer c.Unlock()
+
+	for key, cache := range c.caches {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(cache.SizeOf()), key.instance, key.name)
+	}
+}
+
+// RegisterCache exports the size of a cache of an enforcer instance. The
+// instance keeps apart the caches with the same name of the enforcers of a
+// process. A cache registered with the same instance and name replaces the
+// previous one.
+func RegisterCache(instance string, name string, cache Sizer) {
+
+	caches.Lock()
+	defer caches.Unlock()
+
+	caches.caches[cacheKey{instance: instance, name: name}] = cache
+}
+
+// UnregisterCache stops the export of the size of a cache of an enforcer
+// instance
+func UnregisterCache(instance string, name string) {
+
+	caches.Lock()
+	defer caches.Unlock()
+
+	delete(caches.caches, cacheKey{instance: instance, name: name})
+}
+
+// queueKey identifies a queue
+type queueKey struct {
+	direction string
+	queue     uint16
+}
+
+// queueVerdicts are the packet counters of a queue per verdict
+type queueVerdicts struct {
+	accept prometheus.Counter
+	drop   prometheus.Counter
+}
+
+// queueCounter returns the counters of a queue and creates them the first
+// time the queue is seen
+func queueCounter(direction string, queue uint16) *queueVerdicts {
+
+	key := queueKey{direction: direction, queue: queue}
+
+	queueCounters.RLock()
+	counters, ok := queueCounters.counters[key]
+	queueCounters.RUnlock()
+	if ok {
+		return counters
+	}
+
+	queueCounters.Lock()
+	defer queueCounters.Unlock()
+
+	if counters, ok = queueCounters.counters[key]; ok {
+		return counters
+	}
+
+	label := strconv.Itoa(int(queue))
+	counters = &queueVerdicts{
+		accept: queuePackets.WithLabelValues(direction, label, "accept"),
+		drop:   queuePackets.WithLabelValues(direction, label, "drop"),
+	}
+	queueCounters.counters[key] = counters
+
+	return counters
+}
+
+// RegisterFilterQueue creates the packet counters of all the queues of the
+// configuration so that idle queues are exported as well
+func RegisterFilterQueue(fq *fqconfig.FilterQueue) {
+
+	for i := uint16(0); i < fq.GetNumNetworkQueues(); i++ {
+		queueCounter(DirectionNetwork, fq.GetNetworkQueueStart()+i)
+	}
+
+	for i := uint16(0); i < fq.GetNumApplicationQueues(); i++ {
+		queueCounter(DirectionApplication, fq.GetApplicationQueueStart()+i)
+	}
+}
+
+// QueuePacket counts a packet processed from a queue
+func QueuePacket(direction string, queue uint16, accepted bool) {
+
+	counters := queueCounter(direction, queue)
+
+	if accepted {
+		counters.accept.Inc()
+		return
+	}
+
+	counters.drop.Inc()
+}
+
+// FlowReported counts a flow reported to the collector
+func FlowReported(record *collector.FlowRecord) {
+
+	if record == nil {
+		return
+	}
+
+	labels := [2]string{record.Action.ActionString(), record.DropReason}
+
+	// The counter is incremented with the lock held so that ForgetContext
+	// cannot delete it in between and leave a counter it does not know about
+	flowLabels.Lock()
+	defer flowLabels.Unlock()
+
+	if _, ok := flowLabels.contexts[record.ContextID]; !ok {
+		flowLabels.contexts[record.ContextID] = map[[2]string]struct{}{}
+	}
+	flowLabels.contexts[record.ContextID][labels] = struct{}{}
+
+	flows.WithLabelValues(record.ContextID, labels[0], labels[1]).Inc()
+}
+
+// ForgetContext deletes the flow counters of a processing unit that is not
+// enforced any more
+func ForgetContext(contextID string) {
+
+	flowLabels.Lock()
+	defer flowLabels.Unlock()
+
+	for labels := range flowLabels.contexts[contextID] {
+		flows.DeleteLabelValues(contextID, labels[0], labels[1])
+	}
+
+	delete(flowLabels.contexts, contextID)
+}
+
+// TokenOperation records the latency of a token operation started at start
+func TokenOperation(operation string, start time.Time) {
+
+	tokenDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// RemoteEnforcerLaunched counts the launch of a remote enforcer
+func RemoteEnforcerLaunched(restart bool) {
+
+	remoteEnforcerLaunches.Inc()
+	if restart {
+		remoteEnforcerRestarts.Inc()
+	}
+}
+
+// RemoteEnforcerExited counts the exit of a remote enforcer
+func RemoteEnforcerExited(err error) {
+
+	status := "success"
+	if err != nil {
+		status = "failure"
+	}
+
+	remoteEnforcerExits.WithLabelValues(status).Inc()
+}
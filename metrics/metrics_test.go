@@ -0,0 +1,252 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/enforcer/utils/fqconfig"
+	"github.com/aporeto-inc/trireme/enforcer/utils/tokens"
+	"github.com/aporeto-inc/trireme/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// sample returns the value of the metric with the given labels and if it exists
+func sample(name string, labels map[string]string) (float64, bool) {
+
+	families, err := registry.Gather()
+	if err != nil {
+		return 0, false
+	}
+
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+
+		for _, m := range family.GetMetric() {
+			matched := 0
+			for _, l := range m.GetLabel() {
+				if value, ok := labels[l.GetName()]; ok && value == l.GetValue() {
+					matched++
+				}
+			}
+
+			if matched != len(labels) {
+				continue
+			}
+
+			switch {
+			case m.GetCounter() != nil:
+				return m.GetCounter().GetValue(), true
+			case m.GetGauge() != nil:
+				return m.GetGauge().GetValue(), true
+			case m.GetHistogram() != nil:
+				return float64(m.GetHistogram().GetSampleCount()), true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+type recordCollector struct {
+	flows      int
+	containers int
+}
+
+func (c *recordCollector) CollectFlowEvent(record *collector.FlowRecord) { c.flows++ }
+
+func (c *recordCollector) CollectContainerEvent(record *collector.ContainerRecord) { c.containers++ }
+
+type sizer int
+
+func (s sizer) SizeOf() int { return int(s) }
+
+type fakeTokenEngine struct{}
+
+func (f *fakeTokenEngine) CreateAndSign(isAck bool, claims *tokens.ConnectionClaims) ([]byte, []byte, error) {
+	return []byte("token"), []byte("nonce"), nil
+}
+
+func (f *fakeTokenEngine) Decode(isAck bool, data []byte, previousCert interface{}) (*tokens.ConnectionClaims, []byte, interface{}, error) {
+	return &tokens.ConnectionClaims{}, []byte("nonce"), nil, nil
+}
+
+func (f *fakeTokenEngine) Randomize(token []byte) ([]byte, error) { return []byte("nonce"), nil }
+
+func (f *fakeTokenEngine) RetrieveNonce(token []byte) ([]byte, error) { return []byte("nonce"), nil }
+
+func TestCollector(t *testing.T) {
+
+	Convey("Given a collector that counts the flows", t, func() {
+		next := &recordCollector{}
+		c := NewCollector(next)
+
+		Convey("When I report a nil record, it should be ignored", func() {
+			c.CollectFlowEvent(nil)
+			FlowReported(nil)
+			So(next.flows, ShouldEqual, 0)
+		})
+
+		Convey("When I report flows, they should be counted and forwarded", func() {
+			c.CollectFlowEvent(&collector.FlowRecord{ContextID: "pu1", Action: policy.Accept, DropReason: "NA"})
+			c.CollectFlowEvent(&collector.FlowRecord{ContextID: "pu1", Action: policy.Reject, DropReason: collector.PolicyDrop})
+			c.CollectFlowEvent(&collector.FlowRecord{ContextID: "pu1", Action: policy.Reject, DropReason: collector.PolicyDrop})
+			c.CollectContainerEvent(&collector.ContainerRecord{ContextID: "pu1"})
+
+			So(next.flows, ShouldEqual, 3)
+			So(next.containers, ShouldEqual, 1)
+
+			value, ok := sample("trireme_flows_total", map[string]string{"context_id": "pu1", "action": "reject", "drop_reason": collector.PolicyDrop})
+			So(ok, ShouldBeTrue)
+			So(value, ShouldEqual, 2)
+
+			value, ok = sample("trireme_flows_total", map[string]string{"context_id": "pu1", "action": "accept", "drop_reason": "NA"})
+			So(ok, ShouldBeTrue)
+			So(value, ShouldEqual, 1)
+
+			Convey("When I forget the context, its counters should be deleted", func() {
+				ForgetContext("pu1")
+
+				_, ok := sample("trireme_flows_total", map[string]string{"context_id": "pu1"})
+				So(ok, ShouldBeFalse)
+			})
+		})
+	})
+}
+
+func TestCaches(t *testing.T) {
+
+	Convey("When I register the caches of two instances with the same name, both sizes should be exported", t, func() {
+		RegisterCache("1", "test", sizer(42))
+		RegisterCache("2", "test", sizer(7))
+
+		value, ok := sample("trireme_cache_entries", map[string]string{"instance": "1", "cache": "test"})
+		So(ok, ShouldBeTrue)
+		So(value, ShouldEqual, 42)
+
+		value, ok = sample("trireme_cache_entries", map[string]string{"instance": "2", "cache": "test"})
+		So(ok, ShouldBeTrue)
+		So(value, ShouldEqual, 7)
+
+		Convey("When I unregister the cache of an instance, only its size should not be exported", func() {
+			UnregisterCache("1", "test")
+
+			_, ok := sample("trireme_cache_entries", map[string]string{"instance": "1", "cache": "test"})
+			So(ok, ShouldBeFalse)
+
+			_, ok = sample("trireme_cache_entries", map[string]string{"instance": "2", "cache": "test"})
+			So(ok, ShouldBeTrue)
+
+			UnregisterCache("2", "test")
+		})
+	})
+}
+
+func TestQueues(t *testing.T) {
+
+	Convey("Given a registered filter queue configuration", t, func() {
+		fq := fqconfig.NewFilterQueueWithDefaults()
+		RegisterFilterQueue(fq)
+
+		Convey("The idle queues should be exported", func() {
+			value, ok := sample("trireme_nfqueue_packets_total", map[string]string{"direction": DirectionApplication, "queue": strconv.Itoa(int(fq.GetApplicationQueueStart())), "verdict": "drop"})
+			So(ok, ShouldBeTrue)
+			So(value, ShouldEqual, 0)
+		})
+
+		Convey("When I process packets, they should be counted per verdict", func() {
+			QueuePacket(DirectionNetwork, fq.GetNetworkQueueStart(), true)
+			QueuePacket(DirectionNetwork, fq.GetNetworkQueueStart(), true)
+			QueuePacket(DirectionNetwork, fq.GetNetworkQueueStart(), false)
+
+			value, ok := sample("trireme_nfqueue_packets_total", map[string]string{"direction": DirectionNetwork, "queue": strconv.Itoa(int(fq.GetNetworkQueueStart())), "verdict": "accept"})
+			So(ok, ShouldBeTrue)
+			So(value, ShouldEqual, 2)
+
+			value, ok = sample("trireme_nfqueue_packets_total", map[string]string{"direction": DirectionNetwork, "queue": strconv.Itoa(int(fq.GetNetworkQueueStart())), "verdict": "drop"})
+			So(ok, ShouldBeTrue)
+			So(value, ShouldEqual, 1)
+		})
+
+		Convey("When I process packets from a queue that was not registered, they should be counted", func() {
+			QueuePacket(DirectionApplication, 4000, true)
+
+			value, ok := sample("trireme_nfqueue_packets_total", map[string]string{"direction": DirectionApplication, "queue": "4000", "verdict": "accept"})
+			So(ok, ShouldBeTrue)
+			So(value, ShouldEqual, 1)
+		})
+	})
+}
+
+func TestTokenEngine(t *testing.T) {
+
+	Convey("Given a measured token engine", t, func() {
+		engine := NewTokenEngine(&fakeTokenEngine{})
+
+		Convey("When I create and decode tokens, the latency should be recorded", func() {
+			encoded, _ := sample("trireme_token_duration_seconds", map[string]string{"operation": OperationEncode})
+			decoded, _ := sample("trireme_token_duration_seconds", map[string]string{"operation": OperationDecode})
+
+			token, _, err := engine.CreateAndSign(false, &tokens.ConnectionClaims{})
+			So(err, ShouldBeNil)
+			So(string(token), ShouldEqual, "token")
+
+			_, _, _, err = engine.Decode(false, token, nil)
+			So(err, ShouldBeNil)
+
+			value, ok := sample("trireme_token_duration_seconds", map[string]string{"operation": OperationEncode})
+			So(ok, ShouldBeTrue)
+			So(value, ShouldEqual, encoded+1)
+
+			value, ok = sample("trireme_token_duration_seconds", map[string]string{"operation": OperationDecode})
+			So(ok, ShouldBeTrue)
+			So(value, ShouldEqual, decoded+1)
+		})
+	})
+}
+
+func TestRemoteEnforcers(t *testing.T) {
+
+	Convey("When remote enforcers are launched and exit, they should be counted", t, func() {
+		RemoteEnforcerLaunched(false)
+		RemoteEnforcerLaunched(true)
+		RemoteEnforcerExited(nil)
+
+		value, ok := sample("trireme_remote_enforcer_launches_total", nil)
+		So(ok, ShouldBeTrue)
+		So(value, ShouldEqual, 2)
+
+		value, ok = sample("trireme_remote_enforcer_restarts_total", nil)
+		So(ok, ShouldBeTrue)
+		So(value, ShouldEqual, 1)
+
+		value, ok = sample("trireme_remote_enforcer_exits_total", map[string]string{"status": "success"})
+		So(ok, ShouldBeTrue)
+		So(value, ShouldEqual, 1)
+	})
+}
+
+func TestServer(t *testing.T) {
+
+	Convey("Given a started metrics server", t, func() {
+		s := NewServer("127.0.0.1:0")
+		So(s.Start(), ShouldBeNil)
+		defer s.Stop() // nolint
+
+		Convey("When I scrape /metrics, I should get the metrics", func() {
+			resp, err := http.Get("http://" + s.Address() + "/metrics")
+			So(err, ShouldBeNil)
+			defer resp.Body.Close() // nolint
+
+			body, err := ioutil.ReadAll(resp.Body)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(strings.Contains(string(body), "trireme_remote_enforcer_launches_total"), ShouldBeTrue)
+		})
+	})
+}
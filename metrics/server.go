@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+)
+
+// Handler returns the HTTP handler that exports the metrics
+func Handler() http.Handler {
+
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// Server exports the metrics on /metrics
+type Server struct {
+	address  string
+	server   *http.Server
+	listener net.Listener
+}
+
+// NewServer returns a metrics server that will listen on address
+func NewServer(address string) *Server {
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+
+	return &Server{
+		address: address,
+		server: &http.Server{
+			Handler: mux,
+		},
+	}
+}
+
+// Start starts serving the metrics in the background
+func (s *Server) Start() error {
+
+	listener, err := net.Listen("tcp", s.address)
+	if err != nil {
+		return fmt.Errorf("Unable to start metrics server: %s", err)
+	}
+
+	s.listener = listener
+
+	go func() {
+		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			zap.L().Error("Metrics server stopped", zap.Error(err))
+		}
+	}()
+
+	return nil
+}
+
+// Address returns the address the server listens on once started
+func (s *Server) Address() string {
+
+	if s.listener == nil {
+		return s.address
+	}
+
+	return s.listener.Addr().String()
+}
+
+// Stop stops the server
+func (s *Server) Stop() error {
+
+	if s.listener == nil {
+		return nil
+	}
+
+	return s.server.Close()
+}
@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/aporeto-inc/trireme/enforcer/utils/tokens"
+)
+
+// tokenEngine measures the latency of the tokens created and decoded by the
+// underlying engine
+type tokenEngine struct {
+	engine tokens.TokenEngine
+}
+
+// NewTokenEngine returns a token engine that records the latency of engine
+func NewTokenEngine(engine tokens.TokenEngine) tokens.TokenEngine {
+
+	return &tokenEngine{
+		engine: engine,
+	}
+}
+
+// CreateAndSign implements tokens.TokenEngine
+func (t *tokenEngine) CreateAndSign(isAck bool, claims *tokens.ConnectionClaims) ([]byte, []byte, error) {
+
+	defer TokenOperation(OperationEncode, time.Now())
+
+	return t.engine.CreateAndSign(isAck, claims)
+}
+
+// Decode implements tokens.TokenEngine
+func (t *tokenEngine) Decode(isAck bool, data []byte, previousCert interface{}) (*tokens.ConnectionClaims, []byte, interface{}, error) {
+
+	defer TokenOperation(OperationDecode, time.Now())
+
+	return t.engine.Decode(isAck, data, previousCert)
+}
+
+// Randomize implements tokens.TokenEngine
+func (t *tokenEngine) Randomize(token []byte) ([]byte, error) {
+
+	return t.engine.Randomize(token)
+}
+
+// RetrieveNonce implements tokens.TokenEngine
+func (t *tokenEngine) RetrieveNonce(token []byte) ([]byte, error) {
+
+	return t.engine.RetrieveNonce(token)
+}
@@ -0,0 +1,438 @@
+package crimonitor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/monitor"
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+const (
+	// ServiceAccountAnnotation is the annotation of the pod sandboxes that
+	// holds the service account of the pod
+	ServiceAccountAnnotation = "kubernetes.io/service-account.name"
+
+	// pingTimeout is the time given to the runtime to answer at start
+	pingTimeout = 2 * time.Second
+
+	// retryInterval is the time between two subscriptions to the events of
+	// the runtime when the stream fails
+	retryInterval = time.Second
+
+	// eventQueueSize is the number of events buffered during the sync
+	eventQueueSize = 1000
+)
+
+// A CRIMetadataExtractor is a function used to extract a *policy.PURuntime from
+// the status of a pod sandbox and the verbose information of the runtime.
+type CRIMetadataExtractor func(status *runtimeapi.PodSandboxStatus, info map[string]string) (*policy.PURuntime, error)
+
+func contextIDFromSandboxID(sandboxID string) (string, error) {
+
+	if len(sandboxID) < 12 {
+		return "", fmt.Errorf("Sandbox ID %q smaller than 12 characters", sandboxID)
+	}
+
+	return sandboxID[:12], nil
+}
+
+// sandboxPid returns the pid of the sandbox from the verbose information of
+// the runtime. It returns 0 if the runtime does not provide it.
+func sandboxPid(info map[string]string) int {
+
+	verbose := struct {
+		Pid int `json:"pid"`
+	}{}
+
+	if err := json.Unmarshal([]byte(info["info"]), &verbose); err != nil {
+		return 0
+	}
+
+	return verbose.Pid
+}
+
+// hostNetwork returns true if the sandbox uses the network namespace of the host
+func hostNetwork(status *runtimeapi.PodSandboxStatus) bool {
+
+	return status.GetLinux().GetNamespaces().GetOptions().GetNetwork() == runtimeapi.NamespaceMode_NODE
+}
+
+// DefaultCRIMetadataExtractor is the default metadata extractor for the pod
+// sandboxes. The name, namespace and service account of the pod are system
+// tags and the labels of the pod are user tags.
+func DefaultCRIMetadataExtractor(status *runtimeapi.PodSandboxStatus, info map[string]string) (*policy.PURuntime, error) {
+
+	if status.GetMetadata() == nil {
+		return nil, fmt.Errorf("No metadata for sandbox %s", status.GetId())
+	}
+
+	tags := policy.NewTagStore()
+	tags.AppendKeyValue("@sys:name", status.Metadata.Name)
+	tags.AppendKeyValue("@sys:namespace", status.Metadata.Namespace)
+
+	if account, ok := status.Annotations[ServiceAccountAnnotation]; ok {
+		tags.AppendKeyValue("@sys:serviceaccount", account)
+	}
+
+	for k, v := range status.Labels {
+		tags.AppendKeyValue("@usr:"+k, v)
+	}
+
+	ipa := policy.ExtendedMap{}
+	if network := status.GetNetwork(); network != nil {
+		ipa[policy.DefaultNamespace] = network.Ip
+		for _, ip := range network.AdditionalIps {
+			if ip.GetIp() != "" && ip.Ip != network.Ip {
+				ipa[policy.DefaultNamespaceIPv6] = ip.Ip
+				break
+			}
+		}
+	}
+
+	return policy.NewPURuntime(status.Metadata.Namespace+"/"+status.Metadata.Name, sandboxPid(info), tags, ipa, constants.ContainerPU, nil), nil
+}
+
+// criMonitor implements the connection to a CRI runtime and monitoring of the
+// pod sandboxes based on the container events. Every sandbox is a PU.
+type criMonitor struct {
+	conn              *grpc.ClientConn
+	client            runtimeapi.RuntimeServiceClient
+	metadataExtractor CRIMetadataExtractor
+	events            chan *runtimeapi.ContainerEventResponse
+	syncHandler       monitor.SynchronizationHandler
+	syncAtStart       bool
+
+	collector collector.EventCollector
+	puHandler monitor.ProcessingUnitsHandler
+
+	// started holds the sandboxes that were started. It is only accessed
+	// by the sync and then by the event processor.
+	started map[string]bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewCRIMonitor returns a monitor of the pod sandboxes of the CRI runtime
+// (containerd, CRI-O) listening on the given unix socket.
+func NewCRIMonitor(
+	socketAddress string,
+	p monitor.ProcessingUnitsHandler,
+	m CRIMetadataExtractor,
+	l collector.EventCollector,
+	syncAtStart bool,
+	s monitor.SynchronizationHandler,
+) monitor.Monitor {
+
+	// Sanity check that this path exists
+	if _, err := os.Stat(socketAddress); os.IsNotExist(err) {
+		zap.L().Debug("CRI socket does not exist", zap.String("socket", socketAddress))
+		return nil
+	}
+
+	conn, err := grpc.Dial("unix://"+socketAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		zap.L().Debug("Unable to initialize CRI client", zap.Error(err))
+		return nil
+	}
+
+	if m == nil {
+		m = DefaultCRIMetadataExtractor
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &criMonitor{
+		conn:              conn,
+		client:            runtimeapi.NewRuntimeServiceClient(conn),
+		metadataExtractor: m,
+		events:            make(chan *runtimeapi.ContainerEventResponse, eventQueueSize),
+		syncHandler:       s,
+		syncAtStart:       syncAtStart,
+		collector:         l,
+		puHandler:         p,
+		started:           map[string]bool{},
+		ctx:               ctx,
+		cancel:            cancel,
+	}
+}
+
+// Start subscribes to the events of the runtime, syncs the existing sandboxes
+// if configured to do so and then processes the events.
+func (c *criMonitor) Start() error {
+
+	zap.L().Debug("Starting the CRI monitor")
+
+	ctx, cancel := context.WithTimeout(c.ctx, pingTimeout)
+	defer cancel()
+
+	version, err := c.client.Version(ctx, &runtimeapi.VersionRequest{})
+	if err != nil {
+		return fmt.Errorf("CRI runtime not running: %s", err)
+	}
+
+	zap.L().Debug("Connected to CRI runtime",
+		zap.String("runtime", version.RuntimeName),
+		zap.String("version", version.RuntimeVersion),
+	)
+
+	// The listener is started first so that no event is lost during the sync
+	listenerReady := make(chan struct{})
+	go c.eventListener(listenerReady)
+	<-listenerReady
+
+	if c.syncAtStart {
+		if err := c.syncSandboxes(); err != nil {
+			zap.L().Error("Error Syncing existing sandboxes", zap.Error(err))
+		}
+	}
+
+	go c.eventProcessor()
+
+	return nil
+}
+
+// Stop stops monitoring the runtime
+func (c *criMonitor) Stop() error {
+
+	zap.L().Debug("Stopping the CRI monitor")
+
+	c.cancel()
+
+	return c.conn.Close()
+}
+
+// eventListener subscribes to the container events of the runtime and passes
+// them to the processor through a buffered channel. The subscription is
+// renewed if the stream fails.
+func (c *criMonitor) eventListener(listenerReady chan struct{}) {
+
+	ready := false
+	for {
+		stream, err := c.client.GetContainerEvents(c.ctx, &runtimeapi.GetEventsRequest{})
+
+		if !ready {
+			listenerReady <- struct{}{}
+			ready = true
+		}
+
+		if err == nil {
+			for {
+				event, rerr := stream.Recv()
+				if rerr != nil {
+					err = rerr
+					break
+				}
+				select {
+				case c.events <- event:
+				case <-c.ctx.Done():
+					return
+				}
+			}
+		}
+
+		if c.ctx.Err() != nil {
+			return
+		}
+
+		zap.L().Warn("CRI event stream failed", zap.Error(err))
+
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+// eventProcessor processes the events in the order they were received
+func (c *criMonitor) eventProcessor() {
+
+	for {
+		select {
+		case event := <-c.events:
+			if err := c.handleEvent(event); err != nil {
+				zap.L().Error("Error while handling event",
+					zap.String("container", event.ContainerId),
+					zap.String("type", event.ContainerEventType.String()),
+					zap.Error(err),
+				)
+			}
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// handleEvent translates the events of the sandboxes to PU events. The
+// events of the other containers only start the sandboxes that were not
+// reported by the runtime.
+func (c *criMonitor) handleEvent(event *runtimeapi.ContainerEventResponse) error {
+
+	status := event.GetPodSandboxStatus()
+	if status == nil {
+		zap.L().Debug("CRI event without sandbox", zap.String("container", event.ContainerId))
+		return nil
+	}
+
+	contextID, err := contextIDFromSandboxID(status.Id)
+	if err != nil {
+		return fmt.Errorf("Error Generating ContextID: %s", err)
+	}
+
+	sandbox := event.ContainerId == status.Id
+
+	switch event.ContainerEventType {
+
+	case runtimeapi.ContainerEventType_CONTAINER_CREATED_EVENT:
+		if sandbox {
+			return c.puHandler.HandlePUEvent(contextID, monitor.EventCreate)
+		}
+
+	case runtimeapi.ContainerEventType_CONTAINER_STARTED_EVENT:
+		if sandbox || (!c.started[status.Id] && status.State == runtimeapi.PodSandboxState_SANDBOX_READY) {
+			return c.startSandbox(status.Id)
+		}
+
+	case runtimeapi.ContainerEventType_CONTAINER_STOPPED_EVENT:
+		if sandbox && c.started[status.Id] {
+			c.started[status.Id] = false
+			return c.puHandler.HandlePUEvent(contextID, monitor.EventStop)
+		}
+
+	case runtimeapi.ContainerEventType_CONTAINER_DELETED_EVENT:
+		if sandbox {
+			delete(c.started, status.Id)
+			return c.puHandler.HandlePUEvent(contextID, monitor.EventDestroy)
+		}
+	}
+
+	return nil
+}
+
+// sandboxRuntime returns the status and the runtime of a sandbox
+func (c *criMonitor) sandboxRuntime(sandboxID string) (*runtimeapi.PodSandboxStatus, *policy.PURuntime, error) {
+
+	response, err := c.client.PodSandboxStatus(c.ctx, &runtimeapi.PodSandboxStatusRequest{
+		PodSandboxId: sandboxID,
+		Verbose:      true,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("Cannot read sandbox information: %s", err)
+	}
+
+	runtimeInfo, err := c.metadataExtractor(response.Status, response.Info)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error getting some of the CRI primitives: %s", err)
+	}
+
+	return response.Status, runtimeInfo, nil
+}
+
+// startSandbox activates the PU of a sandbox. The sandboxes that share the
+// network of the host are ignored.
+func (c *criMonitor) startSandbox(sandboxID string) error {
+
+	contextID, err := contextIDFromSandboxID(sandboxID)
+	if err != nil {
+		return fmt.Errorf("Couldn't generate ContextID: %s", err)
+	}
+
+	status, runtimeInfo, err := c.sandboxRuntime(sandboxID)
+	if err != nil {
+		return err
+	}
+
+	if status.State != runtimeapi.PodSandboxState_SANDBOX_READY {
+		return nil
+	}
+
+	if hostNetwork(status) {
+		c.collector.CollectContainerEvent(&collector.ContainerRecord{
+			ContextID: contextID,
+			IPAddress: "N/A",
+			Tags:      runtimeInfo.Tags(),
+			Event:     collector.ContainerIgnored,
+		})
+		return nil
+	}
+
+	if err := c.puHandler.SetPURuntime(contextID, runtimeInfo); err != nil {
+		return err
+	}
+
+	if err := c.puHandler.HandlePUEvent(contextID, monitor.EventStart); err != nil {
+		return fmt.Errorf("Policy cound't be set for sandbox %s: %s", contextID, err)
+	}
+
+	c.started[sandboxID] = true
+
+	return nil
+}
+
+// syncSandboxes resyncs all the existing sandboxes on the host, using the
+// same process as when a sandbox is initially started
+func (c *criMonitor) syncSandboxes() error {
+
+	zap.L().Debug("Syncing all existing sandboxes")
+
+	response, err := c.client.ListPodSandbox(c.ctx, &runtimeapi.ListPodSandboxRequest{})
+	if err != nil {
+		return fmt.Errorf("Error Getting sandbox list: %s", err)
+	}
+
+	if c.syncHandler != nil {
+		for _, sandbox := range response.Items {
+
+			contextID, err := contextIDFromSandboxID(sandbox.Id)
+			if err != nil {
+				zap.L().Error("Error Syncing existing sandbox", zap.Error(err))
+				continue
+			}
+
+			status, runtimeInfo, err := c.sandboxRuntime(sandbox.Id)
+			if err != nil {
+				zap.L().Error("Error Syncing existing sandbox", zap.Error(err))
+				continue
+			}
+
+			state := monitor.StateStopped
+			if status.State == runtimeapi.PodSandboxState_SANDBOX_READY {
+				state = monitor.StateStarted
+			}
+
+			if err := c.syncHandler.HandleSynchronization(contextID, state, runtimeInfo, monitor.SynchronizationTypeInitial); err != nil {
+				zap.L().Error("Error Syncing existing sandbox", zap.Error(err))
+			}
+		}
+
+		c.syncHandler.HandleSynchronizationComplete(monitor.SynchronizationTypeInitial)
+	}
+
+	for _, sandbox := range response.Items {
+
+		if sandbox.State != runtimeapi.PodSandboxState_SANDBOX_READY {
+			continue
+		}
+
+		if err := c.startSandbox(sandbox.Id); err != nil {
+			zap.L().Error("Error Syncing existing sandbox during start handling", zap.Error(err))
+			continue
+		}
+
+		zap.L().Info("Successfully synced sandbox", zap.String("ID", sandbox.Id))
+	}
+
+	return nil
+}
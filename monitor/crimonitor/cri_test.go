@@ -0,0 +1,319 @@
+package crimonitor
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/monitor"
+	"github.com/aporeto-inc/trireme/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeRuntime is a CRI runtime that serves the sandboxes it holds and the
+// events that are pushed to it
+type fakeRuntime struct {
+	runtimeapi.UnimplementedRuntimeServiceServer
+	sandboxes map[string]*runtimeapi.PodSandboxStatus
+	events    chan *runtimeapi.ContainerEventResponse
+	sync.Mutex
+}
+
+func (f *fakeRuntime) Version(ctx context.Context, req *runtimeapi.VersionRequest) (*runtimeapi.VersionResponse, error) {
+	return &runtimeapi.VersionResponse{RuntimeName: "fake", RuntimeVersion: "1.0"}, nil
+}
+
+func (f *fakeRuntime) ListPodSandbox(ctx context.Context, req *runtimeapi.ListPodSandboxRequest) (*runtimeapi.ListPodSandboxResponse, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	response := &runtimeapi.ListPodSandboxResponse{}
+	for _, status := range f.sandboxes {
+		response.Items = append(response.Items, &runtimeapi.PodSandbox{Id: status.Id, Metadata: status.Metadata, State: status.State})
+	}
+
+	return response, nil
+}
+
+func (f *fakeRuntime) PodSandboxStatus(ctx context.Context, req *runtimeapi.PodSandboxStatusRequest) (*runtimeapi.PodSandboxStatusResponse, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	status, ok := f.sandboxes[req.PodSandboxId]
+	if !ok {
+		return nil, fmt.Errorf("sandbox %s not found", req.PodSandboxId)
+	}
+
+	return &runtimeapi.PodSandboxStatusResponse{Status: status, Info: map[string]string{"info": `{"pid": 1000}`}}, nil
+}
+
+func (f *fakeRuntime) GetContainerEvents(req *runtimeapi.GetEventsRequest, stream runtimeapi.RuntimeService_GetContainerEventsServer) error {
+	for {
+		select {
+		case event := <-f.events:
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// add adds a sandbox to the runtime and returns its status
+func (f *fakeRuntime) add(id, name string, state runtimeapi.PodSandboxState) *runtimeapi.PodSandboxStatus {
+	f.Lock()
+	defer f.Unlock()
+
+	status := &runtimeapi.PodSandboxStatus{
+		Id:          id,
+		Metadata:    &runtimeapi.PodSandboxMetadata{Name: name, Namespace: "default"},
+		State:       state,
+		Network:     &runtimeapi.PodSandboxNetworkStatus{Ip: "10.0.0.1"},
+		Labels:      map[string]string{"app": name},
+		Annotations: map[string]string{ServiceAccountAnnotation: "builder"},
+	}
+	f.sandboxes[id] = status
+
+	return status
+}
+
+// recordingHandler records the PU events and runtimes
+type recordingHandler struct {
+	events   chan string
+	runtimes map[string]*policy.PURuntime
+	synced   map[string]monitor.State
+	complete bool
+	sync.Mutex
+}
+
+func (r *recordingHandler) SetPURuntime(contextID string, runtimeInfo *policy.PURuntime) error {
+	r.Lock()
+	defer r.Unlock()
+	r.runtimes[contextID] = runtimeInfo
+	return nil
+}
+
+func (r *recordingHandler) HandlePUEvent(contextID string, event monitor.Event) error {
+	r.events <- contextID + " " + string(event)
+	return nil
+}
+
+func (r *recordingHandler) HandleSynchronization(contextID string, state monitor.State, runtimeReader policy.RuntimeReader, syncType monitor.SynchronizationType) error {
+	r.Lock()
+	defer r.Unlock()
+	r.synced[contextID] = state
+	return nil
+}
+
+func (r *recordingHandler) HandleSynchronizationComplete(syncType monitor.SynchronizationType) {
+	r.Lock()
+	defer r.Unlock()
+	r.complete = true
+}
+
+// next returns the next PU event or a timeout
+func (r *recordingHandler) next() string {
+	select {
+	case event := <-r.events:
+		return event
+	case <-time.After(5 * time.Second):
+		return "timeout"
+	}
+}
+
+// ignoredCollector records the ignored PUs
+type ignoredCollector struct {
+	collector.DefaultCollector
+	ignored chan string
+}
+
+func (c *ignoredCollector) CollectContainerEvent(record *collector.ContainerRecord) {
+	if record.Event == collector.ContainerIgnored {
+		c.ignored <- record.ContextID
+	}
+}
+
+func TestDefaultCRIMetadataExtractor(t *testing.T) {
+
+	Convey("Given the status of a pod sandbox", t, func() {
+		status := &runtimeapi.PodSandboxStatus{
+			Id:       "0123456789abcdef",
+			Metadata: &runtimeapi.PodSandboxMetadata{Name: "web", Namespace: "prod"},
+			Network: &runtimeapi.PodSandboxNetworkStatus{
+				Ip:            "10.0.0.1",
+				AdditionalIps: []*runtimeapi.PodIP{{Ip: "fd00::1"}},
+			},
+			Labels:      map[string]string{"app": "web"},
+			Annotations: map[string]string{ServiceAccountAnnotation: "frontend"},
+		}
+
+		Convey("The runtime should hold the identity of the pod", func() {
+			runtimeInfo, err := DefaultCRIMetadataExtractor(status, map[string]string{"info": `{"pid": 42}`})
+			So(err, ShouldBeNil)
+			So(runtimeInfo.Name(), ShouldEqual, "prod/web")
+			So(runtimeInfo.Pid(), ShouldEqual, 42)
+
+			tags := runtimeInfo.Tags()
+			for _, tag := range []string{"@sys:name=web", "@sys:namespace=prod", "@sys:serviceaccount=frontend", "@usr:app=web"} {
+				So(tags.Tags, ShouldContain, tag)
+			}
+
+			ip, ok := runtimeInfo.DefaultIPAddress()
+			So(ok, ShouldBeTrue)
+			So(ip, ShouldEqual, "10.0.0.1")
+			So(runtimeInfo.IPAddresses()[policy.DefaultNamespaceIPv6], ShouldEqual, "fd00::1")
+		})
+
+		Convey("Without verbose information, the pid should be 0", func() {
+			runtimeInfo, err := DefaultCRIMetadataExtractor(status, nil)
+			So(err, ShouldBeNil)
+			So(runtimeInfo.Pid(), ShouldEqual, 0)
+		})
+
+		Convey("Without metadata, I should get an error", func() {
+			status.Metadata = nil
+			_, err := DefaultCRIMetadataExtractor(status, nil)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestCRIMonitor(t *testing.T) {
+
+	Convey("Given a fake CRI runtime with sandboxes", t, func() {
+		dir, err := ioutil.TempDir("", "crimonitor")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir) // nolint
+
+		socket := filepath.Join(dir, "cri.sock")
+		listener, err := net.Listen("unix", socket)
+		So(err, ShouldBeNil)
+
+		runtime := &fakeRuntime{
+			sandboxes: map[string]*runtimeapi.PodSandboxStatus{},
+			events:    make(chan *runtimeapi.ContainerEventResponse),
+		}
+		runtime.add("aaaaaaaaaaaa0001", "running", runtimeapi.PodSandboxState_SANDBOX_READY)
+		runtime.add("bbbbbbbbbbbb0001", "stopped", runtimeapi.PodSandboxState_SANDBOX_NOTREADY)
+
+		server := grpc.NewServer()
+		runtimeapi.RegisterRuntimeServiceServer(server, runtime)
+		go server.Serve(listener) // nolint
+		defer server.Stop()
+
+		handler := &recordingHandler{
+			events:   make(chan string, 100),
+			runtimes: map[string]*policy.PURuntime{},
+			synced:   map[string]monitor.State{},
+		}
+		events := &ignoredCollector{ignored: make(chan string, 10)}
+
+		Convey("When I start a monitor that syncs at start", func() {
+			m := NewCRIMonitor(socket, handler, nil, events, true, handler)
+			So(m, ShouldNotBeNil)
+			So(m.Start(), ShouldBeNil)
+			defer m.Stop() // nolint
+
+			Convey("The existing sandboxes should be synchronized", func() {
+				handler.Lock()
+				defer handler.Unlock()
+				So(handler.complete, ShouldBeTrue)
+				So(handler.synced["aaaaaaaaaaaa"], ShouldEqual, monitor.StateStarted)
+				So(handler.synced["bbbbbbbbbbbb"], ShouldEqual, monitor.StateStopped)
+			})
+
+			Convey("The running sandbox should be started", func() {
+				So(handler.next(), ShouldEqual, "aaaaaaaaaaaa start")
+
+				handler.Lock()
+				defer handler.Unlock()
+				So(handler.runtimes["aaaaaaaaaaaa"].Tags().Tags, ShouldContain, "@sys:serviceaccount=builder")
+				So(handler.runtimes["aaaaaaaaaaaa"].Pid(), ShouldEqual, 1000)
+			})
+
+			Convey("When a new sandbox goes through its lifecycle", func() {
+				So(handler.next(), ShouldEqual, "aaaaaaaaaaaa start")
+
+				status := runtime.add("cccccccccccc0001", "new", runtimeapi.PodSandboxState_SANDBOX_READY)
+				send := func(id string, eventType runtimeapi.ContainerEventType) {
+					runtime.events <- &runtimeapi.ContainerEventResponse{ContainerId: id, ContainerEventType: eventType, PodSandboxStatus: status}
+				}
+
+				send("cccccccccccc0001", runtimeapi.ContainerEventType_CONTAINER_CREATED_EVENT)
+				send("cccccccccccc0001", runtimeapi.ContainerEventType_CONTAINER_STARTED_EVENT)
+				send("dddddddddddd0001", runtimeapi.ContainerEventType_CONTAINER_STARTED_EVENT)
+				send("cccccccccccc0001", runtimeapi.ContainerEventType_CONTAINER_STOPPED_EVENT)
+				send("cccccccccccc0001", runtimeapi.ContainerEventType_CONTAINER_DELETED_EVENT)
+
+				Convey("The PU events should follow the sandbox only", func() {
+					So(handler.next(), ShouldEqual, "cccccccccccc create")
+					So(handler.next(), ShouldEqual, "cccccccccccc start")
+					So(handler.next(), ShouldEqual, "cccccccccccc stop")
+					So(handler.next(), ShouldEqual, "cccccccccccc destroy")
+				})
+			})
+
+			Convey("When a container starts in a sandbox that was not reported, the sandbox should be started", func() {
+				So(handler.next(), ShouldEqual, "aaaaaaaaaaaa start")
+
+				status := runtime.add("eeeeeeeeeeee0001", "late", runtimeapi.PodSandboxState_SANDBOX_READY)
+				runtime.events <- &runtimeapi.ContainerEventResponse{
+					ContainerId:        "ffffffffffff0001",
+					ContainerEventType: runtimeapi.ContainerEventType_CONTAINER_STARTED_EVENT,
+					PodSandboxStatus:   status,
+				}
+
+				So(handler.next(), ShouldEqual, "eeeeeeeeeeee start")
+			})
+
+			Convey("When a sandbox shares the network of the host, it should be ignored", func() {
+				So(handler.next(), ShouldEqual, "aaaaaaaaaaaa start")
+
+				status := runtime.add("9999999999990001", "host", runtimeapi.PodSandboxState_SANDBOX_READY)
+				status.Linux = &runtimeapi.LinuxPodSandboxStatus{
+					Namespaces: &runtimeapi.Namespace{
+						Options: &runtimeapi.NamespaceOption{Network: runtimeapi.NamespaceMode_NODE},
+					},
+				}
+				runtime.events <- &runtimeapi.ContainerEventResponse{
+					ContainerId:        status.Id,
+					ContainerEventType: runtimeapi.ContainerEventType_CONTAINER_STARTED_EVENT,
+					PodSandboxStatus:   status,
+				}
+
+				select {
+				case id := <-events.ignored:
+					So(id, ShouldEqual, "999999999999")
+				case <-time.After(5 * time.Second):
+					t.Error("The sandbox was not ignored")
+				}
+			})
+		})
+
+		Convey("When I start a monitor without sync, the existing sandboxes should not be started", func() {
+			m := NewCRIMonitor(socket, handler, nil, events, false, handler)
+			So(m.Start(), ShouldBeNil)
+			So(m.Stop(), ShouldBeNil)
+
+			handler.Lock()
+			defer handler.Unlock()
+			So(handler.complete, ShouldBeFalse)
+			So(handler.runtimes, ShouldBeEmpty)
+		})
+
+		Convey("When the socket does not exist, I should not get a monitor", func() {
+			So(NewCRIMonitor(filepath.Join(dir, "none.sock"), handler, nil, events, false, handler), ShouldBeNil)
+		})
+	})
+}
@@ -206,6 +206,14 @@ func (s *netCls) Deletebasepath(cgroupName string) bool {
 
 func mountCgroupController() {
 	mounts, _ := ioutil.ReadFile("/proc/mounts")
+
+	// Hosts with the unified hierarchy only have no net_cls controller
+	if path, ok := unifiedHierarchyPath(string(mounts)); ok {
+		basePath = path
+		unified = true
+		return
+	}
+
 	sc := bufio.NewScanner(strings.NewReader(string(mounts)))
 	var netCls = false
 	var cgroupMount string
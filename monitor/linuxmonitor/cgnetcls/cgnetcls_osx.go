@@ -75,3 +75,25 @@ func MarkVal() uint64 {
 func ListCgroupProcesses(cgroupname string) ([]string, error) {
 	return []string{}, nil
 }
+
+// ReleaseHandler is called with the path of a Trireme cgroup when the last
+// process of the cgroup exits
+type ReleaseHandler func(cgroupPath string)
+
+// NewCgroupV2NetController returns a handle to manage the Trireme cgroups of
+// the unified hierarchy
+func NewCgroupV2NetController(release ReleaseHandler) Cgroupnetcls {
+
+	return &netCls{}
+}
+
+// UnifiedHierarchy returns true if the host only has the cgroup v2 hierarchy
+func UnifiedHierarchy() bool {
+	return false
+}
+
+// CgroupPath returns the path of a Trireme cgroup relative to the root of the
+// unified hierarchy
+func CgroupPath(cgroupname string) string {
+	return TriremeBasePath + "/" + cgroupname
+}
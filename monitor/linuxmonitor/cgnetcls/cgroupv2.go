@@ -0,0 +1,327 @@
+// +build linux,!darwin,!windows
+
+package cgnetcls
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"go.uber.org/zap"
+)
+
+const (
+	eventsFile     = "/cgroup.events"
+	cgroup2FSType  = "cgroup2"
+	cgroupV1FSType = "cgroup"
+)
+
+// unified is true when the host only has the cgroup v2 hierarchy
+var unified bool
+
+// ReleaseHandler is called with the path of a Trireme cgroup, in the format
+// of the v1 release agent, when the last process of the cgroup exits
+type ReleaseHandler func(cgroupPath string)
+
+// netClsV2 manages the Trireme cgroups of the unified hierarchy. There is no
+// net_cls controller in cgroup v2: the traffic of the processes is matched
+// by the path of their cgroup and the mark is assigned by the rules.
+type netClsV2 struct {
+	basePath string
+	release  ReleaseHandler
+	fd       int
+	watches  map[int]string
+	sync.Mutex
+}
+
+// UnifiedHierarchy returns true if the host only has the cgroup v2 hierarchy
+func UnifiedHierarchy() bool {
+	return unified
+}
+
+// CgroupPath returns the path of a Trireme cgroup relative to the root of the
+// unified hierarchy, as matched by the rules
+func CgroupPath(cgroupname string) string {
+
+	if !strings.HasPrefix(cgroupname, "/") {
+		cgroupname = "/" + cgroupname
+	}
+
+	return TriremeBasePath + cgroupname
+}
+
+// unifiedHierarchyPath returns the mount point of the cgroup v2 hierarchy if
+// no cgroup v1 hierarchy is mounted
+func unifiedHierarchyPath(mounts string) (string, bool) {
+
+	var path string
+
+	sc := bufio.NewScanner(strings.NewReader(mounts))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 3 {
+			continue
+		}
+
+		switch fields[2] {
+		case cgroupV1FSType:
+			return "", false
+		case cgroup2FSType:
+			if path == "" {
+				path = fields[1]
+			}
+		}
+	}
+
+	return path, path != ""
+}
+
+// Creategroup creates the cgroup under the Trireme subtree and watches it for
+// release
+func (s *netClsV2) Creategroup(cgroupname string) error {
+
+	if !strings.HasPrefix(cgroupname, "/") {
+		cgroupname = "/" + cgroupname
+	}
+
+	if err := os.MkdirAll(s.basePath+TriremeBasePath+cgroupname, 0755); err != nil {
+		return fmt.Errorf("Failed to create cgroup %s: %s", cgroupname, err)
+	}
+
+	if s.release == nil {
+		return nil
+	}
+
+	return s.watch(cgroupname)
+}
+
+// AssignMark checks that the cgroup exists. The mark is assigned by the rules
+// that match the path of the cgroup.
+func (s *netClsV2) AssignMark(cgroupname string, mark uint64) error {
+
+	if !strings.HasPrefix(cgroupname, "/") {
+		cgroupname = "/" + cgroupname
+	}
+
+	if _, err := os.Stat(s.basePath + TriremeBasePath + cgroupname); os.IsNotExist(err) {
+		return errors.New("Cgroup does not exist")
+	}
+
+	return nil
+}
+
+// AddProcess moves the process to the cgroup
+func (s *netClsV2) AddProcess(cgroupname string, pid int) error {
+
+	if !strings.HasPrefix(cgroupname, "/") {
+		cgroupname = "/" + cgroupname
+	}
+
+	if _, err := os.Stat(s.basePath + TriremeBasePath + cgroupname); os.IsNotExist(err) {
+		return errors.New("Cannot add process. Cgroup does not exist")
+	}
+
+	if err := syscall.Kill(pid, 0); err != nil {
+		return nil
+	}
+
+	if err := ioutil.WriteFile(s.basePath+TriremeBasePath+cgroupname+procs, []byte(strconv.Itoa(pid)), 0644); err != nil {
+		return errors.New("Cannot add process. Failed to add process to cgroup")
+	}
+
+	return nil
+}
+
+// RemoveProcess moves the process back to the root of the hierarchy
+func (s *netClsV2) RemoveProcess(cgroupname string, pid int) error {
+
+	if !strings.HasPrefix(cgroupname, "/") {
+		cgroupname = "/" + cgroupname
+	}
+
+	data, err := ioutil.ReadFile(s.basePath + TriremeBasePath + cgroupname + procs)
+	if err != nil {
+		return errors.New("Cannot clean up process. Cgroup does not exist")
+	}
+
+	if !containsPid(string(data), pid) {
+		return errors.New("Cannot cleanup process. Process is not a part of this cgroup")
+	}
+
+	if err := ioutil.WriteFile(s.basePath+procs, []byte(strconv.Itoa(pid)), 0644); err != nil {
+		return errors.New("Cannot clean up process. Failed to remove process from cgroup")
+	}
+
+	return nil
+}
+
+// DeleteCgroup stops watching the cgroup and removes it. The cgroup must be
+// empty.
+func (s *netClsV2) DeleteCgroup(cgroupname string) error {
+
+	if !strings.HasPrefix(cgroupname, "/") {
+		cgroupname = "/" + cgroupname
+	}
+
+	s.unwatch(cgroupname)
+
+	if _, err := os.Stat(s.basePath + TriremeBasePath + cgroupname); os.IsNotExist(err) {
+		zap.L().Debug("Group already deleted", zap.Error(err))
+		return nil
+	}
+
+	if err := os.Remove(s.basePath + TriremeBasePath + cgroupname); err != nil {
+		return fmt.Errorf("Failed to delete cgroup %s error returned %s", cgroupname, err)
+	}
+
+	return nil
+}
+
+// Deletebasepath removes the Trireme subtree when it is the released cgroup
+func (s *netClsV2) Deletebasepath(cgroupName string) bool {
+
+	if !strings.HasPrefix(cgroupName, "/") {
+		cgroupName = "/" + cgroupName
+	}
+
+	if cgroupName == TriremeBasePath {
+		os.Remove(s.basePath + cgroupName) // nolint
+		return true
+	}
+
+	return false
+}
+
+// watch adds an inotify watch on the cgroup.events file of the cgroup. The
+// kernel modifies it when the cgroup becomes empty.
+func (s *netClsV2) watch(cgroupname string) error {
+
+	s.Lock()
+	defer s.Unlock()
+
+	for _, name := range s.watches {
+		if name == cgroupname {
+			return nil
+		}
+	}
+
+	if s.fd < 0 {
+		fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+		if err != nil {
+			return fmt.Errorf("Failed to initialize release notifications: %s", err)
+		}
+		s.fd = fd
+		go s.listen(fd)
+	}
+
+	wd, err := syscall.InotifyAddWatch(s.fd, s.basePath+TriremeBasePath+cgroupname+eventsFile, syscall.IN_MODIFY)
+	if err != nil {
+		return fmt.Errorf("Failed to watch cgroup %s for release: %s", cgroupname, err)
+	}
+
+	s.watches[wd] = cgroupname
+
+	return nil
+}
+
+// unwatch removes the watch of the cgroup
+func (s *netClsV2) unwatch(cgroupname string) {
+
+	s.Lock()
+	defer s.Unlock()
+
+	for wd, name := range s.watches {
+		if name == cgroupname {
+			delete(s.watches, wd)
+			syscall.InotifyRmWatch(s.fd, uint32(wd)) // nolint
+			return
+		}
+	}
+}
+
+// listen reads the inotify events and releases the cgroups that are no
+// longer populated
+func (s *netClsV2) listen(fd int) {
+
+	buffer := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+
+	for {
+		n, err := syscall.Read(fd, buffer)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			zap.L().Error("Release notifications stopped", zap.Error(err))
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buffer[offset]))
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+
+			if event.Mask&syscall.IN_MODIFY == 0 {
+				continue
+			}
+
+			s.Lock()
+			cgroupname, ok := s.watches[int(event.Wd)]
+			s.Unlock()
+
+			if ok && !s.populated(cgroupname) {
+				s.unwatch(cgroupname)
+				s.release(TriremeBasePath + cgroupname)
+			}
+		}
+	}
+}
+
+// populated returns false if the cgroup.events file of the cgroup reports
+// that there is no process left in the cgroup or its descendants
+func (s *netClsV2) populated(cgroupname string) bool {
+
+	data, err := ioutil.ReadFile(s.basePath + TriremeBasePath + cgroupname + eventsFile)
+	if err != nil {
+		return true
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "populated" {
+			return fields[1] != "0"
+		}
+	}
+
+	return true
+}
+
+// containsPid returns true if the pid is one of the lines of a cgroup.procs file
+func containsPid(data string, pid int) bool {
+
+	for _, line := range strings.Split(data, "\n") {
+		if strings.TrimSpace(line) == strconv.Itoa(pid) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NewCgroupV2NetController returns a handle to manage the Trireme cgroups of
+// the unified hierarchy. The release handler is called when a cgroup becomes
+// empty. It replaces the release agent of the v1 hierarchy.
+func NewCgroupV2NetController(release ReleaseHandler) Cgroupnetcls {
+
+	return &netClsV2{
+		basePath: basePath,
+		release:  release,
+		fd:       -1,
+		watches:  map[int]string{},
+	}
+}
@@ -0,0 +1,174 @@
+// +build linux
+
+package cgnetcls
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	unifiedMounts = `sysfs /sys sysfs rw,nosuid,nodev,noexec,relatime 0 0
+cgroup2 /sys/fs/cgroup cgroup2 rw,nosuid,nodev,noexec,relatime,nsdelegate 0 0
+`
+	hybridMounts = `tmpfs /sys/fs/cgroup tmpfs rw,relatime,mode=755 0 0
+cgroup2 /sys/fs/cgroup/unified cgroup2 rw,relatime 0 0
+cgroup /sys/fs/cgroup/net_cls cgroup rw,relatime,net_cls,net_prio 0 0
+`
+)
+
+// testV2Controller returns a cgroup v2 controller on a temporary directory.
+// The interface files of the kernel are plain files.
+func testV2Controller(t *testing.T, release ReleaseHandler) (*netClsV2, string) {
+
+	dir, err := ioutil.TempDir("", "cgroupv2")
+	if err != nil {
+		t.Fatalf("Failed to create directory %s", err)
+	}
+
+	if err := os.MkdirAll(dir+TriremeBasePath+testcgroupname, 0755); err != nil {
+		t.Fatalf("Failed to create cgroup %s", err)
+	}
+
+	if err := ioutil.WriteFile(dir+TriremeBasePath+testcgroupname+eventsFile, []byte("populated 1\nfrozen 0\n"), 0644); err != nil {
+		t.Fatalf("Failed to create events file %s", err)
+	}
+
+	controller := NewCgroupV2NetController(release).(*netClsV2)
+	controller.basePath = dir
+
+	return controller, dir
+}
+
+func TestUnifiedHierarchyPath(t *testing.T) {
+
+	if path, ok := unifiedHierarchyPath(unifiedMounts); !ok || path != "/sys/fs/cgroup" {
+		t.Errorf("Unified hierarchy not detected, got %s", path)
+	}
+
+	if _, ok := unifiedHierarchyPath(hybridMounts); ok {
+		t.Errorf("Hybrid hierarchy detected as unified")
+	}
+
+	if _, ok := unifiedHierarchyPath(""); ok {
+		t.Errorf("Unified hierarchy detected without cgroups")
+	}
+}
+
+func TestCgroupPath(t *testing.T) {
+
+	if path := CgroupPath(testcgroupnameformat); path != "/trireme/test" {
+		t.Errorf("Unexpected cgroup path %s", path)
+	}
+
+	if path := CgroupPath(testcgroupname); path != "/trireme/test" {
+		t.Errorf("Unexpected cgroup path %s", path)
+	}
+}
+
+func TestV2AddRemoveProcess(t *testing.T) {
+
+	cg, dir := testV2Controller(t, nil)
+	defer os.RemoveAll(dir) // nolint
+
+	if err := cg.Creategroup(testcgroupnameformat); err != nil {
+		t.Fatalf("Failed to create group %s", err)
+	}
+
+	if err := cg.AssignMark(testcgroupnameformat, testmark); err != nil {
+		t.Errorf("Failed to assign mark %s", err)
+	}
+
+	if err := cg.AssignMark("unknown", testmark); err == nil {
+		t.Errorf("Mark assigned to a cgroup that does not exist")
+	}
+
+	pid := os.Getpid()
+	if err := cg.AddProcess(testcgroupnameformat, pid); err != nil {
+		t.Errorf("Failed to add process %s", err)
+	}
+
+	data, _ := ioutil.ReadFile(dir + TriremeBasePath + testcgroupname + procs)
+	if strings.TrimSpace(string(data)) != strconv.Itoa(pid) {
+		t.Errorf("Process not added to the cgroup, got %s", string(data))
+	}
+
+	if err := cg.RemoveProcess(testcgroupnameformat, pid+1); err == nil {
+		t.Errorf("Removed a process that is not in the cgroup")
+	}
+
+	if err := cg.RemoveProcess(testcgroupnameformat, pid); err != nil {
+		t.Errorf("Failed to remove process %s", err)
+	}
+
+	data, _ = ioutil.ReadFile(dir + procs)
+	if strings.TrimSpace(string(data)) != strconv.Itoa(pid) {
+		t.Errorf("Process not moved to the root of the hierarchy, got %s", string(data))
+	}
+
+	if err := cg.DeleteCgroup(testcgroupnameformat); err == nil {
+		t.Errorf("Deleted a cgroup that is not empty")
+	}
+
+	os.Remove(dir + TriremeBasePath + testcgroupname + procs)      // nolint
+	os.Remove(dir + TriremeBasePath + testcgroupname + eventsFile) // nolint
+	if err := cg.DeleteCgroup(testcgroupnameformat); err != nil {
+		t.Errorf("Failed to delete cgroup %s", err)
+	}
+
+	if err := cg.DeleteCgroup(testcgroupnameformat); err != nil {
+		t.Errorf("Failed to delete a deleted cgroup %s", err)
+	}
+
+	if !cg.Deletebasepath(TriremeBasePath) {
+		t.Errorf("Base path not deleted")
+	}
+}
+
+func TestV2Release(t *testing.T) {
+
+	released := make(chan string, 1)
+	cg, dir := testV2Controller(t, func(cgroupPath string) {
+		released <- cgroupPath
+	})
+	defer os.RemoveAll(dir) // nolint
+
+	if err := cg.Creategroup(testcgroupnameformat); err != nil {
+		t.Fatalf("Failed to create group %s", err)
+	}
+
+	events := dir + TriremeBasePath + testcgroupname + eventsFile
+
+	// A change that keeps the cgroup populated is not a release
+	if err := ioutil.WriteFile(events, []byte("populated 1\nfrozen 1\n"), 0644); err != nil {
+		t.Fatalf("Failed to write events file %s", err)
+	}
+
+	if err := ioutil.WriteFile(events, []byte("populated 0\nfrozen 0\n"), 0644); err != nil {
+		t.Fatalf("Failed to write events file %s", err)
+	}
+
+	select {
+	case path := <-released:
+		if path != TriremeBasePath+testcgroupname {
+			t.Errorf("Unexpected released cgroup %s", path)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Cgroup not released")
+	}
+
+	// The cgroup is released once
+	if err := ioutil.WriteFile(events, []byte("populated 0\nfrozen 0\n"), 0644); err != nil {
+		t.Fatalf("Failed to write events file %s", err)
+	}
+
+	select {
+	case path := <-released:
+		t.Errorf("Cgroup %s released twice", path)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -9,10 +9,12 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/constants"
 	"github.com/aporeto-inc/trireme/monitor"
 	"github.com/aporeto-inc/trireme/monitor/contextstore"
 	"github.com/aporeto-inc/trireme/monitor/linuxmonitor/cgnetcls"
 	"github.com/aporeto-inc/trireme/monitor/rpcmonitor"
+	"github.com/aporeto-inc/trireme/policy"
 )
 
 // LinuxProcessor captures all the monitor processor information
@@ -23,18 +25,28 @@ type LinuxProcessor struct {
 	metadataExtractor rpcmonitor.RPCMetadataExtractor
 	netcls            cgnetcls.Cgroupnetcls
 	contextStore      contextstore.ContextStore
+	cgroupV2          bool
 }
 
-// NewLinuxProcessor initializes a processor
+// NewLinuxProcessor initializes a processor. On hosts with the unified cgroup
+// hierarchy only, the processes are placed in cgroup v2 groups and the
+// release notifications are handled by the processor itself.
 func NewLinuxProcessor(collector collector.EventCollector, puHandler monitor.ProcessingUnitsHandler, metadataExtractor rpcmonitor.RPCMetadataExtractor, releasePath string) *LinuxProcessor {
 
-	return &LinuxProcessor{
+	p := &LinuxProcessor{
 		collector:         collector,
 		puHandler:         puHandler,
 		metadataExtractor: metadataExtractor,
 		netcls:            cgnetcls.NewCgroupNetController(releasePath),
 		contextStore:      contextstore.NewContextStore(),
 	}
+
+	if cgnetcls.UnifiedHierarchy() {
+		p.netcls = cgnetcls.NewCgroupV2NetController(p.release)
+		p.cgroupV2 = true
+	}
+
+	return p
 }
 
 // Create handles create events
@@ -66,39 +78,72 @@ func (s *LinuxProcessor) Start(eventInfo *rpcmonitor.EventInfo) error {
 
 	defaultIP, _ := runtimeInfo.DefaultIPAddress()
 
+	pid, _ := strconv.Atoi(eventInfo.PID)
+
+	// The rules match the path of a cgroup v2 group, which must exist
+	// before they are programmed
+	if s.cgroupV2 {
+		if err = s.setupCgroup(eventInfo.PUID, pid, runtimeInfo); err != nil {
+			return err
+		}
+	}
+
 	if perr := s.puHandler.HandlePUEvent(contextID, monitor.EventStart); perr != nil {
 		zap.L().Error("Failed to activate process", zap.Error(perr))
+		if s.cgroupV2 {
+			s.cleanupCgroup(eventInfo.PUID, pid)
+		}
 		return perr
 	}
 
+	if !s.cgroupV2 {
+		if err = s.setupCgroup(eventInfo.PUID, pid, runtimeInfo); err != nil {
+			return err
+		}
+	}
+
+	s.collector.CollectContainerEvent(&collector.ContainerRecord{
+		ContextID: contextID,
+		IPAddress: defaultIP,
+		Tags:      runtimeInfo.Tags(),
+		Event:     collector.ContainerStart,
+	})
+
+	// Store the state in the context store for future access
+	return s.contextStore.StoreContext(contextID, eventInfo)
+}
+
+// setupCgroup creates the cgroup of the PU, assigns its mark and adds the
+// process to it. The cgroup is deleted on failures.
+func (s *LinuxProcessor) setupCgroup(cgroupName string, pid int, runtimeInfo *policy.PURuntime) error {
+
 	//It is okay to launch this so let us create a cgroup for it
-	err = s.netcls.Creategroup(eventInfo.PUID)
+	err := s.netcls.Creategroup(cgroupName)
 	if err != nil {
 		return err
 	}
 
 	markval, ok := runtimeInfo.Options().Get(cgnetcls.CgroupMarkTag)
 	if !ok {
-		if derr := s.netcls.DeleteCgroup(eventInfo.PUID); derr != nil {
+		if derr := s.netcls.DeleteCgroup(cgroupName); derr != nil {
 			zap.L().Warn("Failed to clean cgroup", zap.Error(derr))
 		}
 		return errors.New("Mark value not found")
 	}
 
 	mark, _ := strconv.ParseUint(markval, 10, 32)
-	err = s.netcls.AssignMark(eventInfo.PUID, mark)
+	err = s.netcls.AssignMark(cgroupName, mark)
 	if err != nil {
-		if derr := s.netcls.DeleteCgroup(eventInfo.PUID); derr != nil {
+		if derr := s.netcls.DeleteCgroup(cgroupName); derr != nil {
 			zap.L().Warn("Failed to clean cgroup", zap.Error(derr))
 		}
 		return err
 	}
 
-	pid, _ := strconv.Atoi(eventInfo.PID)
-	err = s.netcls.AddProcess(eventInfo.PUID, pid)
+	err = s.netcls.AddProcess(cgroupName, pid)
 	if err != nil {
 
-		if derr := s.netcls.DeleteCgroup(eventInfo.PUID); derr != nil {
+		if derr := s.netcls.DeleteCgroup(cgroupName); derr != nil {
 			zap.L().Warn("Failed to clean cgroup", zap.Error(derr))
 		}
 
@@ -106,15 +151,40 @@ func (s *LinuxProcessor) Start(eventInfo *rpcmonitor.EventInfo) error {
 
 	}
 
-	s.collector.CollectContainerEvent(&collector.ContainerRecord{
-		ContextID: contextID,
-		IPAddress: defaultIP,
-		Tags:      runtimeInfo.Tags(),
-		Event:     collector.ContainerStart,
-	})
+	return nil
+}
 
-	// Store the state in the context store for future access
-	return s.contextStore.StoreContext(contextID, eventInfo)
+// cleanupCgroup moves the process out of the cgroup of the PU and deletes it
+func (s *LinuxProcessor) cleanupCgroup(cgroupName string, pid int) {
+
+	if err := s.netcls.RemoveProcess(cgroupName, pid); err != nil {
+		zap.L().Debug("Failed to remove process from cgroup", zap.Error(err))
+	}
+
+	if err := s.netcls.DeleteCgroup(cgroupName); err != nil {
+		zap.L().Warn("Failed to clean cgroup", zap.Error(err))
+	}
+}
+
+// release handles the release notifications of the cgroup v2 groups like the
+// release agent does for cgroup v1: with a stop and a destroy event
+func (s *LinuxProcessor) release(cgroupPath string) {
+
+	eventInfo := &rpcmonitor.EventInfo{
+		PUType: constants.LinuxProcessPU,
+		PUID:   cgroupPath,
+		Name:   cgroupPath,
+	}
+
+	eventInfo.EventType = monitor.EventStop
+	if err := s.Stop(eventInfo); err != nil {
+		zap.L().Warn("Failed to stop released PU", zap.String("cgroup", cgroupPath), zap.Error(err))
+	}
+
+	eventInfo.EventType = monitor.EventDestroy
+	if err := s.Destroy(eventInfo); err != nil {
+		zap.L().Warn("Failed to destroy released PU", zap.String("cgroup", cgroupPath), zap.Error(err))
+	}
 }
 
 // Stop handles a stop event
@@ -12,6 +12,7 @@ import (
 	"github.com/aporeto-inc/trireme/monitor/linuxmonitor/cgnetcls"
 	"github.com/aporeto-inc/trireme/monitor/linuxmonitor/cgnetcls/mock"
 	"github.com/aporeto-inc/trireme/monitor/rpcmonitor"
+	"github.com/aporeto-inc/trireme/policy"
 	"github.com/golang/mock/gomock"
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -285,5 +286,73 @@ func TestStart(t *testing.T) {
 			})
 		})
 
+		Convey("When I get a start event on the unified cgroup hierarchy", func() {
+			event := &rpcmonitor.EventInfo{
+				Name:      "PU",
+				PID:       "1",
+				PUID:      "12345",
+				EventType: monitor.EventStart,
+				PUType:    constants.LinuxProcessPU,
+			}
+
+			mockcls := mock_cgnetcls.NewMockCgroupnetcls(ctrl)
+			p.netcls = mockcls
+			p.cgroupV2 = true
+			p.metadataExtractor = func(event *rpcmonitor.EventInfo) (*policy.PURuntime, error) {
+				options := policy.ExtendedMap{cgnetcls.CgroupMarkTag: "100"}
+				return policy.NewPURuntime(event.Name, 1, nil, nil, constants.LinuxProcessPU, options), nil
+			}
+
+			Convey("The cgroup should be set up before the PU is started", func() {
+				puHandler.EXPECT().SetPURuntime(gomock.Any(), gomock.Any()).Return(nil)
+				gomock.InOrder(
+					mockcls.EXPECT().Creategroup("12345").Return(nil),
+					mockcls.EXPECT().AssignMark("12345", gomock.Any()).Return(nil),
+					mockcls.EXPECT().AddProcess("12345", 1).Return(nil),
+					puHandler.EXPECT().HandlePUEvent("12345", monitor.EventStart).Return(nil),
+				)
+
+				So(p.Start(event), ShouldBeNil)
+			})
+
+			Convey("The cgroup should be deleted if the PU fails to start", func() {
+				puHandler.EXPECT().SetPURuntime(gomock.Any(), gomock.Any()).Return(nil)
+				gomock.InOrder(
+					mockcls.EXPECT().Creategroup("12345").Return(nil),
+					mockcls.EXPECT().AssignMark("12345", gomock.Any()).Return(nil),
+					mockcls.EXPECT().AddProcess("12345", 1).Return(nil),
+					puHandler.EXPECT().HandlePUEvent("12345", monitor.EventStart).Return(fmt.Errorf("Error")),
+					mockcls.EXPECT().RemoveProcess("12345", 1).Return(nil),
+					mockcls.EXPECT().DeleteCgroup("12345").Return(nil),
+				)
+
+				So(p.Start(event), ShouldNotBeNil)
+			})
+		})
+
+	})
+}
+
+func TestRelease(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given a processor on the unified cgroup hierarchy", t, func() {
+		puHandler := mock_trireme.NewMockProcessingUnitsHandler(ctrl)
+		p := testLinuxProcessor(&collector.DefaultCollector{}, puHandler, rpcmonitor.DefaultRPCMetadataExtractor, "")
+		mockcls := mock_cgnetcls.NewMockCgroupnetcls(ctrl)
+		p.netcls = mockcls
+		p.cgroupV2 = true
+
+		Convey("When a cgroup is released, the PU should be stopped and destroyed", func() {
+			mockcls.EXPECT().Deletebasepath("/1234").Return(false)
+			mockcls.EXPECT().DeleteCgroup("/1234").Return(nil)
+			gomock.InOrder(
+				puHandler.EXPECT().HandlePUEvent("/1234", monitor.EventStop).Return(nil),
+				puHandler.EXPECT().HandlePUEvent("/1234", monitor.EventDestroy).Return(nil),
+			)
+
+			p.release("/trireme/1234")
+		})
 	})
 }
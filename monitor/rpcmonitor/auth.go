@@ -0,0 +1,214 @@
+package rpcmonitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/monitor/contextstore"
+	"github.com/aporeto-inc/trireme/monitor/linuxmonitor/cgnetcls"
+)
+
+// maxProcessTreeDepth bounds the walk of the process tree
+const maxProcessTreeDepth = 1024
+
+// PeerCredentials are the credentials of the process connected to the RPC
+// socket, as reported by the kernel
+type PeerCredentials struct {
+	PID int
+	UID uint32
+	GID uint32
+}
+
+// PeerPolicy is the allow-list of the processes that can send the events of
+// a PU type. A peer is allowed if its user or its group is listed.
+type PeerPolicy struct {
+	// UIDs are the users allowed to send events
+	UIDs []uint32
+	// GIDs are the groups allowed to send events
+	GIDs []uint32
+	// AnyProcess allows the peers to send events for any process. By default,
+	// the PID of an event must be the PID of the peer or of a descendant, and
+	// so must be the processes of the PU named by the PUID of the event.
+	AnyProcess bool
+}
+
+// DefaultPeerPolicy returns the policy of the PU types without a configured
+// policy: only root and the user running the monitor can send events for their
+// own process tree.
+func DefaultPeerPolicy() *PeerPolicy {
+
+	uids := []uint32{0}
+	if uid := uint32(os.Getuid()); uid != 0 {
+		uids = append(uids, uid)
+	}
+
+	return &PeerPolicy{
+		UIDs: uids,
+	}
+}
+
+// allows returns true if the policy allows the credentials
+func (p *PeerPolicy) allows(credentials *PeerCredentials) bool {
+
+	for _, uid := range p.UIDs {
+		if uid == credentials.UID {
+			return true
+		}
+	}
+
+	for _, gid := range p.GIDs {
+		if gid == credentials.GID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// peerServer is the RPC server of a connection. It authorizes the events of
+// the peer before handing them to the monitor server.
+type peerServer struct {
+	server      *Server
+	credentials *PeerCredentials
+}
+
+// HandleEvent authorizes and handles the events of the peer
+func (p *peerServer) HandleEvent(eventInfo *EventInfo, result *RPCResponse) error {
+
+	if err := p.server.authorize(p.credentials, eventInfo); err != nil {
+		zap.L().Warn("Rejected RPC event",
+			zap.Int("pid", p.credentials.PID),
+			zap.Uint32("uid", p.credentials.UID),
+			zap.Uint32("gid", p.credentials.GID),
+			zap.String("puID", eventInfo.PUID),
+			zap.String("event", string(eventInfo.EventType)),
+			zap.Error(err),
+		)
+		result.Error = err.Error()
+		return err
+	}
+
+	return p.server.HandleEvent(eventInfo, result)
+}
+
+// peerPolicy returns the policy of a PU type
+func (s *Server) peerPolicy(puType constants.PUType) *PeerPolicy {
+
+	s.RLock()
+	defer s.RUnlock()
+
+	if p, ok := s.policies[puType]; ok {
+		return p
+	}
+
+	return DefaultPeerPolicy()
+}
+
+// authorize checks that the peer is allowed to send the event
+func (s *Server) authorize(credentials *PeerCredentials, eventInfo *EventInfo) error {
+
+	if credentials == nil {
+		return fmt.Errorf("Unauthorized: unknown peer")
+	}
+
+	p := s.peerPolicy(eventInfo.PUType)
+
+	if !p.allows(credentials) {
+		return fmt.Errorf("Unauthorized: user %d and group %d are not allowed to send events for PU type %d", credentials.UID, credentials.GID, eventInfo.PUType)
+	}
+
+	if p.AnyProcess {
+		return nil
+	}
+
+	if eventInfo.PID == "" {
+		return fmt.Errorf("Unauthorized: the event has no PID")
+	}
+
+	pid, err := strconv.Atoi(eventInfo.PID)
+	if err != nil {
+		return fmt.Errorf("Unauthorized: invalid PID %s", eventInfo.PID)
+	}
+
+	if !s.descendant(pid, credentials.PID) {
+		return fmt.Errorf("Unauthorized: process %d does not belong to the process tree of %d", pid, credentials.PID)
+	}
+
+	for _, puPID := range s.processes(eventInfo.PUID) {
+		if !s.descendant(puPID, credentials.PID) {
+			return fmt.Errorf("Unauthorized: process %d of PU %s does not belong to the process tree of %d", puPID, eventInfo.PUID, credentials.PID)
+		}
+	}
+
+	return nil
+}
+
+// puProcesses returns the processes of an existing PU: the processes of its
+// cgroup or, if it has no cgroup, the process stored in its context. A new
+// PU has no process.
+func puProcesses(puID string) []int {
+
+	if puID == "" {
+		return nil
+	}
+
+	if procs, err := cgnetcls.ListCgroupProcesses(puID); err == nil {
+		pids := []int{}
+		for _, proc := range procs {
+			if pid, err := strconv.Atoi(proc); err == nil {
+				pids = append(pids, pid)
+			}
+		}
+		return pids
+	}
+
+	data, err := contextstore.NewContextStore().GetContextInfo(puID)
+	if err != nil || data == nil {
+		return nil
+	}
+
+	raw, ok := data.([]byte)
+	if !ok {
+		return nil
+	}
+
+	var stored EventInfo
+	if err := json.Unmarshal(raw, &stored); err != nil {
+		return nil
+	}
+
+	pid, err := strconv.Atoi(stored.PID)
+	if err != nil {
+		return nil
+	}
+
+	return []int{pid}
+}
+
+// descendant returns true if pid is ancestor or one of its descendants
+func (s *Server) descendant(pid int, ancestor int) bool {
+
+	for i := 0; i < maxProcessTreeDepth; i++ {
+		if pid == ancestor {
+			return true
+		}
+
+		if pid <= 1 {
+			return false
+		}
+
+		parent, err := s.parent(pid)
+		if err != nil || parent == pid {
+			return false
+		}
+
+		pid = parent
+	}
+
+	return false
+}
@@ -0,0 +1,183 @@
+package rpcmonitor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/rpc/jsonrpc"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/monitor"
+	"github.com/aporeto-inc/trireme/monitor/contextstore/mock"
+	"github.com/golang/mock/gomock"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testProcessTree is a process tree of parents by PID
+func testProcessTree(parents map[int]int) func(int) (int, error) {
+	return func(pid int) (int, error) {
+		if parent, ok := parents[pid]; ok {
+			return parent, nil
+		}
+		return 0, fmt.Errorf("No such process %d", pid)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+
+	Convey("Given a server with a process tree", t, func() {
+		s := &Server{
+			policies: map[constants.PUType]*PeerPolicy{},
+			parent:   testProcessTree(map[int]int{100: 10, 101: 100, 10: 1, 200: 1}),
+			processes: func(puID string) []int {
+				return map[string][]int{"/mine": {100, 101}, "/other": {200}}[puID]
+			},
+		}
+
+		peer := &PeerCredentials{PID: 10, UID: 1000, GID: 1000}
+		event := func(pid string) *EventInfo {
+			return &EventInfo{EventType: monitor.EventStart, PUType: constants.LinuxProcessPU, PID: pid}
+		}
+
+		Convey("When no policy is set, only root and the user of the monitor should be allowed", func() {
+			So(s.authorize(&PeerCredentials{PID: 10, UID: 0, GID: 0}, event("100")), ShouldBeNil)
+			So(s.authorize(&PeerCredentials{PID: 10, UID: uint32(os.Getuid()), GID: 1000}, event("100")), ShouldBeNil)
+			if os.Getuid() != 1000 {
+				So(s.authorize(peer, event("100")), ShouldNotBeNil)
+			}
+		})
+
+		Convey("When the user of the peer is allowed", func() {
+			s.policies[constants.LinuxProcessPU] = &PeerPolicy{UIDs: []uint32{1000}}
+
+			Convey("The events of its own process and its descendants should be allowed", func() {
+				So(s.authorize(peer, event("10")), ShouldBeNil)
+				So(s.authorize(peer, event("100")), ShouldBeNil)
+			})
+
+			Convey("The events of other processes should be rejected", func() {
+				So(s.authorize(peer, event("200")), ShouldNotBeNil)
+				So(s.authorize(peer, event("1")), ShouldNotBeNil)
+				So(s.authorize(peer, event("300")), ShouldNotBeNil)
+				So(s.authorize(peer, event("pid")), ShouldNotBeNil)
+			})
+
+			Convey("The events without PID should be rejected", func() {
+				So(s.authorize(peer, event("")), ShouldNotBeNil)
+			})
+
+			Convey("The events of a PU should be allowed only if its processes belong to its process tree", func() {
+				e := event("100")
+				e.PUID = "/mine"
+				So(s.authorize(peer, e), ShouldBeNil)
+				e.PUID = "/other"
+				So(s.authorize(peer, e), ShouldNotBeNil)
+			})
+
+			Convey("The events of other PU types should use their own policy", func() {
+				e := event("100")
+				e.PUType = constants.ContainerPU
+				if os.Getuid() != 1000 {
+					So(s.authorize(peer, e), ShouldNotBeNil)
+				}
+			})
+		})
+
+		Convey("When the group of the peer is allowed for any process, the events of other processes should be allowed", func() {
+			s.policies[constants.LinuxProcessPU] = &PeerPolicy{GIDs: []uint32{1000}, AnyProcess: true}
+			So(s.authorize(peer, event("200")), ShouldBeNil)
+			e := event("")
+			e.PUID = "/other"
+			So(s.authorize(peer, e), ShouldBeNil)
+		})
+
+		Convey("When the peer is unknown, the events should be rejected", func() {
+			So(s.authorize(nil, event("100")), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestPeerCredentials(t *testing.T) {
+
+	if runtime.GOOS != "linux" {
+		t.SkipNow()
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given a started RPC monitor", t, func() {
+		dir, err := ioutil.TempDir("", "rpcmonitor")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir) // nolint
+
+		contextlist := make(chan string, 1)
+		contextlist <- ""
+		contextstore := mock_contextstore.NewMockContextStore(ctrl)
+		contextstore.EXPECT().WalkStore().Return(contextlist, nil)
+
+		socket := filepath.Join(dir, "trireme.sock")
+		mon, err := NewRPCMonitor(socket, &CustomPolicyResolver{}, nil)
+		So(err, ShouldBeNil)
+		mon.contextstore = contextstore
+
+		processor := NewMockMonitorProcessor(ctrl)
+		So(mon.RegisterProcessor(constants.LinuxProcessPU, processor), ShouldBeNil)
+		So(mon.Start(), ShouldBeNil)
+		defer mon.Stop() // nolint
+
+		send := func(pid int) error {
+			conn, err := net.Dial("unix", socket)
+			So(err, ShouldBeNil)
+			client := jsonrpc.NewClient(conn)
+			defer client.Close() // nolint
+
+			request := &EventInfo{
+				EventType: monitor.EventStart,
+				PUType:    constants.LinuxProcessPU,
+				PUID:      "/" + strconv.Itoa(pid),
+				Name:      "test",
+				PID:       strconv.Itoa(pid),
+			}
+			return client.Call("Server.HandleEvent", request, &RPCResponse{})
+		}
+
+		Convey("When I send an event for my own process, it should be handled", func() {
+			processor.EXPECT().Start(gomock.Any()).Return(nil)
+			So(send(os.Getpid()), ShouldBeNil)
+		})
+
+		Convey("When I send an event for a process outside of my process tree, it should be rejected", func() {
+			if os.Getpid() == 1 {
+				t.SkipNow()
+			}
+			So(send(1), ShouldNotBeNil)
+		})
+
+		Convey("When my user is not allowed, the event should be rejected", func() {
+			mon.SetPeerPolicy(constants.LinuxProcessPU, &PeerPolicy{UIDs: []uint32{uint32(os.Getuid()) + 1}})
+			So(send(os.Getpid()), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestParentPID(t *testing.T) {
+
+	if runtime.GOOS != "linux" {
+		t.SkipNow()
+	}
+
+	Convey("The parent of my process should be reported", t, func() {
+		parent, err := parentPID(os.Getpid())
+		So(err, ShouldBeNil)
+		So(parent, ShouldEqual, os.Getppid())
+
+		_, err = parentPID(-1)
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,68 @@
+// +build linux
+
+package rpcmonitor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// peerCredentials returns the credentials of the process connected to a unix
+// socket
+func peerCredentials(conn net.Conn) (*PeerCredentials, error) {
+
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, fmt.Errorf("Not a unix socket connection")
+	}
+
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to access the socket: %s", err)
+	}
+
+	var ucred *syscall.Ucred
+	var cerr error
+	if err := raw.Control(func(fd uintptr) {
+		ucred, cerr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return nil, fmt.Errorf("Unable to access the socket: %s", err)
+	}
+
+	if cerr != nil {
+		return nil, fmt.Errorf("Unable to get the peer credentials: %s", cerr)
+	}
+
+	return &PeerCredentials{
+		PID: int(ucred.Pid),
+		UID: ucred.Uid,
+		GID: ucred.Gid,
+	}, nil
+}
+
+// parentPID returns the PID of the parent of a process
+func parentPID(pid int) (int, error) {
+
+	data, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return 0, err
+	}
+
+	// The command name is between parentheses and can contain spaces
+	stat := string(data)
+	end := strings.LastIndex(stat, ")")
+	if end < 0 {
+		return 0, fmt.Errorf("Invalid stat for process %d", pid)
+	}
+
+	fields := strings.Fields(stat[end+1:])
+	if len(fields) < 2 {
+		return 0, fmt.Errorf("Invalid stat for process %d", pid)
+	}
+
+	return strconv.Atoi(fields[1])
+}
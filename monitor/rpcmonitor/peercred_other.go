@@ -0,0 +1,18 @@
+// +build !linux
+
+package rpcmonitor
+
+import (
+	"fmt"
+	"net"
+)
+
+// peerCredentials is not supported: the connections are rejected
+func peerCredentials(conn net.Conn) (*PeerCredentials, error) {
+	return nil, fmt.Errorf("Peer credentials are not supported on this platform")
+}
+
+// parentPID is not supported
+func parentPID(pid int) (int, error) {
+	return 0, fmt.Errorf("Process tree is not supported on this platform")
+}
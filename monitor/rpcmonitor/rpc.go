@@ -9,6 +9,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 
 	"go.uber.org/zap"
 
@@ -30,7 +31,6 @@ type RPCEventHandler func(*EventInfo) error
 // RPCMonitor implements the RPC connection
 type RPCMonitor struct {
 	rpcAddress    string
-	rpcServer     *rpc.Server
 	monitorServer *Server
 	listensock    net.Listener
 	contextstore  contextstore.ContextStore
@@ -39,7 +39,11 @@ type RPCMonitor struct {
 
 // Server represents the Monitor RPC Server implementation
 type Server struct {
-	handlers map[constants.PUType]map[monitor.Event]RPCEventHandler
+	handlers  map[constants.PUType]map[monitor.Event]RPCEventHandler
+	policies  map[constants.PUType]*PeerPolicy
+	parent    func(pid int) (int, error)
+	processes func(puID string) []int
+	sync.RWMutex
 }
 
 // NewRPCMonitor returns a base RPC monitor. Processors must be registered externally
@@ -60,7 +64,10 @@ func NewRPCMonitor(rpcAddress string, puHandler monitor.ProcessingUnitsHandler,
 	}
 
 	monitorServer := &Server{
-		handlers: map[constants.PUType]map[monitor.Event]RPCEventHandler{},
+		handlers:  map[constants.PUType]map[monitor.Event]RPCEventHandler{},
+		policies:  map[constants.PUType]*PeerPolicy{},
+		parent:    parentPID,
+		processes: puProcesses,
 	}
 
 	r := &RPCMonitor{
@@ -70,16 +77,19 @@ func NewRPCMonitor(rpcAddress string, puHandler monitor.ProcessingUnitsHandler,
 		collector:     collector,
 	}
 
-	// Registering the monitorRPCServer as an RPC Server.
-	r.rpcServer = rpc.NewServer()
-	err := r.rpcServer.Register(r.monitorServer)
-	if err != nil {
-		zap.L().Fatal("Format of service MonitorServer isn't correct", zap.Error(err))
-	}
-
 	return r, nil
 }
 
+// SetPeerPolicy sets the allow-list of the processes that can send the events
+// of a PU type. The PU types without a policy use the DefaultPeerPolicy.
+func (r *RPCMonitor) SetPeerPolicy(puType constants.PUType, p *PeerPolicy) {
+
+	r.monitorServer.Lock()
+	defer r.monitorServer.Unlock()
+
+	r.monitorServer.policies[puType] = p
+}
+
 // RegisterProcessor registers an event processor for a given PUTYpe. Only one
 // processor is allowed for a given PU Type.
 func (r *RPCMonitor) RegisterProcessor(puType constants.PUType, processor MonitorProcessor) error {
@@ -187,10 +197,31 @@ func (r *RPCMonitor) processRequests() {
 			break
 		}
 
-		r.rpcServer.ServeCodec(jsonrpc.NewServerCodec(conn))
+		r.serveConnection(conn)
 	}
 }
 
+// serveConnection serves the events of a connection with the credentials of
+// the peer. The connections of unknown peers are closed.
+func (r *RPCMonitor) serveConnection(conn net.Conn) {
+
+	credentials, err := peerCredentials(conn)
+	if err != nil {
+		zap.L().Warn("Rejected RPC connection", zap.Error(err))
+		conn.Close() // nolint
+		return
+	}
+
+	rpcServer := rpc.NewServer()
+	if err := rpcServer.RegisterName("Server", &peerServer{server: r.monitorServer, credentials: credentials}); err != nil {
+		zap.L().Error("Format of service MonitorServer isn't correct", zap.Error(err))
+		conn.Close() // nolint
+		return
+	}
+
+	rpcServer.ServeCodec(jsonrpc.NewServerCodec(conn))
+}
+
 // Start starts the RPC monitoring.
 func (r *RPCMonitor) Start() error {
 
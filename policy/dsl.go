@@ -0,0 +1,681 @@
+package policy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Policy expressions are a text representation of tag selectors. A selector
+// is a list of clauses joined with "and":
+//
+//   app=web and env in (prod, staging) and not @usr:debug=*
+//
+// The clauses map to the operators of a KeyValueOperator:
+//
+//   key = value             Equal
+//   key = prefix*           Equal, any value that starts with prefix
+//   key in (v1, v2)         Equal
+//   key != value            NotEqual
+//   key not in (v1, v2)     NotEqual
+//   not key = value         NotEqual
+//   not key in (v1, v2)     NotEqual
+//   key = *                 KeyExists
+//   not key = *             KeyNotExists
+//   key != *                KeyNotExists
+//
+// Keys and values are words or double quoted strings. Words cannot contain
+// spaces or any of the characters = ! , ( ) ; " # and the keywords of the
+// language must be quoted when used as keys or values. Keys and values
+// cannot be empty. An empty list "()" never matches with "in" and always
+// matches with "not in".
+//
+// The values of "=" and "in" that end with * match the values that start
+// with the rest of them, whether they are quoted or not: a quoted "*"
+// matches any value of the key. Only a quoted value can have a * before
+// its end, which is literal. The values of "!=" and "not in" are always
+// literal.
+//
+// A list of selectors has one rule per line or separated by ";". A rule
+// starts with the actions of the flow policy, an optional policy and
+// service ID, followed by "if" and the selector. Comments start with "#".
+//
+//   accept,encrypt id "web-policy" if app=web and env in (prod, staging)
+//   reject,log id ssh if @port:22=*
+//   if app=db    # no flow policy
+
+// ParseError is returned when a policy expression is invalid
+type ParseError struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+// Error implements the error interface
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Message)
+}
+
+// dslKeywords are the words that must be quoted when used as keys or values
+var dslKeywords = map[string]bool{
+	"and":     true,
+	"in":      true,
+	"not":     true,
+	"if":      true,
+	"id":      true,
+	"service": true,
+	"accept":  true,
+	"reject":  true,
+	"encrypt": true,
+	"log":     true,
+}
+
+// dslActions are the keywords of the flow policy actions in rendering order
+var dslActions = []struct {
+	name   string
+	action ActionType
+}{
+	{"accept", Accept},
+	{"reject", Reject},
+	{"encrypt", Encrypt},
+	{"log", Log},
+}
+
+// dslSpecial are the characters that cannot be part of a word
+const dslSpecial = "=!,();\"#"
+
+type dslTokenType int
+
+const (
+	dslEOF dslTokenType = iota
+	dslWord
+	dslString
+	dslEqual
+	dslNotEqual
+	dslLParen
+	dslRParen
+	dslComma
+	dslSeparator
+)
+
+type dslToken struct {
+	kind   dslTokenType
+	text   string
+	line   int
+	column int
+}
+
+// dslLexer splits an expression in tokens
+type dslLexer struct {
+	input  string
+	pos    int
+	line   int
+	column int
+}
+
+func (l *dslLexer) errorf(line, column int, format string, args ...interface{}) error {
+	return &ParseError{Line: line, Column: column, Message: fmt.Sprintf(format, args...)}
+}
+
+// next returns the next rune and advances the position
+func (l *dslLexer) next() rune {
+
+	r, size := utf8.DecodeRuneInString(l.input[l.pos:])
+	l.pos += size
+	if r == '\n' {
+		l.line++
+		l.column = 1
+	} else {
+		l.column++
+	}
+
+	return r
+}
+
+// peek returns the next rune without advancing the position
+func (l *dslLexer) peek() rune {
+
+	if l.pos >= len(l.input) {
+		return -1
+	}
+
+	r, _ := utf8.DecodeRuneInString(l.input[l.pos:])
+	return r
+}
+
+// token returns the next token of the input
+func (l *dslLexer) token() (dslToken, error) {
+
+	for {
+		r := l.peek()
+		if r == '#' {
+			for r != -1 && r != '\n' {
+				l.next()
+				r = l.peek()
+			}
+		}
+		if r == -1 || r == '\n' || !unicode.IsSpace(r) {
+			break
+		}
+		l.next()
+	}
+
+	t := dslToken{line: l.line, column: l.column}
+
+	r := l.peek()
+	switch {
+	case r == -1:
+		t.kind = dslEOF
+		return t, nil
+
+	case r == '\n' || r == ';':
+		l.next()
+		t.kind = dslSeparator
+		return t, nil
+
+	case r == '=':
+		l.next()
+		t.kind = dslEqual
+		return t, nil
+
+	case r == '!':
+		l.next()
+		if l.peek() != '=' {
+			return t, l.errorf(t.line, t.column, "expected != operator")
+		}
+		l.next()
+		t.kind = dslNotEqual
+		return t, nil
+
+	case r == '(':
+		l.next()
+		t.kind = dslLParen
+		return t, nil
+
+	case r == ')':
+		l.next()
+		t.kind = dslRParen
+		return t, nil
+
+	case r == ',':
+		l.next()
+		t.kind = dslComma
+		return t, nil
+
+	case r == '"':
+		start := l.pos
+		l.next()
+		for {
+			c := l.next()
+			if c == '\n' || c == utf8.RuneError && l.pos >= len(l.input) {
+				return t, l.errorf(t.line, t.column, "unterminated string")
+			}
+			if c == '\\' {
+				l.next()
+				continue
+			}
+			if c == '"' {
+				break
+			}
+		}
+		value, err := strconv.Unquote(l.input[start:l.pos])
+		if err != nil {
+			return t, l.errorf(t.line, t.column, "invalid string %s", l.input[start:l.pos])
+		}
+		t.kind = dslString
+		t.text = value
+		return t, nil
+	}
+
+	start := l.pos
+	for {
+		c := l.peek()
+		if c == -1 || unicode.IsSpace(c) || strings.ContainsRune(dslSpecial, c) {
+			break
+		}
+		l.next()
+	}
+	t.kind = dslWord
+	t.text = l.input[start:l.pos]
+
+	return t, nil
+}
+
+// dslParser parses the tokens of an expression
+type dslParser struct {
+	lexer *dslLexer
+	tok   dslToken
+}
+
+func newDSLParser(input string) (*dslParser, error) {
+
+	p := &dslParser{
+		lexer: &dslLexer{input: input, line: 1, column: 1},
+	}
+
+	return p, p.advance()
+}
+
+func (p *dslParser) advance() (err error) {
+	p.tok, err = p.lexer.token()
+	return err
+}
+
+func (p *dslParser) errorf(format string, args ...interface{}) error {
+	return p.lexer.errorf(p.tok.line, p.tok.column, format, args...)
+}
+
+// isKeyword returns true if the current token is the given keyword
+func (p *dslParser) isKeyword(keyword string) bool {
+	return p.tok.kind == dslWord && p.tok.text == keyword
+}
+
+// describe returns a printable version of the current token for errors
+func (p *dslParser) describe() string {
+
+	switch p.tok.kind {
+	case dslEOF:
+		return "end of input"
+	case dslSeparator:
+		return "end of rule"
+	case dslEqual:
+		return "\"=\""
+	case dslNotEqual:
+		return "\"!=\""
+	case dslLParen:
+		return "\"(\""
+	case dslRParen:
+		return "\")\""
+	case dslComma:
+		return "\",\""
+	case dslString:
+		return strconv.Quote(p.tok.text)
+	}
+
+	return "\"" + p.tok.text + "\""
+}
+
+// value parses a key or a value. The second return is true for a word.
+func (p *dslParser) value(what string) (string, bool, error) {
+
+	if p.tok.kind == dslString {
+		v := p.tok.text
+		return v, false, p.advance()
+	}
+
+	if p.tok.kind != dslWord || dslKeywords[p.tok.text] {
+		return "", false, p.errorf("expected %s, found %s", what, p.describe())
+	}
+
+	v := p.tok.text
+	if strings.Contains(v[:len(v)-1], "*") {
+		return "", false, p.errorf("* is only allowed at the end of %s", what)
+	}
+
+	return v, true, p.advance()
+}
+
+// tagValue parses a key or a value of a clause, which cannot be empty
+func (p *dslParser) tagValue(what string) (string, bool, error) {
+
+	if p.tok.kind == dslString && p.tok.text == "" {
+		return "", false, p.errorf("%s cannot be empty", what)
+	}
+
+	return p.value(what)
+}
+
+// valueList parses a list of values in parentheses
+func (p *dslParser) valueList() ([]string, error) {
+
+	if p.tok.kind != dslLParen {
+		return nil, p.errorf("expected \"(\", found %s", p.describe())
+	}
+
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	values := []string{}
+	if p.tok.kind == dslRParen {
+		return values, p.advance()
+	}
+
+	for {
+		v, _, err := p.tagValue("a value")
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+
+		if p.tok.kind == dslRParen {
+			return values, p.advance()
+		}
+
+		if p.tok.kind != dslComma {
+			return nil, p.errorf("expected \",\" or \")\", found %s", p.describe())
+		}
+
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// clause parses a single clause of a selector
+func (p *dslParser) clause() (KeyValueOperator, error) {
+
+	negated := false
+	if p.isKeyword("not") {
+		negated = true
+		if err := p.advance(); err != nil {
+			return KeyValueOperator{}, err
+		}
+	}
+
+	key, _, err := p.tagValue("a key")
+	if err != nil {
+		return KeyValueOperator{}, err
+	}
+
+	kv := KeyValueOperator{Key: key}
+
+	switch {
+	case p.tok.kind == dslEqual || p.tok.kind == dslNotEqual:
+		notEqual := p.tok.kind == dslNotEqual
+		if notEqual && negated {
+			return kv, p.errorf("!= cannot be negated")
+		}
+		if err := p.advance(); err != nil {
+			return kv, err
+		}
+
+		v, word, err := p.tagValue("a value")
+		if err != nil {
+			return kv, err
+		}
+
+		if word && v == "*" {
+			kv.Operator = KeyExists
+			if negated || notEqual {
+				kv.Operator = KeyNotExists
+			}
+			return kv, nil
+		}
+
+		kv.Value = []string{v}
+		kv.Operator = Equal
+		if negated || notEqual {
+			kv.Operator = NotEqual
+		}
+		return kv, nil
+
+	case p.isKeyword("in"):
+		if err := p.advance(); err != nil {
+			return kv, err
+		}
+		kv.Operator = Equal
+		if negated {
+			kv.Operator = NotEqual
+		}
+
+	case p.isKeyword("not"):
+		if negated {
+			return kv, p.errorf("not in cannot be negated")
+		}
+		if err := p.advance(); err != nil {
+			return kv, err
+		}
+		if !p.isKeyword("in") {
+			return kv, p.errorf("expected \"in\", found %s", p.describe())
+		}
+		if err := p.advance(); err != nil {
+			return kv, err
+		}
+		kv.Operator = NotEqual
+
+	default:
+		return kv, p.errorf("expected an operator after %s, found %s", key, p.describe())
+	}
+
+	kv.Value, err = p.valueList()
+
+	return kv, err
+}
+
+// selector parses the clauses of a selector
+func (p *dslParser) selector() ([]KeyValueOperator, error) {
+
+	clauses := []KeyValueOperator{}
+	for {
+		c, err := p.clause()
+		if err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, c)
+
+		if !p.isKeyword("and") {
+			return clauses, nil
+		}
+
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// flowPolicy parses the actions and IDs of a rule up to the "if" keyword
+func (p *dslParser) flowPolicy() (*FlowPolicy, error) {
+
+	var plc *FlowPolicy
+
+	for !p.isKeyword("if") {
+
+		if plc == nil {
+			plc = &FlowPolicy{}
+		}
+
+		switch {
+		case p.isKeyword("id") || p.isKeyword("service"):
+			service := p.isKeyword("service")
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			v, _, err := p.value("an ID")
+			if err != nil {
+				return nil, err
+			}
+			if service {
+				plc.ServiceID = v
+			} else {
+				plc.PolicyID = v
+			}
+			continue
+
+		case p.tok.kind == dslComma && plc.Action != 0:
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+		}
+
+		found := false
+		for _, a := range dslActions {
+			if p.isKeyword(a.name) {
+				plc.Action |= a.action
+				found = true
+			}
+		}
+
+		if !found {
+			return nil, p.errorf("expected an action, \"id\", \"service\" or \"if\", found %s", p.describe())
+		}
+
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+
+	return plc, p.advance()
+}
+
+// end checks that the rule is terminated
+func (p *dslParser) end() error {
+
+	if p.tok.kind != dslSeparator && p.tok.kind != dslEOF {
+		return p.errorf("expected \"and\" or end of rule, found %s", p.describe())
+	}
+
+	return nil
+}
+
+// ParseTagSelector parses a selector expression. The selector has no flow policy.
+func ParseTagSelector(expression string) (TagSelector, error) {
+
+	p, err := newDSLParser(expression)
+	if err != nil {
+		return TagSelector{}, err
+	}
+
+	clauses, err := p.selector()
+	if err != nil {
+		return TagSelector{}, err
+	}
+
+	if p.tok.kind != dslEOF {
+		return TagSelector{}, p.errorf("expected \"and\" or end of input, found %s", p.describe())
+	}
+
+	return TagSelector{Clause: clauses}, nil
+}
+
+// ParseTagSelectorList parses a list of rules with their flow policies
+func ParseTagSelectorList(rules string) (TagSelectorList, error) {
+
+	p, err := newDSLParser(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	list := TagSelectorList{}
+	for p.tok.kind != dslEOF {
+
+		if p.tok.kind == dslSeparator {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		plc, err := p.flowPolicy()
+		if err != nil {
+			return nil, err
+		}
+
+		clauses, err := p.selector()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := p.end(); err != nil {
+			return nil, err
+		}
+
+		list = append(list, TagSelector{Clause: clauses, Policy: plc})
+	}
+
+	return list, nil
+}
+
+// renderValue quotes the keys and values that are not valid words
+func renderValue(v string) string {
+
+	if v == "" || v == "*" || dslKeywords[v] || strings.ContainsAny(v, dslSpecial) || strings.IndexFunc(v, unicode.IsSpace) >= 0 || strings.Contains(v[:len(v)-1], "*") {
+		return strconv.Quote(v)
+	}
+
+	return v
+}
+
+// renderValueList renders a list of values in parentheses
+func renderValueList(values []string) string {
+
+	rendered := make([]string, len(values))
+	for i, v := range values {
+		rendered[i] = renderValue(v)
+	}
+
+	return "(" + strings.Join(rendered, ", ") + ")"
+}
+
+// RenderTagSelector renders the clauses of a selector as an expression
+func RenderTagSelector(selector TagSelector) string {
+
+	clauses := make([]string, len(selector.Clause))
+
+	for i, c := range selector.Clause {
+		key := renderValue(c.Key)
+
+		switch c.Operator {
+		case KeyExists:
+			clauses[i] = key + "=*"
+		case KeyNotExists:
+			clauses[i] = "not " + key + "=*"
+		case NotEqual:
+			if len(c.Value) == 1 {
+				clauses[i] = key + "!=" + renderValue(c.Value[0])
+			} else {
+				clauses[i] = key + " not in " + renderValueList(c.Value)
+			}
+		default:
+			if len(c.Value) == 1 {
+				clauses[i] = key + "=" + renderValue(c.Value[0])
+			} else {
+				clauses[i] = key + " in " + renderValueList(c.Value)
+			}
+		}
+	}
+
+	return strings.Join(clauses, " and ")
+}
+
+// RenderTagSelectorList renders a list of selectors with their flow
+// policies, one rule per line. It fails if a policy has actions that the
+// language cannot express.
+func RenderTagSelectorList(list TagSelectorList) (string, error) {
+
+	lines := make([]string, len(list))
+
+	for i, selector := range list {
+
+		parts := []string{}
+
+		if plc := selector.Policy; plc != nil {
+			if unknown := plc.Action &^ (Accept | Reject | Encrypt | Log); unknown != 0 {
+				return "", fmt.Errorf("Unknown actions 0x%x in the policy of rule %d", uint8(unknown), i+1)
+			}
+
+			actions := []string{}
+			for _, a := range dslActions {
+				if plc.Action&a.action != 0 {
+					actions = append(actions, a.name)
+				}
+			}
+			if len(actions) > 0 {
+				parts = append(parts, strings.Join(actions, ","))
+			}
+			if plc.PolicyID != "" || (len(actions) == 0 && plc.ServiceID == "") {
+				parts = append(parts, "id "+strconv.Quote(plc.PolicyID))
+			}
+			if plc.ServiceID != "" {
+				parts = append(parts, "service "+strconv.Quote(plc.ServiceID))
+			}
+		}
+
+		parts = append(parts, "if", RenderTagSelector(selector))
+		lines[i] = strings.Join(parts, " ")
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
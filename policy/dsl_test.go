@@ -0,0 +1,183 @@
+package policy
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseTagSelector(t *testing.T) {
+
+	Convey("When I parse a selector with all the operators", t, func() {
+		s, err := ParseTagSelector(`app=web and env in (prod, staging) and not @usr:debug=* and team=ops* and lang != java and os not in (linux, "bsd os") and dc=* and not tier=db and zone!=*`)
+
+		Convey("I should get the clauses", func() {
+			So(err, ShouldBeNil)
+			So(s.Policy, ShouldBeNil)
+			So(s.Clause, ShouldResemble, []KeyValueOperator{
+				{Key: "app", Value: []string{"web"}, Operator: Equal},
+				{Key: "env", Value: []string{"prod", "staging"}, Operator: Equal},
+				{Key: "@usr:debug", Operator: KeyNotExists},
+				{Key: "team", Value: []string{"ops*"}, Operator: Equal},
+				{Key: "lang", Value: []string{"java"}, Operator: NotEqual},
+				{Key: "os", Value: []string{"linux", "bsd os"}, Operator: NotEqual},
+				{Key: "dc", Operator: KeyExists},
+				{Key: "tier", Value: []string{"db"}, Operator: NotEqual},
+				{Key: "zone", Operator: KeyNotExists},
+			})
+		})
+	})
+
+	Convey("When I parse a selector with quoted keywords and stars", t, func() {
+		s, err := ParseTagSelector(`"and"="in" and key="*" and name="a*b" and team in ("ops*")`)
+
+		Convey("The keywords should be literal and the trailing stars should stay prefixes", func() {
+			So(err, ShouldBeNil)
+			So(s.Clause, ShouldResemble, []KeyValueOperator{
+				{Key: "and", Value: []string{"in"}, Operator: Equal},
+				{Key: "key", Value: []string{"*"}, Operator: Equal},
+				{Key: "name", Value: []string{"a*b"}, Operator: Equal},
+				{Key: "team", Value: []string{"ops*"}, Operator: Equal},
+			})
+		})
+	})
+
+	Convey("When I parse a selector with empty lists", t, func() {
+		s, err := ParseTagSelector(`app in () and env not in ()`)
+
+		Convey("I should get clauses without values", func() {
+			So(err, ShouldBeNil)
+			So(s.Clause, ShouldResemble, []KeyValueOperator{
+				{Key: "app", Value: []string{}, Operator: Equal},
+				{Key: "env", Value: []string{}, Operator: NotEqual},
+			})
+		})
+	})
+
+	Convey("When I parse invalid selectors, I should get the position of the error", t, func() {
+		tests := []struct {
+			expression string
+			line       int
+			column     int
+		}{
+			{"app=web and", 1, 12},
+			{"app=web env=prod", 1, 9},
+			{"app in (web, prod", 1, 18},
+			{"app in web", 1, 8},
+			{"app", 1, 4},
+			{"app=w*b", 1, 5},
+			{"app ! web", 1, 5},
+			{`app="web`, 1, 5},
+			{"not app != web", 1, 9},
+			{"and=web", 1, 1},
+			{`app=""`, 1, 5},
+			{`app!=""`, 1, 6},
+			{`app in (web, "")`, 1, 14},
+			{`""=web`, 1, 1},
+		}
+
+		for _, test := range tests {
+			_, err := ParseTagSelector(test.expression)
+			So(err, ShouldNotBeNil)
+			perr, ok := err.(*ParseError)
+			So(ok, ShouldBeTrue)
+			So(perr.Line, ShouldEqual, test.line)
+			So(perr.Column, ShouldEqual, test.column)
+		}
+	})
+}
+
+func TestParseTagSelectorList(t *testing.T) {
+
+	Convey("When I parse a list of rules", t, func() {
+		l, err := ParseTagSelectorList(`
+# web traffic
+accept,encrypt id "web-policy" if app=web and env in (prod, staging)
+reject, log id ssh service svc if @port:22=*; if app=db
+`)
+
+		Convey("I should get the selectors with their policies", func() {
+			So(err, ShouldBeNil)
+			So(len(l), ShouldEqual, 3)
+			So(l[0].Policy, ShouldResemble, &FlowPolicy{Action: Accept | Encrypt, PolicyID: "web-policy"})
+			So(len(l[0].Clause), ShouldEqual, 2)
+			So(l[1].Policy, ShouldResemble, &FlowPolicy{Action: Reject | Log, PolicyID: "ssh", ServiceID: "svc"})
+			So(l[1].Clause, ShouldResemble, []KeyValueOperator{{Key: "@port:22", Operator: KeyExists}})
+			So(l[2].Policy, ShouldBeNil)
+		})
+	})
+
+	Convey("When I parse an invalid rule, I should get the line of the error", t, func() {
+		_, err := ParseTagSelectorList("accept if app=web\nallow if app=db")
+		So(err, ShouldNotBeNil)
+		perr, ok := err.(*ParseError)
+		So(ok, ShouldBeTrue)
+		So(perr.Line, ShouldEqual, 2)
+		So(perr.Column, ShouldEqual, 1)
+	})
+
+	Convey("When I parse a rule without a selector, I should get an error", t, func() {
+		_, err := ParseTagSelectorList("accept")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestRenderTagSelector(t *testing.T) {
+
+	Convey("Given a list of selectors", t, func() {
+		l := TagSelectorList{
+			TagSelector{
+				Clause: []KeyValueOperator{
+					{Key: "app", Value: []string{"web"}, Operator: Equal},
+					{Key: "env", Value: []string{"prod", "staging"}, Operator: Equal},
+					{Key: "@usr:debug", Operator: KeyNotExists},
+					{Key: "lang", Value: []string{"java"}, Operator: NotEqual},
+					{Key: "os", Value: []string{"linux", "bsd os"}, Operator: NotEqual},
+					{Key: "dc", Operator: KeyExists},
+					{Key: "in", Value: []string{"*", "a b"}, Operator: Equal},
+					{Key: "zone", Value: []string{}, Operator: Equal},
+				},
+				Policy: &FlowPolicy{Action: Accept | Log, PolicyID: "p1", ServiceID: "s1"},
+			},
+			TagSelector{
+				Clause: []KeyValueOperator{
+					{Key: "team", Value: []string{"ops*"}, Operator: Equal},
+				},
+				Policy: &FlowPolicy{},
+			},
+			TagSelector{
+				Clause: []KeyValueOperator{
+					{Key: "app", Value: []string{"db"}, Operator: Equal},
+				},
+			},
+		}
+
+		Convey("When I render a selector, I should get the expression", func() {
+			So(RenderTagSelector(l[0]), ShouldEqual, `app=web and env in (prod, staging) and not @usr:debug=* and lang!=java and os not in (linux, "bsd os") and dc=* and "in" in ("*", "a b") and zone in ()`)
+		})
+
+		Convey("When I render the list and parse it back, I should get the same list", func() {
+			text, err := RenderTagSelectorList(l)
+			So(err, ShouldBeNil)
+			parsed, err := ParseTagSelectorList(text)
+			So(err, ShouldBeNil)
+			So(parsed, ShouldResemble, l)
+		})
+
+		Convey("When I render a policy with unknown actions, I should get an error", func() {
+			_, err := RenderTagSelectorList(TagSelectorList{
+				TagSelector{
+					Clause: []KeyValueOperator{{Key: "app", Value: []string{"db"}, Operator: Equal}},
+					Policy: &FlowPolicy{Action: Accept | 0x40},
+				},
+			})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When I render a single selector and parse it back, I should get the same clauses", func() {
+			s, err := ParseTagSelector(RenderTagSelector(l[0]))
+			So(err, ShouldBeNil)
+			So(s.Clause, ShouldResemble, l[0].Clause)
+		})
+	})
+}
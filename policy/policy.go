@@ -30,6 +30,9 @@ type PUPolicy struct {
 	triremeNetworks []string
 	// excludedNetworks a list of networks that must be excluded
 	excludedNetworks []string
+	// frozen is true for the policy of a paused PU. The established flows
+	// are not revalidated against it.
+	frozen bool
 
 	sync.Mutex
 }
@@ -42,6 +45,10 @@ const (
 	AllowAll = 0x1
 	// Police filters on the PU based on the PolicyRules.
 	Police = 0x2
+	// Audit evaluates the PolicyRules of the PU and reports the decisions,
+	// but never drops a packet. The token exchange is still performed and
+	// invalid tokens are still rejected.
+	Audit = 0x4
 )
 
 // NewPUPolicy generates a new ContainerPolicyInfo
@@ -122,6 +129,7 @@ func (p *PUPolicy) Clone() *PUPolicy {
 		p.triremeNetworks,
 		p.excludedNetworks,
 	)
+	np.frozen = p.frozen
 
 	return np
 }
@@ -150,6 +158,22 @@ func (p *PUPolicy) SetTriremeAction(action PUAction) {
 	p.triremeAction = action
 }
 
+// Frozen returns true if the policy is the policy of a paused PU
+func (p *PUPolicy) Frozen() bool {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.frozen
+}
+
+// SetFrozen sets whether the policy is the policy of a paused PU
+func (p *PUPolicy) SetFrozen(frozen bool) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.frozen = frozen
+}
+
 // ApplicationACLs returns a copy of IPRuleList
 func (p *PUPolicy) ApplicationACLs() IPRuleList {
 	p.Lock()
@@ -249,6 +273,17 @@ func (p *PUPolicy) DefaultIPAddress() (string, bool) {
 	return "0.0.0.0/0", false
 }
 
+// DefaultIPv6Address returns the default IPv6 address for the processing unit
+func (p *PUPolicy) DefaultIPv6Address() (string, bool) {
+	p.Lock()
+	defer p.Unlock()
+
+	if ip, ok := p.ips[DefaultNamespaceIPv6]; ok {
+		return ip, true
+	}
+	return "::/0", false
+}
+
 // TriremeNetworks  returns the list of networks that Trireme must be applied
 func (p *PUPolicy) TriremeNetworks() []string {
 	p.Lock()
@@ -350,6 +350,20 @@ func TestAllLockedSetGet(t *testing.T) {
 			So(defaultIP, ShouldResemble, "0.0.0.0/0")
 		})
 
+		Convey("If I update the IPs with an IPv6 address, I should get the default IPv6 address", func() {
+			p.SetIPAddresses(ExtendedMap{DefaultNamespace: "40.0.0.1", DefaultNamespaceIPv6: "2001:db8::1"})
+			defaultIP, ok := p.DefaultIPv6Address()
+			So(ok, ShouldBeTrue)
+			So(defaultIP, ShouldResemble, "2001:db8::1")
+		})
+
+		Convey("If I update the IPs without an IPv6 address, I should get the any IPv6 network", func() {
+			p.SetIPAddresses(ExtendedMap{DefaultNamespace: "40.0.0.1"})
+			defaultIP, ok := p.DefaultIPv6Address()
+			So(ok, ShouldBeFalse)
+			So(defaultIP, ShouldResemble, "::/0")
+		})
+
 		Convey("If I update the trireme networks it should succeed", func() {
 			p.UpdateTriremeNetworks([]string{"123.0.0.0/8"})
 			So(p.TriremeNetworks(), ShouldResemble, []string{"123.0.0.0/8"})
@@ -168,6 +168,16 @@ func (r *PURuntime) DefaultIPAddress() (string, bool) {
 	return ip, ok
 }
 
+// DefaultIPv6Address returns the default IPv6 address for the processing unit
+func (r *PURuntime) DefaultIPv6Address() (string, bool) {
+	r.Lock()
+	defer r.Unlock()
+
+	ip, ok := r.ips[DefaultNamespaceIPv6]
+
+	return ip, ok
+}
+
 // IPAddresses returns all the IP addresses for the processing unit
 func (r *PURuntime) IPAddresses() ExtendedMap {
 	r.Lock()
@@ -3,6 +3,9 @@ package policy
 const (
 	// DefaultNamespace is the default namespace for applying policy
 	DefaultNamespace = "bridge"
+	// DefaultNamespaceIPv6 is the default namespace for the IPv6 address
+	// of a processing unit
+	DefaultNamespaceIPv6 = "bridge6"
 )
 
 // Operator defines the operation between your key and value.
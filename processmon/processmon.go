@@ -19,6 +19,7 @@ import (
 	"github.com/aporeto-inc/trireme/cache"
 	"github.com/aporeto-inc/trireme/crypto"
 	"github.com/aporeto-inc/trireme/enforcer/utils/rpcwrapper"
+	"github.com/aporeto-inc/trireme/metrics"
 	"github.com/kardianos/osext"
 )
 
@@ -27,9 +28,15 @@ var (
 	GlobalCommandArgs map[string]interface{}
 )
 
+// restartWindow is the time during which a new launch for the same context
+// is considered a restart of the remote enforcer
+const restartWindow = 10 * time.Minute
+
 //ProcessMon exported
 type ProcessMon struct {
 	activeProcesses *cache.Cache
+	// launchedProcesses keeps the contexts recently launched to detect restarts
+	launchedProcesses *cache.Cache
 }
 
 var launcher *ProcessMon
@@ -85,6 +92,7 @@ func collectChildExitStatus() {
 			zap.Int("pid", exitStatus.process),
 			zap.Error(exitStatus.exitStatus),
 		)
+		metrics.RemoteEnforcerExited(exitStatus.exitStatus)
 	}
 }
 
@@ -293,13 +301,20 @@ func (p *ProcessMon) LaunchProcess(contextID string, refPid int, rpchdl rpcwrapp
 		RPCHdl:  rpchdl,
 		deleted: false})
 
+	_, err = p.launchedProcesses.Get(contextID)
+	metrics.RemoteEnforcerLaunched(err == nil)
+	p.launchedProcesses.AddOrUpdate(contextID, refPid)
+
 	return nil
 }
 
 //NewProcessMon is a method to create a new processmon
 func newProcessMon() ProcessManager {
 
-	launcher = &ProcessMon{activeProcesses: cache.NewCache()}
+	launcher = &ProcessMon{
+		activeProcesses:   cache.NewCache(),
+		launchedProcesses: cache.NewCacheWithExpiration(restartWindow),
+	}
 	return launcher
 }
 
@@ -0,0 +1,170 @@
+package supervisor
+
+import (
+	"fmt"
+
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+// dualStackImplementor programs the same processing unit in an IPv4 and an
+// IPv6 implementation. Each implementation only programs the addresses of
+// its own family.
+type dualStackImplementor struct {
+	ipv4 Implementor
+	ipv6 Implementor
+}
+
+// newDualStackImplementor returns an implementor that uses both implementations
+func newDualStackImplementor(ipv4, ipv6 Implementor) Implementor {
+
+	return &dualStackImplementor{
+		ipv4: ipv4,
+		ipv6: ipv6,
+	}
+}
+
+// ConfigureRules implements the ConfigureRules interface
+func (d *dualStackImplementor) ConfigureRules(version int, contextID string, containerInfo *policy.PUInfo) error {
+
+	if err := d.ipv4.ConfigureRules(version, contextID, containerInfo); err != nil {
+		return err
+	}
+
+	if err := d.ipv6.ConfigureRules(version, contextID, containerInfo); err != nil {
+		return fmt.Errorf("Failed to configure IPv6 rules: %s", err)
+	}
+
+	return nil
+}
+
+// UpdateRules implements the UpdateRules interface
+func (d *dualStackImplementor) UpdateRules(version int, contextID string, containerInfo *policy.PUInfo) error {
+
+	if err := d.ipv4.UpdateRules(version, contextID, containerInfo); err != nil {
+		return err
+	}
+
+	if err := d.ipv6.UpdateRules(version, contextID, containerInfo); err != nil {
+		return fmt.Errorf("Failed to update IPv6 rules: %s", err)
+	}
+
+	return nil
+}
+
+// DeleteRules implements the DeleteRules interface. Both implementations
+// are always cleaned up.
+func (d *dualStackImplementor) DeleteRules(version int, contextID string, ipAddresses policy.ExtendedMap, port string, mark string) error {
+
+	err4 := d.ipv4.DeleteRules(version, contextID, ipAddresses, port, mark)
+	err6 := d.ipv6.DeleteRules(version, contextID, ipAddresses, port, mark)
+
+	if err4 != nil {
+		return err4
+	}
+
+	if err6 != nil {
+		return fmt.Errorf("Failed to delete IPv6 rules: %s", err6)
+	}
+
+	return nil
+}
+
+// SetTargetNetworks implements the SetTargetNetworks interface
+func (d *dualStackImplementor) SetTargetNetworks(current, networks []string) error {
+
+	if err := d.ipv4.SetTargetNetworks(current, networks); err != nil {
+		return err
+	}
+
+	if err := d.ipv6.SetTargetNetworks(current, networks); err != nil {
+		return fmt.Errorf("Failed to set IPv6 target networks: %s", err)
+	}
+
+	return nil
+}
+
+// Start implements the Start interface
+func (d *dualStackImplementor) Start() error {
+
+	if err := d.ipv4.Start(); err != nil {
+		return err
+	}
+
+	if err := d.ipv6.Start(); err != nil {
+		return fmt.Errorf("Failed to start IPv6 controller: %s", err)
+	}
+
+	return nil
+}
+
+// Stop implements the Stop interface. The IPv6 implementation is stopped
+// first since the IPv4 one cleans up all the shared resources.
+func (d *dualStackImplementor) Stop() error {
+
+	err6 := d.ipv6.Stop()
+	err4 := d.ipv4.Stop()
+
+	if err4 != nil {
+		return err4
+	}
+
+	return err6
+}
+
+// Drift implements the DriftDetector interface for the implementations that
+// support it
+func (d *dualStackImplementor) Drift(version int, contextID string, containerInfo *policy.PUInfo) ([]string, error) {
+
+	drift := []string{}
+
+	if detector, ok := d.ipv4.(DriftDetector); ok {
+		ipv4, err := detector.Drift(version, contextID, containerInfo)
+		if err != nil {
+			return nil, err
+		}
+		drift = append(drift, ipv4...)
+	}
+
+	if detector, ok := d.ipv6.(DriftDetector); ok {
+		ipv6, err := detector.Drift(version, contextID, containerInfo)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to check IPv6 rules: %s", err)
+		}
+		for _, description := range ipv6 {
+			drift = append(drift, "IPv6 "+description)
+		}
+	}
+
+	return drift, nil
+}
+
+// RenderRules implements the Renderer interface. The rules of the IPv6
+// implementation follow the rules of the IPv4 one.
+func (d *dualStackImplementor) RenderRules(version int, contextID string, containerInfo *policy.PUInfo) (string, error) {
+
+	renderer, ok := d.ipv4.(Renderer)
+	if !ok {
+		return "", fmt.Errorf("Rendering is not supported by the implementation")
+	}
+
+	ipv4, err := renderer.RenderRules(version, contextID, containerInfo)
+	if err != nil {
+		return "", err
+	}
+
+	renderer, ok = d.ipv6.(Renderer)
+	if !ok {
+		return ipv4, nil
+	}
+
+	ipv6, err := renderer.RenderRules(version, contextID, containerInfo)
+	if err != nil {
+		return "", fmt.Errorf("Failed to render IPv6 rules: %s", err)
+	}
+
+	if ipv6 == "" {
+		return ipv4, nil
+	}
+
+	return ipv4 + "# IPv6\n" + ipv6, nil
+}
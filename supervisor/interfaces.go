@@ -42,3 +42,22 @@ type Implementor interface {
 	// Stop cleans up state
 	Stop() error
 }
+
+// DriftDetector is implemented by the implementations that can verify that
+// the rules of a processing unit are still programmed as expected
+type DriftDetector interface {
+
+	// Drift returns a description of every difference between the programmed
+	// rules of the processing unit and the rules of its policy
+	Drift(version int, contextID string, containerInfo *policy.PUInfo) ([]string, error)
+}
+
+// Renderer is implemented by the implementations that can render the rules
+// of a processing unit without programming them
+type Renderer interface {
+
+	// RenderRules returns the rules that ConfigureRules programs for the
+	// processing unit in the format of the implementation. The global rules
+	// programmed when the implementation starts are not rendered.
+	RenderRules(version int, contextID string, containerInfo *policy.PUInfo) (string, error)
+}
@@ -9,26 +9,41 @@ import (
 
 	"github.com/aporeto-inc/trireme/constants"
 	"github.com/aporeto-inc/trireme/enforcer/utils/packet"
+	"github.com/aporeto-inc/trireme/monitor/linuxmonitor/cgnetcls"
 	"github.com/aporeto-inc/trireme/policy"
 )
 
-func (i *Instance) cgroupChainRules(appChain string, netChain string, mark string, port string) [][]string {
+// cgroupMatch returns the match of the traffic of the cgroup of a PU. The
+// net_cls classid is matched on cgroup v1 and the path of the cgroup on the
+// unified hierarchy.
+func (i *Instance) cgroupMatch(contextID string, mark string) []string {
+
+	if i.cgroupV2 {
+		return []string{"-m", "cgroup", "--path", cgnetcls.CgroupPath(contextID)}
+	}
+
+	return []string{"-m", "cgroup", "--cgroup", mark}
+}
+
+func (i *Instance) cgroupChainRules(contextID string, appChain string, netChain string, mark string, port string) [][]string {
+
+	match := i.cgroupMatch(contextID, mark)
 
 	str := [][]string{
-		{
+		append(append([]string{
 			i.appAckPacketIPTableContext,
 			i.appCgroupIPTableSection,
-			"-m", "cgroup", "--cgroup", mark,
+		}, match...),
 			"-m", "comment", "--comment", "Server-specific-chain",
 			"-j", "MARK", "--set-mark", mark,
-		},
-		{
+		),
+		append(append([]string{
 			i.appAckPacketIPTableContext,
 			i.appCgroupIPTableSection,
-			"-m", "cgroup", "--cgroup", mark,
+		}, match...),
 			"-m", "comment", "--comment", "Server-specific-chain",
 			"-j", appChain,
-		},
+		),
 
 		{
 			i.netPacketIPTableContext,
@@ -39,6 +54,16 @@ func (i *Instance) cgroupChainRules(appChain string, netChain string, mark strin
 			"-m", "comment", "--comment", "Container-specific-chain",
 			"-j", netChain,
 		},
+
+		{
+			i.netPacketIPTableContext,
+			i.netPacketIPTableSection,
+			"-p", "udp",
+			"-m", "multiport",
+			"--destination-ports", port,
+			"-m", "comment", "--comment", "Container-specific-chain",
+			"-j", netChain,
+		},
 	}
 
 	return str
@@ -89,14 +114,14 @@ func (i *Instance) trapRules(appChain string, netChain string) [][]string {
 		// Application Packets - SYN
 		rules = append(rules, []string{
 			i.appPacketIPTableContext, appChain,
-			"-m", "set", "--match-set", targetNetworkSet, "dst",
+			"-m", "set", "--match-set", i.targetNetworkSet, "dst",
 			"-p", "tcp", "--tcp-flags", "FIN,SYN,RST,PSH,URG", "SYN",
 			"-j", "NFQUEUE", "--queue-balance", i.fqc.GetApplicationQueueSynStr(),
 		})
 		// Application Packets - Evertyhing but SYN (first 4 packets)
 		rules = append(rules, []string{
 			i.appAckPacketIPTableContext, appChain,
-			"-m", "set", "--match-set", targetNetworkSet, "dst",
+			"-m", "set", "--match-set", i.targetNetworkSet, "dst",
 			"-p", "tcp", "--tcp-flags", "SYN,ACK", "ACK",
 			"-m", "connbytes", "--connbytes", ":3", "--connbytes-dir", "original", "--connbytes-mode", "packets",
 			"-j", "NFQUEUE", "--queue-balance", i.fqc.GetApplicationQueueAckStr(),
@@ -104,14 +129,14 @@ func (i *Instance) trapRules(appChain string, netChain string) [][]string {
 		// Network Packets - SYN
 		rules = append(rules, []string{
 			i.netPacketIPTableContext, netChain,
-			"-m", "set", "--match-set", targetNetworkSet, "src",
+			"-m", "set", "--match-set", i.targetNetworkSet, "src",
 			"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN",
 			"-j", "NFQUEUE", "--queue-balance", i.fqc.GetNetworkQueueSynStr(),
 		})
 		// Network Packets - Evertyhing but SYN (first 4 packets)
 		rules = append(rules, []string{
 			i.netPacketIPTableContext, netChain,
-			"-m", "set", "--match-set", targetNetworkSet, "src",
+			"-m", "set", "--match-set", i.targetNetworkSet, "src",
 			"-p", "tcp",
 			"-m", "connbytes", "--connbytes", ":3", "--connbytes-dir", "original", "--connbytes-mode", "packets",
 			"-j", "NFQUEUE", "--queue-balance", i.fqc.GetNetworkQueueAckStr(),
@@ -121,32 +146,48 @@ func (i *Instance) trapRules(appChain string, netChain string) [][]string {
 		// Application Packets - SYN
 		rules = append(rules, []string{
 			i.appAckPacketIPTableContext, appChain,
-			"-m", "set", "--match-set", targetNetworkSet, "dst",
+			"-m", "set", "--match-set", i.targetNetworkSet, "dst",
 			"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN",
 			"-j", "NFQUEUE", "--queue-balance", i.fqc.GetApplicationQueueSynStr(),
 		})
 		// Application Packets - Evertyhing but SYN and SYN,ACK (first 4 packets). SYN,ACK is captured by global rule
 		rules = append(rules, []string{
 			i.appAckPacketIPTableContext, appChain,
-			"-m", "set", "--match-set", targetNetworkSet, "dst",
+			"-m", "set", "--match-set", i.targetNetworkSet, "dst",
 			"-p", "tcp", "--tcp-flags", "SYN,ACK", "ACK",
 			"-j", "NFQUEUE", "--queue-balance", i.fqc.GetApplicationQueueAckStr(),
 		})
 		// Network Packets - SYN
 		rules = append(rules, []string{
 			i.netPacketIPTableContext, netChain,
-			"-m", "set", "--match-set", targetNetworkSet, "src",
+			"-m", "set", "--match-set", i.targetNetworkSet, "src",
 			"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN",
 			"-j", "NFQUEUE", "--queue-balance", i.fqc.GetNetworkQueueSynStr(),
 		})
 		// Network Packets - Evertyhing but SYN and SYN,ACK (first 4 packets). SYN,ACK is captured by global rule
 		rules = append(rules, []string{
 			i.netPacketIPTableContext, netChain,
-			"-m", "set", "--match-set", targetNetworkSet, "src",
+			"-m", "set", "--match-set", i.targetNetworkSet, "src",
 			"-p", "tcp", "--tcp-flags", "SYN,ACK,PSH", "ACK",
 			"-j", "NFQUEUE", "--queue-balance", i.fqc.GetNetworkQueueAckStr(),
 		})
 	}
+
+	// Application UDP Packets - Everything until the flow is marked as authorized
+	rules = append(rules, []string{
+		i.appAckPacketIPTableContext, appChain,
+		"-m", "set", "--match-set", i.targetNetworkSet, "dst",
+		"-p", "udp",
+		"-j", "NFQUEUE", "--queue-balance", i.fqc.GetApplicationQueueAckStr(),
+	})
+	// Network UDP Packets - Everything until the flow is marked as authorized
+	rules = append(rules, []string{
+		i.netPacketIPTableContext, netChain,
+		"-m", "set", "--match-set", i.targetNetworkSet, "src",
+		"-p", "udp",
+		"-j", "NFQUEUE", "--queue-balance", i.fqc.GetNetworkQueueAckStr(),
+	})
+
 	return rules
 }
 
@@ -195,10 +236,10 @@ func (i *Instance) processRulesFromList(rulelist [][]string, methodType string)
 }
 
 // addChainrules implements all the iptable rules that redirect traffic to a chain
-func (i *Instance) addChainRules(appChain string, netChain string, ip string, port string, mark string) error {
+func (i *Instance) addChainRules(contextID string, appChain string, netChain string, ip string, port string, mark string) error {
 
 	if i.mode == constants.LocalServer {
-		return i.processRulesFromList(i.cgroupChainRules(appChain, netChain, mark, port), "Append")
+		return i.processRulesFromList(i.cgroupChainRules(contextID, appChain, netChain, mark, port), "Append")
 	}
 
 	return i.processRulesFromList(i.chainRules(appChain, netChain, ip), "Append")
@@ -212,9 +253,22 @@ func (i *Instance) addPacketTrap(appChain string, netChain string, ip string, ne
 
 }
 
+// dropTarget returns the target of the rules that reject traffic. Audited
+// PUs log the rejected traffic and accept it.
+func dropTarget(audit bool) string {
+
+	if audit {
+		return "ACCEPT"
+	}
+
+	return "DROP"
+}
+
 // addAppACLs adds a set of rules to the external services that are initiated
 // by an application. The allow rules are inserted with highest priority.
-func (i *Instance) addAppACLs(contextID, chain, ip string, rules policy.IPRuleList) error {
+func (i *Instance) addAppACLs(contextID, chain, ip string, rules policy.IPRuleList, audit bool) error {
+
+	drop := dropTarget(audit)
 
 	for _, rule := range rules {
 
@@ -256,12 +310,12 @@ func (i *Instance) addAppACLs(contextID, chain, ip string, rules policy.IPRuleLi
 					"-p", rule.Protocol, "-m", "state", "--state", "NEW",
 					"-d", rule.Address,
 					"--dport", rule.Port,
-					"-j", "DROP",
+					"-j", drop,
 				); err != nil {
 					return fmt.Errorf("Failed to add acl rule for table %s, chain %s, with %s", i.appAckPacketIPTableContext, chain, err.Error())
 				}
 
-				if rule.Policy.Action&policy.Log > 0 {
+				if rule.Policy.Action&policy.Log > 0 || audit {
 					if err := i.ipt.Insert(
 						i.appAckPacketIPTableContext,
 						chain,
@@ -314,12 +368,12 @@ func (i *Instance) addAppACLs(contextID, chain, ip string, rules policy.IPRuleLi
 					i.appAckPacketIPTableContext, chain, 1,
 					"-p", rule.Protocol,
 					"-d", rule.Address,
-					"-j", "DROP",
+					"-j", drop,
 				); err != nil {
 					return fmt.Errorf("Failed to add acl rule for table %s, chain %s, with error: %s", i.appAckPacketIPTableContext, chain, err.Error())
 				}
 
-				if rule.Policy.Action&policy.Log > 0 {
+				if rule.Policy.Action&policy.Log > 0 || audit {
 					if err := i.ipt.Insert(
 						i.appAckPacketIPTableContext,
 						chain,
@@ -343,7 +397,7 @@ func (i *Instance) addAppACLs(contextID, chain, ip string, rules policy.IPRuleLi
 	// Accept established connections
 	if err := i.ipt.Append(
 		i.appAckPacketIPTableContext, chain,
-		"-d", "0.0.0.0/0",
+		"-d", i.anyNetwork(),
 		"-p", "udp", "-m", "state", "--state", "ESTABLISHED",
 		"-j", "ACCEPT"); err != nil {
 
@@ -352,7 +406,7 @@ func (i *Instance) addAppACLs(contextID, chain, ip string, rules policy.IPRuleLi
 
 	if err := i.ipt.Append(
 		i.appAckPacketIPTableContext, chain,
-		"-d", "0.0.0.0/0",
+		"-d", i.anyNetwork(),
 		"-p", "tcp", "-m", "state", "--state", "ESTABLISHED",
 		"-j", "ACCEPT"); err != nil {
 
@@ -363,7 +417,7 @@ func (i *Instance) addAppACLs(contextID, chain, ip string, rules policy.IPRuleLi
 	if err := i.ipt.Append(
 		i.appAckPacketIPTableContext,
 		chain,
-		"-d", "0.0.0.0/0",
+		"-d", i.anyNetwork(),
 		"-m", "state", "--state", "NEW",
 		"-j", "NFLOG", "--nflog-group", "10",
 		"--nflog-prefix", contextID+":default:defaultr",
@@ -374,8 +428,8 @@ func (i *Instance) addAppACLs(contextID, chain, ip string, rules policy.IPRuleLi
 	// Drop everything else
 	if err := i.ipt.Append(
 		i.appAckPacketIPTableContext, chain,
-		"-d", "0.0.0.0/0",
-		"-j", "DROP"); err != nil {
+		"-d", i.anyNetwork(),
+		"-j", drop); err != nil {
 
 		return fmt.Errorf("Failed to add default drop acl rule for table %s, chain %s, with error: %s", i.appAckPacketIPTableContext, chain, err.Error())
 	}
@@ -385,7 +439,9 @@ func (i *Instance) addAppACLs(contextID, chain, ip string, rules policy.IPRuleLi
 
 // addNetACLs adds iptables rules that manage traffic from external services. The
 // explicit rules are added with the highest priority since they are direct allows.
-func (i *Instance) addNetACLs(contextID, chain, ip string, rules policy.IPRuleList) error {
+func (i *Instance) addNetACLs(contextID, chain, ip string, rules policy.IPRuleList, audit bool) error {
+
+	drop := dropTarget(audit)
 
 	for _, rule := range rules {
 
@@ -427,13 +483,13 @@ func (i *Instance) addNetACLs(contextID, chain, ip string, rules policy.IPRuleLi
 					"-p", rule.Protocol,
 					"-s", rule.Address,
 					"--dport", rule.Port,
-					"-j", "DROP",
+					"-j", drop,
 				); err != nil {
 
 					return fmt.Errorf("Failed to add net acl rule for table %s, chain %s, with error: %s", i.netPacketIPTableContext, chain, err.Error())
 				}
 
-				if rule.Policy.Action&policy.Log > 0 {
+				if rule.Policy.Action&policy.Log > 0 || audit {
 					if err := i.ipt.Insert(
 						i.netPacketIPTableContext,
 						chain,
@@ -485,13 +541,13 @@ func (i *Instance) addNetACLs(contextID, chain, ip string, rules policy.IPRuleLi
 					i.netPacketIPTableContext, chain, 1,
 					"-p", rule.Protocol,
 					"-s", rule.Address,
-					"-j", "DROP",
+					"-j", drop,
 				); err != nil {
 
 					return fmt.Errorf("Failed to add net acl rule for table %s, chain %s, with error: %s", i.netPacketIPTableContext, chain, err.Error())
 				}
 
-				if rule.Policy.Action&policy.Log > 0 {
+				if rule.Policy.Action&policy.Log > 0 || audit {
 					if err := i.ipt.Insert(
 						i.netPacketIPTableContext,
 						chain,
@@ -514,7 +570,7 @@ func (i *Instance) addNetACLs(contextID, chain, ip string, rules policy.IPRuleLi
 	// Accept established connections
 	if err := i.ipt.Append(
 		i.netPacketIPTableContext, chain,
-		"-s", "0.0.0.0/0",
+		"-s", i.anyNetwork(),
 		"-p", "tcp", "-m", "state", "--state", "ESTABLISHED",
 		"-j", "ACCEPT",
 	); err != nil {
@@ -524,7 +580,7 @@ func (i *Instance) addNetACLs(contextID, chain, ip string, rules policy.IPRuleLi
 
 	if err := i.ipt.Append(
 		i.netPacketIPTableContext, chain,
-		"-s", "0.0.0.0/0",
+		"-s", i.anyNetwork(),
 		"-p", "udp", "-m", "state", "--state", "ESTABLISHED",
 		"-j", "ACCEPT",
 	); err != nil {
@@ -536,7 +592,7 @@ func (i *Instance) addNetACLs(contextID, chain, ip string, rules policy.IPRuleLi
 	if err := i.ipt.Append(
 		i.netPacketIPTableContext,
 		chain,
-		"-s", "0.0.0.0/0",
+		"-s", i.anyNetwork(),
 		"-m", "state", "--state", "NEW",
 		"-j", "NFLOG", "--nflog-group", "11",
 		"--nflog-prefix", contextID+":default:defaultr",
@@ -547,8 +603,8 @@ func (i *Instance) addNetACLs(contextID, chain, ip string, rules policy.IPRuleLi
 	// Drop everything else
 	if err := i.ipt.Append(
 		i.netPacketIPTableContext, chain,
-		"-s", "0.0.0.0/0",
-		"-j", "DROP",
+		"-s", i.anyNetwork(),
+		"-j", drop,
 	); err != nil {
 
 		return fmt.Errorf("Failed to add net acl rule for table %s, chain %s, with error: %s", i.netPacketIPTableContext, chain, err.Error())
@@ -558,10 +614,10 @@ func (i *Instance) addNetACLs(contextID, chain, ip string, rules policy.IPRuleLi
 }
 
 // deleteChainRules deletes the rules that send traffic to our chain
-func (i *Instance) deleteChainRules(appChain, netChain, ip string, port string, mark string) error {
+func (i *Instance) deleteChainRules(contextID, appChain, netChain, ip string, port string, mark string) error {
 
 	if i.mode == constants.LocalServer {
-		return i.processRulesFromList(i.cgroupChainRules(appChain, netChain, mark, port), "Delete")
+		return i.processRulesFromList(i.cgroupChainRules(contextID, appChain, netChain, mark, port), "Delete")
 	}
 
 	return i.processRulesFromList(i.chainRules(appChain, netChain, ip), "Delete")
@@ -627,7 +683,7 @@ func (i *Instance) setGlobalRules(appChain, netChain string) error {
 	err := i.ipt.Insert(
 		i.appAckPacketIPTableContext,
 		appChain, 1,
-		"-m", "set", "--match-set", targetNetworkSet, "dst",
+		"-m", "set", "--match-set", i.targetNetworkSet, "dst",
 		"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN,ACK",
 		"-j", "NFQUEUE", "--queue-bypass", "--queue-balance", i.fqc.GetApplicationQueueSynAckStr())
 
@@ -638,7 +694,7 @@ func (i *Instance) setGlobalRules(appChain, netChain string) error {
 	err = i.ipt.Insert(
 		i.netPacketIPTableContext,
 		netChain, 1,
-		"-m", "set", "--match-set", targetNetworkSet, "src",
+		"-m", "set", "--match-set", i.targetNetworkSet, "src",
 		"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN,ACK",
 		"-j", "NFQUEUE", "--queue-bypass", "--queue-balance", i.fqc.GetNetworkQueueSynAckStr())
 
@@ -666,6 +722,76 @@ func (i *Instance) setGlobalRules(appChain, netChain string) error {
 		return fmt.Errorf("Failed to add default allow for marked packets at net")
 	}
 
+	// The teardown packets of accepted flows are processed by the enforcer
+	// to track the end of the flows
+	err = i.ipt.Insert(
+		i.appAckPacketIPTableContext,
+		appChain, 1,
+		"-m", "connmark", "--mark", strconv.Itoa(int(constants.DefaultConnMark)),
+		"-p", "tcp", "!", "--tcp-flags", "FIN,RST", "NONE",
+		"-j", "NFQUEUE", "--queue-bypass", "--queue-balance", i.fqc.GetApplicationQueueAckStr())
+
+	if err != nil {
+		return fmt.Errorf("Failed to add capture rule for teardown packets at app")
+	}
+
+	err = i.ipt.Insert(
+		i.netPacketIPTableContext,
+		netChain, 1,
+		"-m", "connmark", "--mark", strconv.Itoa(int(constants.DefaultConnMark)),
+		"-p", "tcp", "!", "--tcp-flags", "FIN,RST", "NONE",
+		"-j", "NFQUEUE", "--queue-bypass", "--queue-balance", i.fqc.GetNetworkQueueAckStr())
+
+	if err != nil {
+		return fmt.Errorf("Failed to add capture rule for teardown packets at net")
+	}
+
+	// Packets of encrypted flows must always be processed by the enforcer
+	err = i.ipt.Insert(
+		i.appAckPacketIPTableContext,
+		appChain, 1,
+		"-m", "connmark", "--mark", strconv.Itoa(int(constants.EncryptConnMark)),
+		"-p", "tcp",
+		"-j", "NFQUEUE", "--queue-balance", i.fqc.GetApplicationQueueAckStr())
+
+	if err != nil {
+		return fmt.Errorf("Failed to add capture rule for encrypted packets at app")
+	}
+
+	err = i.ipt.Insert(
+		i.netPacketIPTableContext,
+		netChain, 1,
+		"-m", "connmark", "--mark", strconv.Itoa(int(constants.EncryptConnMark)),
+		"-p", "tcp",
+		"-j", "NFQUEUE", "--queue-balance", i.fqc.GetNetworkQueueAckStr())
+
+	if err != nil {
+		return fmt.Errorf("Failed to add capture rule for encrypted packets at net")
+	}
+
+	// Packets of flows revoked by a policy update are reset by the enforcer
+	err = i.ipt.Insert(
+		i.appAckPacketIPTableContext,
+		appChain, 1,
+		"-m", "connmark", "--mark", strconv.Itoa(int(constants.RevokedConnMark)),
+		"-p", "tcp",
+		"-j", "NFQUEUE", "--queue-balance", i.fqc.GetApplicationQueueAckStr())
+
+	if err != nil {
+		return fmt.Errorf("Failed to add capture rule for revoked packets at app")
+	}
+
+	err = i.ipt.Insert(
+		i.netPacketIPTableContext,
+		netChain, 1,
+		"-m", "connmark", "--mark", strconv.Itoa(int(constants.RevokedConnMark)),
+		"-p", "tcp",
+		"-j", "NFQUEUE", "--queue-balance", i.fqc.GetNetworkQueueAckStr())
+
+	if err != nil {
+		return fmt.Errorf("Failed to add capture rule for revoked packets at net")
+	}
+
 	return nil
 
 }
@@ -676,7 +802,7 @@ func (i *Instance) CleanGlobalRules() error {
 	if err := i.ipt.Delete(
 		i.appAckPacketIPTableContext,
 		i.appPacketIPTableSection,
-		"-m", "set", "--match-set", targetNetworkSet, "dst",
+		"-m", "set", "--match-set", i.targetNetworkSet, "dst",
 		"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN,ACK",
 		"-j", "NFQUEUE", "--queue-bypass", "--queue-balance", i.fqc.GetApplicationQueueAckStr()); err != nil {
 
@@ -686,7 +812,7 @@ func (i *Instance) CleanGlobalRules() error {
 	if err := i.ipt.Delete(
 		i.netPacketIPTableContext,
 		i.netPacketIPTableSection,
-		"-m", "set", "--match-set", targetNetworkSet, "src",
+		"-m", "set", "--match-set", i.targetNetworkSet, "src",
 		"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN,ACK",
 		"-j", "NFQUEUE", "--queue-bypass", "--queue-balance", i.fqc.GetNetworkQueueAckStr()); err != nil {
 
@@ -712,6 +838,60 @@ func (i *Instance) CleanGlobalRules() error {
 
 	}
 
+	if err := i.ipt.Delete(
+		i.appAckPacketIPTableContext,
+		i.appPacketIPTableSection,
+		"-m", "connmark", "--mark", strconv.Itoa(int(constants.DefaultConnMark)),
+		"-p", "tcp", "!", "--tcp-flags", "FIN,RST", "NONE",
+		"-j", "NFQUEUE", "--queue-bypass", "--queue-balance", i.fqc.GetApplicationQueueAckStr()); err != nil {
+		zap.L().Debug("Can not clear the global app teardown rule", zap.Error(err))
+	}
+
+	if err := i.ipt.Delete(
+		i.netPacketIPTableContext,
+		i.netPacketIPTableSection,
+		"-m", "connmark", "--mark", strconv.Itoa(int(constants.DefaultConnMark)),
+		"-p", "tcp", "!", "--tcp-flags", "FIN,RST", "NONE",
+		"-j", "NFQUEUE", "--queue-bypass", "--queue-balance", i.fqc.GetNetworkQueueAckStr()); err != nil {
+		zap.L().Debug("Can not clear the global net teardown rule", zap.Error(err))
+	}
+
+	if err := i.ipt.Delete(
+		i.appAckPacketIPTableContext,
+		i.appPacketIPTableSection,
+		"-m", "connmark", "--mark", strconv.Itoa(int(constants.EncryptConnMark)),
+		"-p", "tcp",
+		"-j", "NFQUEUE", "--queue-balance", i.fqc.GetApplicationQueueAckStr()); err != nil {
+		zap.L().Debug("Can not clear the global app encryption rule", zap.Error(err))
+	}
+
+	if err := i.ipt.Delete(
+		i.netPacketIPTableContext,
+		i.netPacketIPTableSection,
+		"-m", "connmark", "--mark", strconv.Itoa(int(constants.EncryptConnMark)),
+		"-p", "tcp",
+		"-j", "NFQUEUE", "--queue-balance", i.fqc.GetNetworkQueueAckStr()); err != nil {
+		zap.L().Debug("Can not clear the global net encryption rule", zap.Error(err))
+	}
+
+	if err := i.ipt.Delete(
+		i.appAckPacketIPTableContext,
+		i.appPacketIPTableSection,
+		"-m", "connmark", "--mark", strconv.Itoa(int(constants.RevokedConnMark)),
+		"-p", "tcp",
+		"-j", "NFQUEUE", "--queue-balance", i.fqc.GetApplicationQueueAckStr()); err != nil {
+		zap.L().Debug("Can not clear the global app revocation rule", zap.Error(err))
+	}
+
+	if err := i.ipt.Delete(
+		i.netPacketIPTableContext,
+		i.netPacketIPTableSection,
+		"-m", "connmark", "--mark", strconv.Itoa(int(constants.RevokedConnMark)),
+		"-p", "tcp",
+		"-j", "NFQUEUE", "--queue-balance", i.fqc.GetNetworkQueueAckStr()); err != nil {
+		zap.L().Debug("Can not clear the global net revocation rule", zap.Error(err))
+	}
+
 	if err := i.ipset.DestroyAll(); err != nil {
 		zap.L().Debug("Failed to clear targetIPset", zap.Error(err))
 	}
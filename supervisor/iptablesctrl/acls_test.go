@@ -3,6 +3,7 @@ package iptablesctrl
 import (
 	"fmt"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/bvandewalle/go-ipset/ipset"
@@ -93,7 +94,7 @@ func TestAddChainRules(t *testing.T) {
 			iptables.MockAppend(t, func(table string, chain string, rulespec ...string) error {
 				return nil
 			})
-			err := i.addChainRules("appchain", "netchain", "172.17.0.1", "0", "100")
+			err := i.addChainRules("context", "appchain", "netchain", "172.17.0.1", "0", "100")
 			Convey("I should get no error", func() {
 				So(err, ShouldBeNil)
 			})
@@ -106,7 +107,7 @@ func TestAddChainRules(t *testing.T) {
 				}
 				return nil
 			})
-			err := i.addChainRules("appchain", "netchain", "172.17.0.1", "0", "100")
+			err := i.addChainRules("context", "appchain", "netchain", "172.17.0.1", "0", "100")
 			Convey("I should get  error", func() {
 				So(err, ShouldNotBeNil)
 			})
@@ -119,7 +120,7 @@ func TestAddChainRules(t *testing.T) {
 				}
 				return nil
 			})
-			err := i.addChainRules("appchain", "netchain", "172.17.0.1", "0", "100")
+			err := i.addChainRules("context", "appchain", "netchain", "172.17.0.1", "0", "100")
 			Convey("I should get  error", func() {
 				So(err, ShouldNotBeNil)
 			})
@@ -132,7 +133,7 @@ func TestAddChainRules(t *testing.T) {
 				}
 				return nil
 			})
-			err := i.addChainRules("appchain", "netchain", "172.17.0.1", "0", "100")
+			err := i.addChainRules("context", "appchain", "netchain", "172.17.0.1", "0", "100")
 			Convey("I should get  error", func() {
 				So(err, ShouldNotBeNil)
 			})
@@ -149,7 +150,7 @@ func TestAddChainRules(t *testing.T) {
 			iptables.MockAppend(t, func(table string, chain string, rulespec ...string) error {
 				return nil
 			})
-			err := i.addChainRules("appchain", "netchain", "172.17.0.1", "0", "100")
+			err := i.addChainRules("context", "appchain", "netchain", "172.17.0.1", "0", "100")
 			Convey("I should get no error", func() {
 				So(err, ShouldBeNil)
 			})
@@ -162,7 +163,7 @@ func TestAddChainRules(t *testing.T) {
 				}
 				return nil
 			})
-			err := i.addChainRules("appchain", "netchain", "172.17.0.1", "0", "100")
+			err := i.addChainRules("context", "appchain", "netchain", "172.17.0.1", "0", "100")
 			Convey("I should get  error", func() {
 				So(err, ShouldNotBeNil)
 			})
@@ -175,12 +176,31 @@ func TestAddChainRules(t *testing.T) {
 				}
 				return nil
 			})
-			err := i.addChainRules("appchain", "netchain", "172.17.0.1", "0", "100")
+			err := i.addChainRules("context", "appchain", "netchain", "172.17.0.1", "0", "100")
 			Convey("I should get  error", func() {
 				So(err, ShouldNotBeNil)
 			})
 		})
 
+		Convey("When I add the chain rules on the unified cgroup hierarchy", func() {
+			i.cgroupV2 = true
+			var cgroupRules [][]string
+			iptables.MockAppend(t, func(table string, chain string, rulespec ...string) error {
+				if chain == i.appCgroupIPTableSection {
+					cgroupRules = append(cgroupRules, rulespec)
+				}
+				return nil
+			})
+			err := i.addChainRules("/1234", "appchain", "netchain", "172.17.0.1", "0", "100")
+			Convey("The traffic should be matched by the path of the cgroup and marked", func() {
+				So(err, ShouldBeNil)
+				So(len(cgroupRules), ShouldEqual, 2)
+				So(strings.Join(cgroupRules[0], " "), ShouldStartWith, "-m cgroup --path /trireme/1234 ")
+				So(strings.Join(cgroupRules[0], " "), ShouldEndWith, "-j MARK --set-mark 100")
+				So(strings.Join(cgroupRules[1], " "), ShouldEndWith, "-j appchain")
+			})
+		})
+
 	})
 }
 
@@ -309,7 +329,7 @@ func TestAddAppACLs(t *testing.T) {
 				return fmt.Errorf("Error")
 			})
 
-			err := i.addAppACLs("", "chain", "", policy.IPRuleList{})
+			err := i.addAppACLs("", "chain", "", policy.IPRuleList{}, false)
 			Convey("I should get no error", func() {
 				So(err, ShouldBeNil)
 			})
@@ -323,7 +343,7 @@ func TestAddAppACLs(t *testing.T) {
 				return nil
 			})
 
-			err := i.addAppACLs("", "chain", "", policy.IPRuleList{})
+			err := i.addAppACLs("", "chain", "", policy.IPRuleList{}, false)
 			Convey("I should get  error", func() {
 				So(err, ShouldNotBeNil)
 			})
@@ -359,7 +379,7 @@ func TestAddAppACLs(t *testing.T) {
 				}
 				return fmt.Errorf("error %s ", rulespec)
 			})
-			err := i.addAppACLs("chain", "", "", rules)
+			err := i.addAppACLs("chain", "", "", rules, false)
 			Convey("I should get no error", func() {
 				So(err, ShouldBeNil)
 			})
@@ -392,7 +412,7 @@ func TestAddAppACLs(t *testing.T) {
 				}
 				return fmt.Errorf("error %s ", rulespec)
 			})
-			err := i.addAppACLs("chain", "", "", rules)
+			err := i.addAppACLs("chain", "", "", rules, false)
 			Convey("I should get no error", func() {
 				So(err, ShouldNotBeNil)
 			})
@@ -425,12 +445,48 @@ func TestAddAppACLs(t *testing.T) {
 				}
 				return fmt.Errorf("error %s ", rulespec)
 			})
-			err := i.addAppACLs("chain", "", "", rules)
+			err := i.addAppACLs("chain", "", "", rules, false)
 			Convey("I should get no error", func() {
 				So(err, ShouldBeNil)
 			})
 		})
 
+		Convey("When I add app ACLs of an audited PU", func() {
+
+			rules := policy.IPRuleList{
+				policy.IPRule{
+					Address:  "192.30.253.0/24",
+					Port:     "80",
+					Protocol: "TCP",
+					Policy:   &policy.FlowPolicy{Action: policy.Reject},
+				},
+			}
+
+			logged := 0
+			rulesWithDrop := 0
+			iptables.MockAppend(t, func(table string, chain string, rulespec ...string) error {
+				if matchSpec("DROP", rulespec) == nil {
+					rulesWithDrop++
+				}
+				return nil
+			})
+			iptables.MockInsert(t, func(table string, chain string, pos int, rulespec ...string) error {
+				if matchSpec("DROP", rulespec) == nil {
+					rulesWithDrop++
+				}
+				if matchSpec("NFLOG", rulespec) == nil {
+					logged++
+				}
+				return nil
+			})
+			err := i.addAppACLs("chain", "", "", rules, true)
+			Convey("I should get no error, the reject rule should be logged and nothing should be dropped", func() {
+				So(err, ShouldBeNil)
+				So(logged, ShouldEqual, 1)
+				So(rulesWithDrop, ShouldEqual, 0)
+			})
+		})
+
 	})
 }
 
@@ -457,7 +513,7 @@ func TestAddNetAcls(t *testing.T) {
 				return fmt.Errorf("Error")
 			})
 
-			err := i.addNetACLs("", "chain", "", policy.IPRuleList{})
+			err := i.addNetACLs("", "chain", "", policy.IPRuleList{}, false)
 			Convey("I should get no error", func() {
 				So(err, ShouldBeNil)
 			})
@@ -471,7 +527,7 @@ func TestAddNetAcls(t *testing.T) {
 				return nil
 			})
 
-			err := i.addNetACLs("", "chain", "", policy.IPRuleList{})
+			err := i.addNetACLs("", "chain", "", policy.IPRuleList{}, false)
 			Convey("I should get  error", func() {
 				So(err, ShouldNotBeNil)
 			})
@@ -510,7 +566,7 @@ func TestAddNetAcls(t *testing.T) {
 				}
 				return fmt.Errorf("error %s ", rulespec)
 			})
-			err := i.addNetACLs("chain", "", "", rules)
+			err := i.addNetACLs("chain", "", "", rules, false)
 			Convey("I should get no error", func() {
 				So(err, ShouldBeNil)
 			})
@@ -546,7 +602,7 @@ func TestAddNetAcls(t *testing.T) {
 				}
 				return fmt.Errorf("error %s ", rulespec)
 			})
-			err := i.addNetACLs("chain", "", "", rules)
+			err := i.addNetACLs("chain", "", "", rules, false)
 			Convey("I should get no error", func() {
 				So(err, ShouldNotBeNil)
 			})
@@ -582,7 +638,7 @@ func TestAddNetAcls(t *testing.T) {
 				}
 				return fmt.Errorf("error %s ", rulespec)
 			})
-			err := i.addNetACLs("chain", "", "", rules)
+			err := i.addNetACLs("chain", "", "", rules, false)
 			Convey("I should get no error", func() {
 				So(err, ShouldBeNil)
 			})
@@ -602,7 +658,7 @@ func TestDeleteChainRules(t *testing.T) {
 			iptables.MockDelete(t, func(table string, chain string, rulespec ...string) error {
 				return nil
 			})
-			err := i.deleteChainRules("appchain", "netchain", "172.17.0.1", "0", "100")
+			err := i.deleteChainRules("context", "appchain", "netchain", "172.17.0.1", "0", "100")
 			Convey("I should get no error", func() {
 				So(err, ShouldBeNil)
 			})
@@ -612,7 +668,7 @@ func TestDeleteChainRules(t *testing.T) {
 			iptables.MockDelete(t, func(table string, chain string, rulespec ...string) error {
 				return nil
 			})
-			err := i.deleteChainRules("appchain", "netchain", "172.17.0.1", "0", "100")
+			err := i.deleteChainRules("context", "appchain", "netchain", "172.17.0.1", "0", "100")
 			Convey("I should still get no error", func() {
 				So(err, ShouldBeNil)
 			})
@@ -794,6 +850,12 @@ func TestSetGlobalRules(t *testing.T) {
 					if matchSpec("connmark", rulespec) == nil && matchSpec(strconv.Itoa(int(constants.DefaultConnMark)), rulespec) == nil {
 						return nil
 					}
+					if matchSpec("connmark", rulespec) == nil && matchSpec(strconv.Itoa(int(constants.EncryptConnMark)), rulespec) == nil {
+						return nil
+					}
+					if matchSpec("connmark", rulespec) == nil && matchSpec(strconv.Itoa(int(constants.RevokedConnMark)), rulespec) == nil {
+						return nil
+					}
 				}
 				return fmt.Errorf("Failed")
 			})
@@ -0,0 +1,310 @@
+package iptablesctrl
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+// chainKey identifies a chain of a table
+type chainKey struct {
+	table string
+	chain string
+}
+
+// ruleRecorder is an IptablesProvider that records the rules that would be
+// programmed instead of programming them
+type ruleRecorder struct {
+	// chains are the chains in the order of their first change
+	chains []chainKey
+	// rules are the specifications of the rules of every chain in order
+	rules map[chainKey][][]string
+	// created are the chains created by the recorded calls
+	created map[chainKey]bool
+}
+
+// newRuleRecorder returns an empty recorder
+func newRuleRecorder() *ruleRecorder {
+
+	return &ruleRecorder{
+		rules:   map[chainKey][][]string{},
+		created: map[chainKey]bool{},
+	}
+}
+
+// target returns the target of a rule specification
+func target(rulespec []string) string {
+
+	for idx, arg := range rulespec {
+		if (arg == "-j" || arg == "--jump") && idx+1 < len(rulespec) {
+			return rulespec[idx+1]
+		}
+	}
+
+	return ""
+}
+
+// record adds the key to the list of changed chains
+func (r *ruleRecorder) record(key chainKey) {
+
+	if _, ok := r.rules[key]; !ok {
+		r.chains = append(r.chains, key)
+		r.rules[key] = [][]string{}
+	}
+}
+
+// Append implements the IptablesProvider interface
+func (r *ruleRecorder) Append(table, chain string, rulespec ...string) error {
+
+	key := chainKey{table, chain}
+	r.record(key)
+	r.rules[key] = append(r.rules[key], rulespec)
+
+	return nil
+}
+
+// Insert implements the IptablesProvider interface
+func (r *ruleRecorder) Insert(table, chain string, pos int, rulespec ...string) error {
+
+	key := chainKey{table, chain}
+	r.record(key)
+
+	rules := r.rules[key]
+	if pos < 1 || pos > len(rules)+1 {
+		return fmt.Errorf("Index of insertion too big")
+	}
+
+	rules = append(rules, nil)
+	copy(rules[pos:], rules[pos-1:])
+	rules[pos-1] = rulespec
+	r.rules[key] = rules
+
+	return nil
+}
+
+// NewChain implements the IptablesProvider interface
+func (r *ruleRecorder) NewChain(table, chain string) error {
+
+	key := chainKey{table, chain}
+	r.record(key)
+	r.created[key] = true
+
+	return nil
+}
+
+// Delete implements the IptablesProvider interface
+func (r *ruleRecorder) Delete(table, chain string, rulespec ...string) error {
+	return nil
+}
+
+// ListChains implements the IptablesProvider interface
+func (r *ruleRecorder) ListChains(table string) ([]string, error) {
+	return nil, nil
+}
+
+// List implements the IptablesProvider interface
+func (r *ruleRecorder) List(table, chain string) ([]string, error) {
+	return nil, nil
+}
+
+// ClearChain implements the IptablesProvider interface
+func (r *ruleRecorder) ClearChain(table, chain string) error {
+	return nil
+}
+
+// DeleteChain implements the IptablesProvider interface
+func (r *ruleRecorder) DeleteChain(table, chain string) error {
+	return nil
+}
+
+// isOption returns true if the argument of a rule specification starts an
+// option
+func isOption(arg string) bool {
+	return arg == "!" || (len(arg) > 1 && arg[0] == '-')
+}
+
+// canonicalAddress returns an address or a network with its prefix length
+func canonicalAddress(address string) string {
+
+	if strings.Contains(address, "/") {
+		return address
+	}
+
+	if ip := net.ParseIP(address); ip != nil && ip.To4() == nil {
+		return address + "/128"
+	}
+
+	return address + "/32"
+}
+
+// canonicalOption returns an option of a rule specification the way iptables
+// lists it, or an empty string if iptables omits it
+func canonicalOption(option []string) string {
+
+	values := make([]string, len(option))
+	for idx, v := range option {
+		values[idx] = strings.Trim(v, "\"")
+	}
+
+	name := len(values) - 1
+	for idx, v := range values {
+		if v != "!" {
+			name = idx
+			break
+		}
+	}
+
+	if name+1 < len(values) {
+		value := values[name+1]
+		switch values[name] {
+		case "-p", "--protocol":
+			values[name+1] = strings.ToLower(value)
+		case "-m", "--match":
+			// The protocol matches are implied by the protocol
+			if value == "tcp" || value == "udp" {
+				return ""
+			}
+		case "-s", "--source", "-d", "--destination":
+			if value == "0.0.0.0/0" || value == "::/0" {
+				return ""
+			}
+			values[name+1] = canonicalAddress(value)
+		case "--connbytes":
+			if strings.HasPrefix(value, ":") {
+				values[name+1] = "0" + value
+			}
+		case "--set-mark":
+			if mark, err := strconv.ParseUint(value, 0, 32); err == nil {
+				values[name] = "--set-xmark"
+				values[name+1] = fmt.Sprintf("0x%x/0xffffffff", mark)
+			}
+		}
+	}
+
+	return strings.Join(values, " ")
+}
+
+// canonicalRule returns a rule specification in a form that can be compared
+// with the rules listed by iptables. iptables lists the addresses and the
+// protocol before the other matches, omits the defaults and normalizes some
+// values, so the options are normalized and sorted.
+func canonicalRule(rulespec []string) string {
+
+	options := []string{}
+	for start := 0; start < len(rulespec); {
+		end := start + 1
+		if rulespec[start] == "!" {
+			end++
+		}
+		for end < len(rulespec) && !isOption(rulespec[end]) {
+			end++
+		}
+		if end > len(rulespec) {
+			end = len(rulespec)
+		}
+
+		if option := canonicalOption(rulespec[start:end]); option != "" {
+			options = append(options, option)
+		}
+		start = end
+	}
+
+	sort.Strings(options)
+
+	return strings.Join(options, " ")
+}
+
+// programmedRules returns the specifications of the rules of a chain as
+// listed by iptables
+func programmedRules(chain string, rules []string) [][]string {
+
+	specs := [][]string{}
+	for _, rule := range rules {
+		fields := strings.Fields(rule)
+		if len(fields) < 2 || fields[0] != "-A" || fields[1] != chain {
+			continue
+		}
+		specs = append(specs, fields[2:])
+	}
+
+	return specs
+}
+
+// canonicalJumps returns the canonical rules that jump to one of the chains
+func canonicalJumps(rules [][]string, chains map[string]bool) []string {
+
+	jumps := []string{}
+	for _, rule := range rules {
+		if chains[target(rule)] {
+			jumps = append(jumps, canonicalRule(rule))
+		}
+	}
+
+	sort.Strings(jumps)
+
+	return jumps
+}
+
+// Drift implements the DriftDetector interface. The chains of the PU are
+// listed and compared with the rules that ConfigureRules programs for the
+// same version of the policy. The rules of the PU chains must match in
+// order, and the base chains must hold the expected jumps to the PU chains.
+// The rules are compared in the canonical form of canonicalRule since
+// iptables normalizes them when they are listed.
+func (i *Instance) Drift(version int, contextID string, containerInfo *policy.PUInfo) ([]string, error) {
+
+	if containerInfo == nil || containerInfo.Policy == nil {
+		return nil, fmt.Errorf("Policy rules cannot be nil")
+	}
+
+	recorder := newRuleRecorder()
+
+	expected := *i
+	expected.ipt = recorder
+	if err := expected.configureRules(version, contextID, containerInfo); err != nil {
+		return nil, fmt.Errorf("Unable to generate the rules of %s: %s", contextID, err)
+	}
+
+	puChains := map[string]bool{}
+	for key := range recorder.created {
+		puChains[key.chain] = true
+	}
+
+	drift := []string{}
+
+	for _, key := range recorder.chains {
+
+		rules, err := i.ipt.List(key.table, key.chain)
+		if err != nil {
+			drift = append(drift, fmt.Sprintf("chain %s of table %s is missing", key.chain, key.table))
+			continue
+		}
+
+		programmed := programmedRules(key.chain, rules)
+
+		if recorder.created[key] {
+			if len(programmed) != len(recorder.rules[key]) {
+				drift = append(drift, fmt.Sprintf("chain %s of table %s has %d rules, %d expected", key.chain, key.table, len(programmed), len(recorder.rules[key])))
+				continue
+			}
+			for idx, rule := range recorder.rules[key] {
+				if canonicalRule(programmed[idx]) != canonicalRule(rule) {
+					drift = append(drift, fmt.Sprintf("rule %d of chain %s of table %s does not match the expected rule %s", idx+1, key.chain, key.table, strings.Join(rule, " ")))
+					break
+				}
+			}
+			continue
+		}
+
+		want := canonicalJumps(recorder.rules[key], puChains)
+		if got := canonicalJumps(programmed, puChains); strings.Join(got, "\n") != strings.Join(want, "\n") {
+			drift = append(drift, fmt.Sprintf("chain %s of table %s has %d jumps to the chains of the PU that do not match the %d expected jumps", key.chain, key.table, len(got), len(want)))
+		}
+	}
+
+	return drift, nil
+}
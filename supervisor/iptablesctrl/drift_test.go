@@ -0,0 +1,155 @@
+package iptablesctrl
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/enforcer/utils/fqconfig"
+	"github.com/aporeto-inc/trireme/policy"
+	"github.com/aporeto-inc/trireme/supervisor/provider"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// mockTables programs the rules of the provider in memory
+func mockTables(t *testing.T, iptables provider.TestIptablesProvider) map[string][]string {
+
+	tables := map[string][]string{}
+	key := func(table, chain string) string {
+		return table + " " + chain
+	}
+
+	iptables.MockNewChain(t, func(table string, chain string) error {
+		tables[key(table, chain)] = []string{}
+		return nil
+	})
+	iptables.MockAppend(t, func(table string, chain string, rulespec ...string) error {
+		rule := "-A " + chain + " " + strings.Join(rulespec, " ")
+		tables[key(table, chain)] = append(tables[key(table, chain)], rule)
+		return nil
+	})
+	iptables.MockInsert(t, func(table string, chain string, pos int, rulespec ...string) error {
+		rule := "-A " + chain + " " + strings.Join(rulespec, " ")
+		tables[key(table, chain)] = append([]string{rule}, tables[key(table, chain)]...)
+		return nil
+	})
+	iptables.MockList(t, func(table string, chain string) ([]string, error) {
+		rules, ok := tables[key(table, chain)]
+		if !ok {
+			return nil, fmt.Errorf("chain %s does not exist", chain)
+		}
+		return append([]string{"-N " + chain}, rules...), nil
+	})
+
+	return tables
+}
+
+func TestDrift(t *testing.T) {
+	Convey("Given an iptables controller with the rules of a PU", t, func() {
+		iptables := provider.NewTestIptablesProvider()
+		i := newInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalContainer, iptables, false)
+		tables := mockTables(t, iptables)
+
+		rules := policy.IPRuleList{
+			policy.IPRule{
+				Address:  "192.30.253.0/24",
+				Port:     "80",
+				Protocol: "TCP",
+				Policy:   &policy.FlowPolicy{Action: policy.Reject},
+			},
+			policy.IPRule{
+				Address:  "192.30.253.0/24",
+				Port:     "443",
+				Protocol: "TCP",
+				Policy:   &policy.FlowPolicy{Action: policy.Accept},
+			},
+		}
+
+		ipl := policy.ExtendedMap{policy.DefaultNamespace: "172.17.0.1"}
+		containerinfo := policy.NewPUInfo("Context", constants.ContainerPU)
+		containerinfo.Policy = policy.NewPUPolicy("Context", policy.Police, rules, rules, nil, nil, nil, nil, ipl, []string{"172.17.0.0/24"}, []string{"10.0.0.0/8"})
+		containerinfo.Runtime = policy.NewPURuntimeWithDefaults()
+
+		So(i.ConfigureRules(1, "Context", containerinfo), ShouldBeNil)
+
+		Convey("When the rules are untouched, there should be no drift", func() {
+			drift, err := i.Drift(1, "Context", containerinfo)
+			So(err, ShouldBeNil)
+			So(drift, ShouldBeEmpty)
+		})
+
+		Convey("When a chain of the PU is flushed, the drift should be reported", func() {
+			tables["mangle TRIREME-App-Context-1"] = []string{}
+			drift, err := i.Drift(1, "Context", containerinfo)
+			So(err, ShouldBeNil)
+			So(len(drift), ShouldEqual, 1)
+			So(drift[0], ShouldContainSubstring, "TRIREME-App-Context-1")
+		})
+
+		Convey("When a rule of the PU has a different target, the drift should be reported", func() {
+			chain := tables["mangle TRIREME-Net-Context-1"]
+			chain[len(chain)-1] = "-A TRIREME-Net-Context-1 -j ACCEPT"
+			drift, err := i.Drift(1, "Context", containerinfo)
+			So(err, ShouldBeNil)
+			So(len(drift), ShouldEqual, 1)
+			So(drift[0], ShouldContainSubstring, "TRIREME-Net-Context-1")
+		})
+
+		Convey("When a rule of the PU matches other packets with the same target, the drift should be reported", func() {
+			chain := tables["mangle TRIREME-Net-Context-1"]
+			for idx, rule := range chain {
+				chain[idx] = strings.Replace(rule, "--dport 80", "--dport 8080", 1)
+			}
+			drift, err := i.Drift(1, "Context", containerinfo)
+			So(err, ShouldBeNil)
+			So(len(drift), ShouldEqual, 1)
+			So(drift[0], ShouldContainSubstring, "--dport 80 -j DROP")
+		})
+
+		Convey("When the rules are listed in the form of iptables, there should be no drift", func() {
+			chain := tables["mangle TRIREME-App-Context-1"]
+			for idx, rule := range chain {
+				rule = strings.Replace(rule, "-p TCP -m state --state NEW -d 192.30.253.0/24 --dport 80", "-d 192.30.253.0/24 -p tcp -m state --state NEW -m tcp --dport 80", 1)
+				rule = strings.Replace(rule, "-s 172.17.0.1 ", "-s 172.17.0.1/32 ", 1)
+				rule = strings.Replace(rule, "-d 0.0.0.0/0 ", "", 1)
+				chain[idx] = strings.Replace(rule, "--connbytes :3", "--connbytes 0:3", 1)
+			}
+			drift, err := i.Drift(1, "Context", containerinfo)
+			So(err, ShouldBeNil)
+			So(drift, ShouldBeEmpty)
+		})
+
+		Convey("When a chain of the PU is deleted, the drift should be reported", func() {
+			delete(tables, "raw TRIREME-App-Context-1")
+			drift, err := i.Drift(1, "Context", containerinfo)
+			So(err, ShouldBeNil)
+			So(drift, ShouldContain, "chain TRIREME-App-Context-1 of table raw is missing")
+		})
+
+		Convey("When the jump to a chain of the PU is removed, the drift should be reported", func() {
+			tables["mangle POSTROUTING"] = []string{}
+			drift, err := i.Drift(1, "Context", containerinfo)
+			So(err, ShouldBeNil)
+			So(drift, ShouldContain, "chain POSTROUTING of table mangle has 0 jumps to the chains of the PU that do not match the 1 expected jumps")
+		})
+
+		Convey("When the jump to a chain of the PU matches another address, the drift should be reported", func() {
+			tables["mangle POSTROUTING"] = []string{"-A POSTROUTING -d 172.17.0.2 -m comment --comment Container-specific-chain -j TRIREME-Net-Context-1"}
+			drift, err := i.Drift(1, "Context", containerinfo)
+			So(err, ShouldBeNil)
+			So(drift, ShouldContain, "chain POSTROUTING of table mangle has 1 jumps to the chains of the PU that do not match the 1 expected jumps")
+		})
+
+		Convey("When I check another version of the policy, the drift should be reported", func() {
+			drift, err := i.Drift(0, "Context", containerinfo)
+			So(err, ShouldBeNil)
+			So(drift, ShouldNotBeEmpty)
+		})
+
+		Convey("When I check a PU without policy, I should get an error", func() {
+			_, err := i.Drift(1, "Context", policy.NewPUInfo("Context", constants.ContainerPU))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,127 @@
+package iptablesctrl
+
+import (
+	"testing"
+
+	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/enforcer/utils/fqconfig"
+	"github.com/aporeto-inc/trireme/policy"
+	"github.com/aporeto-inc/trireme/supervisor/provider"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRulesWithEmulator(t *testing.T) {
+	Convey("Given an iptables controller programming an emulator", t, func() {
+		fqc := fqconfig.NewFilterQueueWithDefaults()
+		emulator := provider.NewEmulator()
+		i := newInstance(fqc, constants.LocalContainer, emulator, false)
+		i.ipset = emulator
+
+		So(i.Start(), ShouldBeNil)
+		So(i.SetTargetNetworks([]string{}, []string{"0.0.0.0/0"}), ShouldBeNil)
+
+		rules := policy.IPRuleList{
+			policy.IPRule{
+				Address:  "192.30.253.0/24",
+				Port:     "80",
+				Protocol: "TCP",
+				Policy:   &policy.FlowPolicy{Action: policy.Reject},
+			},
+			policy.IPRule{
+				Address:  "192.30.253.0/24",
+				Port:     "443",
+				Protocol: "TCP",
+				Policy:   &policy.FlowPolicy{Action: policy.Accept},
+			},
+		}
+
+		ipl := policy.ExtendedMap{policy.DefaultNamespace: "172.17.0.1"}
+		containerinfo := policy.NewPUInfo("Context", constants.ContainerPU)
+		containerinfo.Policy = policy.NewPUPolicy("Context", policy.Police, rules, rules, nil, nil, nil, nil, ipl, []string{"0.0.0.0/0"}, []string{"10.0.0.0/8"})
+		containerinfo.Runtime = policy.NewPURuntimeWithDefaults()
+
+		So(i.ConfigureRules(0, "Context", containerinfo), ShouldBeNil)
+
+		packet := func(destination string, port uint16, flags string) *provider.Packet {
+			return &provider.Packet{
+				SourceIP:        "172.17.0.1",
+				DestinationIP:   destination,
+				SourcePort:      40000,
+				DestinationPort: port,
+				Protocol:        "tcp",
+				TCPFlags:        flags,
+			}
+		}
+
+		Convey("The SYN packets of the PU should be sent to the application queues", func() {
+			v, err := emulator.EvaluateHook("PREROUTING", packet("8.8.8.8", 443, "SYN"))
+			So(err, ShouldBeNil)
+			So(v.Target, ShouldEqual, "NFQUEUE")
+			So(v.Queues, ShouldEqual, fqc.GetApplicationQueueSynStr())
+		})
+
+		Convey("The new connections to a rejected port should be dropped", func() {
+			v, err := emulator.EvaluateHook("PREROUTING", packet("192.30.253.1", 80, "ACK"))
+			So(err, ShouldBeNil)
+			So(v.Target, ShouldEqual, "DROP")
+		})
+
+		Convey("The packets to an excluded network should be accepted", func() {
+			v, err := emulator.EvaluateHook("PREROUTING", packet("10.1.1.1", 80, "ACK"))
+			So(err, ShouldBeNil)
+			So(v.Target, ShouldEqual, "ACCEPT")
+		})
+
+		Convey("The packets of other addresses should not be processed", func() {
+			p := packet("192.30.253.1", 80, "ACK")
+			p.SourceIP = "172.17.0.2"
+			v, err := emulator.EvaluateHook("PREROUTING", p)
+			So(err, ShouldBeNil)
+			So(v.Target, ShouldEqual, "ACCEPT")
+		})
+
+		Convey("When I update the policy to reject the accepted port", func() {
+			rules[1].Policy = &policy.FlowPolicy{Action: policy.Reject}
+			containerinfo.Policy = policy.NewPUPolicy("Context", policy.Police, rules, rules, nil, nil, nil, nil, ipl, []string{"0.0.0.0/0"}, []string{"10.0.0.0/8"})
+			So(i.UpdateRules(1, "Context", containerinfo), ShouldBeNil)
+
+			Convey("The new connections to the port should be dropped", func() {
+				v, err := emulator.EvaluateHook("PREROUTING", packet("192.30.253.1", 443, "ACK"))
+				So(err, ShouldBeNil)
+				So(v.Target, ShouldEqual, "DROP")
+			})
+
+			Convey("Only the chains of the new version should remain", func() {
+				chains, err := emulator.ListChains("mangle")
+				So(err, ShouldBeNil)
+				So(chains, ShouldContain, "TRIREME-App-Context-1")
+				So(chains, ShouldNotContain, "TRIREME-App-Context-0")
+			})
+		})
+
+		Convey("When I delete the rules of the PU", func() {
+			So(i.DeleteRules(0, "Context", ipl, "", ""), ShouldBeNil)
+
+			Convey("The packets of the PU should not be processed", func() {
+				v, err := emulator.EvaluateHook("PREROUTING", packet("192.30.253.1", 80, "ACK"))
+				So(err, ShouldBeNil)
+				So(v.Target, ShouldEqual, "ACCEPT")
+			})
+
+			Convey("The chains of the PU should be removed", func() {
+				for _, table := range []string{"raw", "mangle"} {
+					chains, err := emulator.ListChains(table)
+					So(err, ShouldBeNil)
+					So(chains, ShouldNotContain, "TRIREME-App-Context-0")
+					So(chains, ShouldNotContain, "TRIREME-Net-Context-0")
+				}
+			})
+		})
+
+		Convey("When I stop the controller, the sets should be destroyed", func() {
+			So(i.Stop(), ShouldBeNil)
+			_, ok := emulator.Set(targetNetworkSet)
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
@@ -40,9 +40,14 @@ func (i *Instance) updateTargetNetworks(old, new []string) error {
 // createTargetSet creates a new target set
 func (i *Instance) createTargetSet(networks []string) error {
 
-	ips, err := i.ipset.NewIpset(targetNetworkSet, "hash:net", &ipset.Params{})
+	params := &ipset.Params{}
+	if i.ipv6 {
+		params.HashFamily = "inet6"
+	}
+
+	ips, err := i.ipset.NewIpset(i.targetNetworkSet, "hash:net", params)
 	if err != nil {
-		return fmt.Errorf("Couldn't create IPSet for %s: %s", targetNetworkSet, err)
+		return fmt.Errorf("Couldn't create IPSet for %s: %s", i.targetNetworkSet, err)
 	}
 
 	i.targetSet = ips
@@ -2,6 +2,7 @@ package iptablesctrl
 
 import (
 	"fmt"
+	"net"
 	"strconv"
 
 	"go.uber.org/zap"
@@ -19,6 +20,7 @@ const (
 	appChainPrefix            = chainPrefix + "App-"
 	netChainPrefix            = chainPrefix + "Net-"
 	targetNetworkSet          = "TargetNetSet"
+	targetNetworkSetIPv6      = "TargetNetSet6"
 	ipTableSectionOutput      = "OUTPUT"
 	ipTableSectionInput       = "INPUT"
 	ipTableSectionPreRouting  = "PREROUTING"
@@ -39,6 +41,9 @@ type Instance struct {
 	appCgroupIPTableSection    string
 	appSynAckIPTableSection    string
 	mode                       constants.ModeType
+	ipv6                       bool
+	cgroupV2                   bool
+	targetNetworkSet           string
 }
 
 // NewInstance creates a new iptables controller instance
@@ -49,19 +54,75 @@ func NewInstance(fqc *fqconfig.FilterQueue, mode constants.ModeType) (*Instance,
 		return nil, fmt.Errorf("Cannot initialize IPtables provider: %s", err)
 	}
 
-	ips := provider.NewGoIPsetProvider()
+	return newInstance(fqc, mode, batchProvider(ipt, false), false), nil
+}
+
+// NewIPv6Instance creates a new ip6tables controller instance. It only
+// programs the IPv6 addresses of the processing units.
+func NewIPv6Instance(fqc *fqconfig.FilterQueue, mode constants.ModeType) (*Instance, error) {
+
+	ipt, err := provider.NewGoIP6TablesProvider()
 	if err != nil {
-		return nil, fmt.Errorf("Cannot initialize ipsets")
+		return nil, fmt.Errorf("Cannot initialize IP6tables provider: %s", err)
+	}
+
+	return newInstance(fqc, mode, batchProvider(ipt, true), true), nil
+}
+
+// batchProvider returns a provider that programs the rules of a PU in a
+// single transaction when iptables-restore is available
+func batchProvider(ipt provider.IptablesProvider, ipv6 bool) provider.IptablesProvider {
+
+	batch, err := provider.NewBatchProvider(ipt, ipv6)
+	if err != nil {
+		zap.L().Warn("Rules will be programmed one by one", zap.Error(err))
+		return ipt
+	}
+
+	return batch
+}
+
+// transaction runs f with an instance that buffers all the changes in a
+// transaction, if the provider supports it. The changes are committed if f
+// succeeds and discarded otherwise.
+func (i *Instance) transaction(f func(tx *Instance) error) error {
+
+	batch, ok := i.ipt.(provider.BatchProvider)
+	if !ok {
+		return f(i)
 	}
 
+	t := batch.NewTransaction()
+
+	tx := *i
+	tx.ipt = t
+
+	if err := f(&tx); err != nil {
+		t.Abort()
+		return err
+	}
+
+	return t.Commit()
+}
+
+// newInstance creates an instance for the given address family
+func newInstance(fqc *fqconfig.FilterQueue, mode constants.ModeType, ipt provider.IptablesProvider, ipv6 bool) *Instance {
+
 	i := &Instance{
-		fqc:   fqc,
-		ipt:   ipt,
-		ipset: ips,
+		fqc:                        fqc,
+		ipt:                        ipt,
+		ipset:                      provider.NewGoIPsetProvider(),
 		appPacketIPTableContext:    "raw",
 		appAckPacketIPTableContext: "mangle",
 		netPacketIPTableContext:    "mangle",
-		mode: mode,
+		mode:                       mode,
+		ipv6:                       ipv6,
+		cgroupV2:                   cgnetcls.UnifiedHierarchy(),
+		targetNetworkSet:           targetNetworkSet,
+	}
+
+	if ipv6 {
+		i.targetNetworkSet = targetNetworkSetIPv6
 	}
 
 	if mode == constants.LocalServer || mode == constants.RemoteContainer {
@@ -76,8 +137,58 @@ func NewInstance(fqc *fqconfig.FilterQueue, mode constants.ModeType) (*Instance,
 		i.appSynAckIPTableSection = ipTableSectionInput
 	}
 
-	return i, nil
+	return i
+}
+
+// anyNetwork returns the network that matches all addresses of the family
+func (i *Instance) anyNetwork() string {
+
+	if i.ipv6 {
+		return "::/0"
+	}
+
+	return "0.0.0.0/0"
+}
+
+// matchFamily returns true if the address or network belongs to the
+// address family of the instance
+func (i *Instance) matchFamily(address string) bool {
+
+	ip := net.ParseIP(address)
+	if ip == nil {
+		var err error
+		if ip, _, err = net.ParseCIDR(address); err != nil {
+			return false
+		}
+	}
+
+	return (ip.To4() == nil) == i.ipv6
+}
+
+// filterFamily returns the addresses that belong to the family of the instance
+func (i *Instance) filterFamily(addresses []string) []string {
+
+	filtered := []string{}
+	for _, address := range addresses {
+		if i.matchFamily(address) {
+			filtered = append(filtered, address)
+		}
+	}
+
+	return filtered
+}
+
+// filterRules returns the ACL rules that belong to the family of the instance
+func (i *Instance) filterRules(rules policy.IPRuleList) policy.IPRuleList {
+
+	filtered := policy.IPRuleList{}
+	for _, rule := range rules {
+		if i.matchFamily(rule.Address) {
+			filtered = append(filtered, rule)
+		}
+	}
 
+	return filtered
 }
 
 // chainPrefix returns the chain name for the specific PU
@@ -90,24 +201,63 @@ func (i *Instance) chainName(contextID string, version int) (app, net string) {
 // DefaultIPAddress returns the default IP address for the processing unit
 func (i *Instance) defaultIP(addresslist map[string]string) (string, bool) {
 
-	if ip, ok := addresslist[policy.DefaultNamespace]; ok && len(ip) > 0 {
+	namespace := policy.DefaultNamespace
+	if i.ipv6 {
+		namespace = policy.DefaultNamespaceIPv6
+	}
+
+	if ip, ok := addresslist[namespace]; ok && len(ip) > 0 {
 		return ip, true
 	}
 
 	if i.mode == constants.LocalContainer {
-		return "0.0.0.0/0", false
+		return i.anyNetwork(), false
+	}
+
+	return i.anyNetwork(), true
+}
+
+// skipPU returns true if a container PU has no address in the family of the
+// instance and the instance of the other family is responsible for it. A
+// PU without any address is the responsibility of the IPv4 instance.
+func (i *Instance) skipPU(addresslist map[string]string) bool {
+
+	if i.mode != constants.LocalContainer {
+		return false
 	}
 
-	return "0.0.0.0/0", true
+	if _, ok := i.defaultIP(addresslist); ok {
+		return false
+	}
+
+	if i.ipv6 {
+		return true
+	}
+
+	ip, ok := addresslist[policy.DefaultNamespaceIPv6]
+	return ok && len(ip) > 0
 }
 
-// ConfigureRules implmenets the ConfigureRules interface
+// ConfigureRules implmenets the ConfigureRules interface. All the rules of
+// the PU are programmed in a single transaction.
 func (i *Instance) ConfigureRules(version int, contextID string, containerInfo *policy.PUInfo) error {
+
+	return i.transaction(func(tx *Instance) error {
+		return tx.configureRules(version, contextID, containerInfo)
+	})
+}
+
+// configureRules programs the rules of a new PU
+func (i *Instance) configureRules(version int, contextID string, containerInfo *policy.PUInfo) error {
 	policyrules := containerInfo.Policy
 
 	appChain, netChain := i.chainName(contextID, version)
 	// policyrules.DefaultIPAddress()
 
+	if i.skipPU(policyrules.IPAddresses()) {
+		return nil
+	}
+
 	// Supporting only one ip
 	ipAddress, ok := i.defaultIP(policyrules.IPAddresses())
 	if !ok {
@@ -121,7 +271,7 @@ func (i *Instance) ConfigureRules(version int, contextID string, containerInfo *
 
 	if i.mode != constants.LocalServer {
 
-		if err := i.addChainRules(appChain, netChain, ipAddress, "", ""); err != nil {
+		if err := i.addChainRules(contextID, appChain, netChain, ipAddress, "", ""); err != nil {
 			return err
 		}
 
@@ -135,24 +285,24 @@ func (i *Instance) ConfigureRules(version int, contextID string, containerInfo *
 		if !ok {
 			port = "0"
 		}
-		if err := i.addChainRules(appChain, netChain, ipAddress, port, mark); err != nil {
+		if err := i.addChainRules(contextID, appChain, netChain, ipAddress, port, mark); err != nil {
 			return err
 		}
 	}
 
-	if err := i.addPacketTrap(appChain, netChain, ipAddress, containerInfo.Policy.TriremeNetworks()); err != nil {
+	if err := i.addPacketTrap(appChain, netChain, ipAddress, i.filterFamily(containerInfo.Policy.TriremeNetworks())); err != nil {
 		return err
 	}
 
-	if err := i.addAppACLs(contextID, appChain, ipAddress, policyrules.ApplicationACLs()); err != nil {
+	if err := i.addAppACLs(contextID, appChain, ipAddress, i.filterRules(policyrules.ApplicationACLs()), policyrules.TriremeAction() == policy.Audit); err != nil {
 		return err
 	}
 
-	if err := i.addNetACLs(contextID, netChain, ipAddress, policyrules.NetworkACLs()); err != nil {
+	if err := i.addNetACLs(contextID, netChain, ipAddress, i.filterRules(policyrules.NetworkACLs()), policyrules.TriremeAction() == policy.Audit); err != nil {
 		return err
 	}
 
-	if err := i.addExclusionACLs(appChain, netChain, ipAddress, policyrules.ExcludedNetworks()); err != nil {
+	if err := i.addExclusionACLs(appChain, netChain, ipAddress, i.filterFamily(policyrules.ExcludedNetworks())); err != nil {
 		return err
 	}
 
@@ -170,6 +320,10 @@ func (i *Instance) DeleteRules(version int, contextID string, ipAddresses policy
 			return fmt.Errorf("Provided map of IP addresses is nil")
 		}
 
+		if i.skipPU(ipAddresses) {
+			return nil
+		}
+
 		ipAddress, ok = i.defaultIP(ipAddresses)
 		if !ok {
 			return fmt.Errorf("No ip address found ")
@@ -178,7 +332,7 @@ func (i *Instance) DeleteRules(version int, contextID string, ipAddresses policy
 
 	appChain, netChain := i.chainName(contextID, version)
 
-	if derr := i.deleteChainRules(appChain, netChain, ipAddress, port, mark); derr != nil {
+	if derr := i.deleteChainRules(contextID, appChain, netChain, ipAddress, port, mark); derr != nil {
 		zap.L().Warn("Failed to clean rules", zap.Error(derr))
 	}
 
@@ -189,9 +343,19 @@ func (i *Instance) DeleteRules(version int, contextID string, ipAddresses policy
 	return nil
 }
 
-// UpdateRules implements the update part of the interface
+// UpdateRules implements the update part of the interface. The new rules of
+// the PU are programmed in a single transaction and the old ones are removed
+// once it is committed.
 func (i *Instance) UpdateRules(version int, contextID string, containerInfo *policy.PUInfo) error {
 
+	return i.transaction(func(tx *Instance) error {
+		return tx.updateRules(version, contextID, containerInfo)
+	})
+}
+
+// updateRules replaces the rules of a PU
+func (i *Instance) updateRules(version int, contextID string, containerInfo *policy.PUInfo) error {
+
 	if containerInfo == nil {
 		return fmt.Errorf("Container info cannot be nil")
 	}
@@ -201,6 +365,10 @@ func (i *Instance) UpdateRules(version int, contextID string, containerInfo *pol
 		return fmt.Errorf("Policy rules cannot be nil")
 	}
 
+	if i.skipPU(policyrules.IPAddresses()) {
+		return nil
+	}
+
 	// Supporting only one ip
 	ipAddress, ok := i.defaultIP(policyrules.IPAddresses())
 	if !ok {
@@ -216,26 +384,26 @@ func (i *Instance) UpdateRules(version int, contextID string, containerInfo *pol
 		return err
 	}
 
-	if err := i.addPacketTrap(appChain, netChain, ipAddress, containerInfo.Policy.TriremeNetworks()); err != nil {
+	if err := i.addPacketTrap(appChain, netChain, ipAddress, i.filterFamily(containerInfo.Policy.TriremeNetworks())); err != nil {
 		return err
 	}
 
-	if err := i.addAppACLs(contextID, appChain, ipAddress, policyrules.ApplicationACLs()); err != nil {
+	if err := i.addAppACLs(contextID, appChain, ipAddress, i.filterRules(policyrules.ApplicationACLs()), policyrules.TriremeAction() == policy.Audit); err != nil {
 		return err
 	}
 
-	if err := i.addNetACLs(contextID, netChain, ipAddress, policyrules.NetworkACLs()); err != nil {
+	if err := i.addNetACLs(contextID, netChain, ipAddress, i.filterRules(policyrules.NetworkACLs()), policyrules.TriremeAction() == policy.Audit); err != nil {
 		return err
 	}
 
-	if err := i.addExclusionACLs(appChain, netChain, ipAddress, policyrules.ExcludedNetworks()); err != nil {
+	if err := i.addExclusionACLs(appChain, netChain, ipAddress, i.filterFamily(policyrules.ExcludedNetworks())); err != nil {
 		return err
 	}
 
 	// Add mapping to new chain
 	if i.mode != constants.LocalServer {
 
-		if err := i.addChainRules(appChain, netChain, ipAddress, "", ""); err != nil {
+		if err := i.addChainRules(contextID, appChain, netChain, ipAddress, "", ""); err != nil {
 			return err
 		}
 	} else {
@@ -248,14 +416,14 @@ func (i *Instance) UpdateRules(version int, contextID string, containerInfo *pol
 			portlist = "0"
 		}
 
-		if err := i.addChainRules(appChain, netChain, ipAddress, portlist, mark); err != nil {
+		if err := i.addChainRules(contextID, appChain, netChain, ipAddress, portlist, mark); err != nil {
 			return err
 		}
 	}
 
 	//Remove mapping from old chain
 	if i.mode != constants.LocalServer {
-		if err := i.deleteChainRules(oldAppChain, oldNetChain, ipAddress, "", ""); err != nil {
+		if err := i.deleteChainRules(contextID, oldAppChain, oldNetChain, ipAddress, "", ""); err != nil {
 			return err
 		}
 	} else {
@@ -264,7 +432,7 @@ func (i *Instance) UpdateRules(version int, contextID string, containerInfo *pol
 		if !ok {
 			port = "0"
 		}
-		if err := i.deleteChainRules(oldAppChain, oldNetChain, ipAddress, port, mark); err != nil {
+		if err := i.deleteChainRules(contextID, oldAppChain, oldNetChain, ipAddress, port, mark); err != nil {
 			return err
 		}
 	}
@@ -299,8 +467,9 @@ func (i *Instance) Start() error {
 // SetTargetNetworks updates ths target networks for SynAck packets
 func (i *Instance) SetTargetNetworks(current, networks []string) error {
 
-	if len(networks) == 0 {
-		networks = []string{"0.0.0.0/1", "128.0.0.0/1"}
+	networks = i.targetNetworks(networks)
+	if len(current) > 0 {
+		current = i.targetNetworks(current)
 	}
 
 	// Cleanup old ACLs
@@ -321,6 +490,34 @@ func (i *Instance) SetTargetNetworks(current, networks []string) error {
 	return nil
 }
 
+// targetNetworks returns the target networks of the address family of the
+// instance. When the list is empty or has no network of the family, all the
+// addresses of the family are targeted, so that the traffic of the family does not bypass
+// the datapath.
+func (i *Instance) targetNetworks(networks []string) []string {
+
+	filtered := i.filterFamily(networks)
+	if len(filtered) > 0 {
+		return filtered
+	}
+
+	defaults := []string{"0.0.0.0/1", "128.0.0.0/1"}
+	if i.ipv6 {
+		defaults = []string{"::/1", "8000::/1"}
+	}
+
+	if len(networks) == 0 {
+		return defaults
+	}
+
+	zap.L().Warn("No target network of the address family, targeting all the addresses",
+		zap.Strings("networks", networks),
+		zap.Strings("defaults", defaults),
+	)
+
+	return defaults
+}
+
 // Stop stops the supervisor
 func (i *Instance) Stop() error {
 
@@ -331,6 +528,17 @@ func (i *Instance) Stop() error {
 		zap.L().Error("Failed to clean acls while stopping the supervisor", zap.Error(err))
 	}
 
+	// The IPv6 instance only owns its target set. The IPv4 instance
+	// cleans up all the ipsets.
+	if i.ipv6 {
+		if i.targetSet != nil {
+			if err := i.targetSet.Destroy(); err != nil {
+				zap.L().Error("Failed to clean up target network ipset", zap.Error(err))
+			}
+		}
+		return nil
+	}
+
 	if err := i.ipset.DestroyAll(); err != nil {
 		zap.L().Error("Failed to clean up ipsets", zap.Error(err))
 	}
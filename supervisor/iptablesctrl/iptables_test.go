@@ -452,3 +452,197 @@ func TestStop(t *testing.T) {
 		})
 	})
 }
+
+func TestIPv6Instance(t *testing.T) {
+	Convey("Given an ip6tables controller", t, func() {
+		i, err := NewIPv6Instance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalContainer)
+		So(err, ShouldBeNil)
+		iptables := provider.NewTestIptablesProvider()
+		i.ipt = iptables
+
+		Convey("The target network set and the any network should be IPv6 specific", func() {
+			So(i.targetNetworkSet, ShouldEqual, targetNetworkSetIPv6)
+			So(i.anyNetwork(), ShouldEqual, "::/0")
+		})
+
+		Convey("When I get the default IP address of a list that has the IPv6 namespace", func() {
+			address, status := i.defaultIP(map[string]string{
+				policy.DefaultNamespace:     "10.1.1.1",
+				policy.DefaultNamespaceIPv6: "2001:db8::1",
+			})
+			So(address, ShouldEqual, "2001:db8::1")
+			So(status, ShouldBeTrue)
+		})
+
+		Convey("When I filter addresses, I should only get the IPv6 ones", func() {
+			So(i.filterFamily([]string{"10.0.0.0/8", "2001:db8::/32", "2001:db8::1", "bad"}), ShouldResemble, []string{"2001:db8::/32", "2001:db8::1"})
+		})
+
+		Convey("When the target networks have no IPv6 network, all the IPv6 addresses should be targeted", func() {
+			So(i.targetNetworks([]string{"10.0.0.0/8", "172.17.0.0/16"}), ShouldResemble, []string{"::/1", "8000::/1"})
+			So(i.targetNetworks([]string{}), ShouldResemble, []string{"::/1", "8000::/1"})
+			So(i.targetNetworks([]string{"10.0.0.0/8", "2001:db8::/32"}), ShouldResemble, []string{"2001:db8::/32"})
+		})
+
+		Convey("When I configure a PU with only an IPv4 address, no rules should be installed", func() {
+			ipl := policy.ExtendedMap{policy.DefaultNamespace: "172.17.0.1"}
+			containerinfo := policy.NewPUInfo("Context", constants.ContainerPU)
+			containerinfo.Policy = policy.NewPUPolicy("Context", policy.Police, nil, nil, nil, nil, nil, nil, ipl, []string{}, []string{})
+			containerinfo.Runtime = policy.NewPURuntimeWithDefaults()
+
+			iptables.MockNewChain(t, func(table string, chain string) error {
+				return fmt.Errorf("No chains should be created")
+			})
+			So(i.ConfigureRules(1, "Context", containerinfo), ShouldBeNil)
+		})
+
+		Convey("When I configure a PU with an IPv6 address, only IPv6 rules should be installed", func() {
+			rules := policy.IPRuleList{
+				policy.IPRule{
+					Address:  "192.30.253.0/24",
+					Port:     "80",
+					Protocol: "TCP",
+					Policy:   &policy.FlowPolicy{Action: policy.Accept},
+				},
+				policy.IPRule{
+					Address:  "2001:db8:1::/48",
+					Port:     "443",
+					Protocol: "TCP",
+					Policy:   &policy.FlowPolicy{Action: policy.Accept},
+				},
+			}
+			ipl := policy.ExtendedMap{policy.DefaultNamespace: "172.17.0.1", policy.DefaultNamespaceIPv6: "2001:db8::1"}
+			containerinfo := policy.NewPUInfo("Context", constants.ContainerPU)
+			containerinfo.Policy = policy.NewPUPolicy("Context", policy.Police, rules, rules, nil, nil, nil, nil, ipl, []string{"2001:db8::/32", "172.17.0.0/24"}, []string{"10.0.0.0/8"})
+			containerinfo.Runtime = policy.NewPURuntimeWithDefaults()
+
+			addresses := []string{}
+			record := func(table string, chain string, rulespec ...string) error {
+				for j, spec := range rulespec {
+					if (spec == "-s" || spec == "-d") && j+1 < len(rulespec) {
+						addresses = append(addresses, rulespec[j+1])
+					}
+				}
+				return nil
+			}
+			iptables.MockNewChain(t, func(table string, chain string) error {
+				return nil
+			})
+			iptables.MockAppend(t, record)
+			iptables.MockInsert(t, func(table string, chain string, pos int, rulespec ...string) error {
+				return record(table, chain, rulespec...)
+			})
+
+			So(i.ConfigureRules(1, "Context", containerinfo), ShouldBeNil)
+			So(addresses, ShouldContain, "2001:db8:1::/48")
+			So(addresses, ShouldContain, "2001:db8::1")
+			So(addresses, ShouldNotContain, "192.30.253.0/24")
+			So(addresses, ShouldNotContain, "172.17.0.1")
+			So(addresses, ShouldNotContain, "10.0.0.0/8")
+		})
+	})
+}
+
+func TestIPv6OnlyPU(t *testing.T) {
+	Convey("Given an iptables controller", t, func() {
+		i, err := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalContainer)
+		So(err, ShouldBeNil)
+		iptables := provider.NewTestIptablesProvider()
+		i.ipt = iptables
+
+		iptables.MockNewChain(t, func(table string, chain string) error {
+			return fmt.Errorf("No chains should be created")
+		})
+		iptables.MockDelete(t, func(table string, chain string, rulespec ...string) error {
+			return fmt.Errorf("No rules should be deleted")
+		})
+
+		ipl := policy.ExtendedMap{policy.DefaultNamespaceIPv6: "2001:db8::1"}
+		containerinfo := policy.NewPUInfo("Context", constants.ContainerPU)
+		containerinfo.Policy = policy.NewPUPolicy("Context", policy.Police, nil, nil, nil, nil, nil, nil, ipl, []string{}, []string{})
+		containerinfo.Runtime = policy.NewPURuntimeWithDefaults()
+
+		Convey("When I configure a PU with only an IPv6 address, it should be skipped without error", func() {
+			So(i.ConfigureRules(1, "Context", containerinfo), ShouldBeNil)
+		})
+
+		Convey("When I update a PU with only an IPv6 address, it should be skipped without error", func() {
+			So(i.UpdateRules(2, "Context", containerinfo), ShouldBeNil)
+		})
+
+		Convey("When I delete a PU with only an IPv6 address, it should be skipped without error", func() {
+			So(i.DeleteRules(1, "Context", ipl, "0", "0"), ShouldBeNil)
+		})
+	})
+}
+
+// testTransaction records the outcome of a transaction
+type testTransaction struct {
+	provider.TestIptablesProvider
+	committed bool
+	aborted   bool
+}
+
+func (t *testTransaction) Commit() error {
+	t.committed = true
+	return nil
+}
+
+func (t *testTransaction) Abort() {
+	t.aborted = true
+}
+
+// testBatchProvider returns the same transaction every time
+type testBatchProvider struct {
+	provider.TestIptablesProvider
+	tx *testTransaction
+}
+
+func (b *testBatchProvider) NewTransaction() provider.Transaction {
+	return b.tx
+}
+
+func TestTransactions(t *testing.T) {
+	Convey("Given an iptables controller with a batch provider", t, func() {
+		iptables := provider.NewTestIptablesProvider()
+		tx := &testTransaction{TestIptablesProvider: provider.NewTestIptablesProvider()}
+		i := newInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalContainer, &testBatchProvider{TestIptablesProvider: iptables, tx: tx}, false)
+
+		direct := 0
+		iptables.MockAppend(t, func(table string, chain string, rulespec ...string) error {
+			direct++
+			return nil
+		})
+		iptables.MockNewChain(t, func(table string, chain string) error {
+			direct++
+			return nil
+		})
+
+		ipl := policy.ExtendedMap{policy.DefaultNamespace: "172.17.0.1"}
+		containerinfo := policy.NewPUInfo("Context", constants.ContainerPU)
+		containerinfo.Policy = policy.NewPUPolicy("Context", policy.Police, nil, nil, nil, nil, nil, nil, ipl, []string{"172.17.0.0/24"}, []string{})
+		containerinfo.Runtime = policy.NewPURuntimeWithDefaults()
+
+		Convey("When I configure the rules, they should be programmed in the transaction and committed", func() {
+			buffered := 0
+			tx.MockAppend(t, func(table string, chain string, rulespec ...string) error {
+				buffered++
+				return nil
+			})
+			So(i.ConfigureRules(1, "Context", containerinfo), ShouldBeNil)
+			So(buffered, ShouldBeGreaterThan, 0)
+			So(direct, ShouldEqual, 0)
+			So(tx.committed, ShouldBeTrue)
+			So(tx.aborted, ShouldBeFalse)
+		})
+
+		Convey("When a rule fails, the transaction should be aborted", func() {
+			tx.MockNewChain(t, func(table string, chain string) error {
+				return fmt.Errorf("Failed to add container chain")
+			})
+			So(i.ConfigureRules(1, "Context", containerinfo), ShouldNotBeNil)
+			So(tx.committed, ShouldBeFalse)
+			So(tx.aborted, ShouldBeTrue)
+		})
+	})
+}
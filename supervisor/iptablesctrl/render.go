@@ -0,0 +1,31 @@
+package iptablesctrl
+
+import (
+	"fmt"
+
+	"github.com/aporeto-inc/trireme/policy"
+	"github.com/aporeto-inc/trireme/supervisor/provider"
+)
+
+// RenderRules implements the Renderer interface. It returns the tables,
+// chains and rules that ConfigureRules programs for the PU as an
+// iptables-save script, without programming them. Only the rules of the PU
+// are rendered: the global chains and the ipsets that Start programs, such
+// as the target networks, are not part of the script and must exist before
+// it is restored.
+func (i *Instance) RenderRules(version int, contextID string, containerInfo *policy.PUInfo) (string, error) {
+
+	if containerInfo == nil || containerInfo.Policy == nil {
+		return "", fmt.Errorf("Policy rules cannot be nil")
+	}
+
+	renderer := provider.NewIptablesRenderer()
+
+	dryRun := *i
+	dryRun.ipt = renderer
+	if err := dryRun.configureRules(version, contextID, containerInfo); err != nil {
+		return "", fmt.Errorf("Unable to render the rules of %s: %s", contextID, err)
+	}
+
+	return "# Rules of the PU " + contextID + " only. The global rules and the ipsets are programmed by Start.\n" + renderer.Render(), nil
+}
@@ -0,0 +1,74 @@
+package iptablesctrl
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/enforcer/utils/fqconfig"
+	"github.com/aporeto-inc/trireme/policy"
+	"github.com/aporeto-inc/trireme/supervisor/provider"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRenderRules(t *testing.T) {
+	Convey("Given an iptables controller", t, func() {
+		iptables := provider.NewTestIptablesProvider()
+		i := newInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalContainer, iptables, false)
+
+		programmed := 0
+		iptables.MockNewChain(t, func(table string, chain string) error {
+			programmed++
+			return nil
+		})
+		iptables.MockAppend(t, func(table string, chain string, rulespec ...string) error {
+			programmed++
+			return nil
+		})
+
+		rules := policy.IPRuleList{
+			policy.IPRule{
+				Address:  "192.30.253.0/24",
+				Port:     "80",
+				Protocol: "TCP",
+				Policy:   &policy.FlowPolicy{Action: policy.Reject},
+			},
+			policy.IPRule{
+				Address:  "192.30.253.0/24",
+				Port:     "443",
+				Protocol: "TCP",
+				Policy:   &policy.FlowPolicy{Action: policy.Accept | policy.Log, PolicyID: "policy"},
+			},
+		}
+
+		ipl := policy.ExtendedMap{policy.DefaultNamespace: "172.17.0.1"}
+		containerinfo := policy.NewPUInfo("Context", constants.ContainerPU)
+		containerinfo.Policy = policy.NewPUPolicy("Context", policy.Police, rules, rules, nil, nil, nil, nil, ipl, []string{"172.17.0.0/24"}, []string{"10.0.0.0/8"})
+		containerinfo.Runtime = policy.NewPURuntimeWithDefaults()
+
+		Convey("When I render the rules of a PU, they should match the golden file", func() {
+			script, err := i.RenderRules(1, "Context", containerinfo)
+			So(err, ShouldBeNil)
+
+			golden, err := ioutil.ReadFile("testdata/container.rules")
+			So(err, ShouldBeNil)
+			So(script, ShouldEqual, string(golden))
+
+			Convey("Nothing should be programmed", func() {
+				So(programmed, ShouldEqual, 0)
+			})
+		})
+
+		Convey("When I render the rules of a PU without address, I should get an error", func() {
+			containerinfo.Policy = policy.NewPUPolicy("Context", policy.Police, rules, rules, nil, nil, nil, nil, policy.ExtendedMap{}, []string{}, []string{})
+			_, err := i.RenderRules(1, "Context", containerinfo)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When I render the rules of a PU without policy, I should get an error", func() {
+			containerinfo.Policy = nil
+			_, err := i.RenderRules(1, "Context", containerinfo)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,422 @@
+// Package nftablesctrl implements the supervisor with native nftables rules.
+// All the rules live in a single inet table, so that one instance programs
+// both the IPv4 and the IPv6 addresses of the processing units. The base
+// chains dispatch the packets to the chains of the processing units with
+// verdict maps, and every change is applied as one atomic transaction.
+package nftablesctrl
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/enforcer/utils/fqconfig"
+	"github.com/aporeto-inc/trireme/monitor/linuxmonitor/cgnetcls"
+	"github.com/aporeto-inc/trireme/policy"
+	"github.com/aporeto-inc/trireme/supervisor/provider"
+)
+
+const (
+	tableFamily          = "inet"
+	tableName            = "trireme"
+	table                = tableFamily + " " + tableName
+	appBaseChain         = "app"
+	netBaseChain         = "net"
+	appChainPrefix       = "app-"
+	netChainPrefix       = "net-"
+	appMap               = "app-pus"
+	appMapIPv6           = "app-pus6"
+	netMap               = "net-pus"
+	netMapIPv6           = "net-pus6"
+	appCgroupMap         = "app-cgroups"
+	netPortMap           = "net-ports"
+	targetNetworkSet     = "TargetNetSet"
+	targetNetworkSetIPv6 = "TargetNetSet6"
+	chainPriority        = "-150"
+	hookOutput           = "output"
+	hookInput            = "input"
+	hookPreRouting       = "prerouting"
+	hookPostRouting      = "postrouting"
+)
+
+// invalidChainCharacters are the characters of a contextID that are
+// replaced in the name of a chain. The underscore is replaced as well, so
+// that only the sanitized names contain it.
+var invalidChainCharacters = regexp.MustCompile(`[^a-zA-Z0-9.-]`)
+
+// Instance is the structure holding all information about the implementation
+type Instance struct {
+	fqc      *fqconfig.FilterQueue
+	nft      provider.NftablesProvider
+	mode     constants.ModeType
+	appHook  string
+	netHook  string
+	cgroupV2 bool
+}
+
+// NewInstance creates a new nftables controller instance
+func NewInstance(fqc *fqconfig.FilterQueue, mode constants.ModeType) (*Instance, error) {
+
+	nft, err := provider.NewNftProvider()
+	if err != nil {
+		return nil, fmt.Errorf("Cannot initialize nftables provider: %s", err)
+	}
+
+	return newInstance(fqc, mode, nft), nil
+}
+
+// newInstance creates an instance with the given provider
+func newInstance(fqc *fqconfig.FilterQueue, mode constants.ModeType, nft provider.NftablesProvider) *Instance {
+
+	i := &Instance{
+		fqc:      fqc,
+		nft:      nft,
+		mode:     mode,
+		cgroupV2: cgnetcls.UnifiedHierarchy(),
+	}
+
+	if mode == constants.LocalServer || mode == constants.RemoteContainer {
+		i.appHook = hookOutput
+		i.netHook = hookInput
+	} else {
+		i.appHook = hookPreRouting
+		i.netHook = hookPostRouting
+	}
+
+	return i
+}
+
+// chainName returns the chain names for the specific PU. The contextIDs
+// that are not valid chain names are sanitized and suffixed with their hash,
+// so that two of them cannot get the same chains.
+func (i *Instance) chainName(contextID string, version int) (app, net string) {
+
+	id := invalidChainCharacters.ReplaceAllString(contextID, "_")
+	if id != contextID {
+		h := fnv.New64a()
+		h.Write([]byte(contextID)) // nolint
+		id = id + "-" + strconv.FormatUint(h.Sum64(), 16)
+	}
+
+	app = appChainPrefix + id + "-" + strconv.Itoa(version)
+	net = netChainPrefix + id + "-" + strconv.Itoa(version)
+
+	return app, net
+}
+
+// puKeys are the keys of the verdict maps that dispatch the packets of a PU
+// to its chains
+type puKeys struct {
+	ipv4   string
+	ipv6   string
+	mark   string
+	cgroup string
+	ports  []string
+}
+
+// addresses returns the keys of the addresses of the PU. Container PUs need
+// at least one address. The other PUs own all the traffic of their namespace.
+func (i *Instance) addresses(addresslist map[string]string) (*puKeys, error) {
+
+	keys := &puKeys{}
+
+	if ip, ok := addresslist[policy.DefaultNamespace]; ok && len(ip) > 0 {
+		keys.ipv4 = ip
+	}
+
+	if ip, ok := addresslist[policy.DefaultNamespaceIPv6]; ok && len(ip) > 0 {
+		keys.ipv6 = ip
+	}
+
+	if i.mode == constants.LocalContainer {
+		if keys.ipv4 == "" && keys.ipv6 == "" {
+			return nil, fmt.Errorf("No ip address found")
+		}
+		return keys, nil
+	}
+
+	if keys.ipv4 == "" {
+		keys.ipv4 = "0.0.0.0/0"
+	}
+
+	if keys.ipv6 == "" {
+		keys.ipv6 = "::/0"
+	}
+
+	return keys, nil
+}
+
+// puKeys returns the keys of a PU. Linux processes are identified by the
+// ports they listen on and by their cgroup: the net_cls classid on cgroup v1
+// and the path of the cgroup on the unified hierarchy.
+func (i *Instance) puKeys(contextID string, addresslist map[string]string, port string, mark string) (*puKeys, error) {
+
+	if i.mode != constants.LocalServer {
+		return i.addresses(addresslist)
+	}
+
+	if mark == "" {
+		return nil, fmt.Errorf("No Mark value found")
+	}
+
+	keys := &puKeys{
+		mark: mark,
+	}
+
+	if i.cgroupV2 {
+		keys.cgroup = strconv.Quote(strings.TrimPrefix(cgnetcls.CgroupPath(contextID), "/"))
+	}
+
+	for _, p := range strings.Split(port, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" || p == "0" {
+			continue
+		}
+		keys.ports = append(keys.ports, strings.Replace(p, ":", "-", 1))
+	}
+
+	return keys, nil
+}
+
+// runtimeKeys returns the keys of a PU from its runtime
+func (i *Instance) runtimeKeys(containerInfo *policy.PUInfo) (*puKeys, string, error) {
+
+	if i.mode != constants.LocalServer {
+		keys, err := i.addresses(containerInfo.Policy.IPAddresses())
+		return keys, "", err
+	}
+
+	mark, _ := containerInfo.Runtime.Options().Get(cgnetcls.CgroupMarkTag)
+	port, ok := containerInfo.Runtime.Options().Get(cgnetcls.PortTag)
+	if !ok {
+		port = "0"
+	}
+
+	keys, err := i.puKeys(containerInfo.ContextID, nil, port, mark)
+	return keys, mark, err
+}
+
+// elements returns the map elements of the keys that point to the chains
+func (i *Instance) elements(keys *puKeys, appChain, netChain string) [][2]string {
+
+	elements := [][2]string{}
+
+	if keys.ipv4 != "" {
+		elements = append(elements,
+			[2]string{appMap, keys.ipv4 + " : jump " + appChain},
+			[2]string{netMap, keys.ipv4 + " : jump " + netChain},
+		)
+	}
+
+	if keys.ipv6 != "" {
+		elements = append(elements,
+			[2]string{appMapIPv6, keys.ipv6 + " : jump " + appChain},
+			[2]string{netMapIPv6, keys.ipv6 + " : jump " + netChain},
+		)
+	}
+
+	switch {
+	case keys.cgroup != "":
+		elements = append(elements, [2]string{appCgroupMap, keys.cgroup + " : jump " + appChain})
+	case keys.mark != "":
+		elements = append(elements, [2]string{appCgroupMap, keys.mark + " : jump " + appChain})
+	}
+
+	for _, port := range keys.ports {
+		elements = append(elements, [2]string{netPortMap, port + " : jump " + netChain})
+	}
+
+	return elements
+}
+
+// addElements returns the commands that add the elements to the maps
+func addElements(elements [][2]string) []string {
+
+	commands := []string{}
+	for _, e := range elements {
+		commands = append(commands, "add element "+table+" "+e[0]+" { "+e[1]+" }")
+	}
+
+	return commands
+}
+
+// deleteElements returns the commands that delete the elements of the maps
+func deleteElements(elements [][2]string) []string {
+
+	commands := []string{}
+	for _, e := range elements {
+		key := strings.SplitN(e[1], " : ", 2)[0]
+		commands = append(commands, "delete element "+table+" "+e[0]+" { "+key+" }")
+	}
+
+	return commands
+}
+
+// deleteChains returns the commands that delete the chains of a PU
+func deleteChains(appChain, netChain string) []string {
+
+	return []string{
+		"flush chain " + table + " " + appChain,
+		"flush chain " + table + " " + netChain,
+		"delete chain " + table + " " + appChain,
+		"delete chain " + table + " " + netChain,
+	}
+}
+
+// ConfigureRules implements the ConfigureRules interface
+func (i *Instance) ConfigureRules(version int, contextID string, containerInfo *policy.PUInfo) error {
+
+	keys, mark, err := i.runtimeKeys(containerInfo)
+	if err != nil {
+		return err
+	}
+
+	appChain, netChain := i.chainName(contextID, version)
+
+	commands := i.containerChains(contextID, appChain, netChain, mark, containerInfo.Policy)
+	commands = append(commands, addElements(i.elements(keys, appChain, netChain))...)
+
+	if err := i.nft.Apply(commands); err != nil {
+		return fmt.Errorf("Failed to configure the rules of %s: %s", contextID, err)
+	}
+
+	return nil
+}
+
+// UpdateRules implements the update part of the interface. The new chains
+// replace the old ones in the same transaction, so there is no window
+// without a policy.
+func (i *Instance) UpdateRules(version int, contextID string, containerInfo *policy.PUInfo) error {
+
+	if containerInfo == nil {
+		return fmt.Errorf("Container info cannot be nil")
+	}
+
+	if containerInfo.Policy == nil {
+		return fmt.Errorf("Policy rules cannot be nil")
+	}
+
+	keys, mark, err := i.runtimeKeys(containerInfo)
+	if err != nil {
+		return err
+	}
+
+	appChain, netChain := i.chainName(contextID, version)
+	oldAppChain, oldNetChain := i.chainName(contextID, version^1)
+
+	commands := i.containerChains(contextID, appChain, netChain, mark, containerInfo.Policy)
+	commands = append(commands, deleteElements(i.elements(keys, oldAppChain, oldNetChain))...)
+	commands = append(commands, addElements(i.elements(keys, appChain, netChain))...)
+	commands = append(commands, deleteChains(oldAppChain, oldNetChain)...)
+
+	if err := i.nft.Apply(commands); err != nil {
+		return fmt.Errorf("Failed to update the rules of %s: %s", contextID, err)
+	}
+
+	return nil
+}
+
+// DeleteRules implements the DeleteRules interface. The elements are removed
+// one by one, so that a missing element does not prevent the cleanup.
+func (i *Instance) DeleteRules(version int, contextID string, ipAddresses policy.ExtendedMap, port string, mark string) error {
+
+	if i.mode != constants.LocalServer && ipAddresses == nil {
+		return fmt.Errorf("Provided map of IP addresses is nil")
+	}
+
+	keys, err := i.puKeys(contextID, ipAddresses, port, mark)
+	if err != nil {
+		return err
+	}
+
+	appChain, netChain := i.chainName(contextID, version)
+
+	for _, command := range deleteElements(i.elements(keys, appChain, netChain)) {
+		if err := i.nft.Apply([]string{command}); err != nil {
+			zap.L().Warn("Failed to delete map element", zap.String("command", command), zap.Error(err))
+		}
+	}
+
+	if err := i.nft.Apply(deleteChains(appChain, netChain)); err != nil {
+		zap.L().Warn("Failed to clean container chains while deleting the rules", zap.Error(err))
+	}
+
+	return nil
+}
+
+// SetTargetNetworks updates the target networks. The sets are replaced in a
+// single transaction.
+func (i *Instance) SetTargetNetworks(current, networks []string) error {
+
+	if len(networks) == 0 {
+		networks = []string{"0.0.0.0/1", "128.0.0.0/1", "::/1", "8000::/1"}
+	}
+
+	ipv4 := []string{}
+	ipv6 := []string{}
+
+	for _, network := range networks {
+		ip, _, err := net.ParseCIDR(network)
+		if err != nil {
+			if ip = net.ParseIP(network); ip == nil {
+				return fmt.Errorf("Invalid target network %s", network)
+			}
+		}
+
+		if ip.To4() != nil {
+			ipv4 = append(ipv4, network)
+		} else {
+			ipv6 = append(ipv6, network)
+		}
+	}
+
+	commands := []string{
+		"flush set " + table + " " + targetNetworkSet,
+		"flush set " + table + " " + targetNetworkSetIPv6,
+	}
+
+	if len(ipv4) > 0 {
+		commands = append(commands, "add element "+table+" "+targetNetworkSet+" { "+strings.Join(ipv4, ", ")+" }")
+	}
+
+	if len(ipv6) > 0 {
+		commands = append(commands, "add element "+table+" "+targetNetworkSetIPv6+" { "+strings.Join(ipv6, ", ")+" }")
+	}
+
+	if err := i.nft.Apply(commands); err != nil {
+		return fmt.Errorf("Failed to update target networks: %s", err)
+	}
+
+	return nil
+}
+
+// Start creates the table with the global rules. Any previous table is
+// replaced.
+func (i *Instance) Start() error {
+
+	if err := i.nft.Apply(i.globalRules()); err != nil {
+		return fmt.Errorf("Failed to create the nftables table: %s", err)
+	}
+
+	zap.L().Debug("Started the nftables controller")
+
+	return nil
+}
+
+// Stop removes the table and all the rules
+func (i *Instance) Stop() error {
+
+	zap.L().Debug("Stop the supervisor")
+
+	if err := i.nft.Apply([]string{"add table " + table, "delete table " + table}); err != nil {
+		zap.L().Error("Failed to clean the nftables table while stopping the supervisor", zap.Error(err))
+	}
+
+	return nil
+}
@@ -0,0 +1,422 @@
+package nftablesctrl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/enforcer/utils/fqconfig"
+	"github.com/aporeto-inc/trireme/monitor/linuxmonitor/cgnetcls"
+	"github.com/aporeto-inc/trireme/policy"
+	"github.com/aporeto-inc/trireme/supervisor/provider"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// recordCommands mocks the provider and returns the applied transactions
+func recordCommands(t *testing.T, nft provider.TestNftablesProvider) *[][]string {
+
+	transactions := &[][]string{}
+	nft.MockApply(t, func(commands []string) error {
+		*transactions = append(*transactions, commands)
+		return nil
+	})
+
+	return transactions
+}
+
+// contains returns true if a command of the transaction contains all the parts
+func contains(commands []string, parts ...string) bool {
+
+	for _, command := range commands {
+		found := true
+		for _, part := range parts {
+			if !strings.Contains(command, part) {
+				found = false
+				break
+			}
+		}
+		if found {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containerInfo(action policy.PUAction, ips policy.ExtendedMap) *policy.PUInfo {
+
+	appACLs := policy.IPRuleList{
+		policy.IPRule{
+			Address:  "192.30.253.0/24",
+			Port:     "80",
+			Protocol: "TCP",
+			Policy:   &policy.FlowPolicy{Action: policy.Reject, PolicyID: "web"},
+		},
+		policy.IPRule{
+			Address:  "192.30.254.0/24",
+			Port:     "1000:2000",
+			Protocol: "udp",
+			Policy:   &policy.FlowPolicy{Action: policy.Accept | policy.Log, PolicyID: "udp"},
+		},
+	}
+
+	netACLs := policy.IPRuleList{
+		policy.IPRule{
+			Address:  "2001:db8::/32",
+			Protocol: "icmpv6",
+			Policy:   &policy.FlowPolicy{Action: policy.Accept, PolicyID: "icmp"},
+		},
+	}
+
+	p := policy.NewPUPolicy("Context", action, appACLs, netACLs, nil, nil, nil, nil, ips, []string{"172.17.0.0/16"}, []string{"10.10.10.0/24"})
+	puInfo := policy.NewPUInfo("Context", constants.ContainerPU)
+	puInfo.Policy = p
+	puInfo.Runtime.SetOptions(policy.ExtendedMap{
+		cgnetcls.CgroupMarkTag: "100",
+		cgnetcls.PortTag:       "80,8000:8100",
+	})
+
+	return puInfo
+}
+
+func TestChainName(t *testing.T) {
+
+	Convey("When I get the chain names of a context", t, func() {
+		i := newInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalServer, provider.NewTestNftablesProvider())
+		app, net := i.chainName("/trireme/1234", 1)
+
+		Convey("I should get names without invalid characters, suffixed with the hash of the context", func() {
+			So(app, ShouldStartWith, "app-_trireme_1234-")
+			So(app, ShouldEndWith, "-1")
+			So(net, ShouldStartWith, "net-_trireme_1234-")
+			So(strings.TrimPrefix(app, "app-"), ShouldEqual, strings.TrimPrefix(net, "net-"))
+		})
+
+		Convey("Contexts that are sanitized to the same name should get different chains", func() {
+			other, _ := i.chainName("_trireme_1234", 1)
+			slash, _ := i.chainName("/trireme/1234", 1)
+			valid, _ := i.chainName("trireme-1234", 1)
+			So(other, ShouldNotEqual, app)
+			So(slash, ShouldEqual, app)
+			So(valid, ShouldEqual, "app-trireme-1234-1")
+		})
+	})
+}
+
+func TestQueue(t *testing.T) {
+
+	Convey("When I convert the queues of fqconfig", t, func() {
+		So(queue("0:3", false), ShouldEqual, "queue num 0-3 fanout")
+		So(queue("4:4", true), ShouldEqual, "queue num 4 bypass")
+		So(queue("4:7", true), ShouldEqual, "queue num 4-7 bypass,fanout")
+		So(queue("4", false), ShouldEqual, "queue num 4")
+	})
+}
+
+func TestStartStop(t *testing.T) {
+
+	Convey("Given an nftables controller for containers", t, func() {
+		nft := provider.NewTestNftablesProvider()
+		i := newInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalContainer, nft)
+		transactions := recordCommands(t, nft)
+
+		Convey("When I start it, the table should be replaced in one transaction", func() {
+			So(i.Start(), ShouldBeNil)
+			So(len(*transactions), ShouldEqual, 1)
+
+			commands := (*transactions)[0]
+			So(commands[0], ShouldEqual, "add table inet trireme")
+			So(commands[1], ShouldEqual, "delete table inet trireme")
+			So(contains(commands, "add chain inet trireme app", "hook prerouting"), ShouldBeTrue)
+			So(contains(commands, "add chain inet trireme net", "hook postrouting"), ShouldBeTrue)
+			So(contains(commands, "add set inet trireme TargetNetSet6", "ipv6_addr"), ShouldBeTrue)
+			So(contains(commands, "add rule inet trireme app meta mark"), ShouldBeTrue)
+			So(contains(commands, "add rule inet trireme net ct mark 61166 accept"), ShouldBeTrue)
+			So(contains(commands, "add rule inet trireme app ip saddr vmap @app-pus"), ShouldBeTrue)
+			So(contains(commands, "add rule inet trireme net ip6 daddr vmap @net-pus6"), ShouldBeTrue)
+		})
+
+		Convey("When the transaction fails, I should get an error", func() {
+			nft.MockApply(t, func(commands []string) error {
+				return fmt.Errorf("error")
+			})
+			So(i.Start(), ShouldNotBeNil)
+		})
+
+		Convey("When I stop it, the table should be deleted", func() {
+			So(i.Stop(), ShouldBeNil)
+			So(len(*transactions), ShouldEqual, 1)
+			So((*transactions)[0], ShouldResemble, []string{"add table inet trireme", "delete table inet trireme"})
+		})
+	})
+
+	Convey("Given an nftables controller for Linux processes", t, func() {
+		nft := provider.NewTestNftablesProvider()
+		i := newInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalServer, nft)
+		transactions := recordCommands(t, nft)
+
+		Convey("When I start it, the PUs should be dispatched by cgroup and port", func() {
+			So(i.Start(), ShouldBeNil)
+
+			commands := (*transactions)[0]
+			So(contains(commands, "add chain inet trireme app", "hook output"), ShouldBeTrue)
+			So(contains(commands, "add rule inet trireme app meta cgroup vmap @app-cgroups"), ShouldBeTrue)
+			So(contains(commands, "add rule inet trireme net meta l4proto { tcp, udp } th dport vmap @net-ports"), ShouldBeTrue)
+			So(contains(commands, "add rule inet trireme app meta mark"), ShouldBeFalse)
+		})
+
+		Convey("When I start it on the unified cgroup hierarchy, the PUs should be dispatched by the path of their cgroup", func() {
+			i.cgroupV2 = true
+			So(i.Start(), ShouldBeNil)
+
+			commands := (*transactions)[0]
+			So(contains(commands, "add map inet trireme app-cgroups { typeof socket cgroupv2 level 2 : verdict; }"), ShouldBeTrue)
+			So(contains(commands, "add rule inet trireme app socket cgroupv2 level 2 vmap @app-cgroups"), ShouldBeTrue)
+			So(contains(commands, "meta cgroup"), ShouldBeFalse)
+		})
+	})
+}
+
+func TestRuleset(t *testing.T) {
+
+	Convey("Given an nftables controller for Linux processes on the unified cgroup hierarchy", t, func() {
+		nft := provider.NewTestNftablesProvider()
+		i := newInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalServer, nft)
+		i.cgroupV2 = true
+		transactions := recordCommands(t, nft)
+
+		Convey("When I start it and configure a PU, the ruleset should match the golden file", func() {
+			So(i.Start(), ShouldBeNil)
+			So(i.SetTargetNetworks(nil, []string{"172.17.0.0/16", "2001:db8::/32"}), ShouldBeNil)
+			So(i.ConfigureRules(0, "Context", containerInfo(policy.Police, nil)), ShouldBeNil)
+
+			ruleset := ""
+			for _, commands := range *transactions {
+				ruleset += strings.Join(commands, "\n") + "\n"
+			}
+
+			golden, err := ioutil.ReadFile("testdata/ruleset.nft")
+			So(err, ShouldBeNil)
+			So(ruleset, ShouldEqual, string(golden))
+
+			Convey("The golden file should be accepted by nft if it is available", func() {
+				if _, err := exec.LookPath("nft"); err != nil || os.Geteuid() != 0 {
+					return
+				}
+				out, err := exec.Command("nft", "-c", "-f", "testdata/ruleset.nft").CombinedOutput()
+				So(string(out), ShouldBeEmpty)
+				So(err, ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestSetTargetNetworks(t *testing.T) {
+
+	Convey("Given an nftables controller", t, func() {
+		nft := provider.NewTestNftablesProvider()
+		i := newInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalContainer, nft)
+		transactions := recordCommands(t, nft)
+
+		Convey("When I set target networks, the sets should be replaced", func() {
+			So(i.SetTargetNetworks(nil, []string{"10.0.0.0/8", "2001:db8::/32", "172.17.0.1"}), ShouldBeNil)
+			So((*transactions)[0], ShouldResemble, []string{
+				"flush set inet trireme TargetNetSet",
+				"flush set inet trireme TargetNetSet6",
+				"add element inet trireme TargetNetSet { 10.0.0.0/8, 172.17.0.1 }",
+				"add element inet trireme TargetNetSet6 { 2001:db8::/32 }",
+			})
+		})
+
+		Convey("When I set no target networks, all the traffic should be captured", func() {
+			So(i.SetTargetNetworks(nil, nil), ShouldBeNil)
+			So(contains((*transactions)[0], "TargetNetSet { 0.0.0.0/1, 128.0.0.0/1 }"), ShouldBeTrue)
+			So(contains((*transactions)[0], "TargetNetSet6 { ::/1, 8000::/1 }"), ShouldBeTrue)
+		})
+
+		Convey("When I set an invalid network, I should get an error", func() {
+			So(i.SetTargetNetworks(nil, []string{"invalid"}), ShouldNotBeNil)
+			So(len(*transactions), ShouldEqual, 0)
+		})
+	})
+}
+
+func TestConfigureRules(t *testing.T) {
+
+	Convey("Given an nftables controller for containers", t, func() {
+		nft := provider.NewTestNftablesProvider()
+		i := newInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalContainer, nft)
+		transactions := recordCommands(t, nft)
+
+		Convey("When I configure a PU, its chains and elements should be added in one transaction", func() {
+			err := i.ConfigureRules(0, "Context", containerInfo(policy.Police, policy.ExtendedMap{policy.DefaultNamespace: "172.17.0.2"}))
+			So(err, ShouldBeNil)
+			So(len(*transactions), ShouldEqual, 1)
+
+			commands := (*transactions)[0]
+			So(commands[0], ShouldEqual, "add chain inet trireme app-Context-0")
+			So(commands[1], ShouldEqual, "add chain inet trireme net-Context-0")
+			So(contains(commands, "add rule inet trireme app-Context-0 ip daddr 10.10.10.0/24 accept"), ShouldBeTrue)
+			So(contains(commands, "add rule inet trireme net-Context-0 ip saddr 10.10.10.0/24 tcp option 34 missing accept"), ShouldBeTrue)
+			So(contains(commands, "add rule inet trireme app-Context-0 ip daddr 192.30.253.0/24 tcp dport 80 ct state new drop"), ShouldBeTrue)
+			So(contains(commands, `add rule inet trireme app-Context-0 ip daddr 192.30.254.0/24 udp dport 1000-2000 ct state new log prefix "Context:udp:a" group 10`), ShouldBeTrue)
+			So(contains(commands, "add rule inet trireme app-Context-0 ip daddr 192.30.254.0/24 udp dport 1000-2000 ct state new accept"), ShouldBeTrue)
+			So(contains(commands, "add rule inet trireme net-Context-0 ip6 saddr 2001:db8::/32 meta l4proto icmpv6 accept"), ShouldBeTrue)
+			So(contains(commands, "add rule inet trireme app-Context-0 ip daddr @TargetNetSet tcp flags & (fin|syn|rst|psh|urg) == syn queue num"), ShouldBeTrue)
+			So(contains(commands, "add rule inet trireme net-Context-0 ip6 saddr @TargetNetSet6 meta l4proto udp queue num"), ShouldBeTrue)
+			So(commands[len(commands)-3], ShouldEqual, "add rule inet trireme net-Context-0 drop")
+			So(commands[len(commands)-2], ShouldEqual, "add element inet trireme app-pus { 172.17.0.2 : jump app-Context-0 }")
+			So(commands[len(commands)-1], ShouldEqual, "add element inet trireme net-pus { 172.17.0.2 : jump net-Context-0 }")
+		})
+
+		Convey("When I configure an audited PU, the rejected traffic should be logged and accepted", func() {
+			err := i.ConfigureRules(0, "Context", containerInfo(policy.Audit, policy.ExtendedMap{policy.DefaultNamespace: "172.17.0.2"}))
+			So(err, ShouldBeNil)
+
+			commands := (*transactions)[0]
+			So(contains(commands, `ip daddr 192.30.253.0/24 tcp dport 80 ct state new log prefix "Context:web:r" group 10`), ShouldBeTrue)
+			So(contains(commands, "ip daddr 192.30.253.0/24 tcp dport 80 ct state new accept"), ShouldBeTrue)
+			So(contains(commands, " drop"), ShouldBeFalse)
+		})
+
+		Convey("When I configure a PU without address, I should get an error", func() {
+			err := i.ConfigureRules(0, "Context", containerInfo(policy.Police, policy.ExtendedMap{}))
+			So(err, ShouldNotBeNil)
+			So(len(*transactions), ShouldEqual, 0)
+		})
+
+		Convey("When the transaction fails, I should get an error", func() {
+			nft.MockApply(t, func(commands []string) error {
+				return fmt.Errorf("error")
+			})
+			err := i.ConfigureRules(0, "Context", containerInfo(policy.Police, policy.ExtendedMap{policy.DefaultNamespace: "172.17.0.2"}))
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given an nftables controller for Linux processes", t, func() {
+		nft := provider.NewTestNftablesProvider()
+		i := newInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalServer, nft)
+		transactions := recordCommands(t, nft)
+
+		Convey("When I configure a PU, it should be dispatched by its cgroup and its ports", func() {
+			err := i.ConfigureRules(0, "Context", containerInfo(policy.Police, nil))
+			So(err, ShouldBeNil)
+
+			commands := (*transactions)[0]
+			So(commands[2], ShouldEqual, "add rule inet trireme app-Context-0 meta mark set 100")
+			So(contains(commands, "add element inet trireme app-cgroups { 100 : jump app-Context-0 }"), ShouldBeTrue)
+			So(contains(commands, "add element inet trireme net-ports { 80 : jump net-Context-0 }"), ShouldBeTrue)
+			So(contains(commands, "add element inet trireme net-ports { 8000-8100 : jump net-Context-0 }"), ShouldBeTrue)
+			So(contains(commands, "app-pus"), ShouldBeFalse)
+		})
+
+		Convey("When I configure a PU on the unified cgroup hierarchy, it should be dispatched by the path of its cgroup", func() {
+			i.cgroupV2 = true
+			err := i.ConfigureRules(0, "Context", containerInfo(policy.Police, nil))
+			So(err, ShouldBeNil)
+
+			commands := (*transactions)[0]
+			So(contains(commands, `add element inet trireme app-cgroups { "trireme/Context" : jump app-Context-0 }`), ShouldBeTrue)
+			So(contains(commands, "add element inet trireme app-cgroups { 100 :"), ShouldBeFalse)
+
+			Convey("When I delete it, the element of its cgroup should be deleted", func() {
+				So(i.DeleteRules(0, "Context", nil, "80", "100"), ShouldBeNil)
+				So(contains((*transactions)[1], `delete element inet trireme app-cgroups { "trireme/Context" }`), ShouldBeTrue)
+			})
+		})
+	})
+
+	Convey("Given an nftables controller in a remote container", t, func() {
+		nft := provider.NewTestNftablesProvider()
+		i := newInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, nft)
+		transactions := recordCommands(t, nft)
+
+		Convey("When I configure a PU without address, it should own all the traffic", func() {
+			err := i.ConfigureRules(0, "Context", containerInfo(policy.Police, policy.ExtendedMap{}))
+			So(err, ShouldBeNil)
+
+			commands := (*transactions)[0]
+			So(contains(commands, "add element inet trireme app-pus { 0.0.0.0/0 : jump app-Context-0 }"), ShouldBeTrue)
+			So(contains(commands, "add element inet trireme net-pus6 { ::/0 : jump net-Context-0 }"), ShouldBeTrue)
+		})
+	})
+}
+
+func TestUpdateRules(t *testing.T) {
+
+	Convey("Given an nftables controller", t, func() {
+		nft := provider.NewTestNftablesProvider()
+		i := newInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalContainer, nft)
+		transactions := recordCommands(t, nft)
+
+		Convey("When I update a PU, the chains should be swapped in one transaction", func() {
+			err := i.UpdateRules(1, "Context", containerInfo(policy.Police, policy.ExtendedMap{policy.DefaultNamespace: "172.17.0.2", policy.DefaultNamespaceIPv6: "2001:db8::2"}))
+			So(err, ShouldBeNil)
+			So(len(*transactions), ShouldEqual, 1)
+
+			commands := (*transactions)[0]
+			So(commands[0], ShouldEqual, "add chain inet trireme app-Context-1")
+			So(commands[len(commands)-12:], ShouldResemble, []string{
+				"delete element inet trireme app-pus { 172.17.0.2 }",
+				"delete element inet trireme net-pus { 172.17.0.2 }",
+				"delete element inet trireme app-pus6 { 2001:db8::2 }",
+				"delete element inet trireme net-pus6 { 2001:db8::2 }",
+				"add element inet trireme app-pus { 172.17.0.2 : jump app-Context-1 }",
+				"add element inet trireme net-pus { 172.17.0.2 : jump net-Context-1 }",
+				"add element inet trireme app-pus6 { 2001:db8::2 : jump app-Context-1 }",
+				"add element inet trireme net-pus6 { 2001:db8::2 : jump net-Context-1 }",
+				"flush chain inet trireme app-Context-0",
+				"flush chain inet trireme net-Context-0",
+				"delete chain inet trireme app-Context-0",
+				"delete chain inet trireme net-Context-0",
+			})
+		})
+
+		Convey("When I update a PU without policy, I should get an error", func() {
+			So(i.UpdateRules(1, "Context", nil), ShouldNotBeNil)
+
+			puInfo := policy.NewPUInfo("Context", constants.ContainerPU)
+			puInfo.Policy = nil
+			So(i.UpdateRules(1, "Context", puInfo), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestDeleteRules(t *testing.T) {
+
+	Convey("Given an nftables controller", t, func() {
+		nft := provider.NewTestNftablesProvider()
+		i := newInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalContainer, nft)
+		transactions := recordCommands(t, nft)
+
+		Convey("When I delete a PU, all the elements and chains should be deleted even if some fail", func() {
+			nft.MockApply(t, func(commands []string) error {
+				*transactions = append(*transactions, commands)
+				if strings.HasPrefix(commands[0], "delete element") {
+					return fmt.Errorf("element not found")
+				}
+				return nil
+			})
+
+			err := i.DeleteRules(0, "Context", policy.ExtendedMap{policy.DefaultNamespace: "172.17.0.2"}, "0", "")
+			So(err, ShouldBeNil)
+			So(len(*transactions), ShouldEqual, 3)
+			So((*transactions)[0], ShouldResemble, []string{"delete element inet trireme app-pus { 172.17.0.2 }"})
+			So((*transactions)[2], ShouldResemble, []string{
+				"flush chain inet trireme app-Context-0",
+				"flush chain inet trireme net-Context-0",
+				"delete chain inet trireme app-Context-0",
+				"delete chain inet trireme net-Context-0",
+			})
+		})
+
+		Convey("When I delete a PU without addresses, I should get an error", func() {
+			So(i.DeleteRules(0, "Context", nil, "0", ""), ShouldNotBeNil)
+		})
+	})
+}
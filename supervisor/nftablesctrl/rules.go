@@ -0,0 +1,339 @@
+package nftablesctrl
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/enforcer/utils/packet"
+	"github.com/aporeto-inc/trireme/monitor/linuxmonitor/cgnetcls"
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+// family returns the nftables address family of an address or network
+func family(address string) string {
+
+	ip := net.ParseIP(address)
+	if ip == nil {
+		ip, _, _ = net.ParseCIDR(address)
+	}
+
+	if ip != nil && ip.To4() == nil {
+		return "ip6"
+	}
+
+	return "ip"
+}
+
+// targetSet returns the target network set of an address family
+func targetSet(family string) string {
+
+	if family == "ip6" {
+		return targetNetworkSetIPv6
+	}
+
+	return targetNetworkSet
+}
+
+// queue returns the statement that sends packets to a range of queues. The
+// range uses the iptables format of fqconfig.
+func queue(queues string, bypass bool) string {
+
+	flags := []string{}
+	if bypass {
+		flags = append(flags, "bypass")
+	}
+
+	parts := strings.SplitN(queues, ":", 2)
+	num := parts[0]
+	if len(parts) == 2 && parts[1] != parts[0] {
+		num = parts[0] + "-" + parts[1]
+		flags = append(flags, "fanout")
+	}
+
+	if len(flags) == 0 {
+		return "queue num " + num
+	}
+
+	return "queue num " + num + " " + strings.Join(flags, ",")
+}
+
+// logStatement returns the statement that sends packets to the nflog group
+func logStatement(group int, prefix string) string {
+
+	return fmt.Sprintf("log prefix \"%s\" group %d", prefix, group)
+}
+
+// dropVerdict returns the verdict of the rules that reject traffic. Audited
+// PUs log the rejected traffic and accept it.
+func dropVerdict(audit bool) string {
+
+	if audit {
+		return "accept"
+	}
+
+	return "drop"
+}
+
+// addRules returns the commands that append the rules to a chain
+func addRules(chain string, rules []string) []string {
+
+	commands := make([]string, len(rules))
+	for idx, rule := range rules {
+		commands[idx] = "add rule " + table + " " + chain + " " + rule
+	}
+
+	return commands
+}
+
+// cgroupKey returns the expression that identifies the cgroup of the
+// processes. The net_cls classid only exists on cgroup v1. On the unified
+// hierarchy, the Trireme cgroups are matched at their level of the tree.
+func (i *Instance) cgroupKey() string {
+
+	if !i.cgroupV2 {
+		return "meta cgroup"
+	}
+
+	level := strings.Count(strings.Trim(cgnetcls.CgroupPath("pu"), "/"), "/") + 1
+
+	return "socket cgroupv2 level " + strconv.Itoa(level)
+}
+
+// globalRules returns the commands that create the table, the sets, the
+// verdict maps and the base chains
+func (i *Instance) globalRules() []string {
+
+	mark := func(m uint32) string {
+		return "ct mark " + strconv.Itoa(int(m))
+	}
+
+	commands := []string{
+		// Replace any previous table
+		"add table " + table,
+		"delete table " + table,
+		"add table " + table,
+		"add set " + table + " " + targetNetworkSet + " { type ipv4_addr; flags interval; }",
+		"add set " + table + " " + targetNetworkSetIPv6 + " { type ipv6_addr; flags interval; }",
+		"add map " + table + " " + appMap + " { type ipv4_addr : verdict; flags interval; }",
+		"add map " + table + " " + appMapIPv6 + " { type ipv6_addr : verdict; flags interval; }",
+		"add map " + table + " " + netMap + " { type ipv4_addr : verdict; flags interval; }",
+		"add map " + table + " " + netMapIPv6 + " { type ipv6_addr : verdict; flags interval; }",
+		"add map " + table + " " + appCgroupMap + " { typeof " + i.cgroupKey() + " : verdict; }",
+		"add map " + table + " " + netPortMap + " { type inet_service : verdict; flags interval; }",
+		"add chain " + table + " " + appBaseChain + " { type filter hook " + i.appHook + " priority " + chainPriority + "; }",
+		"add chain " + table + " " + netBaseChain + " { type filter hook " + i.netHook + " priority " + chainPriority + "; }",
+	}
+
+	appRules := []string{}
+
+	if i.mode == constants.LocalContainer {
+		appRules = append(appRules, "meta mark "+strconv.Itoa(i.fqc.GetMarkValue())+" accept")
+	}
+
+	appRules = append(appRules,
+		// Packets of flows revoked by a policy update are reset by the enforcer
+		mark(constants.RevokedConnMark)+" meta l4proto tcp "+queue(i.fqc.GetApplicationQueueAckStr(), false),
+		// Packets of encrypted flows must always be processed by the enforcer
+		mark(constants.EncryptConnMark)+" meta l4proto tcp "+queue(i.fqc.GetApplicationQueueAckStr(), false),
+		// The teardown packets of accepted flows are processed by the enforcer
+		mark(constants.DefaultConnMark)+" tcp flags & (fin|rst) != 0 "+queue(i.fqc.GetApplicationQueueAckStr(), true),
+		mark(constants.DefaultConnMark)+" accept",
+		"ip daddr @"+targetNetworkSet+" tcp flags & (syn|ack) == syn|ack "+queue(i.fqc.GetApplicationQueueSynAckStr(), true),
+		"ip6 daddr @"+targetNetworkSetIPv6+" tcp flags & (syn|ack) == syn|ack "+queue(i.fqc.GetApplicationQueueSynAckStr(), true),
+	)
+
+	netRules := []string{
+		mark(constants.RevokedConnMark) + " meta l4proto tcp " + queue(i.fqc.GetNetworkQueueAckStr(), false),
+		mark(constants.EncryptConnMark) + " meta l4proto tcp " + queue(i.fqc.GetNetworkQueueAckStr(), false),
+		mark(constants.DefaultConnMark) + " tcp flags & (fin|rst) != 0 " + queue(i.fqc.GetNetworkQueueAckStr(), true),
+		mark(constants.DefaultConnMark) + " accept",
+		"ip saddr @" + targetNetworkSet + " tcp flags & (syn|ack) == syn|ack " + queue(i.fqc.GetNetworkQueueSynAckStr(), true),
+		"ip6 saddr @" + targetNetworkSetIPv6 + " tcp flags & (syn|ack) == syn|ack " + queue(i.fqc.GetNetworkQueueSynAckStr(), true),
+	}
+
+	// Dispatch the packets to the chains of the PUs
+	if i.mode == constants.LocalServer {
+		appRules = append(appRules, i.cgroupKey()+" vmap @"+appCgroupMap)
+		netRules = append(netRules, "meta l4proto { tcp, udp } th dport vmap @"+netPortMap)
+	} else {
+		appRules = append(appRules,
+			"ip saddr vmap @"+appMap,
+			"ip6 saddr vmap @"+appMapIPv6,
+		)
+		netRules = append(netRules,
+			"ip daddr vmap @"+netMap,
+			"ip6 daddr vmap @"+netMapIPv6,
+		)
+	}
+
+	commands = append(commands, addRules(appBaseChain, appRules)...)
+	commands = append(commands, addRules(netBaseChain, netRules)...)
+
+	return commands
+}
+
+// trapRules returns the rules that capture the control packets to user space
+func (i *Instance) trapRules() (app []string, network []string) {
+
+	for _, f := range []string{"ip", "ip6"} {
+
+		dst := f + " daddr @" + targetSet(f) + " "
+		src := f + " saddr @" + targetSet(f) + " "
+
+		if i.mode == constants.LocalContainer {
+			app = append(app,
+				dst+"tcp flags & (fin|syn|rst|psh|urg) == syn "+queue(i.fqc.GetApplicationQueueSynStr(), false),
+				// Everything but SYN (first 4 packets)
+				dst+"tcp flags & (syn|ack) == ack ct original packets < 4 "+queue(i.fqc.GetApplicationQueueAckStr(), false),
+			)
+			network = append(network,
+				src+"tcp flags & (syn|ack) == syn "+queue(i.fqc.GetNetworkQueueSynStr(), false),
+				src+"meta l4proto tcp ct original packets < 4 "+queue(i.fqc.GetNetworkQueueAckStr(), false),
+			)
+		} else {
+			app = append(app,
+				dst+"tcp flags & (syn|ack) == syn "+queue(i.fqc.GetApplicationQueueSynStr(), false),
+				// SYN,ACK is captured by the global rule
+				dst+"tcp flags & (syn|ack) == ack "+queue(i.fqc.GetApplicationQueueAckStr(), false),
+			)
+			network = append(network,
+				src+"tcp flags & (syn|ack) == syn "+queue(i.fqc.GetNetworkQueueSynStr(), false),
+				src+"tcp flags & (syn|ack|psh) == ack "+queue(i.fqc.GetNetworkQueueAckStr(), false),
+			)
+		}
+
+		// UDP packets until the flow is marked as authorized
+		app = append(app, dst+"meta l4proto udp "+queue(i.fqc.GetApplicationQueueAckStr(), false))
+		network = append(network, src+"meta l4proto udp "+queue(i.fqc.GetNetworkQueueAckStr(), false))
+	}
+
+	return app, network
+}
+
+// aclMatch returns the match of an ACL rule. The direction is daddr for the
+// application rules and saddr for the network rules.
+func aclMatch(rule policy.IPRule, direction string) (match string, l4 bool) {
+
+	match = family(rule.Address) + " " + direction + " " + rule.Address
+
+	proto := strings.ToLower(rule.Protocol)
+	switch proto {
+	case "tcp", "udp":
+		if rule.Port == "" {
+			return match + " meta l4proto " + proto, true
+		}
+		return match + " " + proto + " dport " + strings.Replace(rule.Port, ":", "-", 1), true
+	case "", "all":
+		return match, false
+	default:
+		return match + " meta l4proto " + proto, false
+	}
+}
+
+// aclRules returns the rules of the ACLs. The reject rules have precedence
+// over the accept rules.
+func aclRules(contextID string, rules policy.IPRuleList, direction string, group int, audit bool) (reject []string, accept []string) {
+
+	drop := dropVerdict(audit)
+
+	for _, rule := range rules {
+
+		match, l4 := aclMatch(rule, direction)
+		prefix := contextID + ":" + rule.Policy.PolicyID + ":" + rule.Policy.ServiceID + rule.Policy.Action.ShortActionString()
+		logged := rule.Policy.Action&policy.Log > 0
+
+		switch rule.Policy.Action & (policy.Accept | policy.Reject) {
+		case policy.Accept:
+			if logged {
+				accept = append(accept, match+" ct state new "+logStatement(group, prefix))
+			}
+			// The application rules only accept new connections
+			if l4 && direction == "daddr" {
+				accept = append(accept, match+" ct state new accept")
+			} else {
+				accept = append(accept, match+" accept")
+			}
+
+		case policy.Reject:
+			if logged || audit {
+				reject = append(reject, match+" ct state new "+logStatement(group, prefix))
+			}
+			if l4 && direction == "daddr" {
+				reject = append(reject, match+" ct state new "+drop)
+			} else {
+				reject = append(reject, match+" "+drop)
+			}
+		}
+	}
+
+	return reject, accept
+}
+
+// defaultRules returns the rules that accept established connections and
+// reject everything else
+func defaultRules(contextID string, group int, audit bool) []string {
+
+	return []string{
+		"meta l4proto udp ct state established accept",
+		"meta l4proto tcp ct state established accept",
+		"ct state new " + logStatement(group, contextID+":default:defaultr"),
+		dropVerdict(audit),
+	}
+}
+
+// exclusionRules returns the rules that accept the excluded networks
+func exclusionRules(exclusions []string) (app []string, network []string) {
+
+	for _, e := range exclusions {
+		f := family(e)
+		app = append(app, f+" daddr "+e+" accept")
+		network = append(network, f+" saddr "+e+" tcp option "+strconv.Itoa(int(packet.TCPAuthenticationOption))+" missing accept")
+	}
+
+	return app, network
+}
+
+// containerChains returns the commands that create the chains of a PU with
+// all the rules of its policy
+func (i *Instance) containerChains(contextID, appChain, netChain, mark string, policyrules *policy.PUPolicy) []string {
+
+	audit := policyrules.TriremeAction() == policy.Audit
+
+	appRules := []string{}
+	netRules := []string{}
+
+	// Linux processes are marked with their cgroup
+	if mark != "" {
+		appRules = append(appRules, "meta mark set "+mark)
+	}
+
+	appExclusions, netExclusions := exclusionRules(policyrules.ExcludedNetworks())
+	appReject, appAccept := aclRules(contextID, policyrules.ApplicationACLs(), "daddr", 10, audit)
+	netReject, netAccept := aclRules(contextID, policyrules.NetworkACLs(), "saddr", 11, audit)
+	appTrap, netTrap := i.trapRules()
+
+	appRules = append(appRules, appExclusions...)
+	appRules = append(appRules, appReject...)
+	appRules = append(appRules, appTrap...)
+	appRules = append(appRules, appAccept...)
+	appRules = append(appRules, defaultRules(contextID, 10, audit)...)
+
+	netRules = append(netRules, netExclusions...)
+	netRules = append(netRules, netReject...)
+	netRules = append(netRules, netTrap...)
+	netRules = append(netRules, netAccept...)
+	netRules = append(netRules, defaultRules(contextID, 11, audit)...)
+
+	commands := []string{
+		"add chain " + table + " " + appChain,
+		"add chain " + table + " " + netChain,
+	}
+
+	commands = append(commands, addRules(appChain, appRules)...)
+	commands = append(commands, addRules(netChain, netRules)...)
+
+	return commands
+}
@@ -0,0 +1,844 @@
+package provider
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/bvandewalle/go-ipset/ipset"
+)
+
+// maxJumps is the maximum depth of the jumps between chains
+const maxJumps = 32
+
+// hookTables are the tables traversed by the packets of every hook in order
+var hookTables = map[string][]string{
+	"PREROUTING":  {"raw", "mangle"},
+	"INPUT":       {"mangle", "filter"},
+	"FORWARD":     {"mangle", "filter"},
+	"OUTPUT":      {"raw", "mangle", "filter"},
+	"POSTROUTING": {"mangle"},
+}
+
+// tcpFlags are the values of the TCP flags
+var tcpFlags = map[string]uint8{
+	"FIN": 0x01,
+	"SYN": 0x02,
+	"RST": 0x04,
+	"PSH": 0x08,
+	"ACK": 0x10,
+	"URG": 0x20,
+	"ECE": 0x40,
+	"CWR": 0x80,
+}
+
+// Packet is a synthetic packet evaluated by the Emulator
+type Packet struct {
+	SourceIP        string
+	DestinationIP   string
+	SourcePort      uint16
+	DestinationPort uint16
+	// Protocol is tcp, udp or icmp
+	Protocol string
+	// TCPFlags are the flags of a TCP packet separated by commas, e.g. SYN,ACK
+	TCPFlags string
+	// TCPOptions are the kinds of the options of a TCP packet
+	TCPOptions []int
+	// Mark is the mark of the packet
+	Mark int
+	// ConnMark is the mark of the connection of the packet
+	ConnMark int
+	// ConnPackets is the number of packets of the connection in the original
+	// direction, matched by connbytes
+	ConnPackets int
+	// State is the conntrack state of the packet. It is NEW if empty.
+	State string
+	// Cgroup is the net_cls class of the process that sent the packet
+	Cgroup int
+}
+
+// NflogEntry is a log of a packet by the NFLOG target
+type NflogEntry struct {
+	Group  int
+	Prefix string
+}
+
+// Verdict is the result of the evaluation of a packet
+type Verdict struct {
+	// Target is ACCEPT, DROP or NFQUEUE
+	Target string
+	// Queues are the queues of the NFQUEUE target in the --queue-balance
+	// format
+	Queues string
+	// Bypass is true if the NFQUEUE target accepts the packets when no
+	// program listens on the queues
+	Bypass bool
+	// Logs are the NFLOG targets that logged the packet
+	Logs []NflogEntry
+	// Mark is the mark of the packet after the evaluation
+	Mark int
+}
+
+// Emulator is an IptablesProvider and an IpsetProvider that maintains the
+// tables, chains and sets in memory. Synthetic packets can be evaluated
+// through the rules, so that the rules can be tested without root. Only the
+// matches and targets used by the supervisors are supported, and the
+// evaluation fails on any other option.
+type Emulator struct {
+	*IptablesRenderer
+	sets map[string]*emulatedSet
+}
+
+// NewEmulator returns an empty emulator
+func NewEmulator() *Emulator {
+
+	return &Emulator{
+		IptablesRenderer: NewIptablesRenderer(),
+		sets:             map[string]*emulatedSet{},
+	}
+}
+
+// NewIpset implements the IpsetProvider interface. An existing set of the
+// same type is returned as is.
+func (e *Emulator) NewIpset(name string, hasht string, p *ipset.Params) (Ipset, error) {
+
+	if set, ok := e.sets[name]; ok {
+		if set.hashType != hasht {
+			return nil, fmt.Errorf("Set %s already exists with type %s", name, set.hashType)
+		}
+		return set, nil
+	}
+
+	dims := strings.Split(strings.TrimPrefix(hasht, "hash:"), ",")
+	if !strings.HasPrefix(hasht, "hash:") || len(dims) > 2 || (dims[0] != "ip" && dims[0] != "net") || (len(dims) == 2 && dims[1] != "port") {
+		return nil, fmt.Errorf("Unsupported set type %s", hasht)
+	}
+
+	set := &emulatedSet{
+		emulator: e,
+		name:     name,
+		hashType: hasht,
+		dims:     dims,
+		entries:  map[string]*setEntry{},
+	}
+	e.sets[name] = set
+
+	return set, nil
+}
+
+// DestroyAll implements the IpsetProvider interface
+func (e *Emulator) DestroyAll() error {
+
+	for name := range e.sets {
+		if e.setReferenced(name) {
+			return fmt.Errorf("Set %s is referenced by a rule", name)
+		}
+	}
+
+	e.sets = map[string]*emulatedSet{}
+
+	return nil
+}
+
+// Set returns the set with the given name
+func (e *Emulator) Set(name string) (Ipset, bool) {
+
+	set, ok := e.sets[name]
+	return set, ok
+}
+
+// setReferenced returns true if a rule matches the set
+func (e *Emulator) setReferenced(name string) bool {
+
+	for _, t := range e.state {
+		for _, rules := range t.rules {
+			for _, spec := range rules {
+				for idx, arg := range spec {
+					if arg == "--match-set" && idx+1 < len(spec) && spec[idx+1] == name {
+						return true
+					}
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// EvaluateHook evaluates a packet through the built-in chain of the hook of
+// every table, in the order of the kernel. The evaluation stops at the
+// first table that does not accept the packet.
+func (e *Emulator) EvaluateHook(hook string, p *Packet) (*Verdict, error) {
+
+	tables, ok := hookTables[hook]
+	if !ok {
+		return nil, fmt.Errorf("Unknown hook %s", hook)
+	}
+
+	packet := *p
+	verdict := &Verdict{Target: "ACCEPT", Mark: packet.Mark}
+
+	for _, table := range tables {
+
+		if _, ok := e.state[table]; !ok {
+			continue
+		}
+
+		v, err := e.Evaluate(table, hook, &packet)
+		if err != nil {
+			return nil, err
+		}
+
+		v.Logs = append(verdict.Logs, v.Logs...)
+		verdict = v
+		packet.Mark = v.Mark
+
+		if v.Target != "ACCEPT" {
+			break
+		}
+	}
+
+	return verdict, nil
+}
+
+// Evaluate evaluates a packet through a built-in chain of a table. The
+// packets that reach the end of the chain are accepted.
+func (e *Emulator) Evaluate(table, chain string, p *Packet) (*Verdict, error) {
+
+	if !builtinChains[chain] {
+		return nil, fmt.Errorf("Chain %s is not a built-in chain", chain)
+	}
+
+	packet := *p
+	verdict := &Verdict{Mark: packet.Mark}
+
+	if _, ok := e.state[table]; ok {
+		if _, err := e.evaluateChain(table, chain, &packet, verdict, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	if verdict.Target == "" {
+		verdict.Target = "ACCEPT"
+	}
+
+	return verdict, nil
+}
+
+// evaluateChain evaluates the rules of a chain. It returns true if a rule
+// decided the verdict of the packet.
+func (e *Emulator) evaluateChain(table, chain string, p *Packet, verdict *Verdict, depth int) (bool, error) {
+
+	if depth > maxJumps {
+		return false, fmt.Errorf("Too many jumps evaluating chain %s of table %s", chain, table)
+	}
+
+	rules, ok := e.state[table].rules[chain]
+	if !ok {
+		return false, fmt.Errorf("Chain %s does not exist in table %s", chain, table)
+	}
+
+	for _, spec := range rules {
+
+		matched, target, err := e.match(spec, p)
+		if err != nil {
+			return false, fmt.Errorf("Invalid rule %s in chain %s of table %s: %s", rule(spec), chain, table, err)
+		}
+
+		if !matched || len(target) == 0 {
+			continue
+		}
+
+		decided, returned, err := e.apply(table, target, p, verdict, depth)
+		if err != nil {
+			return false, err
+		}
+
+		if decided {
+			return true, nil
+		}
+
+		if returned {
+			return false, nil
+		}
+	}
+
+	return false, nil
+}
+
+// apply applies the target of a matched rule. It returns whether the
+// verdict is decided and whether the chain returns.
+func (e *Emulator) apply(table string, target []string, p *Packet, verdict *Verdict, depth int) (bool, bool, error) {
+
+	options := map[string]string{}
+	for idx := 1; idx < len(target); idx++ {
+		if target[idx] == "--queue-bypass" {
+			options[target[idx]] = ""
+			continue
+		}
+		if idx+1 >= len(target) {
+			return false, false, fmt.Errorf("Missing value of option %s", target[idx])
+		}
+		options[target[idx]] = target[idx+1]
+		idx++
+	}
+
+	switch target[0] {
+
+	case "ACCEPT", "DROP":
+		verdict.Target = target[0]
+		return true, false, nil
+
+	case "RETURN":
+		return false, true, nil
+
+	case "NFQUEUE":
+		verdict.Target = "NFQUEUE"
+		verdict.Queues = options["--queue-balance"]
+		if num, ok := options["--queue-num"]; ok {
+			verdict.Queues = num
+		}
+		_, verdict.Bypass = options["--queue-bypass"]
+		return true, false, nil
+
+	case "NFLOG":
+		group, err := strconv.Atoi(options["--nflog-group"])
+		if err != nil {
+			return false, false, fmt.Errorf("Invalid NFLOG group %s", options["--nflog-group"])
+		}
+		verdict.Logs = append(verdict.Logs, NflogEntry{Group: group, Prefix: options["--nflog-prefix"]})
+		return false, false, nil
+
+	case "MARK":
+		value, ok := options["--set-mark"]
+		if !ok {
+			return false, false, fmt.Errorf("MARK target requires --set-mark")
+		}
+		mark, err := strconv.Atoi(value)
+		if err != nil {
+			return false, false, fmt.Errorf("Invalid mark %s", value)
+		}
+		p.Mark = mark
+		verdict.Mark = mark
+		return false, false, nil
+	}
+
+	if builtinChains[target[0]] {
+		return false, false, fmt.Errorf("Cannot jump to built-in chain %s", target[0])
+	}
+
+	// A RETURN of the chain continues with the next rule of the caller
+	decided, err := e.evaluateChain(table, target[0], p, verdict, depth+1)
+	return decided, false, err
+}
+
+// value returns the value of the option at idx
+func value(spec []string, idx int) (string, error) {
+
+	if idx+1 >= len(spec) {
+		return "", fmt.Errorf("Missing value of option %s", spec[idx])
+	}
+
+	return spec[idx+1], nil
+}
+
+// match evaluates the matches of a rule. It returns the target and its
+// options if the packet matches.
+func (e *Emulator) match(spec []string, p *Packet) (bool, []string, error) {
+
+	negate := false
+	module := ""
+
+	for idx := 0; idx < len(spec); idx++ {
+
+		arg := spec[idx]
+
+		switch arg {
+		case "!":
+			negate = true
+			continue
+		case "-j", "--jump":
+			if idx+1 >= len(spec) {
+				return false, nil, fmt.Errorf("Missing target")
+			}
+			return true, spec[idx+1:], nil
+		case "-m", "--match":
+			v, err := value(spec, idx)
+			if err != nil {
+				return false, nil, err
+			}
+			module = v
+			idx++
+			continue
+		}
+
+		v, err := value(spec, idx)
+		if err != nil {
+			return false, nil, err
+		}
+		idx++
+
+		var matched bool
+
+		switch arg {
+		case "-s", "--source":
+			matched, err = matchAddress(p.SourceIP, v)
+		case "-d", "--destination":
+			matched, err = matchAddress(p.DestinationIP, v)
+		case "-p", "--protocol":
+			matched = v == "all" || strings.EqualFold(v, p.Protocol)
+		case "--sport", "--source-port", "--sports", "--source-ports":
+			matched, err = matchPorts(p.SourcePort, v)
+		case "--dport", "--destination-port", "--dports", "--destination-ports":
+			matched, err = matchPorts(p.DestinationPort, v)
+		case "--match-set":
+			var flags string
+			if flags, err = value(spec, idx); err == nil {
+				idx++
+				matched, err = e.matchSet(p, v, flags)
+			}
+		case "--tcp-flags":
+			var comparison string
+			if comparison, err = value(spec, idx); err == nil {
+				idx++
+				matched, err = matchTCPFlags(p, v, comparison)
+			}
+		case "--tcp-option":
+			matched, err = matchTCPOption(p, v)
+		case "--state", "--ctstate":
+			matched = matchState(p, v)
+		case "--mark":
+			if module == "connmark" {
+				matched, err = matchMark(p.ConnMark, v)
+			} else {
+				matched, err = matchMark(p.Mark, v)
+			}
+		case "--cgroup":
+			matched, err = matchMark(p.Cgroup, v)
+		case "--connbytes":
+			matched, err = matchRange(p.ConnPackets, v)
+		case "--connbytes-dir":
+			matched = true
+			if v != "original" {
+				err = fmt.Errorf("Unsupported connbytes direction %s", v)
+			}
+		case "--connbytes-mode":
+			matched = true
+			if v != "packets" {
+				err = fmt.Errorf("Unsupported connbytes mode %s", v)
+			}
+		case "--comment":
+			matched = true
+		default:
+			err = fmt.Errorf("Unsupported option %s", arg)
+		}
+
+		if err != nil {
+			return false, nil, err
+		}
+
+		if negate {
+			matched = !matched
+			negate = false
+		}
+
+		if !matched {
+			return false, nil, nil
+		}
+	}
+
+	return true, nil, nil
+}
+
+// parseNetwork parses an address or a network
+func parseNetwork(address string) (*net.IPNet, error) {
+
+	if _, network, err := net.ParseCIDR(address); err == nil {
+		return network, nil
+	}
+
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return nil, fmt.Errorf("Invalid address %s", address)
+	}
+
+	if ip.To4() != nil {
+		return &net.IPNet{IP: ip.To4(), Mask: net.CIDRMask(32, 32)}, nil
+	}
+
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// matchAddress returns true if the address belongs to the network
+func matchAddress(address string, network string) (bool, error) {
+
+	n, err := parseNetwork(network)
+	if err != nil {
+		return false, err
+	}
+
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false, nil
+	}
+
+	return n.Contains(ip), nil
+}
+
+// matchRange returns true if the value belongs to a range in the min:max
+// format. Missing bounds are not limited.
+func matchRange(v int, r string) (bool, error) {
+
+	bounds := strings.SplitN(strings.Replace(r, "-", ":", 1), ":", 2)
+
+	min, max := 0, int(^uint(0)>>1)
+	var err error
+
+	if bounds[0] != "" {
+		if min, err = strconv.Atoi(bounds[0]); err != nil {
+			return false, fmt.Errorf("Invalid range %s", r)
+		}
+	}
+
+	if len(bounds) == 1 {
+		return v == min, nil
+	}
+
+	if bounds[1] != "" {
+		if max, err = strconv.Atoi(bounds[1]); err != nil {
+			return false, fmt.Errorf("Invalid range %s", r)
+		}
+	}
+
+	return v >= min && v <= max, nil
+}
+
+// matchPorts returns true if the port belongs to a list of ports or ranges
+func matchPorts(port uint16, ports string) (bool, error) {
+
+	for _, r := range strings.Split(ports, ",") {
+		matched, err := matchRange(int(port), r)
+		if err != nil || matched {
+			return matched, err
+		}
+	}
+
+	return false, nil
+}
+
+// parseTCPFlags returns the value of a list of TCP flags
+func parseTCPFlags(flags string) (uint8, error) {
+
+	switch flags {
+	case "NONE", "":
+		return 0, nil
+	case "ALL":
+		return 0xff, nil
+	}
+
+	value := uint8(0)
+	for _, flag := range strings.Split(flags, ",") {
+		v, ok := tcpFlags[strings.ToUpper(flag)]
+		if !ok {
+			return 0, fmt.Errorf("Unknown TCP flag %s", flag)
+		}
+		value |= v
+	}
+
+	return value, nil
+}
+
+// matchTCPFlags returns true if the flags of the mask that are set are the
+// flags of the comparison
+func matchTCPFlags(p *Packet, mask, comparison string) (bool, error) {
+
+	m, err := parseTCPFlags(mask)
+	if err != nil {
+		return false, err
+	}
+
+	c, err := parseTCPFlags(comparison)
+	if err != nil {
+		return false, err
+	}
+
+	if !strings.EqualFold(p.Protocol, "tcp") {
+		return false, nil
+	}
+
+	flags, err := parseTCPFlags(p.TCPFlags)
+	if err != nil {
+		return false, err
+	}
+
+	return flags&m == c, nil
+}
+
+// matchTCPOption returns true if the packet carries the TCP option
+func matchTCPOption(p *Packet, option string) (bool, error) {
+
+	kind, err := strconv.Atoi(option)
+	if err != nil {
+		return false, fmt.Errorf("Invalid TCP option %s", option)
+	}
+
+	if !strings.EqualFold(p.Protocol, "tcp") {
+		return false, nil
+	}
+
+	for _, o := range p.TCPOptions {
+		if o == kind {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// matchState returns true if the state of the packet is one of the states
+func matchState(p *Packet, states string) bool {
+
+	state := p.State
+	if state == "" {
+		state = "NEW"
+	}
+
+	for _, s := range strings.Split(states, ",") {
+		if strings.EqualFold(s, state) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchMark returns true if the mark matches the value in the value[/mask]
+// format
+func matchMark(mark int, v string) (bool, error) {
+
+	parts := strings.SplitN(v, "/", 2)
+
+	value, err := strconv.ParseInt(parts[0], 0, 64)
+	if err != nil {
+		return false, fmt.Errorf("Invalid mark %s", v)
+	}
+
+	mask := int64(0xffffffff)
+	if len(parts) == 2 {
+		if mask, err = strconv.ParseInt(parts[1], 0, 64); err != nil {
+			return false, fmt.Errorf("Invalid mark %s", v)
+		}
+	}
+
+	return int64(mark)&mask == value, nil
+}
+
+// matchSet returns true if the packet belongs to the set. Every dimension
+// of the set is matched against the source of the packet if its flag is
+// src, and against the destination otherwise.
+func (e *Emulator) matchSet(p *Packet, name string, flags string) (bool, error) {
+
+	set, ok := e.sets[name]
+	if !ok {
+		return false, fmt.Errorf("Set %s does not exist", name)
+	}
+
+	directions := strings.Split(flags, ",")
+	source := func(dim int) bool {
+		return dim < len(directions) && directions[dim] == "src"
+	}
+
+	address, port := p.DestinationIP, p.DestinationPort
+	if source(0) {
+		address = p.SourceIP
+	}
+	if source(1) {
+		port = p.SourcePort
+	}
+
+	return set.contains(address, strings.ToLower(p.Protocol), port), nil
+}
+
+// setEntry is an entry of an emulated set
+type setEntry struct {
+	network  *net.IPNet
+	protocol string
+	port     string
+	nomatch  bool
+}
+
+// emulatedSet is an Ipset maintained in memory
+type emulatedSet struct {
+	emulator *Emulator
+	name     string
+	hashType string
+	dims     []string
+	entries  map[string]*setEntry
+}
+
+// parse parses an entry of the set
+func (s *emulatedSet) parse(entry string) (*setEntry, error) {
+
+	parts := strings.Split(entry, ",")
+	if len(parts) != len(s.dims) {
+		return nil, fmt.Errorf("Invalid entry %s for set %s of type %s", entry, s.name, s.hashType)
+	}
+
+	network, err := parseNetwork(parts[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if ones, bits := network.Mask.Size(); s.dims[0] == "ip" && ones != bits {
+		return nil, fmt.Errorf("Invalid address %s for set %s of type %s", parts[0], s.name, s.hashType)
+	}
+
+	e := &setEntry{network: network}
+
+	if len(parts) == 2 {
+		e.protocol = "tcp"
+		e.port = parts[1]
+		if proto := strings.SplitN(parts[1], ":", 2); len(proto) == 2 {
+			e.protocol, e.port = strings.ToLower(proto[0]), proto[1]
+		}
+		if _, err := matchRange(0, e.port); err != nil {
+			return nil, err
+		}
+	}
+
+	return e, nil
+}
+
+// contains returns true if the most specific entry that matches the address
+// and the port is not a nomatch entry
+func (s *emulatedSet) contains(address string, protocol string, port uint16) bool {
+
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+
+	var best *setEntry
+	bestOnes := -1
+
+	for _, e := range s.entries {
+
+		if !e.network.Contains(ip) {
+			continue
+		}
+
+		if e.port != "" {
+			if e.protocol != protocol {
+				continue
+			}
+			if matched, _ := matchRange(int(port), e.port); !matched {
+				continue
+			}
+		}
+
+		if ones, _ := e.network.Mask.Size(); ones > bestOnes {
+			best, bestOnes = e, ones
+		}
+	}
+
+	return best != nil && !best.nomatch
+}
+
+// key returns the normalized entry
+func (e *setEntry) key() string {
+
+	if e.port == "" {
+		return e.network.String()
+	}
+
+	return e.network.String() + "," + e.protocol + ":" + e.port
+}
+
+// Add implements the Ipset interface. Existing entries are replaced.
+func (s *emulatedSet) Add(entry string, timeout int) error {
+
+	e, err := s.parse(entry)
+	if err != nil {
+		return err
+	}
+
+	s.entries[e.key()] = e
+
+	return nil
+}
+
+// AddOption implements the Ipset interface. Only the nomatch option is
+// supported.
+func (s *emulatedSet) AddOption(entry string, option string, timeout int) error {
+
+	if option != "nomatch" {
+		return fmt.Errorf("Unsupported option %s", option)
+	}
+
+	e, err := s.parse(entry)
+	if err != nil {
+		return err
+	}
+
+	e.nomatch = true
+	s.entries[e.key()] = e
+
+	return nil
+}
+
+// Del implements the Ipset interface
+func (s *emulatedSet) Del(entry string) error {
+
+	e, err := s.parse(entry)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := s.entries[e.key()]; !ok {
+		return fmt.Errorf("Element %s is not in set %s", entry, s.name)
+	}
+
+	delete(s.entries, e.key())
+
+	return nil
+}
+
+// Destroy implements the Ipset interface
+func (s *emulatedSet) Destroy() error {
+
+	if s.emulator.setReferenced(s.name) {
+		return fmt.Errorf("Set %s is referenced by a rule", s.name)
+	}
+
+	delete(s.emulator.sets, s.name)
+
+	return nil
+}
+
+// Flush implements the Ipset interface
+func (s *emulatedSet) Flush() error {
+
+	s.entries = map[string]*setEntry{}
+
+	return nil
+}
+
+// Test implements the Ipset interface. The entry matches if the most
+// specific entry of the set that contains it is not a nomatch entry.
+func (s *emulatedSet) Test(entry string) (bool, error) {
+
+	e, err := s.parse(entry)
+	if err != nil {
+		return false, err
+	}
+
+	port := 0
+	if e.port != "" {
+		if port, err = strconv.Atoi(e.port); err != nil {
+			return false, fmt.Errorf("Invalid port %s", e.port)
+		}
+	}
+
+	return s.contains(e.network.IP.String(), e.protocol, uint16(port)), nil
+}
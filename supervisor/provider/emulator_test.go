@@ -0,0 +1,248 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/bvandewalle/go-ipset/ipset"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEmulatorSets(t *testing.T) {
+
+	Convey("Given an emulator", t, func() {
+		e := NewEmulator()
+
+		Convey("When I create a set of networks", func() {
+			set, err := e.NewIpset("TargetNetSet", "hash:net", &ipset.Params{})
+			So(err, ShouldBeNil)
+			So(set.Add("10.0.0.0/8", 0), ShouldBeNil)
+			So(set.AddOption("10.1.0.0/16", "nomatch", 0), ShouldBeNil)
+			So(set.Add("10.1.1.0/24", 0), ShouldBeNil)
+
+			Convey("The most specific entry should decide the membership", func() {
+				matched, err := set.Test("10.2.0.1")
+				So(err, ShouldBeNil)
+				So(matched, ShouldBeTrue)
+
+				matched, err = set.Test("10.1.0.1")
+				So(err, ShouldBeNil)
+				So(matched, ShouldBeFalse)
+
+				matched, err = set.Test("10.1.1.1")
+				So(err, ShouldBeNil)
+				So(matched, ShouldBeTrue)
+
+				matched, err = set.Test("192.168.0.1")
+				So(err, ShouldBeNil)
+				So(matched, ShouldBeFalse)
+			})
+
+			Convey("When I delete an entry, it should not match", func() {
+				So(set.Del("10.0.0.0/8"), ShouldBeNil)
+				So(set.Del("10.0.0.0/8"), ShouldNotBeNil)
+				matched, _ := set.Test("10.2.0.1")
+				So(matched, ShouldBeFalse)
+			})
+
+			Convey("When I flush the set, nothing should match", func() {
+				So(set.Flush(), ShouldBeNil)
+				matched, _ := set.Test("10.1.1.1")
+				So(matched, ShouldBeFalse)
+			})
+
+			Convey("When I create it again, I should get the same set", func() {
+				same, err := e.NewIpset("TargetNetSet", "hash:net", &ipset.Params{})
+				So(err, ShouldBeNil)
+				So(same, ShouldEqual, set)
+
+				_, err = e.NewIpset("TargetNetSet", "hash:ip", &ipset.Params{})
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("When a rule references the set, it should not be destroyed", func() {
+				So(e.Append("mangle", "OUTPUT", "-m", "set", "--match-set", "TargetNetSet", "dst", "-j", "ACCEPT"), ShouldBeNil)
+				So(set.Destroy(), ShouldNotBeNil)
+				So(e.DestroyAll(), ShouldNotBeNil)
+				So(e.Delete("mangle", "OUTPUT", "-m", "set", "--match-set", "TargetNetSet", "dst", "-j", "ACCEPT"), ShouldBeNil)
+				So(set.Destroy(), ShouldBeNil)
+				_, ok := e.Set("TargetNetSet")
+				So(ok, ShouldBeFalse)
+			})
+		})
+
+		Convey("When I create a set of networks and ports", func() {
+			set, err := e.NewIpset("ACL", "hash:net,port", &ipset.Params{})
+			So(err, ShouldBeNil)
+			So(set.Add("192.30.253.0/24,443", 0), ShouldBeNil)
+			So(set.Add("192.30.253.0/24,udp:53", 0), ShouldBeNil)
+
+			Convey("The entries should match the protocol and the port", func() {
+				matched, _ := set.Test("192.30.253.1,443")
+				So(matched, ShouldBeTrue)
+				matched, _ = set.Test("192.30.253.1,80")
+				So(matched, ShouldBeFalse)
+				matched, _ = set.Test("192.30.253.1,udp:53")
+				So(matched, ShouldBeTrue)
+				matched, _ = set.Test("192.30.253.1,53")
+				So(matched, ShouldBeFalse)
+			})
+
+			Convey("Invalid entries should be rejected", func() {
+				So(set.Add("192.30.253.0/24", 0), ShouldNotBeNil)
+				So(set.Add("host,80", 0), ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I create a set of an unsupported type, I should get an error", func() {
+			_, err := e.NewIpset("set", "bitmap:port", &ipset.Params{})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("A set of addresses should reject networks", func() {
+			set, err := e.NewIpset("set", "hash:ip", &ipset.Params{})
+			So(err, ShouldBeNil)
+			So(set.Add("10.0.0.0/8", 0), ShouldNotBeNil)
+			So(set.Add("10.0.0.1", 0), ShouldBeNil)
+		})
+	})
+}
+
+func TestEmulatorEvaluate(t *testing.T) {
+
+	Convey("Given an emulator with rules", t, func() {
+		e := NewEmulator()
+
+		set, _ := e.NewIpset("TargetNetSet", "hash:net", &ipset.Params{})
+		So(set.Add("0.0.0.0/1", 0), ShouldBeNil)
+		So(set.Add("128.0.0.0/1", 0), ShouldBeNil)
+
+		So(e.NewChain("mangle", "App"), ShouldBeNil)
+		So(e.Append("mangle", "App", "-d", "10.0.0.0/8", "-p", "tcp", "!", "--tcp-option", "34", "-j", "ACCEPT"), ShouldBeNil)
+		So(e.Append("mangle", "App", "-p", "TCP", "-m", "state", "--state", "NEW", "-d", "192.30.253.0/24", "--dport", "80", "-j", "DROP"), ShouldBeNil)
+		So(e.Append("mangle", "App", "-m", "set", "--match-set", "TargetNetSet", "dst", "-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN", "-j", "NFQUEUE", "--queue-balance", "0:3"), ShouldBeNil)
+		So(e.Append("mangle", "App", "-p", "tcp", "-m", "connbytes", "--connbytes", ":3", "--connbytes-dir", "original", "--connbytes-mode", "packets", "-j", "NFQUEUE", "--queue-bypass", "--queue-balance", "4:7"), ShouldBeNil)
+		So(e.Append("mangle", "App", "-m", "state", "--state", "NEW", "-j", "NFLOG", "--nflog-group", "10", "--nflog-prefix", "Context:default:defaultr"), ShouldBeNil)
+		So(e.Append("mangle", "App", "-m", "connmark", "--mark", "100", "-j", "RETURN"), ShouldBeNil)
+		So(e.Append("mangle", "App", "-p", "udp", "-m", "state", "--state", "ESTABLISHED", "-j", "ACCEPT"), ShouldBeNil)
+		So(e.Append("mangle", "App", "-j", "DROP"), ShouldBeNil)
+
+		So(e.Append("mangle", "OUTPUT", "-m", "cgroup", "--cgroup", "200", "-j", "MARK", "--set-mark", "200"), ShouldBeNil)
+		So(e.Append("mangle", "OUTPUT", "-s", "172.17.0.1", "-m", "comment", "--comment", "Container-specific-chain", "-j", "App"), ShouldBeNil)
+		So(e.Append("mangle", "OUTPUT", "-m", "mark", "--mark", "200", "-j", "DROP"), ShouldBeNil)
+
+		packet := &Packet{
+			SourceIP:        "172.17.0.1",
+			DestinationIP:   "8.8.8.8",
+			SourcePort:      1000,
+			DestinationPort: 443,
+			Protocol:        "tcp",
+			TCPFlags:        "SYN",
+			ConnPackets:     1,
+		}
+
+		Convey("A packet to an excluded network without the option should be accepted", func() {
+			packet.DestinationIP = "10.1.1.1"
+			v, err := e.Evaluate("mangle", "OUTPUT", packet)
+			So(err, ShouldBeNil)
+			So(v.Target, ShouldEqual, "ACCEPT")
+
+			packet.TCPOptions = []int{34}
+			v, err = e.Evaluate("mangle", "OUTPUT", packet)
+			So(err, ShouldBeNil)
+			So(v.Target, ShouldEqual, "NFQUEUE")
+		})
+
+		Convey("A new packet to a rejected port should be dropped", func() {
+			packet.DestinationIP = "192.30.253.1"
+			packet.DestinationPort = 80
+			v, err := e.Evaluate("mangle", "OUTPUT", packet)
+			So(err, ShouldBeNil)
+			So(v.Target, ShouldEqual, "DROP")
+		})
+
+		Convey("A SYN packet to a target network should be queued", func() {
+			v, err := e.Evaluate("mangle", "OUTPUT", packet)
+			So(err, ShouldBeNil)
+			So(v.Target, ShouldEqual, "NFQUEUE")
+			So(v.Queues, ShouldEqual, "0:3")
+			So(v.Bypass, ShouldBeFalse)
+		})
+
+		Convey("The first packets of a connection should be queued with bypass", func() {
+			packet.TCPFlags = "ACK"
+			packet.State = "ESTABLISHED"
+			v, err := e.Evaluate("mangle", "OUTPUT", packet)
+			So(err, ShouldBeNil)
+			So(v.Target, ShouldEqual, "NFQUEUE")
+			So(v.Queues, ShouldEqual, "4:7")
+			So(v.Bypass, ShouldBeTrue)
+		})
+
+		Convey("A new UDP packet should be logged and dropped", func() {
+			packet.Protocol = "udp"
+			v, err := e.Evaluate("mangle", "OUTPUT", packet)
+			So(err, ShouldBeNil)
+			So(v.Target, ShouldEqual, "DROP")
+			So(v.Logs, ShouldResemble, []NflogEntry{{Group: 10, Prefix: "Context:default:defaultr"}})
+		})
+
+		Convey("A packet of a marked connection should return to the calling chain", func() {
+			packet.Protocol = "udp"
+			packet.State = "ESTABLISHED"
+			packet.ConnMark = 100
+			v, err := e.Evaluate("mangle", "OUTPUT", packet)
+			So(err, ShouldBeNil)
+			So(v.Target, ShouldEqual, "ACCEPT")
+		})
+
+		Convey("A packet of the cgroup should be marked and match the mark", func() {
+			packet.SourceIP = "172.17.0.2"
+			packet.Cgroup = 200
+			v, err := e.Evaluate("mangle", "OUTPUT", packet)
+			So(err, ShouldBeNil)
+			So(v.Target, ShouldEqual, "DROP")
+			So(v.Mark, ShouldEqual, 200)
+			So(packet.Mark, ShouldEqual, 0)
+		})
+
+		Convey("A packet that does not match any rule should be accepted", func() {
+			packet.SourceIP = "172.17.0.2"
+			v, err := e.EvaluateHook("OUTPUT", packet)
+			So(err, ShouldBeNil)
+			So(v.Target, ShouldEqual, "ACCEPT")
+		})
+
+		Convey("The hooks should evaluate the tables in order", func() {
+			So(e.Append("raw", "OUTPUT", "-s", "172.17.0.3", "-j", "DROP"), ShouldBeNil)
+			packet.SourceIP = "172.17.0.3"
+			v, err := e.EvaluateHook("OUTPUT", packet)
+			So(err, ShouldBeNil)
+			So(v.Target, ShouldEqual, "DROP")
+
+			_, err = e.EvaluateHook("NOHOOK", packet)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When a chain cannot be deleted while it is referenced", func() {
+			So(e.ClearChain("mangle", "App"), ShouldBeNil)
+			So(e.DeleteChain("mangle", "App"), ShouldNotBeNil)
+		})
+
+		Convey("Unsupported options should be reported", func() {
+			So(e.Insert("mangle", "OUTPUT", 1, "-i", "eth0", "-j", "ACCEPT"), ShouldBeNil)
+			_, err := e.Evaluate("mangle", "OUTPUT", packet)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Loops between chains should be reported", func() {
+			So(e.Insert("mangle", "App", 1, "-j", "App"), ShouldBeNil)
+			_, err := e.Evaluate("mangle", "OUTPUT", packet)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Only built-in chains can be evaluated", func() {
+			_, err := e.Evaluate("mangle", "App", packet)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,301 @@
+package provider
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// BatchProvider is an IptablesProvider that can also program the rules in
+// transactions
+type BatchProvider interface {
+	IptablesProvider
+	// NewTransaction returns a transaction that buffers the changes until
+	// it is committed
+	NewTransaction() Transaction
+}
+
+// Transaction is an IptablesProvider that buffers the changes. The chains
+// and rules that are added are programmed with one iptables-restore per
+// table when the transaction is committed. The deletions are applied after
+// a successful commit and their failures are only logged.
+type Transaction interface {
+	IptablesProvider
+	// Commit programs the buffered changes. If a table fails, the chains and
+	// rules added to the tables committed before it are removed.
+	Commit() error
+	// Abort discards the buffered changes
+	Abort()
+}
+
+// runner executes a command with the given input and returns its output
+type runner func(name string, args []string, input string) (string, error)
+
+// runCommand is the runner that executes the commands on the host
+func runCommand(name string, args []string, input string) (string, error) {
+
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.String(), nil
+}
+
+// batchProvider programs the transactions with iptables-restore and
+// forwards all the other calls to the underlying provider
+type batchProvider struct {
+	IptablesProvider
+	restore string
+	run     runner
+	// The commits and rollbacks of the transactions are serialized
+	sync.Mutex
+}
+
+// NewBatchProvider returns a BatchProvider that uses ipt for the changes
+// that are not part of a transaction. It requires the iptables-restore
+// command, or its IPv6 version.
+func NewBatchProvider(ipt IptablesProvider, ipv6 bool) (BatchProvider, error) {
+
+	restore := "iptables-restore"
+	if ipv6 {
+		restore = "ip6tables-restore"
+	}
+
+	restorePath, err := exec.LookPath(restore)
+	if err != nil {
+		return nil, fmt.Errorf("%s command not found: %s", restore, err)
+	}
+
+	return newBatchProvider(ipt, restorePath, runCommand), nil
+}
+
+// newBatchProvider returns a batch provider with the given runner
+func newBatchProvider(ipt IptablesProvider, restore string, run runner) *batchProvider {
+
+	return &batchProvider{
+		IptablesProvider: ipt,
+		restore:          restore,
+		run:              run,
+	}
+}
+
+// NewTransaction implements the BatchProvider interface
+func (b *batchProvider) NewTransaction() Transaction {
+
+	return &transaction{
+		provider: b,
+		ops:      map[string][][]string{},
+	}
+}
+
+// apply programs the operations of every table with its own
+// iptables-restore, so that the tables committed before a failure are
+// known. Their operations are then reverted one by one. Only the chains and
+// rules of the transaction are touched, never the rules that other
+// programs added to the tables.
+func (b *batchProvider) apply(tables []string, ops map[string][][]string) error {
+
+	b.Lock()
+	defer b.Unlock()
+
+	for idx, table := range tables {
+
+		lines := make([]string, len(ops[table]))
+		for i, args := range ops[table] {
+			lines[i] = restoreLine(args)
+		}
+
+		input := "*" + table + "\n" + strings.Join(lines, "\n") + "\nCOMMIT\n"
+
+		if _, err := b.run(b.restore, []string{"--noflush", "--wait"}, input); err != nil {
+			for i := idx - 1; i >= 0; i-- {
+				b.revert(tables[i], ops[tables[i]])
+			}
+			return fmt.Errorf("Failed to commit the rules of table %s: %s", table, err)
+		}
+	}
+
+	return nil
+}
+
+// revert undoes the committed operations of a table in the reverse order.
+// The failures are only logged so that as much as possible is removed.
+func (b *batchProvider) revert(table string, ops [][]string) {
+
+	for i := len(ops) - 1; i >= 0; i-- {
+
+		args := ops[i]
+
+		var err error
+		switch args[0] {
+		case "-A":
+			err = b.IptablesProvider.Delete(table, args[1], args[2:]...)
+		case "-I":
+			err = b.IptablesProvider.Delete(table, args[1], args[3:]...)
+		case "-N":
+			if err = b.IptablesProvider.ClearChain(table, args[1]); err == nil {
+				err = b.IptablesProvider.DeleteChain(table, args[1])
+			}
+		}
+
+		if err != nil {
+			zap.L().Error("Unable to roll back a change of the transaction",
+				zap.String("table", table),
+				zap.String("change", restoreLine(args)),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+// transaction buffers the changes of a transaction
+type transaction struct {
+	provider *batchProvider
+	// tables are the tables in the order of their first change
+	tables []string
+	// ops are the arguments of the iptables-restore lines of every table
+	ops map[string][][]string
+	// deferred are the deletions applied after the commit
+	deferred []func() error
+	done     bool
+}
+
+// quote returns an argument that can be used in an iptables-restore line
+func quote(arg string) string {
+
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'") {
+		return arg
+	}
+
+	return strconv.Quote(arg)
+}
+
+// restoreLine returns the iptables-restore line of the arguments
+func restoreLine(args []string) string {
+
+	quoted := make([]string, len(args))
+	for idx, arg := range args {
+		quoted[idx] = quote(arg)
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+// add buffers an operation of the table
+func (t *transaction) add(table string, args ...string) {
+
+	if _, ok := t.ops[table]; !ok {
+		t.tables = append(t.tables, table)
+	}
+
+	t.ops[table] = append(t.ops[table], args)
+}
+
+// Append implements the IptablesProvider interface
+func (t *transaction) Append(table, chain string, rulespec ...string) error {
+
+	t.add(table, append([]string{"-A", chain}, rulespec...)...)
+	return nil
+}
+
+// Insert implements the IptablesProvider interface
+func (t *transaction) Insert(table, chain string, pos int, rulespec ...string) error {
+
+	t.add(table, append([]string{"-I", chain, strconv.Itoa(pos)}, rulespec...)...)
+	return nil
+}
+
+// NewChain implements the IptablesProvider interface
+func (t *transaction) NewChain(table, chain string) error {
+
+	t.add(table, "-N", chain)
+	return nil
+}
+
+// Delete implements the IptablesProvider interface. The rule is deleted
+// after the commit.
+func (t *transaction) Delete(table, chain string, rulespec ...string) error {
+
+	t.deferred = append(t.deferred, func() error {
+		return t.provider.Delete(table, chain, rulespec...)
+	})
+	return nil
+}
+
+// ClearChain implements the IptablesProvider interface. The chain is
+// cleared after the commit.
+func (t *transaction) ClearChain(table, chain string) error {
+
+	t.deferred = append(t.deferred, func() error {
+		return t.provider.ClearChain(table, chain)
+	})
+	return nil
+}
+
+// DeleteChain implements the IptablesProvider interface. The chain is
+// deleted after the commit.
+func (t *transaction) DeleteChain(table, chain string) error {
+
+	t.deferred = append(t.deferred, func() error {
+		return t.provider.DeleteChain(table, chain)
+	})
+	return nil
+}
+
+// ListChains implements the IptablesProvider interface. It returns the
+// chains programmed before the transaction.
+func (t *transaction) ListChains(table string) ([]string, error) {
+
+	return t.provider.ListChains(table)
+}
+
+// List implements the IptablesProvider interface. It returns the rules
+// programmed before the transaction.
+func (t *transaction) List(table, chain string) ([]string, error) {
+
+	return t.provider.List(table, chain)
+}
+
+// Commit implements the Transaction interface
+func (t *transaction) Commit() error {
+
+	if t.done {
+		return fmt.Errorf("Transaction already completed")
+	}
+	t.done = true
+
+	if len(t.tables) > 0 {
+		if err := t.provider.apply(t.tables, t.ops); err != nil {
+			return err
+		}
+	}
+
+	for _, deletion := range t.deferred {
+		if err := deletion(); err != nil {
+			zap.L().Warn("Failed to apply a deletion of the transaction", zap.Error(err))
+		}
+	}
+
+	return nil
+}
+
+// Abort implements the Transaction interface
+func (t *transaction) Abort() {
+
+	t.done = true
+	t.tables = nil
+	t.ops = map[string][][]string{}
+	t.deferred = nil
+}
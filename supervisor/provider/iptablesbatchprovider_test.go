@@ -0,0 +1,110 @@
+package provider
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type command struct {
+	name  string
+	args  []string
+	input string
+}
+
+func TestBatchProvider(t *testing.T) {
+
+	Convey("Given a batch provider", t, func() {
+		ipt := NewTestIptablesProvider()
+		commands := []command{}
+		failTable := ""
+
+		b := newBatchProvider(ipt, "restore", func(name string, args []string, input string) (string, error) {
+			commands = append(commands, command{name: name, args: args, input: input})
+			if failTable != "" && strings.HasPrefix(input, "*"+failTable+"\n") {
+				return "", fmt.Errorf("line 3 failed")
+			}
+			return "", nil
+		})
+
+		deleted := []string{}
+		ipt.MockDelete(t, func(table, chain string, rulespec ...string) error {
+			deleted = append(deleted, table+" "+chain+" "+strings.Join(rulespec, " "))
+			return nil
+		})
+		ipt.MockClearChain(t, func(table, chain string) error {
+			deleted = append(deleted, "clear "+table+" "+chain)
+			return nil
+		})
+		ipt.MockDeleteChain(t, func(table, chain string) error {
+			deleted = append(deleted, table+" "+chain)
+			return fmt.Errorf("chain not found")
+		})
+
+		tx := b.NewTransaction()
+		So(tx.NewChain("mangle", "TRIREME-App-1"), ShouldBeNil)
+		So(tx.Append("mangle", "TRIREME-App-1", "-d", "10.0.0.0/8", "-j", "ACCEPT"), ShouldBeNil)
+		So(tx.Insert("raw", "PREROUTING", 1, "-m", "comment", "--comment", "a comment", "-j", "TRIREME-App-1"), ShouldBeNil)
+		So(tx.Append("mangle", "OUTPUT", "--nflog-prefix", ""), ShouldBeNil)
+		So(tx.Delete("mangle", "OUTPUT", "-j", "TRIREME-App-0"), ShouldBeNil)
+		So(tx.DeleteChain("mangle", "TRIREME-App-0"), ShouldBeNil)
+
+		Convey("Nothing should be programmed before the commit", func() {
+			So(len(commands), ShouldEqual, 0)
+			So(len(deleted), ShouldEqual, 0)
+		})
+
+		Convey("When I commit, the rules should be programmed with one restore per table and the deletions applied", func() {
+			So(tx.Commit(), ShouldBeNil)
+			So(len(commands), ShouldEqual, 2)
+			So(commands[0].name, ShouldEqual, "restore")
+			So(commands[0].args, ShouldResemble, []string{"--noflush", "--wait"})
+			So(commands[0].input, ShouldEqual, `*mangle
+-N TRIREME-App-1
+-A TRIREME-App-1 -d 10.0.0.0/8 -j ACCEPT
+-A OUTPUT --nflog-prefix ""
+COMMIT
+`)
+			So(commands[1].args, ShouldResemble, []string{"--noflush", "--wait"})
+			So(commands[1].input, ShouldEqual, `*raw
+-I PREROUTING 1 -m comment --comment "a comment" -j TRIREME-App-1
+COMMIT
+`)
+			So(deleted, ShouldResemble, []string{"mangle OUTPUT -j TRIREME-App-0", "mangle TRIREME-App-0"})
+
+			Convey("I should not be able to commit again", func() {
+				So(tx.Commit(), ShouldNotBeNil)
+			})
+		})
+
+		Convey("When a table fails, the changes of the committed tables should be reverted and nothing deleted", func() {
+			failTable = "raw"
+			err := tx.Commit()
+			So(err, ShouldNotBeNil)
+			So(len(commands), ShouldEqual, 2)
+			So(deleted, ShouldResemble, []string{
+				"mangle OUTPUT --nflog-prefix ",
+				"mangle TRIREME-App-1 -d 10.0.0.0/8 -j ACCEPT",
+				"clear mangle TRIREME-App-1",
+				"mangle TRIREME-App-1",
+			})
+		})
+
+		Convey("When the first table fails, nothing should be reverted", func() {
+			failTable = "mangle"
+			err := tx.Commit()
+			So(err, ShouldNotBeNil)
+			So(len(commands), ShouldEqual, 1)
+			So(len(deleted), ShouldEqual, 0)
+		})
+
+		Convey("When I abort, nothing should be programmed", func() {
+			tx.Abort()
+			So(tx.Commit(), ShouldNotBeNil)
+			So(len(commands), ShouldEqual, 0)
+			So(len(deleted), ShouldEqual, 0)
+		})
+	})
+}
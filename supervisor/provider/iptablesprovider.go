@@ -9,6 +9,8 @@ type IptablesProvider interface {
 	Insert(table, chain string, pos int, rulespec ...string) error
 	Delete(table, chain string, rulespec ...string) error
 	ListChains(table string) ([]string, error)
+	// List returns the rules of a chain in the iptables-save format
+	List(table, chain string) ([]string, error)
 	ClearChain(table, chain string) error
 	DeleteChain(table, chain string) error
 	NewChain(table, chain string) error
@@ -19,3 +21,9 @@ type IptablesProvider interface {
 func NewGoIPTablesProvider() (IptablesProvider, error) {
 	return iptables.New()
 }
+
+// NewGoIP6TablesProvider returns an IptablesProvider interface based on the go-iptables
+// external package that programs ip6tables.
+func NewGoIP6TablesProvider() (IptablesProvider, error) {
+	return iptables.NewWithProtocol(iptables.ProtocolIPv6)
+}
@@ -10,6 +10,7 @@ type iptablesProviderMockedMethods struct {
 	insertMock      func(table, chain string, pos int, rulespec ...string) error
 	deleteMock      func(table, chain string, rulespec ...string) error
 	listChainsMock  func(table string) ([]string, error)
+	listMock        func(table, chain string) ([]string, error)
 	clearChainMock  func(table, chain string) error
 	deleteChainMock func(table, chain string) error
 	newChainMock    func(table, chain string) error
@@ -22,6 +23,7 @@ type TestIptablesProvider interface {
 	MockInsert(t *testing.T, impl func(table, chain string, pos int, rulespec ...string) error)
 	MockDelete(t *testing.T, impl func(table, chain string, rulespec ...string) error)
 	MockListChains(t *testing.T, impl func(table string) ([]string, error))
+	MockList(t *testing.T, impl func(table, chain string) ([]string, error))
 	MockClearChain(t *testing.T, impl func(table, chain string) error)
 	MockDeleteChain(t *testing.T, impl func(table, chain string) error)
 	MockNewChain(t *testing.T, impl func(table, chain string) error)
@@ -62,6 +64,11 @@ func (m *testIptablesProvider) MockListChains(t *testing.T, impl func(table stri
 	m.currentMocks(t).listChainsMock = impl
 }
 
+func (m *testIptablesProvider) MockList(t *testing.T, impl func(table, chain string) ([]string, error)) {
+
+	m.currentMocks(t).listMock = impl
+}
+
 func (m *testIptablesProvider) MockClearChain(t *testing.T, impl func(table, chain string) error) {
 
 	m.currentMocks(t).clearChainMock = impl
@@ -113,6 +120,15 @@ func (m *testIptablesProvider) ListChains(table string) ([]string, error) {
 	return nil, nil
 }
 
+func (m *testIptablesProvider) List(table, chain string) ([]string, error) {
+
+	if mock := m.currentMocks(m.currentTest); mock != nil && mock.listMock != nil {
+		return mock.listMock(table, chain)
+	}
+
+	return nil, nil
+}
+
 func (m *testIptablesProvider) ClearChain(table, chain string) error {
 
 	if mock := m.currentMocks(m.currentTest); mock != nil && mock.clearChainMock != nil {
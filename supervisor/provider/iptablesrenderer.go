@@ -0,0 +1,257 @@
+package provider
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// builtinChains are the chains that exist in the tables without being created
+var builtinChains = map[string]bool{
+	"PREROUTING":  true,
+	"INPUT":       true,
+	"FORWARD":     true,
+	"OUTPUT":      true,
+	"POSTROUTING": true,
+}
+
+// renderedTable holds the chains of a table in the order of their creation
+type renderedTable struct {
+	chains []string
+	rules  map[string][][]string
+}
+
+// IptablesRenderer is an IptablesProvider that programs the rules in memory
+// and renders them as an iptables-save script. It never touches the kernel.
+type IptablesRenderer struct {
+	tables []string
+	state  map[string]*renderedTable
+}
+
+// NewIptablesRenderer returns an empty IptablesRenderer
+func NewIptablesRenderer() *IptablesRenderer {
+
+	return &IptablesRenderer{
+		state: map[string]*renderedTable{},
+	}
+}
+
+// chain returns the rules of a chain. Built-in chains are created when they
+// are first used.
+func (r *IptablesRenderer) chain(table, chain string) (*renderedTable, error) {
+
+	t, ok := r.state[table]
+	if !ok {
+		t = &renderedTable{
+			rules: map[string][][]string{},
+		}
+		r.state[table] = t
+		r.tables = append(r.tables, table)
+	}
+
+	if _, ok := t.rules[chain]; !ok {
+		if !builtinChains[chain] {
+			return nil, fmt.Errorf("Chain %s does not exist in table %s", chain, table)
+		}
+		t.chains = append(t.chains, chain)
+		t.rules[chain] = [][]string{}
+	}
+
+	return t, nil
+}
+
+// rule returns the rule specification in the iptables-save format
+func rule(rulespec []string) string {
+
+	quoted := make([]string, len(rulespec))
+	for idx, arg := range rulespec {
+		quoted[idx] = quote(arg)
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+// jumpTarget returns the target of a rule specification
+func jumpTarget(rulespec []string) string {
+
+	for idx, arg := range rulespec {
+		if (arg == "-j" || arg == "--jump") && idx+1 < len(rulespec) {
+			return rulespec[idx+1]
+		}
+	}
+
+	return ""
+}
+
+// Append implements the IptablesProvider interface
+func (r *IptablesRenderer) Append(table, chain string, rulespec ...string) error {
+
+	t, err := r.chain(table, chain)
+	if err != nil {
+		return err
+	}
+
+	t.rules[chain] = append(t.rules[chain], append([]string{}, rulespec...))
+
+	return nil
+}
+
+// Insert implements the IptablesProvider interface
+func (r *IptablesRenderer) Insert(table, chain string, pos int, rulespec ...string) error {
+
+	t, err := r.chain(table, chain)
+	if err != nil {
+		return err
+	}
+
+	rules := t.rules[chain]
+	if pos < 1 || pos > len(rules)+1 {
+		return fmt.Errorf("Index of insertion too big in chain %s of table %s", chain, table)
+	}
+
+	rules = append(rules, nil)
+	copy(rules[pos:], rules[pos-1:])
+	rules[pos-1] = append([]string{}, rulespec...)
+	t.rules[chain] = rules
+
+	return nil
+}
+
+// Delete implements the IptablesProvider interface
+func (r *IptablesRenderer) Delete(table, chain string, rulespec ...string) error {
+
+	t, err := r.chain(table, chain)
+	if err != nil {
+		return err
+	}
+
+	spec := rule(rulespec)
+	for idx, existing := range t.rules[chain] {
+		if rule(existing) == spec {
+			t.rules[chain] = append(t.rules[chain][:idx], t.rules[chain][idx+1:]...)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Rule does not exist in chain %s of table %s", chain, table)
+}
+
+// ListChains implements the IptablesProvider interface
+func (r *IptablesRenderer) ListChains(table string) ([]string, error) {
+
+	t, ok := r.state[table]
+	if !ok {
+		return []string{}, nil
+	}
+
+	return append([]string{}, t.chains...), nil
+}
+
+// List implements the IptablesProvider interface
+func (r *IptablesRenderer) List(table, chain string) ([]string, error) {
+
+	t, err := r.chain(table, chain)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := []string{"-N " + chain}
+	for _, spec := range t.rules[chain] {
+		rules = append(rules, "-A "+chain+" "+rule(spec))
+	}
+
+	return rules, nil
+}
+
+// ClearChain implements the IptablesProvider interface. The chain is created
+// if it does not exist.
+func (r *IptablesRenderer) ClearChain(table, chain string) error {
+
+	if _, err := r.chain(table, chain); err != nil {
+		return r.NewChain(table, chain)
+	}
+
+	r.state[table].rules[chain] = [][]string{}
+
+	return nil
+}
+
+// DeleteChain implements the IptablesProvider interface
+func (r *IptablesRenderer) DeleteChain(table, chain string) error {
+
+	t, ok := r.state[table]
+	if !ok || builtinChains[chain] {
+		return fmt.Errorf("Chain %s cannot be deleted from table %s", chain, table)
+	}
+
+	if _, ok := t.rules[chain]; !ok {
+		return fmt.Errorf("Chain %s does not exist in table %s", chain, table)
+	}
+
+	if len(t.rules[chain]) > 0 {
+		return fmt.Errorf("Chain %s of table %s is not empty", chain, table)
+	}
+
+	for name, rules := range t.rules {
+		for _, spec := range rules {
+			if jumpTarget(spec) == chain {
+				return fmt.Errorf("Chain %s of table %s is referenced by chain %s", chain, table, name)
+			}
+		}
+	}
+
+	delete(t.rules, chain)
+	for idx, name := range t.chains {
+		if name == chain {
+			t.chains = append(t.chains[:idx], t.chains[idx+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+// NewChain implements the IptablesProvider interface
+func (r *IptablesRenderer) NewChain(table, chain string) error {
+
+	if _, err := r.chain(table, chain); err == nil {
+		return fmt.Errorf("Chain %s already exists in table %s", chain, table)
+	}
+
+	t := r.state[table]
+	t.chains = append(t.chains, chain)
+	t.rules[chain] = [][]string{}
+
+	return nil
+}
+
+// Render returns the tables, chains and rules in the iptables-save format.
+// The tables and chains are rendered in the order they were first used.
+func (r *IptablesRenderer) Render() string {
+
+	var buffer bytes.Buffer
+
+	for _, table := range r.tables {
+
+		t := r.state[table]
+		buffer.WriteString("*" + table + "\n")
+
+		for _, chain := range t.chains {
+			policy := "-"
+			if builtinChains[chain] {
+				policy = "ACCEPT"
+			}
+			buffer.WriteString(":" + chain + " " + policy + " [0:0]\n")
+		}
+
+		for _, chain := range t.chains {
+			for _, spec := range t.rules[chain] {
+				buffer.WriteString("-A " + chain + " " + rule(spec) + "\n")
+			}
+		}
+
+		buffer.WriteString("COMMIT\n")
+	}
+
+	return buffer.String()
+}
@@ -0,0 +1,63 @@
+package provider
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIptablesRenderer(t *testing.T) {
+
+	Convey("Given an iptables renderer", t, func() {
+		r := NewIptablesRenderer()
+
+		Convey("When I program chains and rules, they should be rendered in order", func() {
+			So(r.NewChain("mangle", "TRIREME-App-1"), ShouldBeNil)
+			So(r.Append("mangle", "TRIREME-App-1", "-j", "DROP"), ShouldBeNil)
+			So(r.Insert("mangle", "TRIREME-App-1", 1, "-d", "10.0.0.0/8", "-j", "ACCEPT"), ShouldBeNil)
+			So(r.Append("mangle", "OUTPUT", "-m", "comment", "--comment", "a comment", "-j", "TRIREME-App-1"), ShouldBeNil)
+			So(r.Append("raw", "PREROUTING", "-j", "ACCEPT"), ShouldBeNil)
+
+			So(r.Render(), ShouldEqual, `*mangle
+:TRIREME-App-1 - [0:0]
+:OUTPUT ACCEPT [0:0]
+-A TRIREME-App-1 -d 10.0.0.0/8 -j ACCEPT
+-A TRIREME-App-1 -j DROP
+-A OUTPUT -m comment --comment "a comment" -j TRIREME-App-1
+COMMIT
+*raw
+:PREROUTING ACCEPT [0:0]
+-A PREROUTING -j ACCEPT
+COMMIT
+`)
+
+			chains, err := r.ListChains("mangle")
+			So(err, ShouldBeNil)
+			So(chains, ShouldResemble, []string{"TRIREME-App-1", "OUTPUT"})
+
+			rules, err := r.List("mangle", "TRIREME-App-1")
+			So(err, ShouldBeNil)
+			So(rules, ShouldResemble, []string{"-N TRIREME-App-1", "-A TRIREME-App-1 -d 10.0.0.0/8 -j ACCEPT", "-A TRIREME-App-1 -j DROP"})
+
+			Convey("When I delete the rules and the chain, they should not be rendered", func() {
+				So(r.Delete("mangle", "OUTPUT", "-m", "comment", "--comment", "a comment", "-j", "TRIREME-App-1"), ShouldBeNil)
+				So(r.DeleteChain("mangle", "TRIREME-App-1"), ShouldNotBeNil)
+				So(r.ClearChain("mangle", "TRIREME-App-1"), ShouldBeNil)
+				So(r.DeleteChain("mangle", "TRIREME-App-1"), ShouldBeNil)
+				So(r.Render(), ShouldEqual, "*mangle\n:OUTPUT ACCEPT [0:0]\nCOMMIT\n*raw\n:PREROUTING ACCEPT [0:0]\n-A PREROUTING -j ACCEPT\nCOMMIT\n")
+			})
+		})
+
+		Convey("When I use a chain that does not exist, I should get an error", func() {
+			So(r.Append("mangle", "TRIREME-App-1", "-j", "DROP"), ShouldNotBeNil)
+			So(r.Delete("mangle", "OUTPUT", "-j", "DROP"), ShouldNotBeNil)
+			So(r.Insert("mangle", "OUTPUT", 2, "-j", "DROP"), ShouldNotBeNil)
+		})
+
+		Convey("When I create a chain twice, I should get an error", func() {
+			So(r.NewChain("mangle", "TRIREME-App-1"), ShouldBeNil)
+			So(r.NewChain("mangle", "TRIREME-App-1"), ShouldNotBeNil)
+			So(r.NewChain("mangle", "OUTPUT"), ShouldNotBeNil)
+		})
+	})
+}
@@ -85,6 +85,17 @@ func (_mr *_MockIptablesProviderRecorder) ListChains(arg0 interface{}) *gomock.C
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "ListChains", arg0)
 }
 
+func (_m *MockIptablesProvider) List(table string, chain string) ([]string, error) {
+	ret := _m.ctrl.Call(_m, "List", table, chain)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockIptablesProviderRecorder) List(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "List", arg0, arg1)
+}
+
 func (_m *MockIptablesProvider) ClearChain(table string, chain string) error {
 	ret := _m.ctrl.Call(_m, "ClearChain", table, chain)
 	ret0, _ := ret[0].(error)
@@ -0,0 +1,48 @@
+package provider
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// NftablesProvider is an abstraction of the methods an implementation of
+// userspace nftables needs to provide.
+type NftablesProvider interface {
+	// Apply applies the commands as a single atomic transaction. Either all
+	// the commands are applied or none of them.
+	Apply(commands []string) error
+}
+
+// nftProvider applies the commands with the nft utility
+type nftProvider struct {
+	path string
+}
+
+// NewNftProvider returns an NftablesProvider interface based on the nft
+// utility. The commands are loaded as one ruleset file so that the kernel
+// applies them in a single transaction.
+func NewNftProvider() (NftablesProvider, error) {
+
+	path, err := exec.LookPath("nft")
+	if err != nil {
+		return nil, fmt.Errorf("nft command not found: %s", err)
+	}
+
+	return &nftProvider{
+		path: path,
+	}, nil
+}
+
+// Apply implements the Apply interface
+func (n *nftProvider) Apply(commands []string) error {
+
+	cmd := exec.Command(n.path, "-f", "-")
+	cmd.Stdin = strings.NewReader(strings.Join(commands, "\n") + "\n")
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("Failed to apply nftables commands: %s: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
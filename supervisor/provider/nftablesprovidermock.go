@@ -0,0 +1,60 @@
+package provider
+
+import (
+	"sync"
+	"testing"
+)
+
+type nftablesProviderMockedMethods struct {
+	applyMock func(commands []string) error
+}
+
+// TestNftablesProvider is a test implementation for NftablesProvider
+type TestNftablesProvider interface {
+	NftablesProvider
+	MockApply(t *testing.T, impl func(commands []string) error)
+}
+
+// A testNftablesProvider is an empty NftablesProvider that can be easily mocked.
+type testNftablesProvider struct {
+	mocks       map[*testing.T]*nftablesProviderMockedMethods
+	lock        *sync.Mutex
+	currentTest *testing.T
+}
+
+// NewTestNftablesProvider returns a new TestNftablesProvider.
+func NewTestNftablesProvider() TestNftablesProvider {
+	return &testNftablesProvider{
+		lock:  &sync.Mutex{},
+		mocks: map[*testing.T]*nftablesProviderMockedMethods{},
+	}
+}
+
+func (m *testNftablesProvider) MockApply(t *testing.T, impl func(commands []string) error) {
+
+	m.currentMocks(t).applyMock = impl
+}
+
+func (m *testNftablesProvider) Apply(commands []string) error {
+
+	if mock := m.currentMocks(m.currentTest); mock != nil && mock.applyMock != nil {
+		return mock.applyMock(commands)
+	}
+
+	return nil
+}
+
+func (m *testNftablesProvider) currentMocks(t *testing.T) *nftablesProviderMockedMethods {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	mocks := m.mocks[t]
+
+	if mocks == nil {
+		mocks = &nftablesProviderMockedMethods{}
+		m.mocks[t] = mocks
+	}
+
+	m.currentTest = t
+	return mocks
+}
@@ -3,6 +3,7 @@ package supervisor
 import (
 	"fmt"
 	"sync"
+	"time"
 
 	"go.uber.org/zap"
 
@@ -15,13 +16,18 @@ import (
 	"github.com/aporeto-inc/trireme/policy"
 	"github.com/aporeto-inc/trireme/supervisor/ipsetctrl"
 	"github.com/aporeto-inc/trireme/supervisor/iptablesctrl"
+	"github.com/aporeto-inc/trireme/supervisor/nftablesctrl"
 )
 
+// reconcileInterval is the default period of the verification of the rules
+const reconcileInterval = 30 * time.Second
+
 type cacheData struct {
 	version int
 	ips     policy.ExtendedMap
 	mark    string
 	port    string
+	puInfo  *policy.PUInfo
 }
 
 // Config is the structure holding all information about the supervisor
@@ -37,6 +43,14 @@ type Config struct {
 
 	triremeNetworks []string
 
+	// reconcileInterval is the period of the verification of the rules of the
+	// PUs by implementations that can detect drift
+	reconcileInterval time.Duration
+	stop              chan struct{}
+
+	// puLock serializes the changes of the rules of the PUs
+	puLock sync.Mutex
+
 	sync.Mutex
 }
 
@@ -61,19 +75,22 @@ func NewSupervisor(collector collector.EventCollector, enforcerInstance enforcer
 	}
 
 	s := &Config{
-		mode:            mode,
-		impl:            nil,
-		versionTracker:  cache.NewCache(),
-		collector:       collector,
-		filterQueue:     filterQueue,
-		excludedIPs:     []string{},
-		triremeNetworks: networks,
+		mode:              mode,
+		impl:              nil,
+		versionTracker:    cache.NewCache(),
+		collector:         collector,
+		filterQueue:       filterQueue,
+		excludedIPs:       []string{},
+		triremeNetworks:   networks,
+		reconcileInterval: reconcileInterval,
 	}
 
 	var err error
 	switch implementation {
 	case constants.IPSets:
 		s.impl, err = ipsetctrl.NewInstance(s.filterQueue, false, mode)
+	case constants.NFTables:
+		s.impl, err = nftablesctrl.NewInstance(s.filterQueue, mode)
 	default:
 		s.impl, err = iptablesctrl.NewInstance(s.filterQueue, mode)
 	}
@@ -82,6 +99,16 @@ func NewSupervisor(collector collector.EventCollector, enforcerInstance enforcer
 		return nil, fmt.Errorf("Unable to initialize supervisor controllers")
 	}
 
+	// The nftables implementation programs both address families
+	if implementation != constants.IPSets && implementation != constants.NFTables {
+		ipv6, err := iptablesctrl.NewIPv6Instance(s.filterQueue, mode)
+		if err != nil {
+			zap.L().Warn("IPv6 is not supported by the supervisor", zap.Error(err))
+		} else {
+			s.impl = newDualStackImplementor(s.impl, ipv6)
+		}
+	}
+
 	return s, nil
 }
 
@@ -93,6 +120,9 @@ func (s *Config) Supervise(contextID string, containerInfo *policy.PUInfo) error
 		return fmt.Errorf("Runtime, Policy and ContainerInfo should not be nil")
 	}
 
+	s.puLock.Lock()
+	defer s.puLock.Unlock()
+
 	_, err := s.versionTracker.Get(contextID)
 
 	if err != nil {
@@ -109,6 +139,15 @@ func (s *Config) Supervise(contextID string, containerInfo *policy.PUInfo) error
 // as much cleanup as possible to avoid stale state
 func (s *Config) Unsupervise(contextID string) error {
 
+	s.puLock.Lock()
+	defer s.puLock.Unlock()
+
+	return s.unsupervise(contextID)
+}
+
+// unsupervise removes the rules of a PU. The caller holds the puLock.
+func (s *Config) unsupervise(contextID string) error {
+
 	version, err := s.versionTracker.Get(contextID)
 
 	if err != nil {
@@ -128,6 +167,32 @@ func (s *Config) Unsupervise(contextID string) error {
 	return nil
 }
 
+// RenderRules returns the rules that Supervise would program for the PU,
+// without programming them. The rules of a known PU are rendered with the
+// version of its next update. Only the rules of the PU are rendered, not the
+// global rules and sets programmed by Start.
+func (s *Config) RenderRules(contextID string, containerInfo *policy.PUInfo) (string, error) {
+
+	if containerInfo == nil || containerInfo.Policy == nil || containerInfo.Runtime == nil {
+		return "", fmt.Errorf("Runtime, Policy and ContainerInfo should not be nil")
+	}
+
+	renderer, ok := s.impl.(Renderer)
+	if !ok {
+		return "", fmt.Errorf("Rendering is not supported by the implementation")
+	}
+
+	s.puLock.Lock()
+	defer s.puLock.Unlock()
+
+	version := 0
+	if entry, err := s.versionTracker.Get(contextID); err == nil {
+		version = entry.(*cacheData).version ^ 1
+	}
+
+	return renderer.RenderRules(version, contextID, containerInfo)
+}
+
 // Start starts the supervisor
 func (s *Config) Start() error {
 
@@ -141,6 +206,11 @@ func (s *Config) Start() error {
 	}
 	s.Unlock()
 
+	if _, ok := s.impl.(DriftDetector); ok {
+		s.stop = make(chan struct{})
+		go s.reconcileLoop(s.stop, s.reconcileInterval)
+	}
+
 	zap.L().Debug("Started the supervisor")
 
 	return nil
@@ -149,6 +219,11 @@ func (s *Config) Start() error {
 // Stop stops the supervisor
 func (s *Config) Stop() error {
 
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+
 	if err := s.impl.Stop(); err != nil {
 		return fmt.Errorf("Failed to stop the implementer: %s", err)
 	}
@@ -191,13 +266,14 @@ func (s *Config) doCreatePU(contextID string, containerInfo *policy.PUInfo) erro
 		ips:     containerInfo.Policy.IPAddresses(),
 		mark:    mark,
 		port:    port,
+		puInfo:  containerInfo,
 	}
 
 	// Version the policy so that we can do hitless policy changes
 	s.versionTracker.AddOrUpdate(contextID, cacheEntry)
 
 	if err := s.impl.ConfigureRules(version, contextID, containerInfo); err != nil {
-		if uerr := s.Unsupervise(contextID); uerr != nil {
+		if uerr := s.unsupervise(contextID); uerr != nil {
 			zap.L().Warn("Failed to clean up state while creating the PU",
 				zap.String("contextID", contextID),
 				zap.Error(uerr),
@@ -222,7 +298,7 @@ func (s *Config) doUpdatePU(contextID string, containerInfo *policy.PUInfo) erro
 	cachedEntry := cacheEntry.(*cacheData)
 
 	if err := s.impl.UpdateRules(cachedEntry.version, contextID, containerInfo); err != nil {
-		if uerr := s.Unsupervise(contextID); uerr != nil {
+		if uerr := s.unsupervise(contextID); uerr != nil {
 			zap.L().Warn("Failed to clean up state while updating the PU",
 				zap.String("contextID", contextID),
 				zap.Error(uerr),
@@ -231,6 +307,119 @@ func (s *Config) doUpdatePU(contextID string, containerInfo *policy.PUInfo) erro
 		return err
 	}
 
+	cachedEntry.puInfo = containerInfo
+
+	return nil
+}
+
+// reconcileLoop verifies the rules of the PUs periodically until stop is
+// closed
+func (s *Config) reconcileLoop(stop chan struct{}, interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.reconcile()
+		}
+	}
+}
+
+// reconcile verifies the rules of all the supervised PUs and programs again
+// the rules that drifted from their policy
+func (s *Config) reconcile() {
+
+	detector, ok := s.impl.(DriftDetector)
+	if !ok {
+		return
+	}
+
+	for _, key := range s.versionTracker.KeyList() {
+		if contextID, ok := key.(string); ok {
+			s.reconcilePU(detector, contextID)
+		}
+	}
+}
+
+// reconcilePU verifies the rules of a PU. Drift is reported to the collector
+// and repaired with an update of the rules, so that the new rules are
+// programmed before the drifted ones are removed. If the repair fails, the
+// error is reported and the PU keeps its previous rules until the next
+// verification.
+func (s *Config) reconcilePU(detector DriftDetector, contextID string) {
+
+	s.puLock.Lock()
+	defer s.puLock.Unlock()
+
+	// The PU may have been removed since the keys were listed
+	entry, err := s.versionTracker.Get(contextID)
+	if err != nil {
+		return
+	}
+
+	cacheEntry := entry.(*cacheData)
+	if cacheEntry.puInfo == nil {
+		return
+	}
+
+	drift, err := detector.Drift(cacheEntry.version, contextID, cacheEntry.puInfo)
+	if err != nil {
+		zap.L().Warn("Unable to verify the rules of the PU",
+			zap.String("contextID", contextID),
+			zap.Error(err),
+		)
+		return
+	}
+
+	if len(drift) == 0 {
+		return
+	}
+
+	zap.L().Warn("The rules of the PU drifted from its policy",
+		zap.String("contextID", contextID),
+		zap.Strings("drift", drift),
+	)
+
+	ip, _ := cacheEntry.puInfo.Policy.DefaultIPAddress()
+	s.collector.CollectContainerEvent(&collector.ContainerRecord{
+		ContextID: contextID,
+		IPAddress: ip,
+		Tags:      cacheEntry.puInfo.Policy.Annotations(),
+		Event:     collector.ContainerDrift,
+	})
+
+	if err := s.repairPU(contextID, cacheEntry); err != nil {
+		zap.L().Error("Failed to repair the rules of the PU",
+			zap.String("contextID", contextID),
+			zap.Error(err),
+		)
+		s.collector.CollectContainerEvent(&collector.ContainerRecord{
+			ContextID: contextID,
+			IPAddress: ip,
+			Tags:      cacheEntry.puInfo.Policy.Annotations(),
+			Event:     collector.ContainerError,
+			Error:     err.Error(),
+		})
+	}
+}
+
+// repairPU programs the rules of a PU again with the next version. Unlike an
+// update, a failure keeps the PU supervised with its previous rules and
+// version, so that the repair is attempted again at the next verification.
+func (s *Config) repairPU(contextID string, cacheEntry *cacheData) error {
+
+	version := cacheEntry.version ^ 1
+
+	if err := s.impl.UpdateRules(version, contextID, cacheEntry.puInfo); err != nil {
+		return err
+	}
+
+	cacheEntry.version = version
+
 	return nil
 }
 
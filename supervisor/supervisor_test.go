@@ -254,3 +254,177 @@ func TestStop(t *testing.T) {
 		})
 	})
 }
+
+// driftImplementor is an implementor that reports the drift of its function
+type driftImplementor struct {
+	*mock_supervisor.MockImplementor
+	drift func(version int, contextID string) ([]string, error)
+}
+
+func (d *driftImplementor) Drift(version int, contextID string, containerInfo *policy.PUInfo) ([]string, error) {
+	return d.drift(version, contextID)
+}
+
+// containerEvents records the container events
+type containerEvents struct {
+	collector.DefaultCollector
+	records []*collector.ContainerRecord
+}
+
+func (c *containerEvents) CollectContainerEvent(record *collector.ContainerRecord) {
+	c.records = append(c.records, record)
+}
+
+func TestReconcile(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given a supervisor with an implementor that detects drift", t, func() {
+		c := &containerEvents{}
+		secrets := secrets.NewPSKSecrets([]byte("test password"))
+		e := enforcer.NewWithDefaults("serverID", c, nil, secrets, constants.LocalContainer, "/proc")
+
+		s, _ := NewSupervisor(c, e, constants.LocalContainer, constants.IPTables, []string{})
+		So(s, ShouldNotBeNil)
+
+		impl := &driftImplementor{MockImplementor: mock_supervisor.NewMockImplementor(ctrl)}
+		s.impl = impl
+
+		puInfo := createPUInfo()
+		impl.EXPECT().ConfigureRules(0, "contextID", puInfo).Return(nil)
+		So(s.Supervise("contextID", puInfo), ShouldBeNil)
+
+		Convey("When the rules have not drifted, nothing should be reported or programmed", func() {
+			impl.drift = func(version int, contextID string) ([]string, error) {
+				So(version, ShouldEqual, 0)
+				So(contextID, ShouldEqual, "contextID")
+				return []string{}, nil
+			}
+			s.reconcile()
+			So(c.records, ShouldBeEmpty)
+		})
+
+		Convey("When the rules have drifted, it should be reported and the rules programmed again", func() {
+			impl.drift = func(version int, contextID string) ([]string, error) {
+				return []string{"chain is missing"}, nil
+			}
+			impl.EXPECT().UpdateRules(1, "contextID", puInfo).Return(nil)
+			s.reconcile()
+			So(len(c.records), ShouldEqual, 1)
+			So(c.records[0].ContextID, ShouldEqual, "contextID")
+			So(c.records[0].Event, ShouldEqual, collector.ContainerDrift)
+			So(c.records[0].IPAddress, ShouldEqual, "172.17.0.1")
+
+			Convey("The next verification should use the new version", func() {
+				impl.drift = func(version int, contextID string) ([]string, error) {
+					So(version, ShouldEqual, 1)
+					return nil, nil
+				}
+				s.reconcile()
+				So(len(c.records), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When the rules have drifted and cannot be programmed again, the error should be reported", func() {
+			impl.drift = func(version int, contextID string) ([]string, error) {
+				return []string{"chain is missing"}, nil
+			}
+			impl.EXPECT().UpdateRules(1, "contextID", puInfo).Return(fmt.Errorf("iptables failure"))
+			s.reconcile()
+			So(len(c.records), ShouldEqual, 2)
+			So(c.records[0].Event, ShouldEqual, collector.ContainerDrift)
+			So(c.records[1].ContextID, ShouldEqual, "contextID")
+			So(c.records[1].Event, ShouldEqual, collector.ContainerError)
+			So(c.records[1].Error, ShouldEqual, "iptables failure")
+
+			Convey("The PU should keep its rules and version and the repair should be attempted again", func() {
+				entry, err := s.versionTracker.Get("contextID")
+				So(err, ShouldBeNil)
+				So(entry.(*cacheData).version, ShouldEqual, 0)
+				So(entry.(*cacheData).puInfo, ShouldEqual, puInfo)
+
+				impl.drift = func(version int, contextID string) ([]string, error) {
+					So(version, ShouldEqual, 0)
+					return []string{"chain is missing"}, nil
+				}
+				impl.EXPECT().UpdateRules(1, "contextID", puInfo).Return(nil)
+				s.reconcile()
+				So(len(c.records), ShouldEqual, 3)
+				So(c.records[2].Event, ShouldEqual, collector.ContainerDrift)
+			})
+		})
+
+		Convey("When the drift cannot be verified, nothing should be programmed", func() {
+			impl.drift = func(version int, contextID string) ([]string, error) {
+				return nil, fmt.Errorf("error")
+			}
+			s.reconcile()
+			So(c.records, ShouldBeEmpty)
+		})
+
+		Convey("When I start and stop the supervisor, the verification loop should be stopped", func() {
+			impl.EXPECT().Start().Return(nil)
+			impl.EXPECT().SetTargetNetworks([]string{}, []string{}).Return(nil)
+			impl.EXPECT().Stop().Return(nil)
+			So(s.Start(), ShouldBeNil)
+			So(s.stop, ShouldNotBeNil)
+			So(s.Stop(), ShouldBeNil)
+			So(s.stop, ShouldBeNil)
+		})
+	})
+}
+
+// renderImplementor is an implementor that renders the version of the rules
+type renderImplementor struct {
+	*mock_supervisor.MockImplementor
+}
+
+func (r *renderImplementor) RenderRules(version int, contextID string, containerInfo *policy.PUInfo) (string, error) {
+	return fmt.Sprintf("%s-%d", contextID, version), nil
+}
+
+func TestRenderRules(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given a supervisor", t, func() {
+		c := &collector.DefaultCollector{}
+		secrets := secrets.NewPSKSecrets([]byte("test password"))
+		e := enforcer.NewWithDefaults("serverID", c, nil, secrets, constants.LocalContainer, "/proc")
+
+		s, _ := NewSupervisor(c, e, constants.LocalContainer, constants.IPTables, []string{})
+		So(s, ShouldNotBeNil)
+
+		puInfo := createPUInfo()
+
+		Convey("When the implementation cannot render the rules, I should get an error", func() {
+			s.impl = mock_supervisor.NewMockImplementor(ctrl)
+			_, err := s.RenderRules("contextID", puInfo)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When the implementation can render the rules", func() {
+			impl := &renderImplementor{MockImplementor: mock_supervisor.NewMockImplementor(ctrl)}
+			s.impl = impl
+
+			Convey("The rules of a new PU should be rendered with the first version", func() {
+				script, err := s.RenderRules("contextID", puInfo)
+				So(err, ShouldBeNil)
+				So(script, ShouldEqual, "contextID-0")
+			})
+
+			Convey("The rules of a known PU should be rendered with the version of the next update", func() {
+				impl.EXPECT().ConfigureRules(0, "contextID", puInfo).Return(nil)
+				So(s.Supervise("contextID", puInfo), ShouldBeNil)
+				script, err := s.RenderRules("contextID", puInfo)
+				So(err, ShouldBeNil)
+				So(script, ShouldEqual, "contextID-1")
+			})
+
+			Convey("I should get an error without policy", func() {
+				_, err := s.RenderRules("contextID", nil)
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
@@ -19,6 +19,8 @@ import (
 type trireme struct {
 	serverID    string
 	cache       cache.DataStore
+	policies    cache.DataStore
+	paused      cache.DataStore
 	supervisors map[constants.PUType]supervisor.Supervisor
 	enforcers   map[constants.PUType]enforcer.PolicyEnforcer
 	resolver    PolicyResolver
@@ -31,6 +33,8 @@ func NewTrireme(serverID string, resolver PolicyResolver, supervisors map[consta
 	t := &trireme{
 		serverID:    serverID,
 		cache:       cache.NewCache(),
+		policies:    cache.NewCache(),
+		paused:      cache.NewCache(),
 		supervisors: supervisors,
 		enforcers:   enforcers,
 		resolver:    resolver,
@@ -92,6 +96,10 @@ func (t *trireme) HandlePUEvent(contextID string, event monitor.Event) error {
 		return t.doHandleCreate(contextID)
 	case monitor.EventStop:
 		return t.doHandleDelete(contextID)
+	case monitor.EventPause:
+		return t.doHandlePause(contextID)
+	case monitor.EventUnpause:
+		return t.doHandleUnpause(contextID)
 	default:
 		return nil
 	}
@@ -225,6 +233,9 @@ func (t *trireme) doHandleCreate(contextID string) error {
 		return fmt.Errorf("Not able to setup supervisor: %s", err)
 	}
 
+	t.policies.AddOrUpdate(contextID, containerInfo.Policy)
+	t.paused.Remove(contextID) // nolint
+
 	t.collector.CollectContainerEvent(&collector.ContainerRecord{
 		ContextID: contextID,
 		IPAddress: ip,
@@ -262,6 +273,9 @@ func (t *trireme) doHandleDelete(contextID string) error {
 		)
 	}
 
+	t.policies.Remove(contextID) // nolint
+	t.paused.Remove(contextID)   // nolint
+
 	if errS != nil || errE != nil {
 		t.collector.CollectContainerEvent(&collector.ContainerRecord{
 			ContextID: contextID,
@@ -298,6 +312,14 @@ func (t *trireme) doUpdatePolicy(contextID string, newPolicy *policy.PUPolicy) e
 		return nil
 	}
 
+	// The policy of a paused PU is only cached. It is enforced when the PU
+	// is unpaused.
+	if _, err = t.paused.Get(contextID); err == nil {
+		zap.L().Debug("PU is paused. Policy will be enforced when unpaused", zap.String("contextID", contextID))
+		t.policies.AddOrUpdate(contextID, containerInfo.Policy)
+		return nil
+	}
+
 	if err = t.enforcers[containerInfo.Runtime.PUType()].Enforce(contextID, containerInfo); err != nil {
 		//We lost communication with the remote and killed it lets restart it here by feeding a create event in the request channel
 		zap.L().Warn("Re-initializing enforcers - connection lost")
@@ -336,6 +358,8 @@ func (t *trireme) doUpdatePolicy(contextID string, newPolicy *policy.PUPolicy) e
 		return fmt.Errorf("Supervisor failed to update PU policy: context=%s error=%s", contextID, err)
 	}
 
+	t.policies.AddOrUpdate(contextID, containerInfo.Policy)
+
 	ip, _ := newPolicy.DefaultIPAddress()
 	t.collector.CollectContainerEvent(&collector.ContainerRecord{
 		ContextID: contextID,
@@ -347,6 +371,136 @@ func (t *trireme) doUpdatePolicy(contextID string, newPolicy *policy.PUPolicy) e
 	return nil
 }
 
+// frozenPolicy returns a copy of the policy without any ACL or tag selector.
+// It keeps the identity of the PU but always polices it, whatever the mode of
+// the original policy, so that every new connection is rejected. The policy is
+// marked frozen so that the enforcer keeps the decisions of the established
+// flows.
+func frozenPolicy(p *policy.PUPolicy) *policy.PUPolicy {
+
+	frozen := policy.NewPUPolicy(
+		p.ManagementID(),
+		policy.Police,
+		nil,
+		nil,
+		nil,
+		nil,
+		p.Identity().Copy(),
+		p.Annotations().Copy(),
+		p.IPAddresses().Copy(),
+		p.TriremeNetworks(),
+		p.ExcludedNetworks(),
+	)
+	frozen.SetFrozen(true)
+
+	return frozen
+}
+
+// applyPolicy enforces and supervises a policy of an already activated PU.
+func (t *trireme) applyPolicy(contextID string, containerInfo *policy.PUInfo) error {
+
+	puType := containerInfo.Runtime.PUType()
+
+	if err := t.enforcers[puType].Enforce(contextID, containerInfo); err != nil {
+		return fmt.Errorf("Enforcer failed: %s", err)
+	}
+
+	if err := t.supervisors[puType].Supervise(contextID, containerInfo); err != nil {
+		return fmt.Errorf("Supervisor failed: %s", err)
+	}
+
+	return nil
+}
+
+func (t *trireme) doHandlePause(contextID string) error {
+
+	runtimeInfo, err := t.PURuntime(contextID)
+	if err != nil {
+		return fmt.Errorf("Pause failed because couldn't find runtime for contextID %s", contextID)
+	}
+
+	if _, err = t.paused.Get(contextID); err == nil {
+		return nil
+	}
+
+	cachedPolicy, err := t.policies.Get(contextID)
+	if err != nil {
+		zap.L().Debug("PU is not enforced. Ignoring pause", zap.String("contextID", contextID))
+		return nil
+	}
+
+	puPolicy := cachedPolicy.(*policy.PUPolicy)
+	ip, _ := puPolicy.DefaultIPAddress()
+
+	containerInfo := policy.PUInfoFromPolicyAndRuntime(contextID, frozenPolicy(puPolicy), runtimeInfo.(*policy.PURuntime))
+
+	if err := t.applyPolicy(contextID, containerInfo); err != nil {
+		t.collector.CollectContainerEvent(&collector.ContainerRecord{
+			ContextID: contextID,
+			IPAddress: ip,
+			Tags:      puPolicy.Annotations(),
+			Event:     collector.ContainerFailed,
+		})
+
+		return fmt.Errorf("Unable to freeze policy of paused PU: context=%s error=%s", contextID, err)
+	}
+
+	t.paused.AddOrUpdate(contextID, true)
+
+	t.collector.CollectContainerEvent(&collector.ContainerRecord{
+		ContextID: contextID,
+		IPAddress: ip,
+		Tags:      puPolicy.Annotations(),
+		Event:     collector.ContainerPause,
+	})
+
+	return nil
+}
+
+func (t *trireme) doHandleUnpause(contextID string) error {
+
+	runtimeInfo, err := t.PURuntime(contextID)
+	if err != nil {
+		return fmt.Errorf("Unpause failed because couldn't find runtime for contextID %s", contextID)
+	}
+
+	if _, err = t.paused.Get(contextID); err != nil {
+		return nil
+	}
+
+	cachedPolicy, err := t.policies.Get(contextID)
+	if err != nil {
+		return fmt.Errorf("Unpause failed because couldn't find policy for contextID %s", contextID)
+	}
+
+	puPolicy := cachedPolicy.(*policy.PUPolicy)
+	ip, _ := puPolicy.DefaultIPAddress()
+
+	containerInfo := policy.PUInfoFromPolicyAndRuntime(contextID, puPolicy, runtimeInfo.(*policy.PURuntime))
+
+	if err := t.applyPolicy(contextID, containerInfo); err != nil {
+		t.collector.CollectContainerEvent(&collector.ContainerRecord{
+			ContextID: contextID,
+			IPAddress: ip,
+			Tags:      puPolicy.Annotations(),
+			Event:     collector.ContainerFailed,
+		})
+
+		return fmt.Errorf("Unable to restore policy of unpaused PU: context=%s error=%s", contextID, err)
+	}
+
+	t.paused.Remove(contextID) // nolint
+
+	t.collector.CollectContainerEvent(&collector.ContainerRecord{
+		ContextID: contextID,
+		IPAddress: ip,
+		Tags:      puPolicy.Annotations(),
+		Event:     collector.ContainerUnpause,
+	})
+
+	return nil
+}
+
 // Supervisor returns the Trireme supervisor for the given PU Type
 func (t *trireme) Supervisor(kind constants.PUType) supervisor.Supervisor {
 
@@ -7,6 +7,8 @@ import (
 	"github.com/aporeto-inc/trireme/collector"
 	"github.com/aporeto-inc/trireme/constants"
 	"github.com/aporeto-inc/trireme/enforcer"
+	"github.com/aporeto-inc/trireme/enforcer/utils/packetgen"
+	"github.com/aporeto-inc/trireme/enforcer/utils/secrets"
 	"github.com/aporeto-inc/trireme/monitor"
 	"github.com/aporeto-inc/trireme/policy"
 	"github.com/aporeto-inc/trireme/supervisor"
@@ -360,3 +362,160 @@ func TestTransmitterLabel(t *testing.T) {
 	}
 
 }
+
+// eventsCollector records the events of the containers
+type eventsCollector struct {
+	collector.DefaultCollector
+	events []string
+}
+
+func (c *eventsCollector) CollectContainerEvent(record *collector.ContainerRecord) {
+	c.events = append(c.events, record.Event)
+}
+
+func TestFrozenPolicy(t *testing.T) {
+	tagsMap := policy.NewTagStoreFromMap(map[string]string{enforcer.TransmitterLabel: "SomeId"})
+	rules := policy.TagSelectorList{policy.TagSelector{Policy: &policy.FlowPolicy{Action: policy.Accept}}}
+	audited := policy.NewPUPolicy("SomeId", policy.Audit, nil, nil, rules, rules, tagsMap, nil, policy.ExtendedMap{policy.DefaultNamespace: "127.0.0.1"}, []string{"172.17.0.0/24"}, []string{})
+
+	frozen := frozenPolicy(audited)
+	if frozen.TriremeAction() != policy.Police {
+		t.Errorf("Frozen policy was expected to be policed, got %d", frozen.TriremeAction())
+	}
+	if len(frozen.ReceiverRules()) != 0 || len(frozen.TransmitterRules()) != 0 {
+		t.Errorf("Frozen policy was expected to have no rules, got %v", frozen)
+	}
+	if !frozen.Frozen() || audited.Frozen() {
+		t.Errorf("Only the frozen policy was expected to be marked frozen")
+	}
+	if !frozen.Clone().Frozen() {
+		t.Errorf("Clone of a frozen policy was expected to be frozen")
+	}
+}
+
+func TestPausedAllowAllPolicy(t *testing.T) {
+	secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+
+	puInfo := func(contextID, ip string, triremeAction policy.PUAction) *policy.PUInfo {
+		tagsMap := policy.NewTagStoreFromMap(map[string]string{enforcer.TransmitterLabel: contextID})
+		rules := policy.TagSelectorList{
+			policy.TagSelector{
+				Clause: []policy.KeyValueOperator{{Key: enforcer.TransmitterLabel, Value: []string{"client"}, Operator: policy.Equal}},
+				Policy: &policy.FlowPolicy{Action: policy.Accept},
+			},
+		}
+		p := policy.NewPUPolicy(contextID, triremeAction, nil, nil, nil, rules, tagsMap, nil, policy.ExtendedMap{policy.DefaultNamespace: ip}, []string{"10.1.10.0/24", "164.67.228.0/24"}, []string{})
+		runtime := policy.NewPURuntimeWithDefaults()
+		runtime.SetIPAddresses(policy.ExtendedMap{"bridge": ip})
+		return policy.PUInfoFromPolicyAndRuntime(contextID, p, runtime)
+	}
+
+	run := func(server *policy.PUInfo) *enforcer.HarnessResult {
+		flow := packetgen.NewTCPPacketFlow("aa:ff:aa:ff:aa:ff", "ff:aa:ff:aa:ff:aa", "10.1.10.76", "164.67.228.152", 666, 80).GenerateTCPFlow(packetgen.PacketFlowTypeGoodFlow)
+		h, err := enforcer.NewHarness(puInfo("client", "10.1.10.76", policy.Police), server, nil, nil, secret)
+		if err != nil {
+			t.Fatalf("Harness was supposed to be created, got %s", err)
+		}
+		result, err := h.Run(flow)
+		if err != nil {
+			t.Fatalf("Flow was supposed to be processed, got %s", err)
+		}
+		return result
+	}
+
+	server := puInfo("server", "164.67.228.152", policy.AllowAll)
+	if !run(server).Accepted() {
+		t.Errorf("Syn packet to the AllowAll PU was expected to be accepted")
+	}
+
+	paused := policy.PUInfoFromPolicyAndRuntime("server", frozenPolicy(server.Policy), server.Runtime)
+	if paused.Policy.TriremeAction() != policy.Police {
+		t.Errorf("Paused AllowAll PU was expected to be policed, got %d", paused.Policy.TriremeAction())
+	}
+	result := run(paused)
+	if result.Accepted() || result.Packets[0].NetworkError == nil {
+		t.Errorf("Syn packet to a paused AllowAll PU was expected to be rejected")
+	}
+}
+
+func TestPauseUnpause(t *testing.T) {
+	tresolver, tsupervisor, tenforcer, tmonitor, _ := createMocks()
+	tcollector := &eventsCollector{}
+	trireme := NewTrireme("serverID", tresolver, tsupervisor, tenforcer, tcollector)
+	if err := trireme.Start(); err != nil {
+		t.Errorf("Failed to start trireme")
+	}
+	contextID := "123123"
+	runtime := policy.NewPURuntimeWithDefaults()
+
+	if err := trireme.HandlePUEvent(contextID, monitor.EventPause); err == nil {
+		t.Errorf("Pause of an unknown PU was supposed to fail")
+	}
+
+	doTestCreate(t, trireme, tresolver, tsupervisor[constants.ContainerPU].(supervisor.TestSupervisor), tenforcer[constants.ContainerPU].(enforcer.TestPolicyEnforcer), tmonitor, contextID, runtime)
+
+	var enforced, supervised []*policy.PUPolicy
+	tenforcer[constants.ContainerPU].(enforcer.TestPolicyEnforcer).MockEnforce(t, func(contextID string, puInfo *policy.PUInfo) error {
+		enforced = append(enforced, puInfo.Policy)
+		return nil
+	})
+	tsupervisor[constants.ContainerPU].(supervisor.TestSupervisor).MockSupervise(t, func(contextID string, puInfo *policy.PUInfo) error {
+		supervised = append(supervised, puInfo.Policy)
+		return nil
+	})
+
+	resolverCount := 0
+	tresolver.MockResolvePolicy(t, func(contextID string, RuntimeReader policy.RuntimeReader) (*policy.PUPolicy, error) {
+		resolverCount++
+		return nil, nil
+	})
+
+	// Pausing freezes the policy: no rules, same identity
+	if err := trireme.HandlePUEvent(contextID, monitor.EventPause); err != nil {
+		t.Errorf("Pause was supposed to be nil, was %s", err)
+	}
+	if err := trireme.HandlePUEvent(contextID, monitor.EventPause); err != nil {
+		t.Errorf("Second pause was supposed to be nil, was %s", err)
+	}
+	if len(enforced) != 1 || len(supervised) != 1 {
+		t.Fatalf("Pause was expected to enforce and supervise once, got %d and %d", len(enforced), len(supervised))
+	}
+	if len(enforced[0].ReceiverRules()) != 0 || len(enforced[0].TransmitterRules()) != 0 || !enforced[0].Frozen() || enforced[0].TriremeAction() != policy.Police {
+		t.Errorf("Paused policy was expected to reject everything, got %v", enforced[0])
+	}
+	if label, ok := enforced[0].Identity().Get(enforcer.TransmitterLabel); !ok || label != "SomeId" {
+		t.Errorf("Paused policy was expected to keep the identity, got %v", enforced[0].Identity())
+	}
+
+	// Updating the policy of a paused PU only caches it
+	tagsMap := policy.NewTagStoreFromMap(map[string]string{enforcer.TransmitterLabel: contextID})
+	newPolicy := policy.NewPUPolicy("", policy.Police, nil, nil, nil, nil, tagsMap, nil, policy.ExtendedMap{policy.DefaultNamespace: "127.0.0.1"}, []string{"172.17.0.0/24"}, []string{})
+	if err := trireme.UpdatePolicy(contextID, newPolicy); err != nil {
+		t.Errorf("Update of a paused PU was supposed to be nil, was %s", err)
+	}
+	if len(enforced) != 1 {
+		t.Errorf("Update of a paused PU was not supposed to be enforced")
+	}
+
+	// Unpausing restores the cached policy without resolving it again
+	if err := trireme.HandlePUEvent(contextID, monitor.EventUnpause); err != nil {
+		t.Errorf("Unpause was supposed to be nil, was %s", err)
+	}
+	if err := trireme.HandlePUEvent(contextID, monitor.EventUnpause); err != nil {
+		t.Errorf("Second unpause was supposed to be nil, was %s", err)
+	}
+	if len(enforced) != 2 || len(supervised) != 2 {
+		t.Fatalf("Unpause was expected to enforce and supervise once, got %d and %d", len(enforced), len(supervised))
+	}
+	if enforced[1] != newPolicy || supervised[1] != newPolicy {
+		t.Errorf("Unpause was expected to restore the updated policy, got %v", enforced[1])
+	}
+	if resolverCount != 0 {
+		t.Errorf("Unpause was not supposed to resolve the policy")
+	}
+
+	expected := []string{collector.ContainerStart, collector.ContainerPause, collector.ContainerUnpause}
+	if !reflect.DeepEqual(tcollector.events, expected) {
+		t.Errorf("Container events were expected to be %v, got %v", expected, tcollector.events)
+	}
+}